@@ -0,0 +1,270 @@
+// Package alert notifies when devices served by a server.Server cross
+// thresholds or become unreachable, and of suspicious broadcasts seen by a
+// device.Registry.
+//
+// A Rule fires when its condition has held for its For duration, and
+// resolves when the condition stops holding; Notifiers are told of both.
+// Rules are JSON like:
+//
+//	{"name": "freezer warm", "device": "freezer", "dp": 2, "above": -15, "for": "10m"}
+//	{"name": "power spike", "device": "heater", "dp": 19, "above": 2000}
+//	{"name": "freezer offline", "device": "freezer", "offline": true, "for": "5m"}
+//
+// Threshold rules use the last value each device pushed, so a device that
+// goes quiet keeps its last value; pair them with an offline rule.
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/rules"
+	"github.com/lann/tuya/server"
+)
+
+// How often conditions are checked.
+const checkInterval = time.Second
+
+// A Rule is an alert condition on one device.
+type Rule struct {
+	Name string `json:"name,omitempty"`
+	// Device is a device ID or name.
+	Device string `json:"device"`
+
+	// DP, Above, and Below give a threshold condition: the dp's value is
+	// above or below a number. Given both, the value must be outside the
+	// range between them.
+	DP    uint32   `json:"dp,omitempty"`
+	Above *float64 `json:"above,omitempty"`
+	Below *float64 `json:"below,omitempty"`
+
+	// Offline instead makes the condition the device being unreachable.
+	Offline bool `json:"offline,omitempty"`
+
+	// For is how long the condition must hold before the alert fires.
+	For rules.Duration `json:"for,omitempty"`
+}
+
+func (r Rule) validate() error {
+	if r.Device == "" {
+		return errors.New("device is required")
+	}
+	if r.Offline {
+		if r.DP != 0 || r.Above != nil || r.Below != nil {
+			return errors.New("offline can't be combined with a threshold")
+		}
+	} else if r.DP == 0 || (r.Above == nil && r.Below == nil) {
+		return errors.New("needs offline, or dp and above or below")
+	}
+	if r.For < 0 {
+		return errors.New("for must be positive")
+	}
+	return nil
+}
+
+// Notification states.
+const (
+	Firing   = "firing"
+	Resolved = "resolved"
+)
+
+// A Notification is sent when an alert fires or resolves.
+type Notification struct {
+	Alert string    `json:"alert"`
+	State string    `json:"state"`
+	Time  time.Time `json:"time"`
+	// Since is when the condition started to hold.
+	Since   time.Time   `json:"since"`
+	ID      string      `json:"id"`
+	Name    string      `json:"name,omitempty"`
+	DP      uint32      `json:"dp,omitempty"`
+	Value   interface{} `json:"value,omitempty"`
+	Message string      `json:"message"`
+}
+
+// A Notifier delivers Notifications.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// An alert's state.
+type alertState struct {
+	Rule
+	since  time.Time // when the condition started holding; zero if it isn't
+	firing bool
+}
+
+// A Monitor checks Rules and sends Notifications. The Server must be
+// started for threshold rules to see values.
+type Monitor struct {
+	Server    *server.Server
+	Notifiers []Notifier
+
+	// Logger, if not nil, receives Notifiers' delivery errors.
+	Logger net.Logger
+
+	alerts []*alertState
+	values map[string]map[uint32]interface{} // by device ID; only used by Run
+
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// NewMonitor returns a Monitor of rules, or an error if any is invalid.
+func NewMonitor(s *server.Server, rules []Rule, notifiers ...Notifier) (*Monitor, error) {
+	m := &Monitor{Server: s, Notifiers: notifiers, values: make(map[string]map[uint32]interface{})}
+	for i, r := range rules {
+		if r.Name == "" {
+			r.Name = "alert " + strconv.Itoa(i+1)
+		}
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", r.Name, err)
+		}
+		m.alerts = append(m.alerts, &alertState{Rule: r})
+	}
+	return m, nil
+}
+
+// Run checks rules until the Monitor is closed.
+func (m *Monitor) Run() error {
+	m.mu.Lock()
+	if m.stop == nil {
+		m.stop = make(chan struct{})
+	}
+	stop := m.stop
+	m.mu.Unlock()
+
+	events, unsubscribe := m.Server.Subscribe()
+	defer unsubscribe()
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			values := m.values[ev.ID]
+			if values == nil {
+				values = make(map[uint32]interface{})
+				m.values[ev.ID] = values
+			}
+			for dp, v := range ev.DPs {
+				values[dp] = v
+			}
+			m.check(ev.Time)
+		case now := <-ticker.C:
+			m.check(now)
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// Close stops Run.
+func (m *Monitor) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop == nil {
+		m.stop = make(chan struct{})
+	}
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+	return nil
+}
+
+// Update every alert, sending notifications for those that fire or resolve.
+func (m *Monitor) check(now time.Time) {
+	devices := m.Server.Devices()
+	for _, a := range m.alerts {
+		dev := server.Device{ID: a.Device}
+		for _, d := range devices {
+			if d.ID == a.Device || d.Name == a.Device {
+				dev = d
+			}
+		}
+		var value interface{}
+		var holds bool
+		if a.Offline {
+			holds = !m.Server.Fleet.Connected(dev.ID)
+		} else {
+			value = m.values[dev.ID][a.DP]
+			v, ok := value.(float64)
+			holds = ok && (a.Above != nil && v > *a.Above || a.Below != nil && v < *a.Below)
+		}
+
+		switch {
+		case holds && a.since.IsZero():
+			a.since = now
+		case !holds && !a.since.IsZero():
+			if a.firing {
+				m.notify(a, dev, Resolved, now, value)
+			}
+			a.since, a.firing = time.Time{}, false
+		}
+		if holds && !a.firing && now.Sub(a.since) >= time.Duration(a.For) {
+			a.firing = true
+			m.notify(a, dev, Firing, now, value)
+		}
+	}
+}
+
+// Send a notification in the background.
+func (m *Monitor) notify(a *alertState, dev server.Device, state string, now time.Time, value interface{}) {
+	n := Notification{
+		Alert: a.Name,
+		State: state,
+		Time:  now,
+		Since: a.since,
+		ID:    dev.ID,
+		Name:  dev.Name,
+		DP:    a.DP,
+		Value: value,
+	}
+	switch {
+	case state == Resolved:
+		n.Message = fmt.Sprintf("%s resolved", a.Name)
+	case a.Offline:
+		n.Message = fmt.Sprintf("%s: %s unreachable since %s", a.Name, a.Device, a.since.Format(time.Kitchen))
+	default:
+		n.Message = fmt.Sprintf("%s: %s dp %d is %v", a.Name, a.Device, a.DP, value)
+	}
+	send(m.Logger, m.Notifiers, n)
+}
+
+// Anomalies returns a function for device.Registry.OnAnomaly that sends a
+// firing Notification, named "rogue device", for each anomaly. Anomalies are
+// events rather than conditions, so they never resolve. Delivery errors go
+// to logger, if not nil.
+func Anomalies(logger net.Logger, notifiers ...Notifier) func(device.Anomaly) {
+	return func(a device.Anomaly) {
+		send(logger, notifiers, Notification{
+			Alert:   "rogue device",
+			State:   Firing,
+			Time:    a.Time,
+			Since:   a.Time,
+			ID:      a.Status.GatewayID,
+			Value:   a.Kind,
+			Message: a.String(),
+		})
+	}
+}
+
+// Send a notification to each notifier in the background.
+func send(logger net.Logger, notifiers []Notifier, n Notification) {
+	for _, notifier := range notifiers {
+		go func(notifier Notifier) {
+			if err := notifier.Notify(n); err != nil && logger != nil {
+				logger.Logf(net.LevelError, "alert: %s: %v", n.Alert, err)
+			}
+		}(notifier)
+	}
+}
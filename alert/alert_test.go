@@ -0,0 +1,136 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/server"
+	"github.com/lann/tuya/sink"
+)
+
+// A Notifier that collects notifications.
+type chanNotifier chan Notification
+
+func (c chanNotifier) Notify(n Notification) error {
+	c <- n
+	return nil
+}
+
+func TestMonitor(t *testing.T) {
+	s := server.New()
+	defer s.Close()
+	s.AddDevice(server.Device{ID: "abc", Name: "freezer"}, net.ClientConfig{Addr: "127.0.0.1:1", Key: "0123456789abcdef"})
+
+	var rules []Rule
+	err := json.Unmarshal([]byte(`[
+		{"name": "warm", "device": "freezer", "dp": 2, "above": -15, "for": "10m"},
+		{"name": "offline", "device": "abc", "offline": true, "for": "5m"}
+	]`), &rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := make(chanNotifier, 10)
+	m, err := NewMonitor(s, rules, notes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := func(alert, state string) {
+		t.Helper()
+		select {
+		case n := <-notes:
+			if n.Alert != alert || n.State != state {
+				t.Errorf("got %s %s, want %s %s", n.Alert, n.State, alert, state)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no notification for %s %s", alert, state)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case n := <-notes:
+			t.Errorf("unexpected notification %+v", n)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	start := time.Now()
+	m.values["abc"] = map[uint32]interface{}{2: -10.0}
+	m.check(start)
+	m.check(start.Add(4 * time.Minute))
+	expectNone()
+	m.check(start.Add(5 * time.Minute))
+	expect("offline", Firing)
+
+	// Dipping back below the threshold resets the timer.
+	m.values["abc"][2] = -20.0
+	m.check(start.Add(9 * time.Minute))
+	m.values["abc"][2] = -10.0
+	m.check(start.Add(11 * time.Minute))
+	expectNone()
+	m.check(start.Add(21 * time.Minute))
+	expect("warm", Firing)
+	m.check(start.Add(22 * time.Minute))
+	expectNone()
+
+	m.values["abc"][2] = -18.0
+	m.check(start.Add(23 * time.Minute))
+	expect("warm", Resolved)
+}
+
+func TestNewMonitorInvalid(t *testing.T) {
+	s := server.New()
+	defer s.Close()
+	limit := 1.0
+	for _, r := range []Rule{
+		{DP: 1, Above: &limit},
+		{Device: "x", DP: 1},
+		{Device: "x", Above: &limit},
+		{Device: "x", Offline: true, DP: 1, Above: &limit},
+	} {
+		if _, err := NewMonitor(s, []Rule{r}); err == nil {
+			t.Errorf("%+v: expected error", r)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	got := make(chan *http.Request, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r
+	}))
+	defer ts.Close()
+	w := &Webhook{URL: ts.URL, Secret: "secret"}
+	if err := w.Notify(Notification{Alert: "warm", State: Firing}); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-got; r.Header.Get(sink.SignatureHeader) == "" {
+		t.Error("request not signed")
+	}
+}
+
+func TestAnomalies(t *testing.T) {
+	notes := make(chanNotifier, 1)
+	now := time.Now()
+	notify := Anomalies(nil, notes)
+	notify(device.Anomaly{
+		Kind:   device.UnknownDevice,
+		Status: &net.Status{GatewayID: "abc", IP: "10.0.0.2"},
+		MAC:    "aa:aa:aa:aa:aa:aa",
+		Time:   now,
+	})
+	select {
+	case n := <-notes:
+		want := "unknown device abc broadcasting from 10.0.0.2 (aa:aa:aa:aa:aa:aa)"
+		if n.Alert != "rogue device" || n.State != Firing || n.ID != "abc" || n.Value != device.UnknownDevice || !n.Time.Equal(now) || n.Message != want {
+			t.Errorf("got %+v", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no notification")
+	}
+}
@@ -0,0 +1,69 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/lann/tuya/internal/httpclient"
+	"github.com/lann/tuya/mqtt"
+	"github.com/lann/tuya/sink"
+)
+
+// A Webhook POSTs each Notification as JSON.
+type Webhook struct {
+	URL string
+
+	// Secret, if set, signs requests as described by sink.SignatureHeader.
+	Secret string
+
+	// Client is used for requests. Nil means a default client with a timeout.
+	Client *http.Client
+}
+
+// Notify implements Notifier.
+func (w *Webhook) Notify(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		req.Header.Set(sink.SignatureHeader, "sha256="+sink.Sign(w.Secret, body))
+	}
+	res, err := httpclient.Or(w.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", w.URL, res.Status)
+	}
+	return nil
+}
+
+// An MQTT Notifier publishes each Notification as JSON to Topic, with QoS 1.
+// It connects for each notification, since alerts are rare.
+type MQTT struct {
+	Config mqtt.Config
+	Topic  string
+}
+
+// Notify implements Notifier.
+func (m *MQTT) Notify(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	client, err := m.Config.Dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Publish(mqtt.Message{Topic: m.Topic, Payload: body, QoS: 1})
+}
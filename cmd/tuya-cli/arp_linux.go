@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// Return the MAC address of an IP from the kernel's ARP table, or "" if it
+// isn't there.
+func lookupMAC(ip string) string {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	// IP address, HW type, Flags, HW address, Mask, Device
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] != ip {
+			continue
+		}
+		// Incomplete entries have no address.
+		if fields[2] == "0x0" || fields[3] == "00:00:00:00:00:00" {
+			return ""
+		}
+		return fields[3]
+	}
+	return ""
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// MAC addresses are only looked up on Linux; elsewhere rogue device detection
+// goes by IP alone.
+func lookupMAC(ip string) string {
+	return ""
+}
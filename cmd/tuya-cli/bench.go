@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/lann/tuya/device"
+)
+
+// Latency statistics in milliseconds.
+type latencyStats struct {
+	N    int     `json:"n"`
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+func newLatencyStats(samples []time.Duration) latencyStats {
+	stats := latencyStats{N: len(samples)}
+	if len(samples) == 0 {
+		return stats
+	}
+	ms := make([]float64, len(samples))
+	var sum float64
+	for i, d := range samples {
+		ms[i] = float64(d) / float64(time.Millisecond)
+		sum += ms[i]
+	}
+	sort.Float64s(ms)
+	// Nearest-rank percentiles.
+	rank := func(p float64) float64 {
+		return ms[int(math.Ceil(p/100*float64(len(ms))))-1]
+	}
+	round := func(f float64) float64 { return math.Round(f*100) / 100 }
+	stats.Min = round(ms[0])
+	stats.Mean = round(sum / float64(len(ms)))
+	stats.P50 = round(rank(50))
+	stats.P95 = round(rank(95))
+	stats.P99 = round(rank(99))
+	stats.Max = round(ms[len(ms)-1])
+	return stats
+}
+
+// Benchmark results, as output by bench. Dial covers TCP connection setup and
+// RTT covers dp queries.
+type benchRecord struct {
+	ID       string       `json:"id"`
+	Requests int          `json:"requests"`
+	Errors   int          `json:"errors"`
+	Timeouts int          `json:"timeouts"`
+	Dial     latencyStats `json:"dial"`
+	RTT      latencyStats `json:"rtt"`
+}
+
+func runBench(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	var of outputFlags
+	df.register(fs)
+	of.register(fs)
+	count := fs.Int("count", 100, "number of requests")
+	interval := fs.Duration("interval", 0, "pause between requests")
+	wait := fs.Duration("wait", 5*time.Second, "request timeout")
+	reconnect := fs.Bool("reconnect", false, "dial a new connection for every request")
+	args, err := df.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+	status, err := df.status()
+	if err != nil {
+		return err
+	}
+	config, err := df.clientConfig(status)
+	if err != nil {
+		return err
+	}
+
+	record := benchRecord{ID: status.GatewayID}
+	var dials, rtts []time.Duration
+	var m *device.Manager
+	defer func() {
+		if m != nil {
+			m.Close()
+		}
+	}()
+	for i := 0; i < *count; i++ {
+		if i > 0 && *interval > 0 {
+			time.Sleep(*interval)
+		}
+		record.Requests++
+
+		if m == nil {
+			start := time.Now()
+			client, err := config.Dial()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+				record.Errors++
+				continue
+			}
+			dials = append(dials, time.Since(start))
+			m = device.NewManager(status.GatewayID, client)
+		}
+
+		conn := m
+		timer := time.AfterFunc(*wait, func() { conn.Close() })
+		start := time.Now()
+		_, err := m.GetState()
+		rtt := time.Since(start)
+		timedOut := !timer.Stop()
+		switch {
+		case timedOut:
+			record.Timeouts++
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+			record.Errors++
+		default:
+			rtts = append(rtts, rtt)
+		}
+		if err != nil || *reconnect {
+			m.Close()
+			m = nil
+		}
+	}
+
+	record.Dial = newLatencyStats(dials)
+	record.RTT = newLatencyStats(rtts)
+	if err := enc.encode(record); err != nil {
+		return err
+	}
+	return enc.close()
+}
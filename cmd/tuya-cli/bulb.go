@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/lann/tuya/device"
+)
+
+// A bulb's state, as output by bulb.
+type bulbRecord struct {
+	ID         string  `json:"id"`
+	On         bool    `json:"on"`
+	Mode       string  `json:"mode"`
+	Brightness float64 `json:"brightness"`
+	Kelvin     int     `json:"kelvin"`
+	Color      string  `json:"color"`
+}
+
+func runBulb(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	var of outputFlags
+	df.register(fs)
+	of.register(fs)
+	layout := fs.String("layout", "v2", "dp layout: v1 (dps 1-5) or v2 (dps 20-24)")
+	color := fs.String("color", "", "switch to colour mode with this #rrggbb color")
+	brightness := fs.Float64("brightness", -1, "brightness percentage")
+	temp := fs.Int("temp", 0, "white color temperature in Kelvin")
+	on := fs.Bool("on", false, "turn the bulb on")
+	off := fs.Bool("off", false, "turn the bulb off")
+	args, err := df.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+	var dps device.BulbDPs
+	switch *layout {
+	case "v1":
+		dps = device.BulbDPsV1
+	case "v2":
+		dps = device.BulbDPsV2
+	default:
+		return fmt.Errorf("bad -layout %q", *layout)
+	}
+	if *on && *off {
+		return errors.New("-on and -off are exclusive")
+	}
+	if *color != "" && *temp != 0 {
+		return errors.New("-color and -temp are exclusive")
+	}
+
+	m, err := df.dial()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	bulb := device.NewBulb(m, dps)
+
+	switch {
+	case *off:
+		err = bulb.Off()
+	case *color != "":
+		var c device.HSV
+		if c, err = device.ParseHexColor(*color); err != nil {
+			return err
+		}
+		if *brightness >= 0 {
+			c.V = *brightness / 100
+		}
+		err = bulb.SetColor(c)
+	case *temp != 0 && *brightness >= 0:
+		err = bulb.SetWhite(*brightness, *temp)
+	case *temp != 0:
+		err = bulb.SetKelvin(*temp)
+	case *brightness >= 0:
+		err = bulb.SetBrightness(*brightness)
+	case *on:
+		err = bulb.On()
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := bulb.Status()
+	if err != nil {
+		return err
+	}
+	r, g, b := status.Color.RGB()
+	err = enc.encode(bulbRecord{
+		ID:         df.id,
+		On:         status.On,
+		Mode:       status.Mode,
+		Brightness: status.Brightness,
+		Kelvin:     status.Kelvin,
+		Color:      fmt.Sprintf("#%02x%02x%02x", r, g, b),
+	})
+	if err != nil {
+		return err
+	}
+	return enc.close()
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+)
+
+// A device's dps, as output by get and set.
+type stateRecord struct {
+	ID  string       `json:"id"`
+	DPs device.State `json:"dps"`
+}
+
+// A single dp value change, as output by watch. Name is the configured
+// device name, if any.
+type dpEvent struct {
+	Time  time.Time   `json:"time"`
+	Name  string      `json:"name"`
+	ID    string      `json:"id"`
+	DP    uint32      `json:"dp"`
+	Value interface{} `json:"value"`
+}
+
+func runDiscover(fs *flag.FlagSet, args []string) error {
+	var of outputFlags
+	of.register(fs)
+	duration := fs.Duration("duration", 10*time.Second, "how long to listen")
+	fs.Parse(args)
+
+	enc, err := of.encoder(true)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	var encErr error
+	err = readStatuses(*duration, func(status *net.Status) bool {
+		if !seen[status.GatewayID] {
+			seen[status.GatewayID] = true
+			encErr = enc.encode(status)
+		}
+		return encErr != nil
+	})
+	if err != nil {
+		return err
+	}
+	return encErr
+}
+
+func runStatus(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	var of outputFlags
+	df.register(fs)
+	of.register(fs)
+	args, err := df.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+	if df.id == "" {
+		return errors.New("a device name or -id is required")
+	}
+	status, err := findDevice(df.id, df.timeout)
+	if err != nil {
+		return err
+	}
+	if err := enc.encode(status); err != nil {
+		return err
+	}
+	return enc.close()
+}
+
+func runGet(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	var of outputFlags
+	df.register(fs)
+	of.register(fs)
+	args, err := df.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+	m, err := df.dial()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	state, err := m.GetState()
+	if err != nil {
+		return err
+	}
+	if err := enc.encode(stateRecord{df.id, state}); err != nil {
+		return err
+	}
+	return enc.close()
+}
+
+func runSet(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	var of outputFlags
+	df.register(fs)
+	of.register(fs)
+	args, err := df.parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+	state, err := parseState(args)
+	if err != nil {
+		return err
+	}
+	if len(state) == 0 {
+		return errors.New("no dps given")
+	}
+
+	m, err := df.dial()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := m.SetState(state); err != nil {
+		return err
+	}
+	if err := enc.encode(stateRecord{df.id, state}); err != nil {
+		return err
+	}
+	return enc.close()
+}
+
+func runWatch(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	var of outputFlags
+	df.register(fs)
+	of.register(fs)
+	poll := fs.Duration("poll", 0, "also poll state at this interval, for devices that don't push every change (0 disables)")
+	fs.Parse(args)
+
+	enc, err := of.encoder(true)
+	if err != nil {
+		return err
+	}
+	targets := map[string]deviceFlags{}
+	if fs.NArg() == 0 {
+		if df.id == "" {
+			return errors.New("a device name or -id is required")
+		}
+		targets[df.id] = df
+	}
+	for _, name := range fs.Args() {
+		if targets[name], err = df.named(name); err != nil {
+			return err
+		}
+	}
+
+	events := make(chan dpEvent)
+	for name, target := range targets {
+		go watchDevice(name, target, *poll, events)
+	}
+	for event := range events {
+		if err := enc.encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch a device forever, reconnecting after errors.
+func watchDevice(name string, df deviceFlags, poll time.Duration, events chan<- dpEvent) {
+	last := device.State{}
+	for {
+		err := watchOnce(name, df, poll, last, events)
+		fmt.Fprintf(os.Stderr, "watch %s: %v; reconnecting\n", name, err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Watch a device until its connection fails, sending events for dps that
+// differ from last, which is updated.
+func watchOnce(name string, df deviceFlags, poll time.Duration, last device.State, events chan<- dpEvent) error {
+	m, err := df.dial()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	updates, stop := m.Watch()
+	defer stop()
+
+	emit := func(state device.State) {
+		now := time.Now()
+		for _, dp := range sortedDPs(state) {
+			if v, ok := last[dp]; ok && reflect.DeepEqual(v, state[dp]) {
+				continue
+			}
+			last[dp] = state[dp]
+			events <- dpEvent{now, name, df.id, dp, state[dp]}
+		}
+	}
+	state, err := m.GetState()
+	if err != nil {
+		return err
+	}
+	emit(state)
+
+	var tick <-chan time.Time
+	if poll > 0 {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return errors.New("connection closed")
+			}
+			emit(update)
+		case <-tick:
+			state, err := m.GetState()
+			if err != nil {
+				return err
+			}
+			emit(state)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/alert"
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/server"
+)
+
+// The config file holds named devices, so keys don't need to be passed on
+// the command line (and end up in shell history):
+//
+//	{
+//	  "devices": {
+//	    "desk-lamp": {"id": "...", "key": "...", "ip": "...", "version": "3.1",
+//	                  "readOnly": [18, 19, 20], "dpNames": {"19": "power"}}
+//	  },
+//	  "groups": {
+//	    "living-room": ["desk-lamp", "floor-lamp"]
+//	  },
+//	  "alerts": [
+//	    {"name": "lamp offline", "device": "desk-lamp", "offline": true, "for": "5m"}
+//	  ]
+//	}
+//
+// The ip, version, readOnly, and dpNames fields are optional; without an ip
+// the device is found by its broadcast. readOnly lists dps, like sensor
+// readings, that restore shouldn't try to set. dpNames label exported
+// metrics. Groups list device names. Alerts are checked by serve; see package
+// alert. Keys may be encrypted with a passphrase; see keycrypt.go.
+type config struct {
+	Devices map[string]deviceConfig `json:"devices"`
+	Groups  map[string][]string     `json:"groups,omitempty"`
+	Alerts  []alert.Rule            `json:"alerts,omitempty"`
+}
+
+// A configured device.
+type deviceConfig struct {
+	ID       string            `json:"id"`
+	IP       string            `json:"ip,omitempty"`
+	Key      string            `json:"key,omitempty"`
+	Version  string            `json:"version,omitempty"`
+	ReadOnly []uint32          `json:"readOnly,omitempty"`
+	DPNames  map[uint32]string `json:"dpNames,omitempty"`
+}
+
+// Return a ClientConfig for the device. Addr is empty if no ip is configured.
+func (d deviceConfig) clientConfig() net.ClientConfig {
+	config := net.ClientConfig{Key: d.Key, Version: d.Version, Logger: logger}
+	if d.IP != "" {
+		config.Addr = fmt.Sprintf("%s:%d", d.IP, net.ClientPort)
+	}
+	return config
+}
+
+// Return the default config file path: $XDG_CONFIG_HOME/tuya/devices.json,
+// falling back to ~/.config/tuya/devices.json.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "tuya", "devices.json")
+}
+
+// Load a config file. A missing file is treated as empty.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{Devices: make(map[string]deviceConfig)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if cfg.Devices == nil {
+		cfg.Devices = make(map[string]deviceConfig)
+	}
+	for name, dev := range cfg.Devices {
+		if !isEncryptedKey(dev.Key) {
+			continue
+		}
+		kc := passphraseCrypter()
+		if kc == nil {
+			return nil, fmt.Errorf("%s: %w", path, errNoPassphrase)
+		}
+		if dev.Key, err = kc.decrypt(dev.Key); err != nil {
+			return nil, fmt.Errorf("%s: device %q: %v", path, name, err)
+		}
+		cfg.Devices[name] = dev
+	}
+	return cfg, nil
+}
+
+// Save a config file, replacing it atomically. The file is only readable by
+// its owner since it holds keys, which are encrypted if
+// $TUYA_CONFIG_PASSPHRASE is set.
+func saveConfig(path string, cfg *config) error {
+	return writeConfig(path, cfg, passphraseCrypter())
+}
+
+// Save a config file with keys encrypted by kc, if not nil.
+func writeConfig(path string, cfg *config, kc *keyCrypter) error {
+	if kc != nil {
+		encrypted := *cfg
+		encrypted.Devices = make(map[string]deviceConfig, len(cfg.Devices))
+		for name, dev := range cfg.Devices {
+			if dev.Key != "" {
+				var err error
+				if dev.Key, err = kc.encrypt(dev.Key); err != nil {
+					return err
+				}
+			}
+			encrypted.Devices[name] = dev
+		}
+		cfg = &encrypted
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".devices-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Return the device's server.DeviceConfig.
+func (d deviceConfig) serverConfig(name string) server.DeviceConfig {
+	return server.DeviceConfig{ID: d.ID, Name: name, IP: d.IP, Key: d.Key, Version: d.Version}
+}
+
+// A configStore saves devices changed through serve's API to a config file.
+// Devices added without a name are saved under their ID.
+type configStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (s *configStore) PutDevice(c server.DeviceConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg, err := loadConfig(s.path)
+	if err != nil {
+		return err
+	}
+	dev := deviceConfig{ID: c.ID, IP: c.IP, Key: c.Key, Version: c.Version}
+	for name, old := range cfg.Devices {
+		if old.ID == c.ID {
+			// Keep settings the API doesn't know about.
+			dev.ReadOnly, dev.DPNames = old.ReadOnly, old.DPNames
+			delete(cfg.Devices, name)
+		}
+	}
+	name := c.Name
+	if name == "" {
+		name = c.ID
+	}
+	cfg.Devices[name] = dev
+	return saveConfig(s.path, cfg)
+}
+
+func (s *configStore) DeleteDevice(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg, err := loadConfig(s.path)
+	if err != nil {
+		return err
+	}
+	for name, dev := range cfg.Devices {
+		if dev.ID != id {
+			continue
+		}
+		delete(cfg.Devices, name)
+		for group, names := range cfg.Groups {
+			kept := names[:0]
+			for _, n := range names {
+				if n != name {
+					kept = append(kept, n)
+				}
+			}
+			cfg.Groups[group] = kept
+		}
+	}
+	return saveConfig(s.path, cfg)
+}
+
+// Expand device and group names into device names, or return all device
+// names if none are given.
+func (c *config) expand(names []string) []string {
+	if len(names) == 0 {
+		for name := range c.Devices {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	var expanded []string
+	for _, name := range names {
+		if group, ok := c.Groups[name]; ok {
+			expanded = append(expanded, group...)
+		} else {
+			expanded = append(expanded, name)
+		}
+	}
+	return expanded
+}
+
+// Return a Fleet of the named devices and their IDs, in the same order.
+// Devices without a configured ip are found by waiting up to timeout for
+// their broadcasts.
+func (c *config) fleet(names []string, timeout time.Duration) (*device.Fleet, []string, error) {
+	fleet := device.NewFleet()
+	fleet.Registry = device.NewRegistry()
+	var ids []string
+	missing := make(map[string]bool)
+	for _, name := range names {
+		dev, ok := c.Devices[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("no device %q", name)
+		}
+		config := dev.clientConfig()
+		if config.Addr == "" {
+			missing[dev.ID] = true
+		}
+		fleet.Add(dev.ID, config)
+		ids = append(ids, dev.ID)
+	}
+	if len(missing) > 0 {
+		err := readStatuses(timeout, func(status *net.Status) bool {
+			fleet.Registry.Update(status)
+			delete(missing, status.GatewayID)
+			return len(missing) == 0
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return fleet, ids, nil
+}
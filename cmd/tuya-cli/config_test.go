@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Use kc as the $TUYA_CONFIG_PASSPHRASE crypter until the returned function
+// is called.
+func setConfigCrypter(kc *keyCrypter) func() {
+	configCrypterOnce.Do(func() {})
+	old := configCrypter
+	configCrypter = kc
+	return func() { configCrypter = old }
+}
+
+// Write a config file in a new temporary directory, returning its path.
+func writeTestConfig(t *testing.T, data string) (path string, cleanup func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "tuya-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "devices.json")
+	if data != "" {
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfig(t *testing.T) {
+	path, cleanup := writeTestConfig(t, `{
+		"devices": {
+			"lamp": {"id": "dev1", "key": "0123456789abcdef", "ip": "10.0.0.2", "version": "3.3",
+			         "readOnly": [18, 19], "dpNames": {"19": "power"}},
+			"fan": {"id": "dev2"}
+		},
+		"groups": {"all": ["lamp", "fan"]}
+	}`)
+	defer cleanup()
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lamp := deviceConfig{
+		ID: "dev1", Key: testKey, IP: "10.0.0.2", Version: "3.3",
+		ReadOnly: []uint32{18, 19}, DPNames: map[uint32]string{19: "power"},
+	}
+	if !reflect.DeepEqual(cfg.Devices["lamp"], lamp) {
+		t.Errorf("lamp = %+v", cfg.Devices["lamp"])
+	}
+	if got := cfg.Devices["lamp"].clientConfig(); got.Addr != "10.0.0.2:6668" || got.Key != testKey || got.Version != "3.3" {
+		t.Errorf("lamp clientConfig = %+v", got)
+	}
+	if got := cfg.Devices["fan"].clientConfig(); got.Addr != "" {
+		t.Errorf("fan without an ip has Addr %q", got.Addr)
+	}
+
+	for _, tc := range []struct {
+		names, want []string
+	}{
+		{nil, []string{"fan", "lamp"}},
+		{[]string{"all"}, []string{"lamp", "fan"}},
+		{[]string{"lamp", "other"}, []string{"lamp", "other"}},
+	} {
+		if got := cfg.expand(tc.names); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("expand(%v) = %v, want %v", tc.names, got, tc.want)
+		}
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	path, cleanup := writeTestConfig(t, "")
+	defer cleanup()
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Devices == nil || len(cfg.Devices) != 0 {
+		t.Errorf("missing file loaded as %+v", cfg)
+	}
+
+	// A file without devices gets an empty map too, so it can be added to.
+	path, cleanup = writeTestConfig(t, `{"groups": {}}`)
+	defer cleanup()
+	if cfg, err = loadConfig(path); err != nil || cfg.Devices == nil {
+		t.Errorf("loadConfig = %+v, %v", cfg, err)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	path, cleanup := writeTestConfig(t, `{"devices": [}`)
+	defer cleanup()
+	if _, err := loadConfig(path); err == nil || !strings.HasPrefix(err.Error(), path+": ") {
+		t.Errorf("loadConfig = %v, want an error naming the file", err)
+	}
+}
+
+func TestConfigEncryptedKeys(t *testing.T) {
+	path, cleanup := writeTestConfig(t, "")
+	defer cleanup()
+	kc := &keyCrypter{passphrase: []byte("correct horse")}
+	cfg := &config{Devices: map[string]deviceConfig{
+		"lamp": {ID: "dev1", Key: testKey},
+		"fan":  {ID: "dev2"},
+	}}
+	if err := writeConfig(path, cfg, kc); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), testKey) || !strings.Contains(string(data), encryptedKeyPrefix) {
+		t.Fatalf("saved config doesn't encrypt the key:\n%s", data)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("saved config mode = %v, %v; want 0600", fi.Mode(), err)
+	}
+
+	restore := setConfigCrypter(kc)
+	defer restore()
+	loaded, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Devices, cfg.Devices) {
+		t.Errorf("loaded %+v, want %+v", loaded.Devices, cfg.Devices)
+	}
+
+	setConfigCrypter(nil)
+	if _, err := loadConfig(path); !errors.Is(err, errNoPassphrase) {
+		t.Errorf("loadConfig without a passphrase = %v, want errNoPassphrase", err)
+	}
+	setConfigCrypter(&keyCrypter{passphrase: []byte("wrong")})
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), `device "lamp"`) {
+		t.Errorf("loadConfig with the wrong passphrase = %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/tuyatest"
+)
+
+func runConformance(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	var of outputFlags
+	df.register(fs)
+	of.register(fs)
+	dp := fs.Uint("dp", 0, "boolean dp to toggle and restore for the control and push checks")
+	wait := fs.Duration("wait", 5*time.Second, "how long to wait for each reply")
+	args, err := df.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+	if df.key == "" {
+		return errors.New("a device key is required")
+	}
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+
+	// Without an IP, the device is found by its broadcast, which is then
+	// checked too.
+	status, err := df.status()
+	if err != nil {
+		return err
+	}
+	var broadcast *net.Status
+	if df.ip == "" {
+		broadcast = status
+	}
+	config, err := df.clientConfig(status)
+	if err != nil {
+		return err
+	}
+	c := &tuyatest.Conformance{
+		ID:      df.id,
+		Config:  config,
+		Status:  broadcast,
+		DP:      uint32(*dp),
+		Timeout: *wait,
+	}
+	report := c.Run()
+
+	if of.format == formatTable && of.template == "" {
+		for _, res := range report.Results {
+			if err := enc.encode(res); err != nil {
+				return err
+			}
+		}
+	} else if err := enc.encode(report); err != nil {
+		return err
+	}
+	if err := enc.close(); err != nil {
+		return err
+	}
+	if report.Failed() {
+		return errors.New("some checks failed")
+	}
+	return nil
+}
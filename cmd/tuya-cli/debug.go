@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/record"
+)
+
+// The logger for connections to configured devices; see also -log-level.
+var logger = &net.StdLogger{Level: net.LevelWarn}
+
+// A frameDumper writes a Client's frames as hex dumps with decoded headers
+// and plaintext payloads. The local key is redacted wherever it appears.
+type frameDumper struct {
+	mu  sync.Mutex
+	w   io.Writer
+	key []byte
+}
+
+// Open a frame dump destination: a file path, or "-" for stderr.
+func newFrameDumper(path, key string) (*frameDumper, error) {
+	w := io.Writer(os.Stderr)
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &frameDumper{w: w, key: []byte(key)}, nil
+}
+
+func (fd *frameDumper) hooks() net.Hooks {
+	return net.Hooks{
+		Sent: func(f *net.Frame, plaintext []byte) {
+			fd.dump(">>", f, plaintext, nil)
+		},
+		Received: func(f *net.Frame, plaintext []byte, err error) {
+			fd.dump("<<", f, plaintext, err)
+		},
+	}
+}
+
+func (fd *frameDumper) dump(dir string, f *net.Frame, plaintext []byte, err error) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fmt.Fprintf(fd.w, "%s %s seq=%d cmd=0x%02x len=%d\n",
+		time.Now().Format("15:04:05.000"), dir, f.Seq, f.Cmd, len(f.Payload))
+	fd.w.Write([]byte(hex.Dump(fd.redact(f.Payload))))
+	switch {
+	case err != nil:
+		fmt.Fprintf(fd.w, "decrypt error: %v\n", err)
+	case !bytes.Equal(plaintext, f.Payload):
+		fmt.Fprintf(fd.w, "plaintext: %s\n", printable(fd.redact(plaintext)))
+	}
+}
+
+// Replace any occurrence of the key.
+func (fd *frameDumper) redact(data []byte) []byte {
+	if len(fd.key) == 0 || !bytes.Contains(data, fd.key) {
+		return data
+	}
+	return bytes.Replace(data, fd.key, bytes.Repeat([]byte("*"), len(fd.key)), -1)
+}
+
+// Start a recording of a connection to the device, replacing any existing
+// file.
+func (d *deviceFlags) recordHooks(status *net.Status) (net.Hooks, error) {
+	f, err := os.OpenFile(d.record, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return net.Hooks{}, err
+	}
+	rec, err := record.NewRecorder(f, record.Header{
+		ID:      status.GatewayID,
+		Addr:    status.IP,
+		Version: status.Version,
+	})
+	if err != nil {
+		f.Close()
+		return net.Hooks{}, err
+	}
+	return rec.Hooks(), nil
+}
+
+// Return Hooks calling a's hooks and then b's.
+func chainHooks(a, b net.Hooks) net.Hooks {
+	return net.Hooks{
+		Sent: func(f *net.Frame, plaintext []byte) {
+			if a.Sent != nil {
+				a.Sent(f, plaintext)
+			}
+			if b.Sent != nil {
+				b.Sent(f, plaintext)
+			}
+		},
+		Received: func(f *net.Frame, plaintext []byte, err error) {
+			if a.Received != nil {
+				a.Received(f, plaintext, err)
+			}
+			if b.Received != nil {
+				b.Received(f, plaintext, err)
+			}
+		},
+	}
+}
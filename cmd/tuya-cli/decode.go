@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lann/tuya/net"
+)
+
+// A decoded frame, as output by decode and -export. Time, addresses, and
+// direction are only known for frames read from a capture or proxied.
+type frameRecord struct {
+	Time      *time.Time `json:"time,omitempty"`
+	Src       string     `json:"src,omitempty"`
+	Dst       string     `json:"dst,omitempty"`
+	Dir       string     `json:"dir,omitempty"`
+	Seq       uint32     `json:"seq"`
+	Cmd       uint32     `json:"cmd"`
+	CmdName   string     `json:"cmdName,omitempty"`
+	Code      *uint32    `json:"code"`
+	Encrypted bool       `json:"encrypted"`
+	Payload   string     `json:"payload"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// A list of strings from a repeated flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func runDecode(fs *flag.FlagSet, args []string) error {
+	var of outputFlags
+	var keys stringsFlag
+	of.register(fs)
+	fs.Var(&keys, "key", "local key to decrypt payloads with; may be repeated")
+	configPath := fs.String("config", defaultConfigPath(), "config file whose device keys are also tried")
+	isHex := fs.Bool("hex", false, "input is a hex dump rather than a pcap or raw bytes")
+	export := fs.String("export", "", "also write decoded frames as JSON lines to this file, or - for stdout")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args()[1:])
+	}
+
+	enc, err := of.encoder(true)
+	if err != nil {
+		return err
+	}
+	d, err := newFrameDecoder(enc, *configPath, keys)
+	if err != nil {
+		return err
+	}
+	if *export != "" {
+		if d.export, err = newFrameExporter(*export); err != nil {
+			return err
+		}
+	}
+
+	in := io.Reader(os.Stdin)
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	br := bufio.NewReader(in)
+
+	if !*isHex {
+		magic, _ := br.Peek(4)
+		if len(magic) == 4 {
+			le, be := binary.LittleEndian.Uint32(magic), binary.BigEndian.Uint32(magic)
+			for _, m := range []uint32{pcapMagicMicros, pcapMagicNanos} {
+				if le == m || be == m {
+					if err := d.pcap(br); err != nil {
+						return err
+					}
+					return enc.close()
+				}
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		return err
+	}
+	if *isHex {
+		if data, err = parseHexDump(data); err != nil {
+			return err
+		}
+	}
+	var s frameScanner
+	if err := d.decodeAll(s.feed(data), nil); err != nil {
+		return err
+	}
+	if len(s.buf) > 0 {
+		fmt.Fprintf(os.Stderr, "decode: %d trailing bytes\n", len(s.buf))
+	}
+	return enc.close()
+}
+
+// Decodes frames and writes them as records.
+type frameDecoder struct {
+	enc     *encoder
+	export  *frameExporter // if -export is given
+	ciphers []*net.Cipher
+	flows   map[string]*tcpFlow
+}
+
+// One direction of a TCP connection.
+type tcpFlow struct {
+	stream  tcpStream
+	scanner frameScanner
+}
+
+// Create a frameDecoder trying the given keys, those of devices in the config
+// file, and the broadcast key.
+func newFrameDecoder(enc *encoder, configPath string, keys []string) (*frameDecoder, error) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, dev := range cfg.Devices {
+		if dev.Key != "" {
+			keys = append(keys, dev.Key)
+		}
+	}
+	keys = append(keys, string(net.BroadcastKey[:]))
+	d := &frameDecoder{enc: enc}
+	for _, key := range keys {
+		cipher, err := net.NewCipher([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("bad key %q: %v", key, err)
+		}
+		d.ciphers = append(d.ciphers, cipher)
+	}
+	return d, nil
+}
+
+// Decode every TCP stream and UDP datagram in a capture.
+func (d *frameDecoder) pcap(r io.Reader) error {
+	pr, err := newPcapReader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		p, err := pr.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := d.packet(p); err != nil {
+			return err
+		}
+	}
+}
+
+// Decode the frames in a UDP datagram or completed by a TCP segment.
+func (d *frameDecoder) packet(p *packet) error {
+	data := p.payload
+	var s frameScanner
+	scanner := &s
+	if p.tcp {
+		if d.flows == nil {
+			d.flows = make(map[string]*tcpFlow)
+		}
+		key := p.src + ">" + p.dst
+		f, ok := d.flows[key]
+		if !ok {
+			f = &tcpFlow{}
+			d.flows[key] = f
+		}
+		data = f.stream.add(p)
+		scanner = &f.scanner
+	}
+	return d.decodeAll(scanner.feed(data), p)
+}
+
+func (d *frameDecoder) decodeAll(frames []scannedFrame, p *packet) error {
+	for _, sf := range frames {
+		record := d.decode(sf)
+		if p != nil {
+			record.Time = &p.time
+			record.Src, record.Dst = p.src, p.dst
+			record.Dir = packetDir(p)
+		}
+		if err := d.enc.encode(record); err != nil {
+			return err
+		}
+		if d.export != nil {
+			if err := d.export.export(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Decode a frame's payload, decrypting it with the first key that works.
+func (d *frameDecoder) decode(sf scannedFrame) frameRecord {
+	if sf.err != nil {
+		return frameRecord{Error: sf.err.Error()}
+	}
+	f := sf.frame
+	record := frameRecord{Seq: f.Seq, Cmd: f.Cmd, CmdName: cmdNames[f.Cmd]}
+	var payload []byte
+	record.Code, payload = splitCode(f.Payload)
+	switch {
+	case bytes.HasPrefix(payload, []byte(net.Version31)):
+		record.Encrypted = true
+		payload, record.Error = d.open(payload, (*net.Cipher).Decrypt)
+	case bytes.HasPrefix(payload, []byte(net.Version33)) && len(payload) > v33HeaderSize:
+		payload = payload[v33HeaderSize:]
+		fallthrough
+	case len(payload) > 0 && len(payload)%16 == 0 && payload[0] != '{':
+		// Probably bare 3.3 ciphertext.
+		record.Encrypted = true
+		payload, record.Error = d.open(payload, (*net.Cipher).Open)
+	}
+	record.Payload = printable(payload)
+	return record
+}
+
+// Split off the return code that device frames carry; requests and some
+// pushes don't.
+func splitCode(payload []byte) (*uint32, []byte) {
+	if len(payload) >= 4 && payload[0] == 0 {
+		code := binary.BigEndian.Uint32(payload)
+		return &code, payload[4:]
+	}
+	return nil, payload
+}
+
+// Decrypt with the first key that works, returning the plaintext or, if no
+// key works, the ciphertext and an error message.
+func (d *frameDecoder) open(payload []byte, decrypt func(*net.Cipher, []byte) ([]byte, error)) ([]byte, string) {
+	for _, cipher := range d.ciphers {
+		if plaintext, err := decrypt(cipher, payload); err == nil {
+			return plaintext, ""
+		}
+	}
+	return payload, "no key decrypts payload"
+}
+
+// Return text payloads as is and binary payloads hex encoded.
+func printable(data []byte) string {
+	if !utf8.Valid(data) {
+		return hex.EncodeToString(data)
+	}
+	for _, r := range string(data) {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			return hex.EncodeToString(data)
+		}
+	}
+	return string(data)
+}
+
+// A frame or decoding error found by a frameScanner.
+type scannedFrame struct {
+	frame *net.Frame
+	err   error
+}
+
+// A frameScanner finds frames in a byte stream, skipping any bytes that
+// aren't part of a frame.
+type frameScanner struct {
+	buf []byte
+}
+
+var framePrefix = []byte{0, 0, 0x55, 0xaa}
+
+// Frame header size and maximum length, matching the net package.
+const (
+	frameHeaderSize = 16
+	maxFrameSize    = 0xffff
+)
+
+// Add stream data, returning any frames it completes.
+func (s *frameScanner) feed(data []byte) []scannedFrame {
+	s.buf = append(s.buf, data...)
+	var frames []scannedFrame
+	for {
+		i := bytes.Index(s.buf, framePrefix)
+		if i < 0 {
+			// Keep a possible partial prefix.
+			if len(s.buf) > len(framePrefix) {
+				s.buf = s.buf[len(s.buf)-len(framePrefix)+1:]
+			}
+			return frames
+		}
+		s.buf = s.buf[i:]
+		if len(s.buf) < frameHeaderSize {
+			return frames
+		}
+		size := frameHeaderSize + int(binary.BigEndian.Uint32(s.buf[12:]))
+		if size > maxFrameSize+frameHeaderSize {
+			// Not really a frame; keep looking.
+			s.buf = s.buf[1:]
+			continue
+		}
+		if len(s.buf) < size {
+			return frames
+		}
+		f, err := net.DecodeFrame(bytes.NewReader(s.buf[:size]))
+		if err != nil {
+			frames = append(frames, scannedFrame{err: err})
+		} else {
+			frames = append(frames, scannedFrame{frame: f})
+		}
+		s.buf = s.buf[size:]
+	}
+}
+
+// Parse a hex dump, ignoring whitespace, colons, and "0x" prefixes. Dumps
+// with offset columns, as printed by `xxd` or `tcpdump -x`, are also accepted.
+func parseHexDump(dump []byte) ([]byte, error) {
+	var digits []byte
+	for _, line := range strings.Split(string(dump), "\n") {
+		words := strings.Fields(line)
+		if len(words) > 0 && strings.HasSuffix(words[0], ":") {
+			// Drop the offset column and any trailing ASCII column.
+			line = strings.TrimSpace(line[strings.Index(line, ":")+1:])
+			if i := strings.Index(line, "  "); i >= 0 {
+				line = line[:i]
+			}
+		}
+		for _, word := range strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ':' || r == ','
+		}) {
+			word = strings.TrimPrefix(strings.TrimPrefix(word, "0x"), "0X")
+			digits = append(digits, word...)
+		}
+	}
+	data, err := hex.DecodeString(string(digits))
+	if err != nil {
+		return nil, errors.New("bad hex input; pass raw bytes without -hex")
+	}
+	return data, nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+)
+
+// Flags identifying and connecting to a single device. A device may also be
+// named by a leading argument matching a device in the config file; flags
+// override its configured values.
+type deviceFlags struct {
+	ip, id, key, version string
+	timeout              time.Duration
+	config               string
+	debugFrames          string
+	record               string
+	logLevel             string
+	strict               bool
+}
+
+func (d *deviceFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&d.config, "config", defaultConfigPath(), "config file of named devices")
+	fs.StringVar(&d.ip, "ip", "", "device IP address (default: found by broadcast)")
+	fs.StringVar(&d.id, "id", "", "device ID (gwId)")
+	fs.StringVar(&d.key, "key", "", "device local key")
+	fs.StringVar(&d.version, "version", "", "protocol version (default: from broadcast, or detected)")
+	fs.DurationVar(&d.timeout, "timeout", 30*time.Second, "how long to wait for a broadcast")
+	fs.StringVar(&d.debugFrames, "debug-frames", "", "dump sent and received frames to this file, or - for stderr")
+	fs.StringVar(&d.logLevel, "log-level", "warn", "log connection messages at this level or above: debug, info, warn, or error")
+	fs.StringVar(&d.record, "record", "", "record sent and received frames to this file for replay")
+	fs.BoolVar(&d.strict, "strict", false, "refuse devices and messages that aren't encrypted, and protocol versions below 3.3")
+}
+
+// Parse flags and an optional leading device name, returning the remaining
+// arguments. Flags may appear before or after the name.
+func (d *deviceFlags) parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.Parse(args)
+	args = fs.Args()
+	if len(args) == 0 || strings.ContainsRune(args[0], '=') {
+		return args, nil
+	}
+	dev, err := loadDevice(d.config, args[0])
+	if err != nil {
+		return nil, err
+	}
+	fs.Parse(args[1:])
+	d.apply(dev)
+	return fs.Args(), nil
+}
+
+// Return a copy of the flags with values for a named device filled in from
+// the config file.
+func (d *deviceFlags) named(name string) (deviceFlags, error) {
+	dev, err := loadDevice(d.config, name)
+	if err != nil {
+		return deviceFlags{}, err
+	}
+	named := *d
+	named.apply(dev)
+	return named, nil
+}
+
+func loadDevice(path, name string) (deviceConfig, error) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return deviceConfig{}, err
+	}
+	dev, ok := cfg.Devices[name]
+	if !ok {
+		return deviceConfig{}, fmt.Errorf("no device %q in %s", name, path)
+	}
+	return dev, nil
+}
+
+// Fill in any values not given by flags from a configured device.
+func (d *deviceFlags) apply(dev deviceConfig) {
+	if d.id == "" {
+		d.id = dev.ID
+	}
+	if d.ip == "" {
+		d.ip = dev.IP
+	}
+	if d.key == "" {
+		d.key = dev.Key
+	}
+	if d.version == "" {
+		d.version = dev.Version
+	}
+}
+
+// Find the device's broadcast, or build a Status from flags if the IP is
+// known.
+func (d *deviceFlags) status() (*net.Status, error) {
+	if d.id == "" {
+		return nil, errors.New("a device name or -id is required")
+	}
+	if d.ip != "" {
+		return &net.Status{IP: d.ip, GatewayID: d.id, Version: d.version}, nil
+	}
+	status, err := findDevice(d.id, d.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if d.version != "" {
+		status.Version = d.version
+	}
+	return status, nil
+}
+
+// Connect to the device and return a Manager for it.
+func (d *deviceFlags) dial() (*device.Manager, error) {
+	client, status, err := d.dialClient()
+	if err != nil {
+		return nil, err
+	}
+	return device.NewManager(status.GatewayID, client), nil
+}
+
+// Connect to the device and return a bare Client.
+func (d *deviceFlags) dialClient() (*net.Client, *net.Status, error) {
+	status, err := d.status()
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.strict && d.ip == "" {
+		if err := status.CheckStrict(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if status.Version == "" && d.strict {
+		status.Version = net.Version33
+	}
+	if status.Version == "" {
+		// Given by IP without a version, so try each in turn.
+		probe := status.ClientConfig()
+		probe.Key = d.key
+		version, err := device.DetectVersion(status.GatewayID, probe, 0)
+		if err != nil {
+			return nil, nil, unreachable(err)
+		}
+		log.Printf("detected protocol version %s; set -version to skip detection", version)
+		status.Version = version
+	}
+	config, err := d.clientConfig(status)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := config.Dial()
+	if errors.Is(err, net.ErrInsecure) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, unreachable(err)
+	}
+	return client, status, nil
+}
+
+// Build a ClientConfig for a device found by status.
+func (d *deviceFlags) clientConfig(status *net.Status) (net.ClientConfig, error) {
+	config := status.ClientConfig()
+	config.Key = d.key
+	config.Strict = d.strict
+	level, err := net.ParseLevel(d.logLevel)
+	if err != nil {
+		return config, err
+	}
+	config.Logger = &net.StdLogger{Level: level}
+	if d.debugFrames != "" {
+		dumper, err := newFrameDumper(d.debugFrames, d.key)
+		if err != nil {
+			return config, err
+		}
+		config.Hooks = dumper.hooks()
+	}
+	if d.record != "" {
+		hooks, err := d.recordHooks(status)
+		if err != nil {
+			return config, err
+		}
+		config.Hooks = chainHooks(config.Hooks, hooks)
+	}
+	return config, nil
+}
+
+// Listen for broadcasts until one from the given device ID is seen.
+func findDevice(id string, timeout time.Duration) (*net.Status, error) {
+	var found *net.Status
+	err := readStatuses(timeout, func(status *net.Status) bool {
+		if status.GatewayID == id {
+			found = status
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, unreachable(fmt.Errorf("no broadcast from %s within %v", id, timeout))
+	}
+	return found, nil
+}
+
+// Read broadcasts for up to d, calling fn with each until it returns true.
+// Undecodable broadcasts are skipped.
+func readStatuses(d time.Duration, fn func(*net.Status) bool) error {
+	l, err := listenStatuses()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	timer := time.AfterFunc(d, func() { l.Close() })
+	defer timer.Stop()
+	for {
+		status, err := l.ReadStatus()
+		if err != nil {
+			return nil
+		}
+		if fn(status) {
+			return nil
+		}
+	}
+}
+
+// A statusMux merges plain and encrypted status broadcasts.
+type statusMux struct {
+	statuses  chan *net.Status
+	done      chan struct{}
+	closeOnce sync.Once
+	closers   []io.Closer
+}
+
+var errListenerClosed = errors.New("listener closed")
+
+// Listen for plain and, on whichever ports are available, encrypted
+// broadcasts. Undecodable broadcasts are skipped.
+func listenStatuses() (*statusMux, error) {
+	plain, err := net.NewStatusListener()
+	if err != nil {
+		return nil, err
+	}
+	mux := &statusMux{
+		statuses: make(chan *net.Status),
+		done:     make(chan struct{}),
+	}
+	readers := []device.StatusReader{plain}
+	mux.closers = append(mux.closers, plain)
+	if encrypted, err := net.NewEncryptedStatusListener(); err == nil {
+		readers = append(readers, encrypted)
+		mux.closers = append(mux.closers, encrypted)
+	}
+	if app, err := net.NewAppStatusListener(); err == nil {
+		readers = append(readers, app)
+		mux.closers = append(mux.closers, app)
+	}
+	for _, r := range readers {
+		go mux.read(r)
+	}
+	return mux, nil
+}
+
+func (mux *statusMux) read(r device.StatusReader) {
+	for {
+		status, err := r.ReadStatus()
+		select {
+		case <-mux.done:
+			return
+		default:
+		}
+		if err != nil {
+			continue
+		}
+		select {
+		case mux.statuses <- status:
+		case <-mux.done:
+			return
+		}
+	}
+}
+
+// ReadStatus returns the next broadcast from any listener.
+func (mux *statusMux) ReadStatus() (*net.Status, error) {
+	select {
+	case status := <-mux.statuses:
+		return status, nil
+	case <-mux.done:
+		return nil, errListenerClosed
+	}
+}
+
+// Close stops all listeners; pending and future reads fail.
+func (mux *statusMux) Close() error {
+	mux.closeOnce.Do(func() {
+		close(mux.done)
+		for _, c := range mux.closers {
+			c.Close()
+		}
+	})
+	return nil
+}
+
+// Parse "dp=value" arguments into a State. Values are parsed as booleans,
+// then integers, then JSON, and otherwise used as plain strings.
+func parseState(args []string) (device.State, error) {
+	state := device.State{}
+	for _, arg := range args {
+		eq := strings.IndexByte(arg, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("bad dp %q; want dp=value", arg)
+		}
+		dp, err := strconv.ParseUint(arg[:eq], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad dp number %q", arg[:eq])
+		}
+		state[uint32(dp)] = parseValue(arg[eq+1:])
+	}
+	return state, nil
+}
+
+func parseValue(s string) interface{} {
+	if b, err := strconv.ParseBool(s); err == nil {
+		return b
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err == nil {
+		return v
+	}
+	return s
+}
+
+// Return the dps of a State in order.
+func sortedDPs(state device.State) []uint32 {
+	dps := make([]uint32, 0, len(state))
+	for dp := range state {
+		dps = append(dps, dp)
+	}
+	sort.Slice(dps, func(i, j int) bool { return dps[i] < dps[j] })
+	return dps
+}
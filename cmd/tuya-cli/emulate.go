@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/tuyatest"
+)
+
+func runEmulate(fs *flag.FlagSet, args []string) error {
+	id := fs.String("id", "", "device ID")
+	key := fs.String("key", "", "local key, 16 bytes")
+	version := fs.String("version", net.Version33, "protocol version: 3.1 or 3.3")
+	productKey := fs.String("product-key", "", "product key to broadcast")
+	listen := fs.String("listen", fmt.Sprintf(":%d", net.ClientPort), "address to accept connections on")
+	announce := fs.Duration("announce", 5*time.Second, "status broadcast interval; 0 disables broadcasts")
+	fs.Parse(args)
+	if *id == "" || *key == "" {
+		return errors.New("usage: emulate -id ID -key KEY [flags] [dp=value...]")
+	}
+	dps, err := parseState(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	d, err := tuyatest.New(*id, *key, *version, dps)
+	if err != nil {
+		return err
+	}
+	d.ProductKey = *productKey
+	if err := d.Listen(*listen); err != nil {
+		return err
+	}
+	defer d.Close()
+	log.Printf("emulating %s (%s) on %s", *id, *version, d.Addr())
+
+	if *announce > 0 {
+		a, err := d.Announce(*announce)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+	}
+	select {}
+}
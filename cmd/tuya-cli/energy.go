@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/lann/tuya/device"
+)
+
+// An energy reading, as output by energy.
+type energyRecord struct {
+	Time    time.Time `json:"time"`
+	ID      string    `json:"id"`
+	Voltage float64   `json:"voltage"`
+	Current float64   `json:"current"`
+	Power   float64   `json:"power"`
+	Energy  float64   `json:"energy"`
+}
+
+func runEnergy(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	var of outputFlags
+	df.register(fs)
+	of.register(fs)
+	layout := fs.String("layout", "plug", "dp layout: plug (dps 17-20) or meter (DIN rail)")
+	interval := fs.Duration("interval", 0, "keep printing readings at this interval (0 prints once)")
+	args, err := df.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+
+	enc, err := of.encoder(*interval > 0)
+	if err != nil {
+		return err
+	}
+	var dps device.EnergyDPs
+	switch *layout {
+	case "plug":
+		dps = device.EnergyDPsPlug
+	case "meter":
+		dps = device.EnergyDPsMeter
+	default:
+		return fmt.Errorf("bad -layout %q", *layout)
+	}
+
+	m, err := df.dial()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	energy := device.NewEnergy(m, dps)
+
+	for {
+		reading, err := energy.Read(true)
+		if err != nil {
+			return err
+		}
+		err = enc.encode(energyRecord{
+			Time:    reading.Time,
+			ID:      df.id,
+			Voltage: reading.Voltage,
+			Current: reading.Current,
+			Power:   reading.Power,
+			Energy:  reading.Energy,
+		})
+		if err != nil {
+			return err
+		}
+		if *interval <= 0 {
+			return enc.close()
+		}
+		time.Sleep(*interval)
+	}
+}
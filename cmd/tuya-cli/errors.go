@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	stdnet "net"
+
+	"github.com/lann/tuya/net"
+)
+
+// Exit codes. 2 is used for usage errors, as by the flag package.
+const (
+	exitFailure     = 1
+	exitUsage       = 2
+	exitUnreachable = 3
+	exitAuth        = 4
+	exitRejected    = 5
+	exitTimeout     = 6
+)
+
+// Names of exit codes, as output with -error-json.
+var exitKinds = map[int]string{
+	exitFailure:     "failure",
+	exitUsage:       "usage",
+	exitUnreachable: "unreachable",
+	exitAuth:        "auth",
+	exitRejected:    "rejected",
+	exitTimeout:     "timeout",
+}
+
+// A cliError attaches an exit code to an error.
+type cliError struct {
+	code int
+	err  error
+}
+
+func (e cliError) Error() string {
+	return e.err.Error()
+}
+
+// Mark an error as the device being unreachable.
+func unreachable(err error) error {
+	return cliError{exitUnreachable, err}
+}
+
+// Mark an error as a timeout waiting for the device.
+func timedOut(err error) error {
+	return cliError{exitTimeout, err}
+}
+
+// Mark an error as a key or decryption failure.
+func authFailure(err error) error {
+	return cliError{exitAuth, err}
+}
+
+// Return the exit code for an error returned by a command.
+func exitCode(err error) int {
+	var ce cliError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	if errors.As(err, &net.ResponseError{}) {
+		return exitRejected
+	}
+	for _, cause := range []error{net.ErrNoKey, net.ErrTagVerification, net.ErrPadding, net.ErrTooSmall} {
+		if errors.Is(err, cause) {
+			return exitAuth
+		}
+	}
+	var ne stdnet.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return exitTimeout
+	}
+	var oe *stdnet.OpError
+	if errors.As(err, &oe) && oe.Op == "dial" {
+		return exitUnreachable
+	}
+	return exitFailure
+}
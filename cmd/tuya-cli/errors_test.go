@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"testing"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{errors.New("boom"), exitFailure},
+		{unreachable(errors.New("no route")), exitUnreachable},
+		{timedOut(errors.New("no reply")), exitTimeout},
+		{authFailure(errors.New("bad key")), exitAuth},
+		{fmt.Errorf("lamp: %w", unreachable(errors.New("no route"))), exitUnreachable},
+		{net.ResponseError{Code: 1, Message: "rejected"}, exitRejected},
+		{fmt.Errorf("SetState: %w", net.ResponseError{Code: 1}), exitRejected},
+		{device.ErrTimeout, exitTimeout},
+		{fmt.Errorf("GetState: %w", context.DeadlineExceeded), exitTimeout},
+		{&stdnet.OpError{Op: "read", Err: timeoutError{}}, exitTimeout},
+		{fmt.Errorf("Decrypt: %w", net.ErrTagVerification), exitAuth},
+		{fmt.Errorf("request: %w", net.ErrNoKey), exitAuth},
+		{fmt.Errorf("lamp: Dial: %w", &stdnet.OpError{Op: "dial", Err: errors.New("connection refused")}), exitUnreachable},
+		{fmt.Errorf("Dial: %w", &stdnet.OpError{Op: "dial", Err: timeoutError{}}), exitTimeout},
+	} {
+		if got := exitCode(tc.err); got != tc.want {
+			t.Errorf("exitCode(%v) = %s, want %s", tc.err, exitKinds[got], exitKinds[tc.want])
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// Directions of exported frames.
+const (
+	dirToDevice   = "to-device"
+	dirFromDevice = "from-device"
+	dirBroadcast  = "broadcast"
+)
+
+// Names of known command numbers.
+var cmdNames = map[uint32]string{
+	0x07: "control",
+	0x08: "status",
+	0x09: "heartbeat",
+	0x0a: "query",
+	0x10: "query-new",
+	0x12: "refresh",
+	0x13: "broadcast",
+}
+
+// A frameExporter writes decoded frames as JSON lines, one object per frame,
+// for analysis without Wireshark and for diffing traffic across firmware
+// versions; see -export.
+type frameExporter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// Open an export destination: a file path, or "-" for stdout.
+func newFrameExporter(path string) (*frameExporter, error) {
+	w := io.Writer(os.Stdout)
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &frameExporter{w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (e *frameExporter) export(record frameRecord) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(record)
+}
+
+// Return Hooks exporting the frames a Client or proxy sends and receives.
+func (e *frameExporter) hooks() net.Hooks {
+	return net.Hooks{
+		Sent: func(f *net.Frame, plaintext []byte) {
+			e.export(hookRecord(dirToDevice, f, plaintext, nil))
+		},
+		Received: func(f *net.Frame, plaintext []byte, err error) {
+			e.export(hookRecord(dirFromDevice, f, plaintext, err))
+		},
+	}
+}
+
+// Build a record of a frame seen by Hooks.
+func hookRecord(dir string, f *net.Frame, plaintext []byte, err error) frameRecord {
+	now := time.Now()
+	record := frameRecord{
+		Time:      &now,
+		Dir:       dir,
+		Seq:       f.Seq,
+		Cmd:       f.Cmd,
+		CmdName:   cmdNames[f.Cmd],
+		Encrypted: !bytes.Equal(plaintext, f.Payload),
+	}
+	var payload []byte
+	record.Code, payload = splitCode(plaintext)
+	record.Payload = printable(payload)
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// The direction of a captured packet, judged by its ports.
+func packetDir(p *packet) string {
+	switch {
+	case !p.tcp:
+		return dirBroadcast
+	case p.dstPort == net.ClientPort:
+		return dirToDevice
+	case p.srcPort == net.ClientPort:
+		return dirFromDevice
+	}
+	return ""
+}
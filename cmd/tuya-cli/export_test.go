@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	stdnet "net"
+	"strings"
+	"testing"
+
+	"github.com/lann/tuya/net"
+)
+
+const testKey = "0123456789abcdef"
+
+func TestFrameExporter(t *testing.T) {
+	var buf bytes.Buffer
+	e := &frameExporter{w: &buf, enc: json.NewEncoder(&buf)}
+
+	clientConn, deviceConn := stdnet.Pipe()
+	defer clientConn.Close()
+	defer deviceConn.Close()
+	client, err := net.ClientConfig{Key: testKey, Version: net.Version33, Hooks: e.hooks()}.NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := net.ServerConfig{Key: testKey, Version: net.Version33}.NewConn(deviceConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		f, err := dev.Read()
+		if err == nil {
+			dev.Reply(f, 0, []byte(`{"dps":{"1":true}}`))
+		}
+	}()
+	if _, err := client.Write(0x07, true, map[string]interface{}{"dps": map[string]bool{"1": false}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("%q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+
+	for i, want := range []struct {
+		dir     string
+		code    interface{}
+		payload string
+	}{
+		{dirToDevice, nil, `"dps":{"1":false}`},
+		{dirFromDevice, 0.0, `{"dps":{"1":true}}`},
+	} {
+		r := records[i]
+		for _, field := range []string{"time", "dir", "seq", "cmd", "cmdName", "code", "encrypted", "payload"} {
+			if _, ok := r[field]; !ok {
+				t.Errorf("record %d has no %q field: %v", i, field, r)
+			}
+		}
+		if r["dir"] != want.dir || r["cmd"] != 7.0 || r["cmdName"] != "control" || r["encrypted"] != true {
+			t.Errorf("record %d = %v", i, r)
+		}
+		if r["code"] != want.code {
+			t.Errorf("record %d code = %v, want %v", i, r["code"], want.code)
+		}
+		if payload, _ := r["payload"].(string); !strings.Contains(payload, want.payload) {
+			t.Errorf("record %d payload = %q, want decrypted %s", i, payload, want.payload)
+		}
+		if _, ok := r["error"]; ok {
+			t.Errorf("record %d has an error: %v", i, r)
+		}
+	}
+}
+
+func TestHookRecordBinary(t *testing.T) {
+	f := &net.Frame{Seq: 3, Cmd: 0x09, Payload: []byte{0, 0, 0, 1, 0xff}}
+	r := hookRecord(dirFromDevice, f, f.Payload, nil)
+	if r.Code == nil || *r.Code != 1 || r.Payload != "ff" || r.Encrypted || r.CmdName != "heartbeat" {
+		t.Errorf("got %+v", r)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/lann/tuya/device"
+)
+
+// The outcome for one device, as output by group.
+type groupRecord struct {
+	Name  string `json:"name"`
+	ID    string `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+func runGroup(fs *flag.FlagSet, args []string) error {
+	var of outputFlags
+	of.register(fs)
+	configPath := fs.String("config", defaultConfigPath(), "config file of named devices and groups")
+	dp := fs.Uint("dp", 1, "dp switched by on and off")
+	timeout := fs.Duration("timeout", 30*time.Second, "how long to wait for broadcasts from devices without an ip")
+	fs.Parse(args)
+
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errors.New("usage: group [flags] <group> on|off|dp=value...")
+	}
+	var state device.State
+	switch action := fs.Arg(1); action {
+	case "on", "off":
+		if fs.NArg() > 2 {
+			return fmt.Errorf("unexpected arguments %q", fs.Args()[2:])
+		}
+		state = device.State{uint32(*dp): action == "on"}
+	default:
+		if state, err = parseState(fs.Args()[1:]); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	names, ok := cfg.Groups[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("no group %q in %s", fs.Arg(0), *configPath)
+	}
+
+	fleet, ids, err := cfg.fleet(names, *timeout)
+	if err != nil {
+		return fmt.Errorf("group %q: %v", fs.Arg(0), err)
+	}
+	defer fleet.Close()
+
+	failed := 0
+	for i, result := range fleet.SetState(state, ids...) {
+		record := groupRecord{Name: names[i], ID: result.ID, OK: result.Err == nil}
+		if result.Err != nil {
+			record.Error = result.Err.Error()
+			failed++
+		}
+		if err := enc.encode(record); err != nil {
+			return err
+		}
+	}
+	if err := enc.close(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d devices failed", failed, len(ids))
+	}
+	return nil
+}
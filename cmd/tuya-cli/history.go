@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/lann/tuya/history"
+)
+
+func runHistory(fs *flag.FlagSet, args []string) error {
+	dir := fs.String("dir", "", "history directory, as given to serve -history")
+	configPath := fs.String("config", defaultConfigPath(), "config file of named devices and groups")
+	format := fs.String("format", history.FormatCSV, "export format: csv or jsonl")
+	output := fs.String("o", "", "file to write; default stdout")
+	dp := fs.Uint("dp", 0, "only export this dp")
+	from := fs.String("from", "", "start time, RFC 3339")
+	to := fs.String("to", "", "end time, RFC 3339")
+	since := fs.Duration("since", 0, "export this long before now; overrides -from")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.Arg(0) != "export" {
+		return errors.New("usage: history [flags] export [device|group...]")
+	}
+	if *dir == "" {
+		return errors.New("-dir is required")
+	}
+	if *format != history.FormatCSV && *format != history.FormatJSONLines {
+		return fmt.Errorf("bad -format %q", *format)
+	}
+
+	q := history.Query{DP: uint32(*dp)}
+	var err error
+	if *from != "" {
+		if q.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			return fmt.Errorf("-from: %v", err)
+		}
+	}
+	if *to != "" {
+		if q.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			return fmt.Errorf("-to: %v", err)
+		}
+	}
+	if *since > 0 {
+		q.From = time.Now().Add(-*since)
+	}
+
+	// Devices are given by name or ID.
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	if fs.NArg() > 1 {
+		for _, name := range cfg.expand(fs.Args()[1:]) {
+			if dev, ok := cfg.Devices[name]; ok {
+				name = dev.ID
+			}
+			ids = append(ids, name)
+		}
+	}
+
+	store, err := history.OpenFileStore(*dir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	var records []history.Record
+	if len(ids) == 0 {
+		records, err = store.Query(q)
+	} else {
+		for _, id := range ids {
+			q.ID = id
+			found, err := store.Query(q)
+			if err != nil {
+				return err
+			}
+			records = append(records, found...)
+		}
+		sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	}
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return history.Export(os.Stdout, records, *format)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := history.Export(f, records, *format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
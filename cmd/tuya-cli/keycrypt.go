@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Local keys in the config file may be encrypted with a passphrase, given in
+// $TUYA_CONFIG_PASSPHRASE, so they aren't stored on disk in plaintext. An
+// encrypted key is stored as "enc1:" and the base64 of a salt, nonce, and
+// AES-256-GCM ciphertext, with the AES key derived from the passphrase and
+// salt by PBKDF2-HMAC-SHA256. `keys encrypt` encrypts an existing file's keys,
+// and with the passphrase set, keys saved by serve's API are encrypted too.
+const (
+	encryptedKeyPrefix = "enc1:"
+	passphraseEnv      = "TUYA_CONFIG_PASSPHRASE"
+	kdfIterations      = 600000
+	kdfSaltSize        = 16
+)
+
+var errNoPassphrase = errors.New("config file has encrypted keys; set $" + passphraseEnv)
+
+// A keyCrypter en/decrypts config file keys with a passphrase. Derived AES
+// keys are cached by salt, since derivation is deliberately slow.
+type keyCrypter struct {
+	passphrase []byte
+	mu         sync.Mutex
+	salt       []byte // used for encrypting
+	aeads      map[string]cipher.AEAD
+}
+
+var (
+	configCrypterOnce sync.Once
+	configCrypter     *keyCrypter
+)
+
+// Return the keyCrypter for $TUYA_CONFIG_PASSPHRASE, or nil if it's unset.
+func passphraseCrypter() *keyCrypter {
+	configCrypterOnce.Do(func() {
+		if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+			configCrypter = &keyCrypter{passphrase: []byte(passphrase)}
+		}
+	})
+	return configCrypter
+}
+
+func isEncryptedKey(key string) bool {
+	return strings.HasPrefix(key, encryptedKeyPrefix)
+}
+
+// Return the AEAD for salt, deriving its key if needed. Called with kc.mu
+// held.
+func (kc *keyCrypter) aead(salt []byte) (cipher.AEAD, error) {
+	if aead, ok := kc.aeads[string(salt)]; ok {
+		return aead, nil
+	}
+	key := pbkdf2SHA256(kc.passphrase, salt, kdfIterations, 32)
+	block, err := aes.NewCipher(key)
+	for i := range key {
+		key[i] = 0
+	}
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if kc.aeads == nil {
+		kc.aeads = make(map[string]cipher.AEAD)
+	}
+	kc.aeads[string(salt)] = aead
+	return aead, nil
+}
+
+func (kc *keyCrypter) encrypt(key string) (string, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	if kc.salt == nil {
+		kc.salt = make([]byte, kdfSaltSize)
+		if _, err := rand.Read(kc.salt); err != nil {
+			return "", err
+		}
+	}
+	aead, err := kc.aead(kc.salt)
+	if err != nil {
+		return "", err
+	}
+	data := make([]byte, kdfSaltSize+aead.NonceSize())
+	copy(data, kc.salt)
+	if _, err := rand.Read(data[kdfSaltSize:]); err != nil {
+		return "", err
+	}
+	data = aead.Seal(data, data[kdfSaltSize:], []byte(key), nil)
+	return encryptedKeyPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (kc *keyCrypter) decrypt(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, encryptedKeyPrefix))
+	if err != nil {
+		return "", fmt.Errorf("encrypted key: %v", err)
+	}
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	if len(data) < kdfSaltSize {
+		return "", errors.New("encrypted key too short")
+	}
+	salt := data[:kdfSaltSize]
+	aead, err := kc.aead(salt)
+	if err != nil {
+		return "", err
+	}
+	data = data[kdfSaltSize:]
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("encrypted key too short")
+	}
+	key, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("can't decrypt key; wrong passphrase?")
+	}
+	if kc.salt == nil {
+		// Keep using the file's salt, so saving doesn't derive a new key.
+		kc.salt = append([]byte(nil), salt...)
+	}
+	s = string(key)
+	for i := range key {
+		key[i] = 0
+	}
+	return s, nil
+}
+
+// PBKDF2 (RFC 8018) with HMAC-SHA256.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	var block [4]byte
+	for i := uint32(1); len(key) < keyLen; i++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(block[:], i)
+		prf.Write(block[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// From RFC 7914, section 11.
+	for _, tc := range []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		got := pbkdf2SHA256([]byte(tc.password), []byte(tc.salt), tc.iterations, len(tc.want)/2)
+		if hex.EncodeToString(got) != tc.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d) = %x", tc.password, tc.salt, tc.iterations, got)
+		}
+	}
+}
+
+func TestKeyCrypterRoundTrip(t *testing.T) {
+	kc := &keyCrypter{passphrase: []byte("correct horse")}
+	encrypted, err := kc.encrypt(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncryptedKey(encrypted) || strings.Contains(encrypted, testKey) {
+		t.Fatalf("encrypt = %q", encrypted)
+	}
+	again, err := kc.encrypt(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encrypted {
+		t.Error("encrypting twice gave the same ciphertext; nonces must differ")
+	}
+
+	// A fresh crypter, as when the file is next loaded, must derive the
+	// same key from the stored salt.
+	for _, s := range []string{encrypted, again} {
+		key, err := (&keyCrypter{passphrase: []byte("correct horse")}).decrypt(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != testKey {
+			t.Errorf("decrypt = %q, want %q", key, testKey)
+		}
+	}
+}
+
+func TestKeyCrypterWrongPassphrase(t *testing.T) {
+	encrypted, err := (&keyCrypter{passphrase: []byte("correct horse")}).encrypt(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := (&keyCrypter{passphrase: []byte("battery staple")}).decrypt(encrypted)
+	if err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("decrypt with wrong passphrase = %q, %v", key, err)
+	}
+}
+
+func TestKeyCrypterCorrupt(t *testing.T) {
+	kc := &keyCrypter{passphrase: []byte("correct horse")}
+	encrypted, err := kc.encrypt(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, encryptedKeyPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(b []byte) string {
+		return encryptedKeyPrefix + base64.StdEncoding.EncodeToString(b)
+	}
+	flipped := append([]byte(nil), data...)
+	flipped[len(flipped)-1] ^= 1
+
+	for name, s := range map[string]string{
+		"not base64":       encryptedKeyPrefix + "!!!",
+		"empty":            encryptedKeyPrefix,
+		"short salt":       encode(data[:kdfSaltSize-1]),
+		"no nonce":         encode(data[:kdfSaltSize+4]),
+		"no ciphertext":    encode(data[:kdfSaltSize+12]),
+		"truncated":        encode(data[:len(data)-1]),
+		"flipped":          encode(flipped),
+		"extra":            encode(append(append([]byte(nil), data...), 0)),
+		"wrong salt":       encode(append(make([]byte, kdfSaltSize), data[kdfSaltSize:]...)),
+		"plaintext as key": encode([]byte(testKey)),
+	} {
+		if key, err := kc.decrypt(s); err == nil {
+			t.Errorf("%s: decrypt = %q, want error", name, key)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+)
+
+func runKeys(fs *flag.FlagSet, args []string) error {
+	configPath := fs.String("config", defaultConfigPath(), "config file of named devices")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: keys [flags] encrypt|decrypt")
+	}
+	kc := passphraseCrypter()
+	if kc == nil {
+		return fmt.Errorf("set $%s to the passphrase", passphraseEnv)
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	switch fs.Arg(0) {
+	case "encrypt":
+	case "decrypt":
+		kc = nil
+	default:
+		return fmt.Errorf("unknown keys command %q", fs.Arg(0))
+	}
+	if err := writeConfig(*configPath, cfg, kc); err != nil {
+		return err
+	}
+	log.Printf("%sed %d device keys in %s", fs.Arg(0), len(cfg.Devices), *configPath)
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// A lineEditor reads command lines, with history and tab completion when the
+// input is a terminal that supports it.
+type lineEditor struct {
+	prompt   string
+	history  []string
+	complete func(line string) []string
+
+	in  *bufio.Reader
+	out io.Writer
+
+	// raw is true when the terminal is in raw mode and lines are edited here.
+	raw     bool
+	restore func()
+
+	// Protects output, which may come from other goroutines while a line is
+	// being edited.
+	mu   sync.Mutex
+	line []rune
+}
+
+func newLineEditor(prompt string, complete func(string) []string) *lineEditor {
+	e := &lineEditor{
+		prompt:   prompt,
+		complete: complete,
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+	}
+	if restore, err := makeRaw(os.Stdin); err == nil {
+		e.raw = true
+		e.restore = restore
+	}
+	return e
+}
+
+// Close restores the terminal mode.
+func (e *lineEditor) Close() {
+	if e.restore != nil {
+		e.restore()
+	}
+}
+
+// Printf prints a message above the line being edited.
+func (e *lineEditor) Printf(format string, args ...interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.raw {
+		fmt.Fprint(e.out, "\r\x1b[K")
+	}
+	fmt.Fprintf(e.out, format, args...)
+	if e.raw {
+		e.redraw()
+	}
+}
+
+// Redraw the prompt and current line. Must be called with the lock held.
+func (e *lineEditor) redraw() {
+	fmt.Fprintf(e.out, "\r\x1b[K%s%s", e.prompt, string(e.line))
+}
+
+// ReadLine reads the next line, returning io.EOF at end of input or on ^D.
+func (e *lineEditor) ReadLine() (string, error) {
+	if !e.raw {
+		fmt.Fprint(e.out, e.prompt)
+		line, err := e.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	e.mu.Lock()
+	e.line = e.line[:0]
+	e.redraw()
+	e.mu.Unlock()
+	histPos := len(e.history)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		e.mu.Lock()
+		switch r {
+		case '\r', '\n':
+			line := string(e.line)
+			fmt.Fprint(e.out, "\r\n")
+			e.line = e.line[:0]
+			e.mu.Unlock()
+			if strings.TrimSpace(line) != "" {
+				e.history = append(e.history, line)
+			}
+			return line, nil
+		case 3: // ^C discards the line
+			fmt.Fprint(e.out, "^C\r\n")
+			e.line = e.line[:0]
+		case 4: // ^D on an empty line is EOF
+			if len(e.line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				e.mu.Unlock()
+				return "", io.EOF
+			}
+		case 127, 8: // Backspace
+			if len(e.line) > 0 {
+				e.line = e.line[:len(e.line)-1]
+			}
+		case '\t':
+			e.tab()
+		case 27: // Escape sequence; only up and down arrows are handled
+			seq := make([]byte, 2)
+			if _, err := io.ReadFull(e.in, seq); err == nil && seq[0] == '[' {
+				switch seq[1] {
+				case 'A':
+					if histPos > 0 {
+						histPos--
+						e.line = []rune(e.history[histPos])
+					}
+				case 'B':
+					if histPos < len(e.history) {
+						histPos++
+					}
+					e.line = e.line[:0]
+					if histPos < len(e.history) {
+						e.line = []rune(e.history[histPos])
+					}
+				}
+			}
+		default:
+			if r >= ' ' && r != utf8.RuneError {
+				e.line = append(e.line, r)
+			}
+		}
+		e.redraw()
+		e.mu.Unlock()
+	}
+}
+
+// Complete the last word of the line. Must be called with the lock held.
+func (e *lineEditor) tab() {
+	if e.complete == nil {
+		return
+	}
+	line := string(e.line)
+	matches := e.complete(line)
+	if len(matches) == 0 {
+		return
+	}
+	word := line[strings.LastIndexByte(line, ' ')+1:]
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(matches) == 1 {
+		prefix += " "
+	} else if prefix == word {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(matches, "  "))
+	}
+	e.line = append(e.line, []rune(prefix[len(word):])...)
+}
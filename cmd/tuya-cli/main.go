@@ -1,50 +1,85 @@
+// Command tuya-cli discovers and controls Tuya devices on the local network.
 package main
 
 import (
-	"log"
-
-	"github.com/lann/tuya/device"
-	"github.com/lann/tuya/net"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
 )
 
-func main() {
-	l, err := net.NewStatusListener()
-	if err != nil {
-		log.Fatalf("NewStatusListener failed: %v", err)
-	}
-	defer l.Close()
-
-	for {
-		status, err := l.ReadStatus()
-		fatalErr(err, "ReadStatus failed")
-
-		log.Printf("Device: %#v", status)
-
-		config := status.ClientConfig()
-		config.Key = "f4f603d680c9d23d"
-		client, err := config.Dial()
-		fatalErr(err, "Dial failed")
-
-		man := device.NewManager(status.GatewayID, client)
-
-		state, err := man.GetState()
-		fatalErr(err, "GetState failed")
-
-		log.Printf("State: %v", state)
+// A command is a tuya-cli subcommand.
+type command struct {
+	usage string
+	run   func(fs *flag.FlagSet, args []string) error
+}
 
-		//state[1] = !state[1].(bool)
-		//err = man.SetState(state)
-		//fatalErr(err, "SetState failed")
+var commands = map[string]command{
+	"bench":       {"measure connection and request latency: bench [flags] <device>", runBench},
+	"bulb":        {"set bulb color, brightness, or temperature", runBulb},
+	"conformance": {"check how a device follows the protocol: conformance [flags] <device>", runConformance},
+	"crypt":       {"encrypt or decrypt a payload: crypt -key K -encrypt|-decrypt <blob>", runCrypt},
+	"decode":      {"decode frames from a pcap, hex dump, or byte stream", runDecode},
+	"discover":    {"listen for device broadcasts and list devices", runDiscover},
+	"emulate":     {"emulate a device for testing: emulate -id ID -key KEY [flags] [dp=value...]", runEmulate},
+	"energy":      {"print voltage, current, and power readings", runEnergy},
+	"get":         {"print a device's dps", runGet},
+	"group":       {"switch a configured group: group [flags] <group> on|off|dp=value...", runGroup},
+	"history":     {"export recorded dp history: history [flags] export [device|group...]", runHistory},
+	"keys":        {"encrypt or decrypt the config file's keys with $TUYA_CONFIG_PASSPHRASE: keys [flags] encrypt|decrypt", runKeys},
+	"probe":       {"detect a device's protocol version: probe [flags] <ip|device>", runProbe},
+	"proxy":       {"relay and decode traffic between an app and a device: proxy [flags] <device>", runProxy},
+	"raw":         {"send a raw command frame and print the response", runRaw},
+	"replay":      {"replay a recording from -record: replay [flags] <recording> [device]", runReplay},
+	"scan":        {"show a live table of broadcasting devices", runScan},
+	"serve":       {"serve configured devices over HTTP", runServe},
+	"set":         {"set device dps: set [flags] dp=value...", runSet},
+	"shell":       {"interactive shell with persistent connections", runShell},
+	"snapshot":    {"save or restore device dps: snapshot [flags] save|restore <file> [device|group...]", runSnapshot},
+	"sniff":       {"capture and decode live Tuya traffic (Linux only)", runSniff},
+	"status":      {"wait for a device's broadcast and print it", runStatus},
+	"toggle":      {"invert a boolean dp: toggle [flags] <device> [dp]", runToggle},
+	"watch":       {"stream dp changes: watch [flags] [device...]", runWatch},
+}
 
-		man.Close()
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] [args]\n\nCommands:\n", os.Args[0])
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].usage)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for command flags.\n", os.Args[0])
 }
 
-func fatalErr(err error, prefix string) {
-	if err != nil {
-		if prefix != "" {
-			prefix += ": "
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(exitUsage)
+	}
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	errorJSON := fs.Bool("error-json", false, "print errors to stderr as JSON objects")
+	if err := cmd.run(fs, os.Args[2:]); err != nil {
+		code := exitCode(err)
+		if *errorJSON {
+			json.NewEncoder(os.Stderr).Encode(struct {
+				Command string `json:"command"`
+				Error   string `json:"error"`
+				Kind    string `json:"kind"`
+				Code    int    `json:"code"`
+			}{os.Args[1], err.Error(), exitKinds[code], code})
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
 		}
-		log.Fatalf("%s%v", prefix, err)
+		os.Exit(code)
 	}
 }
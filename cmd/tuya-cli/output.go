@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/lann/tuya/device"
+)
+
+// Output formats.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
+
+// Flags selecting the output format.
+type outputFlags struct {
+	format   string
+	template string
+}
+
+func (o *outputFlags) register(fs *flag.FlagSet) {
+	o.registerDefault(fs, formatTable)
+}
+
+func (o *outputFlags) registerDefault(fs *flag.FlagSet, format string) {
+	fs.StringVar(&o.format, "output", format, "output format: table, json, or yaml")
+	fs.StringVar(&o.template, "format", "", "Go template applied to each record, e.g. '{{.ID}}'; overrides -output")
+}
+
+// Return an encoder writing to stdout in the selected format. Streaming
+// encoders write each record immediately, at the cost of table alignment.
+func (o *outputFlags) encoder(stream bool) (*encoder, error) {
+	return o.newEncoder(os.Stdout, stream)
+}
+
+// Return an encoder writing to w in the selected format.
+func (o *outputFlags) newEncoder(w io.Writer, stream bool) (*encoder, error) {
+	switch o.format {
+	case formatTable, formatJSON, formatYAML:
+	default:
+		return nil, fmt.Errorf("bad -output %q", o.format)
+	}
+	e := &encoder{
+		format: o.format,
+		w:      w,
+		tw:     tabwriter.NewWriter(w, 0, 8, 2, ' ', 0),
+		stream: stream,
+	}
+	if o.template != "" {
+		tmpl, err := template.New("format").Funcs(templateFuncs).Parse(o.template)
+		if err != nil {
+			return nil, fmt.Errorf("bad -format: %v", err)
+		}
+		e.template = tmpl
+	}
+	return e, nil
+}
+
+// Functions available to -format templates.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+	// dp looks up a dp by number, which index can't do with uint32 keys.
+	"dp": func(state device.State, dp int) interface{} {
+		return state[uint32(dp)]
+	},
+}
+
+// An encoder writes a stream of records. Records are structs, whose fields
+// are named by their json tags, or maps. JSON records are written one per
+// line and YAML records as separate documents, so streams can be consumed
+// incrementally. Tables flatten nested values into dotted column names and
+// print a header before the first row. A template, if set, is executed with
+// each record, which is followed by a newline; templates see Go field names.
+type encoder struct {
+	format   string
+	template *template.Template
+	w        io.Writer
+	tw       *tabwriter.Writer
+	stream   bool
+	rows     int
+}
+
+// A name/value pair from a record.
+type field struct {
+	name  string
+	value interface{}
+}
+
+func (e *encoder) encode(record interface{}) error {
+	defer func() { e.rows++ }()
+	if e.template != nil {
+		if err := e.template.Execute(e.w, record); err != nil {
+			return fmt.Errorf("-format: %v", err)
+		}
+		_, err := fmt.Fprintln(e.w)
+		return err
+	}
+	switch e.format {
+	case formatJSON:
+		return json.NewEncoder(e.w).Encode(record)
+	case formatYAML:
+		if e.rows > 0 {
+			fmt.Fprintln(e.w, "---")
+		}
+		return writeYAML(e.w, fields(record), 0)
+	}
+
+	flat := flatten("", fields(record))
+	if e.rows == 0 {
+		names := make([]string, len(flat))
+		for i, f := range flat {
+			names[i] = strings.ToUpper(f.name)
+		}
+		fmt.Fprintln(e.tw, strings.Join(names, "\t"))
+	}
+	values := make([]string, len(flat))
+	for i, f := range flat {
+		values[i] = tableValue(f.value)
+	}
+	fmt.Fprintln(e.tw, strings.Join(values, "\t"))
+	if e.stream {
+		return e.tw.Flush()
+	}
+	return nil
+}
+
+// Flush any buffered table rows.
+func (e *encoder) close() error {
+	return e.tw.Flush()
+}
+
+// Return the fields of a struct or map, or nil for other values.
+func fields(v interface{}) []field {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	var fs []field
+	switch rv.Kind() {
+	case reflect.Struct:
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			sf := rt.Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(sf.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = sf.Name
+			}
+			fs = append(fs, field{name, rv.Field(i).Interface()})
+		}
+	case reflect.Map:
+		for _, k := range rv.MapKeys() {
+			fs = append(fs, field{fmt.Sprint(k.Interface()), rv.MapIndex(k).Interface()})
+		}
+		sort.Slice(fs, func(i, j int) bool {
+			// Sort numeric keys (like dps) numerically.
+			ni, erri := strconv.Atoi(fs[i].name)
+			nj, errj := strconv.Atoi(fs[j].name)
+			if erri == nil && errj == nil {
+				return ni < nj
+			}
+			return fs[i].name < fs[j].name
+		})
+	}
+	return fs
+}
+
+// Format a scalar for a table cell, dereferencing pointers.
+func tableValue(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return "-"
+		}
+		return fmt.Sprint(rv.Elem().Interface())
+	}
+	return fmt.Sprint(v)
+}
+
+// Flatten nested records into dotted field names.
+func flatten(prefix string, fs []field) []field {
+	var flat []field
+	for _, f := range fs {
+		name := prefix + f.name
+		if nested := fields(f.value); nested != nil {
+			flat = append(flat, flatten(name+".", nested)...)
+		} else {
+			flat = append(flat, field{name, f.value})
+		}
+	}
+	return flat
+}
+
+func writeYAML(w io.Writer, fs []field, indent int) error {
+	pad := strings.Repeat("  ", indent)
+	for _, f := range fs {
+		key := yamlKey(f.name)
+		if nested := fields(f.value); nested != nil {
+			if _, err := fmt.Fprintf(w, "%s%s:\n", pad, key); err != nil {
+				return err
+			}
+			if err := writeYAML(w, nested, indent+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s%s: %s\n", pad, key, yamlScalar(f.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Format a key for YAML, quoting it unless it is a plain identifier.
+func yamlKey(key string) string {
+	for i, c := range key {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return strconv.Quote(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+// Format a scalar for YAML. Strings are always quoted so that values like
+// "true" or "1" keep their type.
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	case bool, int, int32, int64, uint, uint32, uint64, float64:
+		return fmt.Sprint(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return strconv.Quote(fmt.Sprint(v))
+	}
+	// JSON is valid YAML flow syntax.
+	return string(data)
+}
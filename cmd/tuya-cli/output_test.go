@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/lann/tuya/device"
+)
+
+type testRecord struct {
+	ID     string       `json:"id"`
+	On     bool         `json:"on"`
+	Power  *float64     `json:"power"`
+	State  device.State `json:"state"`
+	Hidden string       `json:"-"`
+	Plain  int
+}
+
+func testRecords() []interface{} {
+	power := 12.5
+	return []interface{}{
+		testRecord{ID: "lamp", On: true, Power: &power, State: device.State{10: "x", 2: 1.5}, Hidden: "h", Plain: 1},
+		&testRecord{ID: "fan-2", State: device.State{2: 0.0, 10: "y"}},
+	}
+}
+
+// Encode records with the given flags, returning the output.
+func encodeRecords(t *testing.T, o outputFlags, stream bool, records ...interface{}) string {
+	t.Helper()
+	var buf bytes.Buffer
+	e, err := o.newEncoder(&buf, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := e.encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestEncoderFormats(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		stream bool
+		want   string
+	}{
+		{
+			format: formatTable,
+			want: `ID     ON     POWER  STATE.2  STATE.10  PLAIN
+lamp   true   12.5   1.5      x         1
+fan-2  false  -      0        y         0
+`,
+		},
+		{
+			// Streamed rows are flushed one at a time, so aren't aligned
+			// with later ones.
+			format: formatTable,
+			stream: true,
+			want: `ID    ON    POWER  STATE.2  STATE.10  PLAIN
+lamp  true  12.5   1.5      x         1
+fan-2  false  -  0  y  0
+`,
+		},
+		{
+			format: formatJSON,
+			want: `{"id":"lamp","on":true,"power":12.5,"state":{"10":"x","2":1.5},"Plain":1}
+{"id":"fan-2","on":false,"power":null,"state":{"10":"y","2":0},"Plain":0}
+`,
+		},
+		{
+			format: formatYAML,
+			want: `id: "lamp"
+on: true
+power: 12.5
+state:
+  "2": 1.5
+  "10": "x"
+Plain: 1
+---
+id: "fan-2"
+on: false
+power: null
+state:
+  "2": 0
+  "10": "y"
+Plain: 0
+`,
+		},
+	} {
+		got := encodeRecords(t, outputFlags{format: tc.format}, tc.stream, testRecords()...)
+		if got != tc.want {
+			t.Errorf("%s (stream %v) got:\n%s\nwant:\n%s", tc.format, tc.stream, got, tc.want)
+		}
+	}
+}
+
+func TestEncoderTemplate(t *testing.T) {
+	for _, tc := range []struct {
+		template string
+		records  []interface{}
+		want     string
+	}{
+		{`{{.ID}}`, testRecords(), "lamp\nfan-2\n"},
+		{`{{.ID}} {{dp .State 10}}`, testRecords(), "lamp x\nfan-2 y\n"},
+		{`{{json .State}}`, testRecords(), "{\"10\":\"x\",\"2\":1.5}\n{\"10\":\"y\",\"2\":0}\n"},
+		{`{{join .Words "+"}}`, []interface{}{struct{ Words []string }{[]string{"a", "b"}}}, "a+b\n"},
+	} {
+		// Templates override -output.
+		o := outputFlags{format: formatJSON, template: tc.template}
+		if got := encodeRecords(t, o, false, tc.records...); got != tc.want {
+			t.Errorf("%s got %q, want %q", tc.template, got, tc.want)
+		}
+	}
+}
+
+func TestEncoderErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := (&outputFlags{format: "xml"}).newEncoder(&buf, false); err == nil {
+		t.Error("accepted -output xml")
+	}
+	if _, err := (&outputFlags{format: formatTable, template: "{{.ID"}).newEncoder(&buf, false); err == nil {
+		t.Error("accepted an unterminated -format")
+	}
+
+	e, err := (&outputFlags{format: formatTable, template: "{{.Missing}}"}).newEncoder(&buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.encode(testRecords()[0]); err == nil || !strings.HasPrefix(err.Error(), "-format:") {
+		t.Errorf("encode with a missing field = %v", err)
+	}
+}
+
+func TestYAMLQuoting(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		want  string
+	}{
+		{"true", `"true"`},
+		{"1", `"1"`},
+		{true, "true"},
+		{3, "3"},
+		{nil, "null"},
+		{[]int{1, 2}, "[1,2]"},
+	} {
+		if got := yamlScalar(tc.value); got != tc.want {
+			t.Errorf("yamlScalar(%#v) = %s, want %s", tc.value, got, tc.want)
+		}
+	}
+	for key, want := range map[string]string{
+		"name":   "name",
+		"dp_2":   "dp_2",
+		"2":      `"2"`,
+		"a.b":    `"a.b"`,
+		"":       `""`,
+		"has sp": `"has sp"`,
+	} {
+		if got := yamlKey(key); got != want {
+			t.Errorf("yamlKey(%q) = %s, want %s", key, got, want)
+		}
+	}
+}
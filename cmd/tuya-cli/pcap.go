@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Magic numbers of the classic pcap file format, as read little endian.
+const (
+	pcapMagicMicros = 0xa1b2c3d4
+	pcapMagicNanos  = 0xa1b23c4d
+)
+
+// Link-layer header types.
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLinuxSLL = 113
+)
+
+var errNotPcap = errors.New("not a pcap file")
+
+// A packet is a TCP segment or UDP datagram read from a capture.
+type packet struct {
+	time             time.Time
+	src, dst         string
+	srcPort, dstPort uint16
+	tcp              bool
+	syn              bool
+	seq              uint32
+	payload          []byte
+}
+
+// A pcapReader reads IPv4 TCP and UDP packets from a classic pcap file.
+// pcapng files aren't supported; convert them with `editcap -F pcap`.
+type pcapReader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	nanos bool
+	link  uint32
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, errNotPcap
+	}
+	pr := &pcapReader{r: r}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr[:]) {
+		case pcapMagicMicros:
+			pr.order = order
+		case pcapMagicNanos:
+			pr.order = order
+			pr.nanos = true
+		}
+	}
+	if pr.order == nil {
+		return nil, errNotPcap
+	}
+	pr.link = pr.order.Uint32(hdr[20:])
+	switch pr.link {
+	case linkNull, linkEthernet, linkRaw, linkLinuxSLL:
+	default:
+		return nil, fmt.Errorf("unsupported pcap link type %d", pr.link)
+	}
+	return pr, nil
+}
+
+// Read the next TCP or UDP packet, skipping anything else. Returns io.EOF at
+// the end of the capture.
+func (pr *pcapReader) next() (*packet, error) {
+	for {
+		var hdr [16]byte
+		if _, err := io.ReadFull(pr.r, hdr[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.New("truncated pcap record")
+			}
+			return nil, err
+		}
+		data := make([]byte, pr.order.Uint32(hdr[8:]))
+		if _, err := io.ReadFull(pr.r, data); err != nil {
+			return nil, errors.New("truncated pcap record")
+		}
+		frac := time.Duration(pr.order.Uint32(hdr[4:]))
+		if !pr.nanos {
+			frac *= time.Microsecond
+		}
+		ts := time.Unix(int64(pr.order.Uint32(hdr[:])), int64(frac))
+
+		ip, ok := stripLink(pr.link, data)
+		if !ok {
+			continue
+		}
+		if p := parseIPv4(ip); p != nil {
+			p.time = ts
+			return p, nil
+		}
+	}
+}
+
+// Strip the link-layer header, returning an IPv4 packet.
+func stripLink(link uint32, data []byte) ([]byte, bool) {
+	switch link {
+	case linkNull:
+		if len(data) < 4 {
+			return nil, false
+		}
+		// The address family is in host byte order; AF_INET is 2 everywhere.
+		family := binary.LittleEndian.Uint32(data)
+		if family != 2 && family != 2<<24 {
+			return nil, false
+		}
+		return data[4:], true
+	case linkEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType, data := binary.BigEndian.Uint16(data[12:]), data[14:]
+		for etherType == 0x8100 && len(data) >= 4 {
+			// Skip VLAN tags.
+			etherType, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+		return data, etherType == 0x0800
+	case linkLinuxSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		return data[16:], binary.BigEndian.Uint16(data[14:]) == 0x0800
+	}
+	return data, true
+}
+
+// Parse an IPv4 packet containing a TCP segment or UDP datagram. Returns nil
+// for other packets, fragments, and malformed packets.
+func parseIPv4(ip []byte) *packet {
+	if len(ip) < 20 || ip[0]>>4 != 4 {
+		return nil
+	}
+	ihl := int(ip[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(ip[2:]))
+	if ihl < 20 || total < ihl || total > len(ip) {
+		return nil
+	}
+	if binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 {
+		// Fragmented; Tuya frames are small enough that this is rare.
+		return nil
+	}
+	proto := ip[9]
+	srcIP, dstIP := net.IP(ip[12:16]), net.IP(ip[16:20])
+	body := ip[ihl:total]
+
+	p := &packet{}
+	switch proto {
+	case 6:
+		if len(body) < 20 {
+			return nil
+		}
+		offset := int(body[12]>>4) * 4
+		if offset < 20 || offset > len(body) {
+			return nil
+		}
+		p.tcp = true
+		p.seq = binary.BigEndian.Uint32(body[4:])
+		p.syn = body[13]&0x02 != 0
+		p.payload = body[offset:]
+	case 17:
+		if len(body) < 8 {
+			return nil
+		}
+		p.payload = body[8:]
+	default:
+		return nil
+	}
+	p.srcPort = binary.BigEndian.Uint16(body)
+	p.dstPort = binary.BigEndian.Uint16(body[2:])
+	p.src = fmt.Sprintf("%v:%d", srcIP, p.srcPort)
+	p.dst = fmt.Sprintf("%v:%d", dstIP, p.dstPort)
+	return p
+}
+
+// A tcpStream reassembles one direction of a TCP connection.
+type tcpStream struct {
+	started bool
+	next    uint32
+	pending map[uint32][]byte
+}
+
+// Add a segment, returning any newly contiguous stream data. Retransmitted
+// data is dropped and out-of-order segments are held until the gap is
+// filled; segments lost from the capture stall the stream.
+func (s *tcpStream) add(p *packet) []byte {
+	if p.syn {
+		s.started = true
+		s.next = p.seq + 1
+		return nil
+	}
+	if !s.started {
+		// Capture started mid-connection.
+		s.started = true
+		s.next = p.seq
+	}
+	if s.pending == nil {
+		s.pending = make(map[uint32][]byte)
+	}
+	if len(p.payload) > 0 {
+		s.pending[p.seq] = p.payload
+	}
+
+	var out []byte
+	for progress := true; progress; {
+		progress = false
+		for seq, data := range s.pending {
+			ahead := int32(seq - s.next)
+			if ahead > 0 {
+				continue
+			}
+			delete(s.pending, seq)
+			if int(-ahead) < len(data) {
+				data = data[-ahead:]
+				out = append(out, data...)
+				s.next += uint32(len(data))
+			}
+			progress = true
+		}
+	}
+	return out
+}
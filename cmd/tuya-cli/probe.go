@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	stdnet "net"
+	"strconv"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// Protocol versions tried by probe, in order of preference.
+var probeVersions = []string{net.Version31, net.Version33}
+
+// A device's protocol fingerprint, as output by probe. Attempts maps each
+// version tried to its result.
+type probeRecord struct {
+	IP         string            `json:"ip"`
+	ID         string            `json:"id"`
+	Broadcast  string            `json:"broadcast"`
+	Encrypt    bool              `json:"encrypt"`
+	ProductKey string            `json:"productKey"`
+	Reachable  bool              `json:"reachable"`
+	Attempts   map[string]string `json:"attempts"`
+	Version    string            `json:"version"`
+}
+
+func runProbe(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	var of outputFlags
+	df.register(fs)
+	of.register(fs)
+	wait := fs.Duration("wait", 5*time.Second, "how long to wait for each connection attempt")
+
+	// Accept a bare IP address in place of a device name.
+	fs.Parse(args)
+	var err error
+	if fs.NArg() > 0 && stdnet.ParseIP(fs.Arg(0)) != nil {
+		ip := fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+		args = fs.Args()
+		if df.ip == "" {
+			df.ip = ip
+		}
+	} else if args, err = df.parse(fs, args); err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+	if df.ip == "" && df.id == "" {
+		return errors.New("an IP address, device name, or -id is required")
+	}
+
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+	record := probeRecord{IP: df.ip, ID: df.id, Attempts: make(map[string]string)}
+
+	// The broadcast gives the device's claimed version.
+	var status *net.Status
+	err = readStatuses(df.timeout, func(s *net.Status) bool {
+		if (df.ip != "" && s.IP == df.ip) || (df.id != "" && s.GatewayID == df.id) {
+			status = s
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	versions := probeVersions
+	if status != nil {
+		record.Broadcast = status.Version
+		record.Encrypt = status.Encrypt
+		record.ProductKey = status.ProductKey
+		if record.IP == "" {
+			record.IP = status.IP
+		}
+		if record.ID == "" {
+			record.ID = status.GatewayID
+		}
+		versions = append([]string{status.Version}, probeVersions...)
+	}
+	if record.IP == "" {
+		return unreachable(fmt.Errorf("no broadcast from %s within %v; pass its IP", df.id, df.timeout))
+	}
+
+	addr := stdnet.JoinHostPort(record.IP, strconv.Itoa(net.ClientPort))
+	if conn, err := stdnet.DialTimeout("tcp", addr, *wait); err == nil {
+		conn.Close()
+		record.Reachable = true
+	}
+
+	for _, version := range versions {
+		if _, ok := record.Attempts[version]; ok || version == "" {
+			continue
+		}
+		switch {
+		case !record.Reachable:
+			record.Attempts[version] = "unreachable"
+		case record.ID == "":
+			record.Attempts[version] = "device ID unknown; pass -id"
+		case version == net.Version33 && df.key == "":
+			record.Attempts[version] = "key required"
+		default:
+			config, err := df.clientConfig(&net.Status{IP: record.IP, Version: version})
+			if err != nil {
+				return err
+			}
+			rtt, err := probeQuery(config, record.ID, *wait)
+			if err != nil {
+				record.Attempts[version] = err.Error()
+				continue
+			}
+			record.Attempts[version] = "ok " + rtt.Round(time.Millisecond).String()
+			if record.Version == "" {
+				record.Version = version
+			}
+		}
+	}
+
+	if err := enc.encode(record); err != nil {
+		return err
+	}
+	if err := enc.close(); err != nil {
+		return err
+	}
+	switch {
+	case !record.Reachable:
+		return unreachable(fmt.Errorf("can't connect to %s", addr))
+	case record.Version == "":
+		return authFailure(errors.New("no protocol version worked; check the key and that no other client is connected"))
+	}
+	return nil
+}
+
+// Query a device's dps with the given config, returning the round trip time.
+// Protocol 3.1 queries are unencrypted, so success doesn't verify the key.
+func probeQuery(config net.ClientConfig, id string, wait time.Duration) (time.Duration, error) {
+	client, err := config.Dial()
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	timer := time.AfterFunc(wait, func() { client.Close() })
+	defer timer.Stop()
+
+	start := time.Now()
+	seq, err := client.Write(0x0a, false, map[string]string{"gwId": id, "devId": id})
+	if err != nil {
+		return 0, err
+	}
+	for {
+		res, err := client.Read()
+		if err != nil {
+			if !timer.Stop() {
+				return 0, errors.New("no response within " + wait.String())
+			}
+			return 0, err
+		}
+		if res.Seq != seq {
+			continue
+		}
+		var reply struct {
+			DPs map[string]interface{} `json:"dps"`
+		}
+		if err := res.DecodeJSON(&reply); err != nil {
+			return 0, err
+		}
+		if reply.DPs == nil {
+			return 0, errors.New("reply has no dps: " + strconv.Quote(string(res.Payload)))
+		}
+		return time.Since(start), nil
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	stdnet "net"
+	"strconv"
+
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/proxy"
+)
+
+func runProxy(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	df.register(fs)
+	listen := fs.String("listen", ":6668", "address to accept client connections on")
+	export := fs.String("export", "", "also write decoded frames as JSON lines to this file, or - for stdout")
+	args, err := df.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+	status, err := df.status()
+	if err != nil {
+		return err
+	}
+
+	// Frames are dumped to stderr unless -debug-frames names a file.
+	out := df.debugFrames
+	if out == "" {
+		out = "-"
+	}
+	dumper, err := newFrameDumper(out, df.key)
+	if err != nil {
+		return err
+	}
+	hooks := dumper.hooks()
+	if *export != "" {
+		exporter, err := newFrameExporter(*export)
+		if err != nil {
+			return err
+		}
+		hooks = chainHooks(hooks, exporter.hooks())
+	}
+	if df.record != "" {
+		rec, err := df.recordHooks(status)
+		if err != nil {
+			return err
+		}
+		hooks = chainHooks(hooks, rec)
+	}
+	level, err := net.ParseLevel(df.logLevel)
+	if err != nil {
+		return err
+	}
+
+	p := &proxy.Proxy{
+		Addr:    stdnet.JoinHostPort(status.IP, strconv.Itoa(net.ClientPort)),
+		Key:     df.key,
+		Version: status.Version,
+		Hooks:   hooks,
+		Logger:  &net.StdLogger{Level: level},
+	}
+	log.Printf("relaying %s to %s", *listen, p.Addr)
+	return p.ListenAndServe(*listen)
+}
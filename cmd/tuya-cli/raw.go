@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// A response frame, as output by raw. Code is the frame's return code, if it
+// has one.
+type rawRecord struct {
+	Seq     uint32  `json:"seq"`
+	Cmd     uint32  `json:"cmd"`
+	Code    *uint32 `json:"code"`
+	Payload string  `json:"payload"`
+	Hex     string  `json:"hex"`
+}
+
+func runRaw(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	var of outputFlags
+	df.register(fs)
+	of.register(fs)
+	cmdFlag := fs.String("cmd", "", "command number, e.g. 0x0a")
+	payload := fs.String("payload", "", "request payload; JSON or raw text")
+	isHex := fs.Bool("hex", false, "payload is hex encoded bytes")
+	encrypt := fs.Bool("encrypt", false, "encrypt the payload with the device key")
+	wait := fs.Duration("wait", 5*time.Second, "how long to wait for a response")
+	args, err := df.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+	if *cmdFlag == "" {
+		return errors.New("-cmd is required")
+	}
+	cmd, err := strconv.ParseUint(*cmdFlag, 0, 32)
+	if err != nil {
+		return fmt.Errorf("bad -cmd: %v", err)
+	}
+	data := []byte(*payload)
+	if *isHex {
+		if data, err = hex.DecodeString(*payload); err != nil {
+			return fmt.Errorf("bad -payload: %v", err)
+		}
+	}
+
+	client, _, err := df.dialClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	timer := time.AfterFunc(*wait, func() { client.Close() })
+	defer timer.Stop()
+
+	seq, err := client.Write(uint32(cmd), *encrypt, data)
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := client.Read()
+		if err != nil {
+			if !timer.Stop() {
+				return timedOut(fmt.Errorf("no response within %v", *wait))
+			}
+			return err
+		}
+		if res.Seq != seq {
+			// Probably a push; keep waiting for our response.
+			continue
+		}
+		record := rawRecord{
+			Seq:     res.Seq,
+			Cmd:     res.Cmd,
+			Payload: string(res.Payload),
+			Hex:     hex.EncodeToString(res.Payload),
+		}
+		if len(res.Payload) >= 4 && res.Payload[0] == 0 {
+			code := binary.BigEndian.Uint32(res.Payload)
+			record.Code = &code
+			record.Payload = string(res.Payload[4:])
+		}
+		if err := enc.encode(record); err != nil {
+			return err
+		}
+		return enc.close()
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/record"
+)
+
+func runReplay(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	df.register(fs)
+	listen := fs.String("listen", "", "act as the recorded device for a client connecting to this address")
+	speed := fs.Float64("speed", 1, "playback speed relative to the recording; 0 sends without delay")
+	wait := fs.Duration("wait", 5*time.Second, "how long to wait for replies after re-sending")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("usage: replay [flags] <recording> [device]")
+	}
+	path := fs.Arg(0)
+	args, err := df.parse(fs, fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	header, entries, err := record.Read(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	replay := &record.Replay{Entries: entries, Speed: *speed}
+	if df.version == "" {
+		df.version = header.Version
+	}
+
+	if *listen != "" {
+		return serveReplay(replay, *listen, df.key, df.version)
+	}
+	if df.id == "" {
+		df.id = header.ID
+	}
+	client, _, err := df.dialClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	go func() {
+		for {
+			res, err := client.Read()
+			if err != nil {
+				return
+			}
+			fmt.Printf("seq=%d cmd=0x%02x %s\n", res.Seq, res.Cmd, printable(res.Payload))
+		}
+	}()
+	if err := replay.Resend(client); err != nil {
+		return err
+	}
+	time.Sleep(*wait)
+	return nil
+}
+
+// Accept one client and answer it with the recording.
+func serveReplay(replay *record.Replay, addr, key, version string) error {
+	s, err := net.ServerConfig{Key: key, Version: version}.Listen(addr)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	log.Printf("replaying on %s", s.Addr())
+	conn, err := s.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	log.Printf("replaying to %s", conn.RemoteAddr())
+	return replay.Serve(conn)
+}
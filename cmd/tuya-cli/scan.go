@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// A device seen by scan.
+type scanRecord struct {
+	ID         string    `json:"id"`
+	IP         string    `json:"ip"`
+	Version    string    `json:"version"`
+	ProductKey string    `json:"productKey"`
+	FirstSeen  time.Time `json:"firstSeen"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Count      int       `json:"count"`
+}
+
+func runScan(fs *flag.FlagSet, args []string) error {
+	var of outputFlags
+	of.registerDefault(fs, formatJSON)
+	duration := fs.Duration("duration", 30*time.Second, "how long to scan")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+	l, err := listenStatuses()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	timer := time.AfterFunc(*duration, func() { l.Close() })
+	defer timer.Stop()
+
+	// Redraw the live table on stderr at most once a second, and only if it
+	// is a terminal.
+	live := isTerminal(os.Stderr)
+	start := time.Now()
+	var lastDraw time.Time
+	seen := make(map[string]*scanRecord)
+	for {
+		status, err := l.ReadStatus()
+		if err != nil {
+			break
+		}
+		now := time.Now()
+		record, ok := seen[status.GatewayID]
+		if !ok {
+			record = &scanRecord{ID: status.GatewayID, FirstSeen: now}
+			seen[status.GatewayID] = record
+		}
+		record.IP = status.IP
+		record.Version = status.Version
+		record.ProductKey = status.ProductKey
+		record.LastSeen = now
+		record.Count++
+		if live && (!ok || now.Sub(lastDraw) >= time.Second) {
+			drawScan(sortedScan(seen), now.Sub(start), *duration)
+			lastDraw = now
+		}
+	}
+	if live {
+		// Clear the live table.
+		fmt.Fprint(os.Stderr, "\x1b[H\x1b[2J")
+	}
+
+	for _, record := range sortedScan(seen) {
+		if err := enc.encode(record); err != nil {
+			return err
+		}
+	}
+	return enc.close()
+}
+
+func sortedScan(seen map[string]*scanRecord) []*scanRecord {
+	records := make([]*scanRecord, 0, len(seen))
+	for _, record := range seen {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records
+}
+
+func drawScan(records []*scanRecord, elapsed, duration time.Duration) {
+	fmt.Fprint(os.Stderr, "\x1b[H\x1b[2J")
+	fmt.Fprintf(os.Stderr, "Scanning %v/%v, %d devices\n\n",
+		elapsed.Round(time.Second), duration, len(records))
+	tw := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tIP\tVERSION\tPRODUCTKEY\tFIRST SEEN\tLAST SEEN\tCOUNT")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", r.ID, r.IP, r.Version, r.ProductKey,
+			r.FirstSeen.Format("15:04:05"), r.LastSeen.Format("15:04:05"), r.Count)
+	}
+	tw.Flush()
+}
+
+// Report whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
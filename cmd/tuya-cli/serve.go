@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	stdnet "net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lann/tuya/alert"
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/history"
+	"github.com/lann/tuya/influx"
+	"github.com/lann/tuya/mqtt"
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/rules"
+	"github.com/lann/tuya/server"
+	"github.com/lann/tuya/sink"
+)
+
+func runServe(fs *flag.FlagSet, args []string) error {
+	listen := fs.String("listen", "localhost:8080", "HTTP listen address")
+	configPath := fs.String("config", defaultConfigPath(), "config file of named devices; devices changed through the API are saved to it")
+	timeout := fs.Duration("timeout", 10*time.Second, "device request timeout")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file; enables HTTPS and gRPC")
+	tlsKey := fs.String("tls-key", "", "TLS private key file")
+	clientCA := fs.String("client-ca", "", "require TLS client certificates signed by the CAs in this PEM file")
+	tokenFile := fs.String("token-file", "", "file of accepted API tokens, one per line; $TUYA_API_TOKEN is also accepted")
+	aclFile := fs.String("acl-file", "", "JSON file of API tokens and MQTT prefixes restricted to some devices and dps")
+	insecure := fs.Bool("insecure", false, "allow serving beyond localhost without TLS and authentication")
+	strict := fs.Bool("strict", false, "refuse devices and messages that aren't encrypted, and protocol versions below 3.3")
+	mqttAddr := fs.String("mqtt", "", "MQTT broker address to bridge devices to, e.g. localhost:1883")
+	mqttPrefix := fs.String("mqtt-prefix", "tuya", "MQTT topic prefix")
+	mqttUser := fs.String("mqtt-user", "", "MQTT user name; the password is read from $TUYA_MQTT_PASSWORD")
+	mqttQoS := fs.Uint("mqtt-qos", 0, "MQTT QoS, 0 or 1")
+	mqttRetain := fs.Bool("mqtt-retain", false, "retain MQTT dp messages")
+	influxURL := fs.String("influx", "", "InfluxDB write URL to export dps to; the token is read from $TUYA_INFLUX_TOKEN")
+	influxInterval := fs.Duration("influx-interval", 0, "also export every device's state at this interval")
+	var webhooks stringsFlag
+	fs.Var(&webhooks, "webhook", "URL to POST state changes to; may be repeated. Requests are signed with $TUYA_WEBHOOK_SECRET if set")
+	natsAddr := fs.String("nats", "", "NATS server address to publish state changes to, e.g. localhost:4222")
+	natsSubject := fs.String("nats-subject", "tuya", "NATS subject prefix")
+	var alertWebhooks stringsFlag
+	fs.Var(&alertWebhooks, "alert-webhook", "URL to POST alerts from the config file and -detect-rogue to; may be repeated. Alerts are also published to <mqtt-prefix>/alerts with -mqtt")
+	rulesPath := fs.String("rules", "", "JSON file of automation rules to run")
+	historyDir := fs.String("history", "", "directory to record dp changes in, for GET /devices/{id}/history")
+	historyRetention := fs.Duration("history-retention", 30*24*time.Hour, "how long to keep recorded history; 0 keeps it forever")
+	detectRogue := fs.Bool("detect-rogue", false, "alert on broadcasts from devices not in the config, or from a configured device at a new address")
+	location := fs.String("location", "", "latitude,longitude for sunrise and sunset rules, e.g. 51.5,-0.13")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if *clientCA != "" && *tlsCert == "" {
+		return errors.New("-client-ca requires -tls-cert")
+	}
+	if *mqttQoS > 1 {
+		return errors.New("-mqtt-qos must be 0 or 1")
+	}
+	tokens, err := loadTokens(*tokenFile)
+	if err != nil {
+		return err
+	}
+	acls, err := loadACLs(*aclFile)
+	if err != nil {
+		return err
+	}
+	if len(acls.MQTT) > 0 && *mqttAddr == "" {
+		return errors.New("-acl-file has MQTT prefixes but -mqtt isn't set")
+	}
+	secure := *tlsCert != "" && (len(tokens) > 0 || len(acls.Tokens) > 0 || *clientCA != "")
+	if !secure && !*insecure && !isLoopback(*listen) {
+		return errors.New("serving beyond localhost needs -tls-cert and either -token-file or -client-ca; use -insecure to override")
+	}
+	httpServer := &http.Server{Addr: *listen}
+	if *clientCA != "" {
+		pem, err := ioutil.ReadFile(*clientCA)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", *clientCA)
+		}
+		httpServer.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	// Errors from the server's integrations go to stderr; the libraries
+	// themselves are silent without a Logger.
+	stderr := &net.StdLogger{Logger: log.New(os.Stderr, "", log.LstdFlags)}
+	srv := server.New()
+	srv.Logger = stderr
+	srv.Timeout = *timeout
+	srv.Tokens = tokens
+	srv.ACLs = acls.Tokens
+	srv.Store = &configStore{path: *configPath}
+	httpServer.Handler = srv
+	if *historyDir != "" {
+		store, err := history.OpenFileStore(*historyDir)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		store.Retention = *historyRetention
+		srv.History = store
+	}
+	var notifiers []alert.Notifier
+	if len(cfg.Alerts) > 0 || *detectRogue {
+		for _, url := range alertWebhooks {
+			notifiers = append(notifiers, &alert.Webhook{URL: url, Secret: os.Getenv("TUYA_WEBHOOK_SECRET")})
+		}
+		if *mqttAddr != "" {
+			notifiers = append(notifiers, &alert.MQTT{
+				Config: mqtt.Config{
+					Addr:     *mqttAddr,
+					ClientID: "tuya-cli-" + *mqttPrefix + "-alerts",
+					Username: *mqttUser,
+					Password: os.Getenv("TUYA_MQTT_PASSWORD"),
+				},
+				Topic: *mqttPrefix + "/alerts",
+			})
+		}
+		if len(notifiers) == 0 {
+			return errors.New("alerts are configured but there's nowhere to send them; use -alert-webhook or -mqtt")
+		}
+	}
+	srv.Fleet.Registry = device.NewRegistry()
+	srv.Fleet.Strict = *strict
+	if *detectRogue {
+		notify := alert.Anomalies(stderr, notifiers...)
+		srv.Fleet.Registry.OnAnomaly = func(a device.Anomaly) {
+			log.Printf("warning: %v", a)
+			notify(a)
+		}
+		srv.Fleet.Registry.Known = func(id string) bool {
+			for _, known := range srv.Fleet.IDs() {
+				if known == id {
+					return true
+				}
+			}
+			return false
+		}
+		srv.Fleet.Registry.LookupMAC = lookupMAC
+	}
+	defer srv.Close()
+	dpNames := make(map[string]map[uint32]string)
+	for name, dev := range cfg.Devices {
+		if err := srv.AddDeviceConfig(dev.serverConfig(name)); err != nil {
+			return err
+		}
+		dpNames[dev.ID] = dev.DPNames
+	}
+
+	// Track broadcasts to find devices without a configured ip.
+	l, err := listenStatuses()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	var registryErr lockedError
+	go func() {
+		err := srv.Fleet.Registry.Run(l)
+		if err == nil {
+			err = errors.New("stopped")
+		}
+		registryErr.set(err)
+	}()
+	srv.AddCheck("broadcasts", registryErr.get)
+	srv.Start()
+
+	if *mqttAddr != "" {
+		bridge := &mqtt.Bridge{Server: srv, Prefix: *mqttPrefix, QoS: byte(*mqttQoS), Retain: *mqttRetain, Logger: stderr}
+		defer bridge.Close()
+		go bridge.Run(mqtt.Config{
+			Addr:     *mqttAddr,
+			ClientID: "tuya-cli-" + *mqttPrefix,
+			Username: *mqttUser,
+			Password: os.Getenv("TUYA_MQTT_PASSWORD"),
+		})
+		for prefix, acl := range acls.MQTT {
+			acl := acl
+			bridge := &mqtt.Bridge{Server: srv, Prefix: prefix, QoS: byte(*mqttQoS), Retain: *mqttRetain, ACL: &acl, Logger: stderr}
+			defer bridge.Close()
+			go bridge.Run(mqtt.Config{
+				Addr:     *mqttAddr,
+				ClientID: "tuya-cli-" + prefix,
+				Username: *mqttUser,
+				Password: os.Getenv("TUYA_MQTT_PASSWORD"),
+			})
+		}
+	}
+	if *influxURL != "" {
+		exporter := &influx.Exporter{
+			Server:   srv,
+			Output:   &influx.HTTPWriter{URL: *influxURL, Token: os.Getenv("TUYA_INFLUX_TOKEN")},
+			DPNames:  dpNames,
+			Interval: *influxInterval,
+			Logger:   stderr,
+		}
+		defer exporter.Close()
+		go exporter.Run()
+	}
+	var sinks []sink.Sink
+	for _, url := range webhooks {
+		sinks = append(sinks, &sink.Webhook{URL: url, Secret: os.Getenv("TUYA_WEBHOOK_SECRET"), Retries: 3})
+	}
+	if *natsAddr != "" {
+		sinks = append(sinks, &sink.NATS{Addr: *natsAddr, Subject: *natsSubject, Token: os.Getenv("TUYA_NATS_TOKEN"), Logger: stderr})
+	}
+	if len(cfg.Alerts) > 0 {
+		monitor, err := alert.NewMonitor(srv, cfg.Alerts, notifiers...)
+		if err != nil {
+			return fmt.Errorf("%s: %v", *configPath, err)
+		}
+		monitor.Logger = stderr
+		defer monitor.Close()
+		go monitor.Run()
+	}
+	// Errors from goroutines that should stop serving, so that runServe
+	// returns them after its deferred Closes rather than exiting from the
+	// goroutine. Buffered so that neither sender blocks.
+	fatal := make(chan error, 2)
+	if *rulesPath != "" {
+		engine, err := loadRules(srv, *rulesPath)
+		if err != nil {
+			return err
+		}
+		if *location != "" {
+			if _, err := fmt.Sscanf(*location, "%g,%g", &engine.Latitude, &engine.Longitude); err != nil {
+				return fmt.Errorf("-location: want latitude,longitude")
+			}
+		}
+		engine.Logger = stderr
+		// Schedules' last runs are kept beside the config file.
+		engine.StatePath = filepath.Join(filepath.Dir(*configPath), "rules-state.json")
+		defer engine.Close()
+		go func() {
+			if err := engine.Run(); err != nil {
+				fatal <- fmt.Errorf("%s: %v", *rulesPath, err)
+			}
+		}()
+	}
+	if len(sinks) > 0 {
+		dispatcher := &sink.Dispatcher{Server: srv, Sinks: sinks, Logger: stderr}
+		defer dispatcher.Close()
+		go dispatcher.Run()
+	}
+
+	// Reload the config on SIGHUP.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			reloadConfig(srv, *configPath)
+		}
+	}()
+
+	log.Printf("serving %d devices on %s", len(cfg.Devices), *listen)
+	go func() {
+		if *tlsCert != "" {
+			fatal <- httpServer.ListenAndServeTLS(*tlsCert, *tlsKey)
+		} else {
+			fatal <- httpServer.ListenAndServe()
+		}
+	}()
+	err = <-fatal
+	httpServer.Close()
+	return err
+}
+
+// Load a JSON array of rules.
+func loadRules(srv *server.Server, path string) (*rules.Engine, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rs []rules.Rule
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	engine, err := rules.New(srv, rs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return engine, nil
+}
+
+// Re-read the config file, updating the server's devices to match.
+func reloadConfig(srv *server.Server, path string) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		log.Printf("reload: %v", err)
+		return
+	}
+	var configs []server.DeviceConfig
+	for name, dev := range cfg.Devices {
+		configs = append(configs, dev.serverConfig(name))
+	}
+	result, err := srv.Sync(configs)
+	if err != nil {
+		log.Printf("reload: %v", err)
+		return
+	}
+	log.Printf("reload: %d added, %d updated, %d removed",
+		len(result.Added), len(result.Updated), len(result.Removed))
+}
+
+// Load API tokens from a file of one token per line, ignoring blank lines and
+// # comments, plus $TUYA_API_TOKEN.
+func loadTokens(path string) ([]string, error) {
+	var tokens []string
+	if token := os.Getenv("TUYA_API_TOKEN"); token != "" {
+		tokens = append(tokens, token)
+	}
+	if path == "" {
+		return tokens, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, line)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", path)
+	}
+	return tokens, nil
+}
+
+// Restricted access, as loaded from an -acl-file: API tokens, and MQTT topic
+// prefixes each served by a Bridge of their own, with the devices and dps
+// they may use. For example:
+//
+//	{"tokens": {"<token>": {"rules": [{"device": "lamp", "write": true}]}},
+//	 "mqtt": {"guest": {"rules": [{"device": "*", "dps": [1]}]}}}
+//
+// Restricting MQTT clients to a prefix is up to the broker's ACLs.
+type aclConfig struct {
+	Tokens map[string]server.ACL `json:"tokens"`
+	MQTT   map[string]server.ACL `json:"mqtt"`
+}
+
+func loadACLs(path string) (aclConfig, error) {
+	var acls aclConfig
+	if path == "" {
+		return acls, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return acls, err
+	}
+	if err := json.Unmarshal(data, &acls); err != nil {
+		return acls, fmt.Errorf("%s: %v", path, err)
+	}
+	return acls, nil
+}
+
+// Report whether a listen address only accepts local connections.
+func isLoopback(addr string) bool {
+	host, _, err := stdnet.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := stdnet.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// An error set by one goroutine and read by others.
+type lockedError struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (e *lockedError) set(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.err = err
+}
+
+func (e *lockedError) get() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/device"
+)
+
+// Maximum number of history lines saved between sessions.
+const maxHistory = 500
+
+const shellHelp = `Commands:
+  devices                 list configured devices
+  get <device>            print a device's dps
+  set <device> dp=value…  set dps
+  toggle <device> [dp]    invert a boolean dp (default 1)
+  watch <device>          print dps pushed by a device until unwatched
+  unwatch <device>        stop watching a device
+  close <device>          close a device's connection
+  help                    show this help
+  exit                    leave the shell
+`
+
+// An interactive session with persistent device connections.
+type shell struct {
+	cfg     *config
+	fleet   *device.Fleet
+	editor  *lineEditor
+	mu      sync.Mutex
+	watches map[string]func()
+}
+
+func runShell(fs *flag.FlagSet, args []string) error {
+	configPath := fs.String("config", defaultConfigPath(), "config file of named devices")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	sh := &shell{
+		cfg:     cfg,
+		fleet:   device.NewFleet(),
+		watches: make(map[string]func()),
+	}
+	defer sh.fleet.Close()
+	for _, dev := range cfg.Devices {
+		sh.fleet.Add(dev.ID, dev.clientConfig())
+	}
+
+	// Find devices without a configured ip by their broadcasts.
+	sh.fleet.Registry = device.NewRegistry()
+	if l, err := listenStatuses(); err == nil {
+		defer l.Close()
+		go sh.fleet.Registry.Run(l)
+	}
+
+	sh.editor = newLineEditor("tuya> ", sh.complete)
+	defer sh.editor.Close()
+	historyPath := filepath.Join(filepath.Dir(*configPath), "history")
+	if data, err := ioutil.ReadFile(historyPath); err == nil {
+		sh.editor.history = strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	defer func() {
+		history := sh.editor.history
+		if len(history) > maxHistory {
+			history = history[len(history)-maxHistory:]
+		}
+		os.MkdirAll(filepath.Dir(historyPath), 0700)
+		ioutil.WriteFile(historyPath, []byte(strings.Join(history, "\n")+"\n"), 0600)
+	}()
+
+	for {
+		line, err := sh.editor.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			continue
+		}
+		if words[0] == "exit" || words[0] == "quit" {
+			return nil
+		}
+		if err := sh.exec(words[0], words[1:]); err != nil {
+			sh.editor.Printf("error: %v\n", err)
+		}
+	}
+}
+
+func (sh *shell) exec(verb string, args []string) error {
+	switch verb {
+	case "help":
+		sh.editor.Printf("%s", shellHelp)
+		return nil
+	case "devices":
+		for _, name := range sh.deviceNames() {
+			dev := sh.cfg.Devices[name]
+			sh.editor.Printf("%s\t%s\t%s\n", name, dev.ID, dev.IP)
+		}
+		return nil
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s <device> ...; try help", verb)
+	}
+	dev, ok := sh.cfg.Devices[args[0]]
+	if !ok {
+		return fmt.Errorf("no device %q", args[0])
+	}
+	name, args := args[0], args[1:]
+	if verb == "close" {
+		sh.unwatch(name)
+		sh.fleet.Add(dev.ID, dev.clientConfig())
+		return nil
+	}
+	if verb == "unwatch" {
+		sh.unwatch(name)
+		return nil
+	}
+
+	m, err := sh.fleet.Manager(dev.ID)
+	if err != nil {
+		return err
+	}
+	switch verb {
+	case "get":
+		state, err := m.GetState()
+		if err != nil {
+			return err
+		}
+		sh.printState(name, state)
+	case "set":
+		state, err := parseState(args)
+		if err != nil {
+			return err
+		}
+		return m.SetState(state)
+	case "toggle":
+		dp := uint64(1)
+		if len(args) > 0 {
+			if dp, err = strconv.ParseUint(args[0], 10, 32); err != nil {
+				return fmt.Errorf("bad dp %q", args[0])
+			}
+		}
+		on, err := toggle(m, uint32(dp))
+		if err != nil {
+			return err
+		}
+		sh.editor.Printf("%s %d=%v\n", name, dp, on)
+	case "watch":
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+		if _, ok := sh.watches[name]; ok {
+			return nil
+		}
+		updates, stop := m.Watch()
+		sh.watches[name] = stop
+		go func() {
+			for update := range updates {
+				sh.printState(name, update)
+			}
+		}()
+	default:
+		return fmt.Errorf("unknown command %q; try help", verb)
+	}
+	return nil
+}
+
+func (sh *shell) unwatch(name string) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if stop, ok := sh.watches[name]; ok {
+		delete(sh.watches, name)
+		stop()
+	}
+}
+
+func (sh *shell) printState(name string, state device.State) {
+	now := time.Now().Format("15:04:05")
+	for _, dp := range sortedDPs(state) {
+		sh.editor.Printf("%s %s %d=%v\n", now, name, dp, state[dp])
+	}
+}
+
+func (sh *shell) deviceNames() []string {
+	var names []string
+	for name := range sh.cfg.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Complete verbs for the first word and device names for the second.
+func (sh *shell) complete(line string) []string {
+	words := strings.Split(line, " ")
+	var candidates []string
+	switch len(words) {
+	case 1:
+		candidates = []string{"close", "devices", "exit", "get", "help",
+			"set", "toggle", "unwatch", "watch"}
+	case 2:
+		candidates = sh.deviceNames()
+	}
+	word := words[len(words)-1]
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lann/tuya/device"
+)
+
+// A snapshot file holds the dps of named devices.
+type snapshot struct {
+	Time    time.Time              `json:"time"`
+	Devices map[string]stateRecord `json:"devices"`
+}
+
+// The outcome for one device, as output by snapshot.
+type snapshotRecord struct {
+	Name  string `json:"name"`
+	ID    string `json:"id"`
+	DPs   int    `json:"dps"`
+	Error string `json:"error"`
+}
+
+func runSnapshot(fs *flag.FlagSet, args []string) error {
+	var of outputFlags
+	of.register(fs)
+	configPath := fs.String("config", defaultConfigPath(), "config file of named devices and groups")
+	timeout := fs.Duration("timeout", 30*time.Second, "how long to wait for broadcasts from devices without an ip")
+	var skip dpsFlag
+	fs.Var(&skip, "skip", "comma-separated dps not to restore, in addition to configured readOnly dps")
+	fs.Parse(args)
+	if fs.NArg() < 2 || (fs.Arg(0) != "save" && fs.Arg(0) != "restore") {
+		return errors.New("usage: snapshot [flags] save|restore <file> [device|group...]")
+	}
+	action, path, names := fs.Arg(0), fs.Arg(1), fs.Args()[2:]
+
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if action == "restore" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if len(names) == 0 {
+			for name := range snap.Devices {
+				names = append(names, name)
+			}
+		}
+	}
+	names = cfg.expand(names)
+
+	fleet, ids, err := cfg.fleet(names, *timeout)
+	if err != nil {
+		return err
+	}
+	defer fleet.Close()
+
+	var results []device.Result
+	if action == "save" {
+		results = fleet.GetState(ids...)
+	} else {
+		// Restore only writable dps.
+		states := make(map[string]device.State)
+		for _, name := range names {
+			saved, ok := snap.Devices[name]
+			if !ok {
+				return fmt.Errorf("no device %q in %s", name, path)
+			}
+			state := device.State{}
+			for dp, value := range saved.DPs {
+				state[dp] = value
+			}
+			for _, dp := range append(cfg.Devices[name].ReadOnly, skip...) {
+				delete(state, dp)
+			}
+			states[cfg.Devices[name].ID] = state
+		}
+		results = fleet.Do(ids, func(m *device.Manager) (device.State, error) {
+			state := states[m.ID()]
+			if len(state) == 0 {
+				return state, nil
+			}
+			return state, m.SetState(state)
+		})
+	}
+
+	snap = snapshot{Time: time.Now(), Devices: make(map[string]stateRecord)}
+	failed := 0
+	for i, result := range results {
+		record := snapshotRecord{Name: names[i], ID: result.ID, DPs: len(result.State)}
+		if result.Err != nil {
+			record.Error = result.Err.Error()
+			failed++
+		} else {
+			snap.Devices[names[i]] = stateRecord{ID: result.ID, DPs: result.State}
+		}
+		if err := enc.encode(record); err != nil {
+			return err
+		}
+	}
+	if err := enc.close(); err != nil {
+		return err
+	}
+
+	if action == "save" && len(snap.Devices) > 0 {
+		data, err := json.MarshalIndent(snap, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d devices failed", failed, len(ids))
+	}
+	return nil
+}
+
+// A comma-separated list of dps.
+type dpsFlag []uint32
+
+func (d *dpsFlag) String() string {
+	return fmt.Sprint([]uint32(*d))
+}
+
+func (d *dpsFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		dp, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+		if err != nil {
+			return fmt.Errorf("bad dp %q", s)
+		}
+		*d = append(*d, uint32(dp))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// Ports carrying Tuya traffic.
+var tuyaPorts = map[uint16]bool{
+	net.StatusPort:          true,
+	net.EncryptedStatusPort: true,
+	net.ClientPort:          true,
+	net.AppStatusPort:       true,
+}
+
+func runSniff(fs *flag.FlagSet, args []string) error {
+	var of outputFlags
+	var keys stringsFlag
+	of.register(fs)
+	iface := fs.String("iface", "", "network interface to capture on (default: all)")
+	keysPath := fs.String("keys", defaultConfigPath(), "config file whose device keys are used to decrypt")
+	fs.Var(&keys, "key", "additional local key; may be repeated")
+	duration := fs.Duration("duration", 0, "how long to capture (default: until interrupted)")
+	promisc := fs.Bool("promisc", true, "put the interface into promiscuous mode")
+	export := fs.String("export", "", "also write decoded frames as JSON lines to this file, or - for stdout")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	enc, err := of.encoder(true)
+	if err != nil {
+		return err
+	}
+	d, err := newFrameDecoder(enc, *keysPath, keys)
+	if err != nil {
+		return err
+	}
+	if *export != "" {
+		if d.export, err = newFrameExporter(*export); err != nil {
+			return err
+		}
+	}
+	c, err := openCapture(*iface, *promisc)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var deadline time.Time
+	if *duration > 0 {
+		deadline = time.Now().Add(*duration)
+	}
+	for deadline.IsZero() || time.Now().Before(deadline) {
+		p, err := c.next()
+		if err != nil {
+			return err
+		}
+		if p == nil || !(tuyaPorts[p.srcPort] || tuyaPorts[p.dstPort]) {
+			continue
+		}
+		if err := d.packet(p); err != nil {
+			return err
+		}
+	}
+	return enc.close()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	stdnet "net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ETH_P_ALL in network byte order, as socket and bind expect it.
+var ethPAll = htons(syscall.ETH_P_ALL)
+
+// Convert v from host to network byte order.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return *(*uint16)(unsafe.Pointer(&b))
+}
+
+// struct packet_mreq
+type packetMreq struct {
+	ifindex int32
+	typ     uint16
+	alen    uint16
+	address [8]byte
+}
+
+// A capture reads packets from an AF_PACKET socket.
+type capture struct {
+	fd  int
+	buf []byte
+}
+
+// Open a capture on the named interface, or on all interfaces if iface is
+// empty. Requires CAP_NET_RAW.
+func openCapture(iface string, promisc bool) (*capture, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(ethPAll))
+	if err != nil {
+		return nil, err
+	}
+	c := &capture{fd: fd, buf: make([]byte, 1<<16)}
+	// Time out reads so callers can stop.
+	tv := syscall.NsecToTimeval(int64(time.Second))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if iface != "" {
+		ifi, err := stdnet.InterfaceByName(iface)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		addr := &syscall.SockaddrLinklayer{Protocol: ethPAll, Ifindex: ifi.Index}
+		if err := syscall.Bind(fd, addr); err != nil {
+			c.Close()
+			return nil, err
+		}
+		if promisc {
+			mreq := packetMreq{ifindex: int32(ifi.Index), typ: syscall.PACKET_MR_PROMISC}
+			_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd),
+				syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP,
+				uintptr(unsafe.Pointer(&mreq)), unsafe.Sizeof(mreq), 0)
+			if errno != 0 {
+				c.Close()
+				return nil, errno
+			}
+		}
+	}
+	return c, nil
+}
+
+// Return the next TCP or UDP packet, or nil if none arrived within a second.
+func (c *capture) next() (*packet, error) {
+	for {
+		n, from, err := syscall.Recvfrom(c.fd, c.buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ll, ok := from.(*syscall.SockaddrLinklayer); ok &&
+			ll.Hatype == syscall.ARPHRD_LOOPBACK && ll.Pkttype == syscall.PACKET_OUTGOING {
+			// Loopback packets are seen going out and again coming in.
+			continue
+		}
+		ip, ok := stripLink(linkEthernet, c.buf[:n])
+		if !ok {
+			continue
+		}
+		// Copy out of the shared buffer; stream reassembly keeps payloads.
+		if p := parseIPv4(append([]byte(nil), ip...)); p != nil {
+			p.time = time.Now()
+			return p, nil
+		}
+	}
+}
+
+// Close closes the capture socket, which also leaves promiscuous mode.
+func (c *capture) Close() error {
+	return syscall.Close(c.fd)
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestHtons(t *testing.T) {
+	v := htons(0x0003)
+	if b := *(*[2]byte)(unsafe.Pointer(&v)); b != [2]byte{0x00, 0x03} {
+		t.Errorf("htons(0x0003) is stored as % x, want 00 03", b)
+	}
+}
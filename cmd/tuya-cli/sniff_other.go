@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+type capture struct{}
+
+// Live capture is only implemented on Linux; elsewhere capture with tcpdump
+// and use decode.
+func openCapture(iface string, promisc bool) (*capture, error) {
+	return nil, errors.New("sniff is only supported on Linux; capture to a pcap file and use decode")
+}
+
+func (c *capture) next() (*packet, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *capture) Close() error {
+	return nil
+}
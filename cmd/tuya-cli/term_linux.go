@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Put the terminal into raw mode, returning a function restoring it. Output
+// processing is left on so newlines still work as usual.
+func makeRaw(f *os.File) (func(), error) {
+	var orig syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, &orig); err != nil {
+		return nil, err
+	}
+	raw := orig
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(f, syscall.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() { ioctl(f, syscall.TCSETS, &orig) }, nil
+}
+
+func ioctl(f *os.File, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
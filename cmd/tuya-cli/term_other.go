@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// Raw mode is only implemented on Linux; elsewhere lines are read as typed.
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errors.New("raw mode not supported")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/lann/tuya/device"
+)
+
+func runToggle(fs *flag.FlagSet, args []string) error {
+	var df deviceFlags
+	var of outputFlags
+	df.register(fs)
+	of.register(fs)
+	args, err := df.parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	enc, err := of.encoder(false)
+	if err != nil {
+		return err
+	}
+	dp := uint64(1)
+	switch len(args) {
+	case 0:
+	case 1:
+		if dp, err = strconv.ParseUint(args[0], 10, 32); err != nil {
+			return fmt.Errorf("bad dp %q", args[0])
+		}
+	default:
+		return fmt.Errorf("unexpected arguments %q", args[1:])
+	}
+
+	m, err := df.dial()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	on, err := toggle(m, uint32(dp))
+	if err != nil {
+		return err
+	}
+	if err := enc.encode(stateRecord{df.id, device.State{uint32(dp): on}}); err != nil {
+		return err
+	}
+	return enc.close()
+}
+
+// Invert a boolean dp and return its new value.
+//
+// Another client may change the dp between our read and write. To narrow that
+// window, pushed updates arriving after the read are applied before deciding
+// the new value, and the result is read back afterwards so a lost race is
+// reported rather than silently ignored.
+func toggle(m *device.Manager, dp uint32) (bool, error) {
+	updates, stop := m.Watch()
+	defer stop()
+
+	state, err := m.GetState()
+	if err != nil {
+		return false, err
+	}
+	on, err := state.Bool(dp)
+	if err != nil {
+		return false, err
+	}
+	for drained := false; !drained; {
+		select {
+		case update := <-updates:
+			if v, err := update.Bool(dp); err == nil {
+				on = v
+			}
+		default:
+			drained = true
+		}
+	}
+
+	if err := m.SetState(device.State{dp: !on}); err != nil {
+		return false, err
+	}
+	state, err = m.GetState()
+	if err != nil {
+		return false, err
+	}
+	now, err := state.Bool(dp)
+	if err != nil {
+		return false, err
+	}
+	if now != !on {
+		return now, fmt.Errorf("dp %d changed concurrently; now %v", dp, now)
+	}
+	return now, nil
+}
@@ -0,0 +1,108 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// ErrBreakerOpen is returned by a Fleet, wrapped with the device ID, for
+// devices whose circuit breaker is open after repeated failures.
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// Circuit breaker states, as reported by Fleet.Stats.
+const (
+	BreakerClosed = "closed"
+	BreakerOpen   = "open"
+	// BreakerProbing is an open breaker that will let the next attempt
+	// through to probe the device.
+	BreakerProbing = "probing"
+)
+
+// A BreakerConfig configures a Fleet's per-device circuit breakers, which
+// stop a dead or flapping device from causing endless reconnects and
+// timeouts.
+type BreakerConfig struct {
+	// Failures is how many consecutive failed connections or requests open
+	// a device's breaker; zero disables breakers. Requests are then
+	// refused with ErrBreakerOpen, even over an open connection, except for
+	// one probe attempt at a time.
+	Failures int
+
+	// Probe is how long an open breaker waits before letting an attempt
+	// through to probe the device. It doubles after each failed probe, up
+	// to MaxProbe. Zero means 10s and 5m.
+	Probe, MaxProbe time.Duration
+}
+
+// A device's circuit breaker.
+type breaker struct {
+	failures int // consecutive
+	open     bool
+	backoff  time.Duration
+	retryAt  time.Time // when open, the time of the next probe
+}
+
+// Report whether an attempt is allowed, reserving the probe if the breaker
+// is open.
+func (b *breaker) allow(now time.Time) bool {
+	if !b.open {
+		return true
+	}
+	if now.Before(b.retryAt) {
+		return false
+	}
+	// Let this attempt probe; others wait for its result.
+	b.retryAt = now.Add(b.backoff)
+	return true
+}
+
+func (b *breaker) success() {
+	*b = breaker{}
+}
+
+func (b *breaker) failure(now time.Time, config BreakerConfig) {
+	b.failures++
+	probe, maxProbe := config.Probe, config.MaxProbe
+	if probe == 0 {
+		probe = 10 * time.Second
+	}
+	if maxProbe == 0 {
+		maxProbe = 5 * time.Minute
+	}
+	switch {
+	case b.open:
+		b.backoff *= 2
+		if b.backoff > maxProbe {
+			b.backoff = maxProbe
+		}
+	case b.failures >= config.Failures:
+		b.open = true
+		b.backoff = probe
+	default:
+		return
+	}
+	b.retryAt = now.Add(b.backoff)
+}
+
+func (b *breaker) state(now time.Time) string {
+	switch {
+	case !b.open:
+		return BreakerClosed
+	case now.Before(b.retryAt):
+		return BreakerOpen
+	}
+	return BreakerProbing
+}
+
+// Whether a request error means the device is unhealthy; error replies show
+// that it's responding, and a caller cancelling says nothing about it.
+func isFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var re net.ResponseError
+	return !errors.As(err, &re)
+}
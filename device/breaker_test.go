@@ -0,0 +1,152 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+func TestBreaker(t *testing.T) {
+	config := BreakerConfig{Failures: 3, Probe: time.Second, MaxProbe: 3 * time.Second}
+	now := time.Unix(0, 0)
+	b := &breaker{}
+	for i := 0; i < 2; i++ {
+		b.failure(now, config)
+	}
+	if !b.allow(now) || b.state(now) != BreakerClosed {
+		t.Fatal("opened before 3 failures")
+	}
+	b.failure(now, config)
+	if b.allow(now) || b.state(now) != BreakerOpen {
+		t.Fatal("not open after 3 failures")
+	}
+
+	// One probe per interval, which doubles after failures up to MaxProbe.
+	for _, wait := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		now = now.Add(wait - time.Millisecond)
+		if b.allow(now) {
+			t.Fatalf("probe allowed before %v", wait)
+		}
+		now = now.Add(time.Millisecond)
+		if b.state(now) != BreakerProbing || !b.allow(now) {
+			t.Fatalf("probe not allowed after %v", wait)
+		}
+		if b.allow(now) {
+			t.Fatal("second concurrent probe allowed")
+		}
+		b.failure(now, config)
+	}
+
+	b.success()
+	if !b.allow(now) || b.state(now) != BreakerClosed || b.failures != 0 {
+		t.Errorf("not closed after success: %+v", b)
+	}
+}
+
+func TestIsFailure(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{net.ResponseError{Code: 1}, false},
+		{ErrTimeout, true},
+		{fmt.Errorf("request: %w", net.ResponseError{Code: 1}), false},
+		{context.Canceled, false},
+		{errors.New("Read: EOF"), true},
+	} {
+		if got := isFailure(tc.err); got != tc.want {
+			t.Errorf("isFailure(%v) = %v", tc.err, got)
+		}
+	}
+}
+
+func TestFleetBreaker(t *testing.T) {
+	// An address that refuses connections.
+	l, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	f := NewFleet()
+	f.Breaker = BreakerConfig{Failures: 2, Probe: time.Hour}
+	f.Add("dead", net.ClientConfig{Addr: addr})
+	for i := 0; i < 2; i++ {
+		if _, err := f.Manager("dead"); err == nil || errors.Is(err, ErrBreakerOpen) {
+			t.Fatalf("attempt %d = %v, want a dial error", i, err)
+		}
+	}
+	if _, err := f.Manager("dead"); err == nil || !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("Manager after failures = %v, want ErrBreakerOpen", err)
+	}
+	stats := f.Stats()
+	if len(stats) != 1 || stats[0].Breaker != BreakerOpen || stats[0].Failures != 2 || stats[0].RetryAt == nil {
+		t.Errorf("Stats = %+v", stats)
+	}
+
+	// Updating the device resets its breaker.
+	f.Add("dead", net.ClientConfig{Addr: addr})
+	if stats := f.Stats(); stats[0].Breaker != BreakerClosed || stats[0].Failures != 0 {
+		t.Errorf("Stats after Add = %+v", stats)
+	}
+}
+
+func TestFleetBreakerConnected(t *testing.T) {
+	// A device that accepts connections but never answers.
+	s, err := net.ServerConfig{Key: testKey, Version: net.Version33}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go func() {
+		for {
+			conn, err := s.Accept()
+			if err != nil {
+				return
+			}
+			go (&testDevice{conn: conn, silent: true}).serve()
+		}
+	}()
+
+	f := NewFleet()
+	defer f.Close()
+	f.Breaker = BreakerConfig{Failures: 2, Probe: time.Hour}
+	f.Add("silent", net.ClientConfig{Addr: s.Addr().String(), Key: testKey, Version: net.Version33})
+	request := func(ctx context.Context) error {
+		m, err := f.Manager("silent")
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err = m.GetStateContext(ctx)
+		return err
+	}
+
+	// Cancelled requests don't count.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		if err := request(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("cancelled request %d = %v", i, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := request(context.Background()); err == nil || errors.Is(err, ErrBreakerOpen) {
+			t.Fatalf("request %d = %v, want a timeout", i, err)
+		}
+	}
+	if err := request(context.Background()); !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("request after timeouts = %v, want ErrBreakerOpen", err)
+	}
+	if stats := f.Stats(); !stats[0].Connected || stats[0].Breaker != BreakerOpen {
+		t.Errorf("Stats = %+v", stats)
+	}
+}
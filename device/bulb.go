@@ -0,0 +1,308 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Light modes reported and accepted by bulbs' mode dp.
+const (
+	ModeWhite  = "white"
+	ModeColour = "colour"
+	ModeScene  = "scene"
+	ModeMusic  = "music"
+)
+
+// A ColorEncoding identifies a colour_data dp string format.
+type ColorEncoding int
+
+const (
+	// ColorRGBHSV is the older 14 hex character format "rrggbbhhhhssvv", with
+	// hue in degrees and saturation and value in 0-255.
+	ColorRGBHSV ColorEncoding = iota
+
+	// ColorHSV is the newer 12 hex character format "hhhhssssvvvv", with hue
+	// in degrees and saturation and value in 0-1000.
+	ColorHSV
+)
+
+// An HSV is a color with hue in degrees [0, 360) and saturation and value in
+// [0, 1].
+type HSV struct {
+	H, S, V float64
+}
+
+// HSVFromRGB converts 8-bit RGB components to an HSV.
+func HSVFromRGB(r, g, b uint8) HSV {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	max := math.Max(rf, math.Max(gf, bf))
+	min := math.Min(rf, math.Min(gf, bf))
+	delta := max - min
+
+	var c HSV
+	c.V = max
+	if max > 0 {
+		c.S = delta / max
+	}
+	switch {
+	case delta == 0:
+		c.H = 0
+	case max == rf:
+		c.H = 60 * math.Mod((gf-bf)/delta, 6)
+	case max == gf:
+		c.H = 60 * ((bf-rf)/delta + 2)
+	default:
+		c.H = 60 * ((rf-gf)/delta + 4)
+	}
+	if c.H < 0 {
+		c.H += 360
+	}
+	return c
+}
+
+// ParseHexColor parses a "#rrggbb" (or "rrggbb") string to an HSV.
+func ParseHexColor(s string) (HSV, error) {
+	if len(s) > 0 && s[0] == '#' {
+		s = s[1:]
+	}
+	if len(s) != 6 {
+		return HSV{}, fmt.Errorf("bad hex color %q", s)
+	}
+	rgb, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return HSV{}, fmt.Errorf("bad hex color %q", s)
+	}
+	return HSVFromRGB(uint8(rgb>>16), uint8(rgb>>8), uint8(rgb)), nil
+}
+
+// RGB converts the color to 8-bit RGB components.
+func (c HSV) RGB() (r, g, b uint8) {
+	h := math.Mod(c.H, 360)
+	if h < 0 {
+		h += 360
+	}
+	chroma := c.V * c.S
+	x := chroma * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := c.V - chroma
+
+	var rf, gf, bf float64
+	switch {
+	case h < 60:
+		rf, gf = chroma, x
+	case h < 120:
+		rf, gf = x, chroma
+	case h < 180:
+		gf, bf = chroma, x
+	case h < 240:
+		gf, bf = x, chroma
+	case h < 300:
+		rf, bf = x, chroma
+	default:
+		rf, bf = chroma, x
+	}
+	to8 := func(f float64) uint8 { return uint8(math.Round((f + m) * 255)) }
+	return to8(rf), to8(gf), to8(bf)
+}
+
+// Encode packs the color into a colour_data dp string.
+func (e ColorEncoding) Encode(c HSV) string {
+	hue := int(math.Round(math.Mod(c.H, 360)))
+	if e == ColorHSV {
+		return fmt.Sprintf("%04x%04x%04x", hue,
+			int(math.Round(c.S*1000)), int(math.Round(c.V*1000)))
+	}
+	r, g, b := c.RGB()
+	return fmt.Sprintf("%02x%02x%02x%04x%02x%02x", r, g, b, hue,
+		int(math.Round(c.S*255)), int(math.Round(c.V*255)))
+}
+
+// DecodeColor unpacks a colour_data dp string in either encoding, which is
+// detected by length.
+func DecodeColor(s string) (HSV, error) {
+	var h, sat, val uint64
+	var scale float64
+	var err error
+	switch len(s) {
+	case 14:
+		// Ignore the RGB prefix; HSV is more precise.
+		s = s[6:]
+		h, err = strconv.ParseUint(s[0:4], 16, 16)
+		if err == nil {
+			sat, err = strconv.ParseUint(s[4:6], 16, 8)
+		}
+		if err == nil {
+			val, err = strconv.ParseUint(s[6:8], 16, 8)
+		}
+		scale = 255
+	case 12:
+		h, err = strconv.ParseUint(s[0:4], 16, 16)
+		if err == nil {
+			sat, err = strconv.ParseUint(s[4:8], 16, 16)
+		}
+		if err == nil {
+			val, err = strconv.ParseUint(s[8:12], 16, 16)
+		}
+		scale = 1000
+	default:
+		return HSV{}, fmt.Errorf("bad colour_data length %d", len(s))
+	}
+	if err != nil {
+		return HSV{}, fmt.Errorf("bad colour_data %q", s)
+	}
+	return HSV{
+		H: float64(h),
+		S: math.Min(float64(sat)/scale, 1),
+		V: math.Min(float64(val)/scale, 1),
+	}, nil
+}
+
+// BulbDPs describes the dp layout of a bulb.
+type BulbDPs struct {
+	Switch, Mode, Brightness, Temperature, Color uint32
+
+	// Raw brightness and temperature dp ranges.
+	BrightnessMin, BrightnessMax int
+	TemperatureMax               int
+
+	Encoding ColorEncoding
+}
+
+var (
+	// BulbDPsV1 is the layout used by older bulbs (dps 1-5).
+	BulbDPsV1 = BulbDPs{
+		Switch: 1, Mode: 2, Brightness: 3, Temperature: 4, Color: 5,
+		BrightnessMin: 25, BrightnessMax: 255, TemperatureMax: 255,
+		Encoding: ColorRGBHSV,
+	}
+
+	// BulbDPsV2 is the layout used by newer bulbs (dps 20-24).
+	BulbDPsV2 = BulbDPs{
+		Switch: 20, Mode: 21, Brightness: 22, Temperature: 23, Color: 24,
+		BrightnessMin: 10, BrightnessMax: 1000, TemperatureMax: 1000,
+		Encoding: ColorHSV,
+	}
+)
+
+// A Bulb controls an RGB and/or tunable white (CCT) bulb.
+type Bulb struct {
+	*Manager
+	DPs BulbDPs
+
+	// Color temperatures, in Kelvin, corresponding to the bulb's warmest and
+	// coolest white.
+	WarmKelvin, CoolKelvin int
+}
+
+// NewBulb creates a Bulb with the given dp layout and a 2700K-6500K white
+// range.
+func NewBulb(m *Manager, dps BulbDPs) *Bulb {
+	return &Bulb{Manager: m, DPs: dps, WarmKelvin: 2700, CoolKelvin: 6500}
+}
+
+// A BulbStatus is a decoded bulb state. Brightness is a percentage, meaningful
+// in white mode; in colour mode Color.V holds the brightness.
+type BulbStatus struct {
+	On         bool
+	Mode       string
+	Brightness float64
+	Kelvin     int
+	Color      HSV
+}
+
+// Status reads and decodes the bulb state. Dps missing from the device state
+// are left zero.
+func (b *Bulb) Status() (*BulbStatus, error) {
+	state, err := b.GetState()
+	if err != nil {
+		return nil, err
+	}
+	status := &BulbStatus{}
+	if status.On, err = state.Bool(b.DPs.Switch); err != nil {
+		return nil, err
+	}
+	status.Mode, _ = state.String(b.DPs.Mode)
+	if raw, err := state.Int(b.DPs.Brightness); err == nil {
+		status.Brightness = unscale(raw, b.DPs.BrightnessMin, b.DPs.BrightnessMax)
+	}
+	if raw, err := state.Int(b.DPs.Temperature); err == nil {
+		pct := unscale(raw, 0, b.DPs.TemperatureMax)
+		status.Kelvin = b.WarmKelvin +
+			int(math.Round(pct/100*float64(b.CoolKelvin-b.WarmKelvin)))
+	}
+	if raw, err := state.String(b.DPs.Color); err == nil {
+		if status.Color, err = DecodeColor(raw); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// On turns the bulb on.
+func (b *Bulb) On() error {
+	return b.SetState(State{b.DPs.Switch: true})
+}
+
+// Off turns the bulb off.
+func (b *Bulb) Off() error {
+	return b.SetState(State{b.DPs.Switch: false})
+}
+
+// SetBrightness switches to white mode at the given brightness percentage.
+func (b *Bulb) SetBrightness(pct float64) error {
+	return b.SetState(State{
+		b.DPs.Switch:     true,
+		b.DPs.Mode:       ModeWhite,
+		b.DPs.Brightness: scale(pct, b.DPs.BrightnessMin, b.DPs.BrightnessMax),
+	})
+}
+
+// SetKelvin switches to white mode at the given color temperature, which is
+// clamped to the bulb's white range.
+func (b *Bulb) SetKelvin(kelvin int) error {
+	return b.SetState(State{
+		b.DPs.Switch:      true,
+		b.DPs.Mode:        ModeWhite,
+		b.DPs.Temperature: b.temperature(kelvin),
+	})
+}
+
+// SetWhite switches to white mode at the given brightness percentage and color
+// temperature in a single request.
+func (b *Bulb) SetWhite(pct float64, kelvin int) error {
+	return b.SetState(State{
+		b.DPs.Switch:      true,
+		b.DPs.Mode:        ModeWhite,
+		b.DPs.Brightness:  scale(pct, b.DPs.BrightnessMin, b.DPs.BrightnessMax),
+		b.DPs.Temperature: b.temperature(kelvin),
+	})
+}
+
+// Convert a color temperature to a raw temperature dp value.
+func (b *Bulb) temperature(kelvin int) int {
+	pct := 100 * float64(kelvin-b.WarmKelvin) / float64(b.CoolKelvin-b.WarmKelvin)
+	return scale(pct, 0, b.DPs.TemperatureMax)
+}
+
+// SetColor switches to colour mode with the given color.
+func (b *Bulb) SetColor(c HSV) error {
+	return b.SetState(State{
+		b.DPs.Switch: true,
+		b.DPs.Mode:   ModeColour,
+		b.DPs.Color:  b.DPs.Encoding.Encode(c),
+	})
+}
+
+// Scale a percentage onto a raw dp range, clamping to the range.
+func scale(pct float64, min, max int) int {
+	pct = math.Max(0, math.Min(100, pct))
+	return min + int(math.Round(pct/100*float64(max-min)))
+}
+
+// Convert a raw dp value to a percentage of its range.
+func unscale(raw, min, max int) float64 {
+	if max <= min {
+		return 0
+	}
+	return math.Max(0, math.Min(100, 100*float64(raw-min)/float64(max-min)))
+}
@@ -0,0 +1,31 @@
+package device
+
+import (
+	"math"
+	"testing"
+)
+
+func TestColorEncode(t *testing.T) {
+	c := HSVFromRGB(0xff, 0x88, 0x00)
+	if got := ColorRGBHSV.Encode(c); got != "ff88000020ffff" {
+		t.Errorf("ColorRGBHSV got %s", got)
+	}
+	if got := ColorHSV.Encode(c); got != "002003e803e8" {
+		t.Errorf("ColorHSV got %s", got)
+	}
+}
+
+func TestColorDecode(t *testing.T) {
+	for _, s := range []string{"ff88000020ffff", "002003e803e8"} {
+		c, err := DecodeColor(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, g, b := c.RGB(); r != 0xff || math.Abs(float64(g)-0x88) > 1 || b != 0 {
+			t.Errorf("%s decoded to %02x%02x%02x", s, r, g, b)
+		}
+	}
+	if _, err := DecodeColor("abc"); err == nil {
+		t.Error("expected error for bad length")
+	}
+}
@@ -0,0 +1,114 @@
+package device
+
+import (
+	"time"
+)
+
+// CoverDPs describes the dp layout of a curtain, blind, or shutter motor.
+type CoverDPs struct {
+	// Control is an enum dp accepting the Open, Close, and Stop values.
+	Control             uint32
+	Open, Close, Stop   string
+	Position            uint32 // Target position (percent)
+	PositionState       uint32 // Reported position (percent); 0 if none
+	Reverse             uint32 // Motor direction ("control_back"); 0 if none
+	TravelTime          uint32 // Calibrated full travel time (ms); 0 if none
+	InvertedPercentages bool   // Device uses 100 for fully closed
+}
+
+// CoverDPsDefault is the layout used by most curtain motors.
+var CoverDPsDefault = CoverDPs{
+	Control: 1, Open: "open", Close: "close", Stop: "stop",
+	Position:      2,
+	PositionState: 3,
+	Reverse:       5,
+	TravelTime:    10,
+}
+
+// A Cover controls a curtain, blind, or shutter motor. Positions are always
+// percent open, where 0 is fully closed and 100 fully open, regardless of the
+// device's own convention.
+type Cover struct {
+	*Manager
+	DPs CoverDPs
+}
+
+// NewCover creates a Cover with the given dp layout.
+func NewCover(m *Manager, dps CoverDPs) *Cover {
+	return &Cover{Manager: m, DPs: dps}
+}
+
+// Open starts opening the cover fully.
+func (c *Cover) Open() error {
+	return c.SetState(State{c.DPs.Control: c.DPs.Open})
+}
+
+// Close starts closing the cover fully.
+func (c *Cover) Close() error {
+	return c.SetState(State{c.DPs.Control: c.DPs.Close})
+}
+
+// Stop stops the cover's movement.
+func (c *Cover) Stop() error {
+	return c.SetState(State{c.DPs.Control: c.DPs.Stop})
+}
+
+// SetPosition moves the cover to the given percent open.
+func (c *Cover) SetPosition(pct int) error {
+	if pct < 0 {
+		pct = 0
+	} else if pct > 100 {
+		pct = 100
+	}
+	return c.SetState(State{c.DPs.Position: c.devicePercent(pct)})
+}
+
+// Position returns the cover's current percent open. If the device does not
+// report its position separately, the last target position is returned.
+func (c *Cover) Position() (int, error) {
+	state, err := c.GetState()
+	if err != nil {
+		return 0, err
+	}
+	dp := c.DPs.PositionState
+	if _, ok := state[dp]; dp == 0 || !ok {
+		dp = c.DPs.Position
+	}
+	pct, err := state.Int(dp)
+	if err != nil {
+		return 0, err
+	}
+	return c.devicePercent(pct), nil
+}
+
+// SetReversed sets the motor direction, for motors installed the "wrong" way
+// around.
+func (c *Cover) SetReversed(reversed bool) error {
+	if c.DPs.Reverse == 0 {
+		return ErrUnsupported
+	}
+	// Most motors use an enum here; "back" reverses the direction.
+	dir := "forward"
+	if reversed {
+		dir = "back"
+	}
+	return c.SetState(State{c.DPs.Reverse: dir})
+}
+
+// SetTravelTime calibrates the time the motor takes to travel fully between
+// open and closed, which position control depends on.
+func (c *Cover) SetTravelTime(d time.Duration) error {
+	if c.DPs.TravelTime == 0 {
+		return ErrUnsupported
+	}
+	return c.SetState(State{c.DPs.TravelTime: int(d / time.Millisecond)})
+}
+
+// Convert between percent open and the device's convention; the conversion is
+// its own inverse.
+func (c *Cover) devicePercent(pct int) int {
+	if c.DPs.InvertedPercentages {
+		return 100 - pct
+	}
+	return pct
+}
@@ -0,0 +1,75 @@
+package device
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCover(t *testing.T) {
+	inverted := CoverDPsDefault
+	inverted.InvertedPercentages = true
+	minimal := CoverDPs{Control: 1, Open: "on", Close: "off", Stop: "stop", Position: 2}
+
+	for _, tc := range []struct {
+		name    string
+		dps     CoverDPs
+		call    func(*Cover) error
+		want    []State
+		wantErr error
+	}{
+		{"open", CoverDPsDefault, (*Cover).Open, []State{{1: "open"}}, nil},
+		{"close", CoverDPsDefault, (*Cover).Close, []State{{1: "close"}}, nil},
+		{"stop", CoverDPsDefault, (*Cover).Stop, []State{{1: "stop"}}, nil},
+		{"open values", minimal, (*Cover).Open, []State{{1: "on"}}, nil},
+		{"position", CoverDPsDefault, func(c *Cover) error { return c.SetPosition(30) }, []State{{2: 30}}, nil},
+		{"position inverted", inverted, func(c *Cover) error { return c.SetPosition(30) }, []State{{2: 70}}, nil},
+		{"position high", CoverDPsDefault, func(c *Cover) error { return c.SetPosition(150) }, []State{{2: 100}}, nil},
+		{"position low", inverted, func(c *Cover) error { return c.SetPosition(-5) }, []State{{2: 100}}, nil},
+		{"reversed", CoverDPsDefault, func(c *Cover) error { return c.SetReversed(true) }, []State{{5: "back"}}, nil},
+		{"forward", CoverDPsDefault, func(c *Cover) error { return c.SetReversed(false) }, []State{{5: "forward"}}, nil},
+		{"no reverse", minimal, func(c *Cover) error { return c.SetReversed(true) }, nil, ErrUnsupported},
+		{"travel time", CoverDPsDefault, func(c *Cover) error { return c.SetTravelTime(25 * time.Second) }, []State{{10: 25000}}, nil},
+		{"no travel time", minimal, func(c *Cover) error { return c.SetTravelTime(time.Second) }, nil, ErrUnsupported},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, d := newTestManagerState(t, State{})
+			defer m.Close()
+			if err := tc.call(NewCover(m, tc.dps)); !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+			checkSets(t, d, tc.want...)
+		})
+	}
+}
+
+func TestCoverPosition(t *testing.T) {
+	noState := CoverDPsDefault
+	noState.PositionState = 0
+	inverted := CoverDPsDefault
+	inverted.InvertedPercentages = true
+
+	for _, tc := range []struct {
+		name    string
+		dps     CoverDPs
+		state   State
+		want    int
+		wantErr bool
+	}{
+		{"reported", CoverDPsDefault, State{2: 50, 3: 40}, 40, false},
+		{"target only", CoverDPsDefault, State{2: 50}, 50, false},
+		{"no reported dp", noState, State{2: 50, 3: 40}, 50, false},
+		{"inverted", inverted, State{2: 50, 3: 25}, 75, false},
+		{"missing", CoverDPsDefault, State{1: "stop"}, 0, true},
+		{"not a number", CoverDPsDefault, State{3: "half"}, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, _ := newTestManagerState(t, tc.state)
+			defer m.Close()
+			pct, err := NewCover(m, tc.dps).Position()
+			if pct != tc.want || (err != nil) != tc.wantErr {
+				t.Errorf("Position() = %d, %v; want %d", pct, err, tc.want)
+			}
+		})
+	}
+}
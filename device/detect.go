@@ -0,0 +1,81 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// DetectVersions are the protocol versions DetectVersion tries, in order.
+var DetectVersions = []string{net.Version33, net.Version31}
+
+// DetectVersion finds the protocol version of a device that hasn't been seen
+// broadcasting, such as one given by IP address, by querying its state with
+// each of DetectVersions in turn. Each attempt waits up to timeout for a
+// reply; zero means 5s. Versions are tried one at a time since many devices
+// accept only one connection. The probe connections are closed before
+// returning.
+func DetectVersion(id string, config net.ClientConfig, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	var errs []string
+	for _, version := range DetectVersions {
+		config.Version = version
+		err := probeVersion(id, config, timeout)
+		if err == nil {
+			return version, nil
+		}
+		if _, ok := err.(dialError); ok {
+			// The device is unreachable with any version.
+			return "", err
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", version, err))
+	}
+	return "", fmt.Errorf("no protocol version worked: %s", strings.Join(errs, "; "))
+}
+
+type dialError struct{ error }
+
+// Query a device with config.Version, succeeding only if it answers in kind.
+func probeVersion(id string, config net.ClientConfig, timeout time.Duration) error {
+	// Protocol 3.3 devices encrypt state replies; a plaintext reply means the
+	// device speaks 3.1 and tolerated the request.
+	var mu sync.Mutex
+	encrypted := false
+	received := config.Hooks.Received
+	config.Hooks.Received = func(f *net.Frame, plaintext []byte, err error) {
+		if f.Cmd == cmdQuery && err == nil {
+			mu.Lock()
+			encrypted = !bytes.Equal(plaintext, f.Payload)
+			mu.Unlock()
+		}
+		if received != nil {
+			received(f, plaintext, err)
+		}
+	}
+
+	client, err := config.Dial()
+	if err != nil {
+		return dialError{err}
+	}
+	m := NewManager(id, client)
+	defer m.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := m.GetStateContext(ctx); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if config.Version == net.Version33 && !encrypted {
+		return errors.New("unencrypted reply")
+	}
+	return nil
+}
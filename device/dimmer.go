@@ -0,0 +1,103 @@
+package device
+
+// DimmerDPs describes the dp layout of a dimmer switch or module.
+type DimmerDPs struct {
+	Switch, Brightness uint32
+
+	// MinBrightness is the dp holding the configured lowest brightness, below
+	// which many lamps flicker or turn off; 0 if none.
+	MinBrightness uint32
+
+	// Raw brightness dp range.
+	Min, Max int
+}
+
+var (
+	// DimmerDPs255 is the layout of older dimmers with a 1-255 brightness.
+	DimmerDPs255 = DimmerDPs{Switch: 1, Brightness: 2, Min: 1, Max: 255}
+
+	// DimmerDPs1000 is the layout of newer dimmers with a 10-1000 brightness
+	// and a minimum brightness dp.
+	DimmerDPs1000 = DimmerDPs{
+		Switch: 1, Brightness: 2, MinBrightness: 3, Min: 10, Max: 1000,
+	}
+)
+
+// A Dimmer controls a dimmer switch. Brightness percentages are scaled between
+// the device's minimum brightness (if it has one) and its maximum, so 1% is
+// the dimmest stable level rather than a level the lamp can't hold.
+type Dimmer struct {
+	*Manager
+	DPs DimmerDPs
+}
+
+// NewDimmer creates a Dimmer with the given dp layout.
+func NewDimmer(m *Manager, dps DimmerDPs) *Dimmer {
+	return &Dimmer{Manager: m, DPs: dps}
+}
+
+// SetOn turns the dimmer on at its previous brightness, or off.
+func (d *Dimmer) SetOn(on bool) error {
+	return d.SetState(State{d.DPs.Switch: on})
+}
+
+// Brightness returns whether the dimmer is on and its brightness percentage.
+func (d *Dimmer) Brightness() (on bool, pct float64, err error) {
+	state, err := d.GetState()
+	if err != nil {
+		return false, 0, err
+	}
+	if on, err = state.Bool(d.DPs.Switch); err != nil {
+		return false, 0, err
+	}
+	raw, err := state.Int(d.DPs.Brightness)
+	if err != nil {
+		return false, 0, err
+	}
+	return on, unscale(raw, d.floor(state), d.DPs.Max), nil
+}
+
+// SetBrightness turns the dimmer on at the given brightness percentage, or off
+// if it is zero.
+//
+// Sending the switch and level dps together makes many dimmers turn on at the
+// old level and then ramp, which flickers, so when the dimmer is off the level
+// is written first and the switch second.
+func (d *Dimmer) SetBrightness(pct float64) error {
+	if pct <= 0 {
+		return d.SetOn(false)
+	}
+	state, err := d.GetState()
+	if err != nil {
+		return err
+	}
+	level := State{d.DPs.Brightness: scale(pct, d.floor(state), d.DPs.Max)}
+	if err := d.SetState(level); err != nil {
+		return err
+	}
+	if on, _ := state.Bool(d.DPs.Switch); on {
+		return nil
+	}
+	return d.SetOn(true)
+}
+
+// SetMinBrightness sets the device's minimum brightness as a percentage of the
+// full raw range.
+func (d *Dimmer) SetMinBrightness(pct float64) error {
+	if d.DPs.MinBrightness == 0 {
+		return ErrUnsupported
+	}
+	return d.SetState(State{
+		d.DPs.MinBrightness: scale(pct, d.DPs.Min, d.DPs.Max),
+	})
+}
+
+// Return the lowest usable raw brightness given the device state.
+func (d *Dimmer) floor(state State) int {
+	if d.DPs.MinBrightness != 0 {
+		if min, err := state.Int(d.DPs.MinBrightness); err == nil && min > d.DPs.Min {
+			return min
+		}
+	}
+	return d.DPs.Min
+}
@@ -0,0 +1,111 @@
+package device
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDimmer(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		dps     DimmerDPs
+		state   State
+		call    func(*Dimmer) error
+		want    []State
+		wantErr error
+	}{
+		{
+			name: "on",
+			dps:  DimmerDPs255,
+			call: func(d *Dimmer) error { return d.SetOn(true) },
+			want: []State{{1: true}},
+		},
+		{
+			name:  "brightness while on",
+			dps:   DimmerDPs255,
+			state: State{1: true, 2: 10},
+			call:  func(d *Dimmer) error { return d.SetBrightness(50) },
+			want:  []State{{2: 128}},
+		},
+		{
+			// The level goes first, so the lamp doesn't flash the old one.
+			name:  "brightness while off",
+			dps:   DimmerDPs255,
+			state: State{1: false, 2: 10},
+			call:  func(d *Dimmer) error { return d.SetBrightness(50) },
+			want:  []State{{2: 128}, {1: true}},
+		},
+		{
+			name:  "brightness above minimum",
+			dps:   DimmerDPs1000,
+			state: State{1: true, 2: 500, 3: 100},
+			call:  func(d *Dimmer) error { return d.SetBrightness(50) },
+			want:  []State{{2: 550}},
+		},
+		{
+			name:  "brightness lowest",
+			dps:   DimmerDPs1000,
+			state: State{1: true, 2: 500, 3: 100},
+			call:  func(d *Dimmer) error { return d.SetBrightness(0.01) },
+			want:  []State{{2: 100}},
+		},
+		{
+			name:  "brightness zero",
+			dps:   DimmerDPs1000,
+			state: State{1: true, 2: 500},
+			call:  func(d *Dimmer) error { return d.SetBrightness(0) },
+			want:  []State{{1: false}},
+		},
+		{
+			name: "minimum brightness",
+			dps:  DimmerDPs1000,
+			call: func(d *Dimmer) error { return d.SetMinBrightness(10) },
+			want: []State{{3: 109}},
+		},
+		{
+			name:    "no minimum brightness",
+			dps:     DimmerDPs255,
+			call:    func(d *Dimmer) error { return d.SetMinBrightness(10) },
+			wantErr: ErrUnsupported,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			state := tc.state
+			if state == nil {
+				state = State{}
+			}
+			m, d := newTestManagerState(t, state)
+			defer m.Close()
+			if err := tc.call(NewDimmer(m, tc.dps)); !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+			checkSets(t, d, tc.want...)
+		})
+	}
+}
+
+func TestDimmerBrightness(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		dps     DimmerDPs
+		state   State
+		on      bool
+		pct     float64
+		wantErr bool
+	}{
+		{"255", DimmerDPs255, State{1: false, 2: 128}, false, 50, false},
+		{"above minimum", DimmerDPs1000, State{1: true, 2: 550, 3: 100}, true, 50, false},
+		{"minimum below range", DimmerDPs1000, State{1: true, 2: 10, 3: 0}, true, 0, false},
+		{"no switch", DimmerDPs255, State{2: 128}, false, 0, true},
+		{"no level", DimmerDPs255, State{1: true}, false, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, _ := newTestManagerState(t, tc.state)
+			defer m.Close()
+			on, pct, err := NewDimmer(m, tc.dps).Brightness()
+			if on != tc.on || pct != tc.pct || (err != nil) != tc.wantErr {
+				t.Errorf("Brightness() = %v, %v, %v; want %v, %v", on, pct, err, tc.on, tc.pct)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package device
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testSnapshot = "eyJidWNrZXQiOiJ0eS11cyIsImZpbGVzIjpbWyIvc25hcC8xLmpwZyIsImFiY2QiXV19"
+
+func TestDecodeDoorbellEvent(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		value   string
+		bucket  string
+		files   [][]string
+		raw     string
+		wantErr bool
+	}{
+		{
+			name:   "snapshot",
+			value:  testSnapshot,
+			bucket: "ty-us",
+			files:  [][]string{{"/snap/1.jpg", "abcd"}},
+			raw:    `{"bucket":"ty-us","files":[["/snap/1.jpg","abcd"]]}`,
+		},
+		{name: "not JSON", value: "AQI=", raw: "\x01\x02"},
+		{name: "not base64", value: "!!", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			event, err := DecodeDoorbellEvent(EventMotion, tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if event.Kind != EventMotion || event.Bucket != tc.bucket ||
+				!reflect.DeepEqual(event.Files, tc.files) || string(event.Raw) != tc.raw {
+				t.Errorf("got %+v", event)
+			}
+			if event.Time.IsZero() {
+				t.Error("event has no time")
+			}
+		})
+	}
+}
+
+func TestDoorbellEvents(t *testing.T) {
+	m, d := newTestManager(t, false)
+	defer m.Close()
+	events, stop := NewDoorbell(m, DoorbellDPsDefault).Events()
+
+	next := func() DoorbellEvent {
+		t.Helper()
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatal("events closed")
+			}
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			return DoorbellEvent{}
+		}
+	}
+
+	// Pushes without event dps, or with undecodable ones, are skipped.
+	d.conn.Push(map[string]interface{}{"dps": State{1: true}})
+	d.conn.Push(map[string]interface{}{"dps": State{154: "!!"}})
+	d.conn.Push(map[string]interface{}{"dps": State{115: testSnapshot}})
+	if event := next(); event.Kind != EventMotion || event.Bucket != "ty-us" {
+		t.Errorf("got %+v, want a motion snapshot", event)
+	}
+	d.conn.Push(map[string]interface{}{"dps": State{154: "AQI="}})
+	if event := next(); event.Kind != EventButton || string(event.Raw) != "\x01\x02" {
+		t.Errorf("got %+v, want a button press", event)
+	}
+
+	stop()
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Errorf("got %+v after stop", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("events not closed by stop")
+	}
+}
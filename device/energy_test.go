@@ -0,0 +1,18 @@
+package device
+
+import (
+	"testing"
+)
+
+func TestDecodePhase(t *testing.T) {
+	r, err := DecodePhase("CQEABNIAARw=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Voltage != 230.5 || r.Current != 1.234 || r.Power != 284 {
+		t.Errorf("got %+v", r)
+	}
+	if _, err := DecodePhase("CQE="); err == nil {
+		t.Error("expected error for short data")
+	}
+}
@@ -0,0 +1,150 @@
+package device
+
+import (
+	"fmt"
+	"math"
+)
+
+// Fan directions accepted by the direction dp.
+const (
+	DirectionForward = "forward"
+	DirectionReverse = "reverse"
+)
+
+// FanDPs describes the dp layout of a fan, optionally with an integrated light.
+// A zero dp means the device has no such dp.
+type FanDPs struct {
+	Switch, Speed, Direction uint32
+
+	// SpeedLevels lists the speed dp's enum values from slowest to fastest.
+	// If empty, the speed dp is numeric in the range SpeedMin-SpeedMax.
+	SpeedLevels        []string
+	SpeedMin, SpeedMax int
+
+	LightSwitch, LightBrightness           uint32
+	LightBrightnessMin, LightBrightnessMax int
+}
+
+var (
+	// FanDPsDefault is the layout of common ceiling fans with an enum speed
+	// and an integrated dimmable light.
+	FanDPsDefault = FanDPs{
+		Switch: 1, Speed: 3, Direction: 8,
+		SpeedLevels: []string{"1", "2", "3", "4"},
+		LightSwitch: 15, LightBrightness: 16,
+		LightBrightnessMin: 10, LightBrightnessMax: 1000,
+	}
+
+	// FanDPsPercent is the layout of fans with a percentage speed dp and no
+	// light.
+	FanDPsPercent = FanDPs{
+		Switch: 1, Speed: 3, Direction: 8,
+		SpeedMin: 1, SpeedMax: 100,
+	}
+)
+
+// A Fan controls a ceiling fan or ventilator. Speeds are percentages; for
+// enum-speed devices they are rounded up to the next level.
+//
+// Combined fan+light devices often ignore a speed or brightness change unless
+// the matching switch dp is set in the same request, so setters always
+// include it.
+type Fan struct {
+	*Manager
+	DPs FanDPs
+}
+
+// NewFan creates a Fan with the given dp layout.
+func NewFan(m *Manager, dps FanDPs) *Fan {
+	return &Fan{Manager: m, DPs: dps}
+}
+
+// A FanStatus is a decoded fan state.
+type FanStatus struct {
+	On              bool
+	Speed           float64
+	Direction       string
+	LightOn         bool
+	LightBrightness float64
+}
+
+// Status reads and decodes the fan state. Dps missing from the device state
+// are left zero.
+func (f *Fan) Status() (*FanStatus, error) {
+	state, err := f.GetState()
+	if err != nil {
+		return nil, err
+	}
+	status := &FanStatus{}
+	status.On, _ = state.Bool(f.DPs.Switch)
+	if len(f.DPs.SpeedLevels) > 0 {
+		level, _ := state.String(f.DPs.Speed)
+		for i, l := range f.DPs.SpeedLevels {
+			if l == level {
+				status.Speed = 100 * float64(i+1) / float64(len(f.DPs.SpeedLevels))
+			}
+		}
+	} else if raw, err := state.Int(f.DPs.Speed); err == nil {
+		status.Speed = unscale(raw, f.DPs.SpeedMin, f.DPs.SpeedMax)
+	}
+	status.Direction, _ = state.String(f.DPs.Direction)
+	status.LightOn, _ = state.Bool(f.DPs.LightSwitch)
+	if raw, err := state.Int(f.DPs.LightBrightness); err == nil {
+		status.LightBrightness = unscale(raw,
+			f.DPs.LightBrightnessMin, f.DPs.LightBrightnessMax)
+	}
+	return status, nil
+}
+
+// SetOn turns the fan on or off, leaving any light alone.
+func (f *Fan) SetOn(on bool) error {
+	return f.SetState(State{f.DPs.Switch: on})
+}
+
+// SetSpeed turns the fan on at the given speed percentage.
+func (f *Fan) SetSpeed(pct float64) error {
+	if pct <= 0 {
+		return f.SetOn(false)
+	}
+	var speed interface{}
+	if levels := f.DPs.SpeedLevels; len(levels) > 0 {
+		i := int(math.Ceil(math.Min(pct, 100)/100*float64(len(levels)))) - 1
+		speed = levels[i]
+	} else {
+		speed = scale(pct, f.DPs.SpeedMin, f.DPs.SpeedMax)
+	}
+	return f.SetState(State{f.DPs.Switch: true, f.DPs.Speed: speed})
+}
+
+// SetDirection sets the fan direction; see DirectionForward and
+// DirectionReverse.
+func (f *Fan) SetDirection(dir string) error {
+	if f.DPs.Direction == 0 {
+		return ErrUnsupported
+	}
+	if dir != DirectionForward && dir != DirectionReverse {
+		return fmt.Errorf("bad direction %q", dir)
+	}
+	return f.SetState(State{f.DPs.Direction: dir})
+}
+
+// SetLight turns the integrated light on or off.
+func (f *Fan) SetLight(on bool) error {
+	if f.DPs.LightSwitch == 0 {
+		return ErrUnsupported
+	}
+	return f.SetState(State{f.DPs.LightSwitch: on})
+}
+
+// SetLightBrightness turns the integrated light on at the given brightness
+// percentage.
+func (f *Fan) SetLightBrightness(pct float64) error {
+	if f.DPs.LightSwitch == 0 || f.DPs.LightBrightness == 0 {
+		return ErrUnsupported
+	}
+	return f.SetState(State{
+		f.DPs.LightSwitch: true,
+		f.DPs.LightBrightness: scale(pct,
+			f.DPs.LightBrightnessMin, f.DPs.LightBrightnessMax),
+	})
+}
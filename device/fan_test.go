@@ -0,0 +1,89 @@
+package device
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFan(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		dps     FanDPs
+		call    func(*Fan) error
+		want    []State
+		wantErr error
+	}{
+		{"on", FanDPsDefault, func(f *Fan) error { return f.SetOn(true) }, []State{{1: true}}, nil},
+		{"speed level", FanDPsDefault, func(f *Fan) error { return f.SetSpeed(30) }, []State{{1: true, 3: "2"}}, nil},
+		{"speed top level", FanDPsDefault, func(f *Fan) error { return f.SetSpeed(100) }, []State{{1: true, 3: "4"}}, nil},
+		{"speed over 100", FanDPsDefault, func(f *Fan) error { return f.SetSpeed(150) }, []State{{1: true, 3: "4"}}, nil},
+		{"speed lowest level", FanDPsDefault, func(f *Fan) error { return f.SetSpeed(1) }, []State{{1: true, 3: "1"}}, nil},
+		{"speed zero", FanDPsDefault, func(f *Fan) error { return f.SetSpeed(0) }, []State{{1: false}}, nil},
+		{"speed percent", FanDPsPercent, func(f *Fan) error { return f.SetSpeed(50) }, []State{{1: true, 3: 51}}, nil},
+		{"direction", FanDPsDefault, func(f *Fan) error { return f.SetDirection(DirectionReverse) }, []State{{8: "reverse"}}, nil},
+		{"no direction", FanDPs{Switch: 1, Speed: 3}, func(f *Fan) error { return f.SetDirection(DirectionForward) }, nil, ErrUnsupported},
+		{"light", FanDPsDefault, func(f *Fan) error { return f.SetLight(true) }, []State{{15: true}}, nil},
+		{"no light", FanDPsPercent, func(f *Fan) error { return f.SetLight(true) }, nil, ErrUnsupported},
+		{"light brightness", FanDPsDefault, func(f *Fan) error { return f.SetLightBrightness(50) }, []State{{15: true, 16: 505}}, nil},
+		{"no light brightness", FanDPsPercent, func(f *Fan) error { return f.SetLightBrightness(50) }, nil, ErrUnsupported},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, d := newTestManagerState(t, State{})
+			defer m.Close()
+			if err := tc.call(NewFan(m, tc.dps)); !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+			checkSets(t, d, tc.want...)
+		})
+	}
+}
+
+func TestFanBadDirection(t *testing.T) {
+	m, d := newTestManagerState(t, State{})
+	defer m.Close()
+	if err := NewFan(m, FanDPsDefault).SetDirection("sideways"); err == nil {
+		t.Error("SetDirection accepted a bad direction")
+	}
+	checkSets(t, d)
+}
+
+func TestFanStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		dps   FanDPs
+		state State
+		want  FanStatus
+	}{
+		{
+			name:  "levels and light",
+			dps:   FanDPsDefault,
+			state: State{1: true, 3: "2", 8: "reverse", 15: true, 16: 505},
+			want:  FanStatus{On: true, Speed: 50, Direction: "reverse", LightOn: true, LightBrightness: 50},
+		},
+		{
+			name:  "unknown level",
+			dps:   FanDPsDefault,
+			state: State{1: true, 3: "turbo"},
+			want:  FanStatus{On: true},
+		},
+		{
+			name:  "percent",
+			dps:   FanDPsPercent,
+			state: State{1: false, 3: 100, 8: "forward"},
+			want:  FanStatus{Speed: 100, Direction: "forward"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, _ := newTestManagerState(t, tc.state)
+			defer m.Close()
+			status, err := NewFan(m, tc.dps).Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*status, tc.want) {
+				t.Errorf("Status() = %+v, want %+v", *status, tc.want)
+			}
+		})
+	}
+}
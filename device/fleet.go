@@ -0,0 +1,418 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// ErrUnknownDevice is returned, wrapped with the ID, for device IDs not added
+// to a Fleet; check for it with errors.Is.
+var ErrUnknownDevice = errors.New("unknown device")
+
+// A Fleet manages connections to many devices, keyed by device ID. Devices are
+// connected lazily and reconnected after their connection fails.
+//
+// Each connected device has a Manager with its own read loop goroutine. While
+// waiting for frames it is parked in the runtime's network poller, which
+// already services many connections from a few threads, so the per-device
+// cost is a small goroutine stack and a frame buffer. For very large fleets,
+// IdleTimeout closes connections to devices that aren't in use.
+type Fleet struct {
+	// Registry, if set, is used to find the address of devices added without
+	// one.
+	Registry *Registry
+
+	// Hooks are given to each Manager the Fleet creates.
+	Hooks Hooks
+
+	// VerifyID is set on each Manager the Fleet creates; see
+	// ManagerConfig.VerifyID.
+	VerifyID bool
+
+	// IdleTimeout and IdleHeartbeat are set on each Manager the Fleet
+	// creates; see ManagerConfig.IdleTimeout. Managers closed while idle are
+	// reconnected on next use.
+	IdleTimeout   time.Duration
+	IdleHeartbeat bool
+
+	// DetectVersion makes the Fleet find the protocol version of devices
+	// added without one, and not seen broadcasting, with DetectVersion,
+	// waiting up to DetectTimeout for each version tried. Detected versions
+	// are remembered in the Registry, if set, and for the device.
+	DetectVersion bool
+	DetectTimeout time.Duration
+
+	// Strict sets net.ClientConfig.Strict on each connection, and refuses
+	// devices whose broadcasts fail net.Status.CheckStrict.
+	Strict bool
+
+	// Breaker configures per-device circuit breakers. Set it before use.
+	Breaker BreakerConfig
+
+	// RateLimit limits how fast the Fleet writes to devices. Set it before
+	// use.
+	RateLimit RateLimitConfig
+
+	mu       sync.Mutex
+	configs  map[string]net.ClientConfig
+	managers map[string]*Manager
+	breakers map[string]*breaker
+	limiters map[string]*net.RateLimiter
+	global   *net.RateLimiter
+}
+
+// A RateLimitConfig configures a Fleet's write rate limits, in writes per
+// second with bursts of up to the given sizes. Zero rates are unlimited.
+type RateLimitConfig struct {
+	// Device limits each device's writes; the limit carries over when a
+	// device is reconnected.
+	Device      float64
+	DeviceBurst int
+
+	// Global limits writes to all devices together.
+	Global      float64
+	GlobalBurst int
+}
+
+// A Result is the outcome of a Fleet operation on one device.
+type Result struct {
+	ID    string
+	State State
+	Err   error
+}
+
+// NewFleet creates an empty Fleet.
+func NewFleet() *Fleet {
+	return &Fleet{
+		configs:  make(map[string]net.ClientConfig),
+		managers: make(map[string]*Manager),
+		breakers: make(map[string]*breaker),
+		limiters: make(map[string]*net.RateLimiter),
+	}
+}
+
+// Add adds or updates a device. If config.Addr is empty, the address is
+// looked up in the Registry when connecting.
+func (f *Fleet) Add(id string, config net.ClientConfig) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.configs[id] = config
+	delete(f.breakers, id)
+	delete(f.limiters, id)
+	if m, ok := f.managers[id]; ok {
+		// Reconnect with the new config on next use.
+		delete(f.managers, id)
+		m.Close()
+	}
+}
+
+// Remove removes a device, closing its connection.
+func (f *Fleet) Remove(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.configs, id)
+	delete(f.breakers, id)
+	delete(f.limiters, id)
+	if m, ok := f.managers[id]; ok {
+		delete(f.managers, id)
+		m.Close()
+	}
+}
+
+// IDs returns the IDs of all devices in the Fleet.
+func (f *Fleet) IDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, 0, len(f.configs))
+	for id := range f.configs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Connected reports whether the Fleet has a live connection to a device.
+func (f *Fleet) Connected(id string) bool {
+	f.mu.Lock()
+	m := f.managers[id]
+	f.mu.Unlock()
+	return m != nil && m.Err() == nil
+}
+
+// Manager returns a connected Manager for a device, connecting if necessary.
+// The Manager is owned by the Fleet and must not be closed by the caller. If
+// the device's circuit breaker is open, it returns ErrBreakerOpen instead of
+// connecting or returning an open connection.
+func (f *Fleet) Manager(id string) (*Manager, error) {
+	f.mu.Lock()
+	config, ok := f.configs[id]
+	m := f.managers[id]
+	f.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDevice, id)
+	}
+	// The breaker gates requests over an open connection too, since a
+	// device can stop answering without dropping it.
+	if !f.allow(id) {
+		return nil, fmt.Errorf("%w: %s", ErrBreakerOpen, id)
+	}
+	if m != nil && m.Err() == nil {
+		return m, nil
+	}
+	if m != nil && config.Metrics != nil {
+		config.Metrics.Reconnected()
+	}
+
+	if f.Strict && f.Registry != nil {
+		if status, ok := f.Registry.Status(id); ok {
+			if err := status.CheckStrict(); err != nil {
+				f.record(id, err)
+				return nil, err
+			}
+		}
+	}
+	config.Strict = config.Strict || f.Strict
+	if config.Addr == "" {
+		if f.Registry == nil {
+			return nil, fmt.Errorf("no address for %s", id)
+		}
+		status, ok := f.Registry.Status(id)
+		if !ok {
+			return nil, fmt.Errorf("no broadcast seen from %s", id)
+		}
+		found := status.ClientConfig()
+		config.Addr = found.Addr
+		if config.Version == "" {
+			config.Version = found.Version
+		}
+	}
+	if config.Version == "" && f.Registry != nil {
+		config.Version, _ = f.Registry.Version(id)
+	}
+	if config.Version == "" && config.Strict {
+		// Nothing else would be allowed, so there's no need to detect.
+		config.Version = net.Version33
+	}
+	if config.Version == "" && f.DetectVersion {
+		version, err := DetectVersion(id, config, f.DetectTimeout)
+		if err != nil {
+			f.record(id, err)
+			return nil, err
+		}
+		config.Version = version
+		f.setVersion(id, version)
+	}
+	// Copy the limiters so appending doesn't modify the stored config.
+	limiters, err := f.rateLimiters(id)
+	if err != nil {
+		return nil, err
+	}
+	config.RateLimiters = append(append([]*net.RateLimiter{}, config.RateLimiters...), limiters...)
+	client, err := config.Dial()
+	if err != nil {
+		f.record(id, err)
+		return nil, err
+	}
+	m = ManagerConfig{
+		Hooks: Hooks{Request: func(id string, cmd uint32, latency time.Duration, err error) {
+			f.record(id, err)
+			if f.Hooks.Request != nil {
+				f.Hooks.Request(id, cmd, latency, err)
+			}
+		}},
+		VerifyID:      f.VerifyID,
+		IdleTimeout:   f.IdleTimeout,
+		IdleHeartbeat: f.IdleHeartbeat,
+	}.NewManager(id, client)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if existing := f.managers[id]; existing != nil && existing.Err() == nil {
+		// Lost a race with another connection attempt.
+		m.Close()
+		return existing, nil
+	}
+	f.managers[id] = m
+	return m, nil
+}
+
+// Remember a detected protocol version.
+func (f *Fleet) setVersion(id, version string) {
+	if f.Registry != nil {
+		f.Registry.SetVersion(id, version)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if config, ok := f.configs[id]; ok && config.Version == "" {
+		config.Version = version
+		f.configs[id] = config
+	}
+}
+
+// Return the device's and global rate limiters, creating them as needed.
+func (f *Fleet) rateLimiters(id string) ([]*net.RateLimiter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var limiters []*net.RateLimiter
+	if f.RateLimit.Device > 0 {
+		l := f.limiters[id]
+		if l == nil {
+			var err error
+			l, err = net.NewRateLimiter(f.RateLimit.Device, f.RateLimit.DeviceBurst)
+			if err != nil {
+				return nil, fmt.Errorf("device RateLimit: %w", err)
+			}
+			f.limiters[id] = l
+		}
+		limiters = append(limiters, l)
+	}
+	if f.RateLimit.Global > 0 {
+		if f.global == nil {
+			l, err := net.NewRateLimiter(f.RateLimit.Global, f.RateLimit.GlobalBurst)
+			if err != nil {
+				return nil, fmt.Errorf("global RateLimit: %w", err)
+			}
+			f.global = l
+		}
+		limiters = append(limiters, f.global)
+	}
+	return limiters, nil
+}
+
+// Report whether the device's breaker allows connecting.
+func (f *Fleet) allow(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := f.breakers[id]
+	return b == nil || b.allow(time.Now())
+}
+
+// Record the outcome of a connection or request for the device's breaker.
+func (f *Fleet) record(id string, err error) {
+	if f.Breaker.Failures == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.configs[id]; !ok {
+		return
+	}
+	b := f.breakers[id]
+	if b == nil {
+		b = &breaker{}
+		f.breakers[id] = b
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The caller gave up, which says nothing either way.
+	case isFailure(err):
+		b.failure(time.Now(), f.Breaker)
+	default:
+		b.success()
+	}
+}
+
+// DeviceStats describes the health of a Fleet device's connection.
+type DeviceStats struct {
+	ID        string `json:"id"`
+	Connected bool   `json:"connected"`
+	// Breaker is the state of the device's circuit breaker: BreakerClosed,
+	// BreakerOpen, or BreakerProbing.
+	Breaker string `json:"breaker"`
+	// Failures is the number of consecutive failed connections and
+	// requests.
+	Failures int `json:"failures"`
+	// RetryAt is when an open breaker next allows a probe.
+	RetryAt *time.Time `json:"retryAt,omitempty"`
+}
+
+// Stats returns the health of each device, ordered by ID.
+func (f *Fleet) Stats() []DeviceStats {
+	ids := f.IDs()
+	sort.Strings(ids)
+	now := time.Now()
+	stats := make([]DeviceStats, len(ids))
+	for i, id := range ids {
+		stats[i] = DeviceStats{ID: id, Connected: f.Connected(id), Breaker: BreakerClosed}
+		f.mu.Lock()
+		if b := f.breakers[id]; b != nil {
+			stats[i].Breaker = b.state(now)
+			stats[i].Failures = b.failures
+			if b.open {
+				retryAt := b.retryAt
+				stats[i].RetryAt = &retryAt
+			}
+		}
+		f.mu.Unlock()
+	}
+	return stats
+}
+
+// Do runs fn concurrently for each of the given devices, returning results in
+// the same order as ids.
+func (f *Fleet) Do(ids []string, fn func(*Manager) (State, error)) []Result {
+	results := make([]Result, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			results[i].ID = id
+			m, err := f.Manager(id)
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			results[i].State, results[i].Err = fn(m)
+		}(i, id)
+	}
+	wg.Wait()
+	return results
+}
+
+// GetState requests the state of each of the given devices concurrently.
+func (f *Fleet) GetState(ids ...string) []Result {
+	return f.Do(ids, func(m *Manager) (State, error) {
+		return m.GetState()
+	})
+}
+
+// SetState applies the same state update to each of the given devices
+// concurrently.
+func (f *Fleet) SetState(state State, ids ...string) []Result {
+	return f.Do(ids, func(m *Manager) (State, error) {
+		return state, m.SetState(state)
+	})
+}
+
+// SetStateBatch applies a state update to each device in states, keyed by
+// device ID. Devices are written concurrently, so the batch takes about as
+// long as the slowest device's round trip. Results are sorted by ID.
+func (f *Fleet) SetStateBatch(states map[string]State) []Result {
+	ids := make([]string, 0, len(states))
+	for id := range states {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return f.Do(ids, func(m *Manager) (State, error) {
+		state := states[m.ID()]
+		return state, m.SetState(state)
+	})
+}
+
+// Close closes all connections. The Fleet may still be used afterwards; its
+// devices are reconnected on next use.
+func (f *Fleet) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, m := range f.managers {
+		delete(f.managers, id)
+		m.Close()
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package device
+
+import (
+	"errors"
+	stdnet "net"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// Serve testDevices on a local address.
+func listenTestDevices(t *testing.T, version string) *net.Server {
+	s, err := net.ServerConfig{Key: testKey, Version: version}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := s.Accept()
+			if err != nil {
+				return
+			}
+			d := &testDevice{conn: conn, state: State{1: false}}
+			go d.serve()
+		}
+	}()
+	return s
+}
+
+func TestFleetSetStateBatch(t *testing.T) {
+	s := listenTestDevices(t, net.Version33)
+	defer s.Close()
+	addr := s.Addr().String()
+	f := NewFleet()
+	defer f.Close()
+	for _, id := range []string{"b", "a", "c"} {
+		f.Add(id, net.ClientConfig{Addr: addr, Key: testKey, Version: net.Version33})
+	}
+	results := f.SetStateBatch(map[string]State{
+		"a": {1: true},
+		"b": {1: false},
+		"c": {1: true},
+	})
+	if len(results) != 3 {
+		t.Fatalf("got %d results", len(results))
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		if r := results[i]; r.ID != id || r.Err != nil {
+			t.Errorf("result %d = %+v, want success for %s", i, r, id)
+		}
+	}
+	for _, r := range f.GetState("a", "b") {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if want := r.ID == "a"; r.State[1] != want {
+			t.Errorf("%s state = %v", r.ID, r.State)
+		}
+	}
+}
+
+func TestDetectVersion(t *testing.T) {
+	for _, version := range []string{net.Version31, net.Version33} {
+		s := listenTestDevices(t, version)
+		config := net.ClientConfig{Addr: s.Addr().String(), Key: testKey}
+		got, err := DetectVersion("dev1", config, time.Second)
+		s.Close()
+		if err != nil || got != version {
+			t.Errorf("DetectVersion = %q, %v; want %q", got, err, version)
+		}
+	}
+}
+
+func TestFleetDetectVersion(t *testing.T) {
+	s := listenTestDevices(t, net.Version31)
+	defer s.Close()
+	f := NewFleet()
+	defer f.Close()
+	f.Registry = NewRegistry()
+	f.DetectVersion = true
+	f.DetectTimeout = time.Second
+	f.Add("dev1", net.ClientConfig{Addr: s.Addr().String(), Key: testKey})
+	if _, err := f.Manager("dev1"); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := f.Registry.Version("dev1"); version != net.Version31 {
+		t.Errorf("Registry version = %q", version)
+	}
+}
+
+func TestFleetStrict(t *testing.T) {
+	s := listenTestDevices(t, net.Version33)
+	defer s.Close()
+	f := NewFleet()
+	defer f.Close()
+	f.Registry = NewRegistry()
+	f.Strict = true
+	addr := s.Addr().(*stdnet.TCPAddr)
+	f.Registry.Update(&net.Status{GatewayID: "plain", IP: addr.IP.String(), Version: net.Version33})
+	f.Add("plain", net.ClientConfig{Addr: addr.String(), Key: testKey})
+	if _, err := f.Manager("plain"); !errors.Is(err, net.ErrInsecure) {
+		t.Errorf("Manager of device announcing encrypt: false = %v, want ErrInsecure", err)
+	}
+
+	// Without a broadcast, the version is taken to be 3.3.
+	f.Add("dev1", net.ClientConfig{Addr: addr.String(), Key: testKey})
+	if _, err := f.Manager("dev1"); err != nil {
+		t.Fatal(err)
+	}
+	f.Add("old", net.ClientConfig{Addr: addr.String(), Key: testKey, Version: net.Version31})
+	if _, err := f.Manager("old"); !errors.Is(err, net.ErrInsecure) {
+		t.Errorf("Manager with protocol 3.1 = %v, want ErrInsecure", err)
+	}
+	if _, err := f.Manager("missing"); !errors.Is(err, ErrUnknownDevice) {
+		t.Errorf("Manager of missing device = %v, want ErrUnknownDevice", err)
+	}
+}
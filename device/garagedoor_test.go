@@ -0,0 +1,100 @@
+package device
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGarageDoor(t *testing.T) {
+	inverted := GarageDoorDPsDefault
+	inverted.ContactOpen = false
+	quick := GarageDoorDPsDefault
+	quick.TravelTime = 10 * time.Millisecond
+
+	for _, tc := range []struct {
+		name  string
+		dps   GarageDoorDPs
+		state State
+		call  func(*GarageDoor) error
+		want  []State
+		// The door's state afterwards.
+		door string
+	}{
+		{"closed", GarageDoorDPsDefault, State{3: false}, nil, nil, DoorClosed},
+		{"open", GarageDoorDPsDefault, State{3: true}, nil, nil, DoorOpen},
+		{"inverted contact", inverted, State{3: true}, nil, nil, DoorClosed},
+		{"open closed door", GarageDoorDPsDefault, State{3: false}, (*GarageDoor).Open, []State{{1: true}}, DoorOpening},
+		{"open open door", GarageDoorDPsDefault, State{3: true}, (*GarageDoor).Open, nil, DoorOpen},
+		{"close open door", GarageDoorDPsDefault, State{3: true}, (*GarageDoor).Close, []State{{1: true}}, DoorClosing},
+		{"close closed door", GarageDoorDPsDefault, State{3: false}, (*GarageDoor).Close, nil, DoorClosed},
+		{
+			"open twice", GarageDoorDPsDefault, State{3: false},
+			func(g *GarageDoor) error {
+				if err := g.Open(); err != nil {
+					return err
+				}
+				return g.Open()
+			},
+			[]State{{1: true}}, DoorOpening,
+		},
+		{
+			// The contact never opened, so the door didn't move.
+			"opening expired", quick, State{3: false},
+			func(g *GarageDoor) error {
+				err := g.Open()
+				time.Sleep(20 * time.Millisecond)
+				return err
+			},
+			[]State{{1: true}}, DoorClosed,
+		},
+		{"trigger", GarageDoorDPsDefault, State{3: true}, (*GarageDoor).Trigger, []State{{1: true}}, DoorOpen},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, d := newTestManagerState(t, tc.state)
+			defer m.Close()
+			g := NewGarageDoor(m, tc.dps)
+			if tc.call != nil {
+				if err := tc.call(g); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkSets(t, d, tc.want...)
+			if door, err := g.State(); door != tc.door || err != nil {
+				t.Errorf("State() = %q, %v; want %q", door, err, tc.door)
+			}
+		})
+	}
+}
+
+func TestGarageDoorClosing(t *testing.T) {
+	m, d := newTestManagerState(t, State{3: true})
+	defer m.Close()
+	g := NewGarageDoor(m, GarageDoorDPsDefault)
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if door, _ := g.State(); door != DoorClosing {
+		t.Fatalf("State() = %q, want closing", door)
+	}
+
+	// A closing door is done as soon as the contact closes.
+	d.mu.Lock()
+	d.state[3] = false
+	d.mu.Unlock()
+	if door, _ := g.State(); door != DoorClosed {
+		t.Errorf("State() = %q after the contact closed, want closed", door)
+	}
+}
+
+func TestGarageDoorNoContact(t *testing.T) {
+	m, d := newTestManagerState(t, State{1: false})
+	defer m.Close()
+	g := NewGarageDoor(m, GarageDoorDPsDefault)
+	if _, err := g.State(); err == nil {
+		t.Error("State() succeeded without a contact dp")
+	}
+	if err := g.Open(); err == nil {
+		t.Error("Open() succeeded without knowing the door's state")
+	}
+	checkSets(t, d)
+}
@@ -0,0 +1,85 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// IRBlasterDPs describes the dp layout of an IR blaster.
+type IRBlasterDPs struct {
+	// Control takes a JSON command string such as {"control":"study"}.
+	Control uint32
+
+	// Learned receives the base64 code captured in study mode.
+	Learned uint32
+}
+
+// IRBlasterDPsDefault is the layout of the common IR blasters using JSON
+// control commands.
+var IRBlasterDPsDefault = IRBlasterDPs{Control: 201, Learned: 202}
+
+// An IRBlaster learns and sends infrared remote control codes.
+type IRBlaster struct {
+	*Manager
+	DPs IRBlasterDPs
+}
+
+// NewIRBlaster creates an IRBlaster with the given dp layout.
+func NewIRBlaster(m *Manager, dps IRBlasterDPs) *IRBlaster {
+	return &IRBlaster{Manager: m, DPs: dps}
+}
+
+func (b *IRBlaster) control(cmd map[string]interface{}) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("control Marshal: %w", err)
+	}
+	return b.SetState(State{b.DPs.Control: string(data)})
+}
+
+// Learn puts the blaster into study mode and waits for a button press on a
+// remote pointed at it, returning the captured base64 code.
+func (b *IRBlaster) Learn(timeout time.Duration) (string, error) {
+	updates, stop := b.Watch()
+	defer stop()
+
+	if err := b.control(map[string]interface{}{"control": "study"}); err != nil {
+		return "", err
+	}
+	defer b.control(map[string]interface{}{"control": "study_exit"})
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case state, ok := <-updates:
+			if !ok {
+				return "", ErrClosed
+			}
+			if code, err := state.String(b.DPs.Learned); err == nil && code != "" {
+				return code, nil
+			}
+		case <-deadline:
+			return "", ErrTimeout
+		}
+	}
+}
+
+// Send transmits a code captured by Learn.
+func (b *IRBlaster) Send(code string) error {
+	// Learned codes are sent as key1 with a "1" prefix and no head.
+	return b.SendHeadKey("", "1"+code)
+}
+
+// SendHeadKey transmits a code in the head/key1 format used by newer blasters
+// and their code libraries, where head holds the protocol timing and key1 the
+// button data.
+func (b *IRBlaster) SendHeadKey(head, key string) error {
+	return b.control(map[string]interface{}{
+		"control": "send_ir",
+		"head":    head,
+		"key1":    key,
+		"type":    0,
+		"delay":   300,
+	})
+}
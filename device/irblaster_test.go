@@ -0,0 +1,77 @@
+package device
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestIRBlasterLearn(t *testing.T) {
+	m, d := newTestManager(t, false)
+	defer m.Close()
+	b := NewIRBlaster(m, IRBlasterDPsDefault)
+
+	type result struct {
+		code string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, err := b.Learn(5 * time.Second)
+		done <- result{code, err}
+	}()
+
+	// Press a button once the blaster is in study mode.
+	deadline := time.Now().Add(5 * time.Second)
+	for d.get(201) != `{"control":"study"}` {
+		if time.Now().After(deadline) {
+			t.Fatalf("blaster not put in study mode: dp 201 = %v", d.get(201))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	d.conn.Push(map[string]interface{}{"dps": State{202: "Y29kZQ=="}})
+
+	r := <-done
+	if r.err != nil || r.code != "Y29kZQ==" {
+		t.Fatalf("Learn = %q, %v", r.code, r.err)
+	}
+	if got := d.get(201); got != `{"control":"study_exit"}` {
+		t.Errorf("dp 201 = %v after Learn, want study_exit", got)
+	}
+}
+
+func TestIRBlasterLearnTimeout(t *testing.T) {
+	m, d := newTestManager(t, false)
+	defer m.Close()
+	b := NewIRBlaster(m, IRBlasterDPsDefault)
+
+	// The study mode echo carries no code, so Learn keeps waiting.
+	if _, err := b.Learn(20 * time.Millisecond); err != ErrTimeout {
+		t.Errorf("Learn = %v, want ErrTimeout", err)
+	}
+	if got := d.get(201); got != `{"control":"study_exit"}` {
+		t.Errorf("dp 201 = %v after Learn, want study_exit", got)
+	}
+
+	m.Close()
+	if _, err := b.Learn(time.Second); err != ErrClosed {
+		t.Errorf("Learn after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestIRBlasterSend(t *testing.T) {
+	m, d := newTestManager(t, false)
+	defer m.Close()
+	b := NewIRBlaster(m, IRBlasterDPsDefault)
+
+	if err := b.Send("Y29kZQ=="); err != nil {
+		t.Fatal(err)
+	}
+	var cmd map[string]interface{}
+	if err := json.Unmarshal([]byte(d.get(201).(string)), &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd["control"] != "send_ir" || cmd["key1"] != "1Y29kZQ==" || cmd["head"] != "" {
+		t.Errorf("sent %v", cmd)
+	}
+}
@@ -0,0 +1,89 @@
+package device
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	local := LockDPsDefault
+	local.RemoteUnlockRequiresCloud = false
+	start := time.Unix(1700000000, 0)
+	password := TemporaryPassword{ID: 1, Start: start, End: start.Add(time.Hour), Code: "1234"}
+
+	for _, tc := range []struct {
+		name    string
+		dps     LockDPs
+		call    func(*Lock) error
+		want    []State
+		wantErr error
+	}{
+		{"cloud required", LockDPsDefault, func(l *Lock) error { return l.SetLocked(false) }, nil, ErrCloudRequired},
+		{"lock", local, func(l *Lock) error { return l.SetLocked(true) }, []State{{47: true}}, nil},
+		{"unlock", local, func(l *Lock) error { return l.SetLocked(false) }, []State{{47: false}}, nil},
+		{"no locked dp", LockDPs{Battery: 8}, func(l *Lock) error { return l.SetLocked(true) }, nil, ErrUnsupported},
+		{
+			"temporary password", LockDPsDefault,
+			func(l *Lock) error { return l.CreateTemporaryPassword(password) },
+			[]State{{51: "AWVT8QBlU/8QBDEyMzQ="}}, nil,
+		},
+		{
+			"no temporary password dp", local,
+			func(l *Lock) error {
+				l.DPs.TemporaryPassword = 0
+				return l.CreateTemporaryPassword(password)
+			},
+			nil, ErrUnsupported,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, d := newTestManagerState(t, State{})
+			defer m.Close()
+			if err := tc.call(NewLock(m, tc.dps)); !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+			checkSets(t, d, tc.want...)
+		})
+	}
+}
+
+func TestTemporaryPasswordEncodeErrors(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	for _, p := range []TemporaryPassword{
+		{Start: start, End: start.Add(time.Hour)},
+		{Start: start, End: start.Add(time.Hour), Code: "12345678901234567"},
+		{Start: start, End: start.Add(time.Hour), Code: "12a4"},
+		{Start: start, End: start, Code: "1234"},
+		{Start: start, End: start.Add(-time.Hour), Code: "1234"},
+	} {
+		if code, err := p.Encode(); err == nil {
+			t.Errorf("Encode(%+v) = %q, want an error", p, code)
+		}
+	}
+}
+
+func TestLockStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		state State
+		want  LockStatus
+	}{
+		{"percent", State{47: true, 8: 80}, LockStatus{Locked: true, Battery: 80}},
+		{"level", State{47: false, 8: "low"}, LockStatus{BatteryLevel: "low"}},
+		{"asleep", State{}, LockStatus{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, _ := newTestManagerState(t, tc.state)
+			defer m.Close()
+			status, err := NewLock(m, LockDPsDefault).Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*status, tc.want) {
+				t.Errorf("Status() = %+v, want %+v", *status, tc.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,9 @@
 package device
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
@@ -13,34 +13,136 @@ import (
 // ErrClosed is return if the Manager has been closed.
 var ErrClosed = errors.New("closed")
 
-// A State holds device state ("dps") data.
-type State map[uint32]interface{}
+// ErrTimeout is returned when a device doesn't respond in time. It
+// implements net.Error, reporting a temporary timeout, and unwraps to
+// context.DeadlineExceeded for errors.Is.
+var ErrTimeout error = timeoutError{}
 
-// Wrap response and error to pass through responseChan.
-type response struct {
-	*net.Response
-	readErr error
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timed out" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+func (timeoutError) Unwrap() error   { return context.DeadlineExceeded }
+
+// ErrNoState is returned by a passive Manager's GetState until the device has
+// pushed its state.
+var ErrNoState = errors.New("no state received yet")
+
+// ErrWrongDevice is returned, with VerifyID, for replies naming a device
+// other than the Manager's, wrapped with the ID they named.
+var ErrWrongDevice = errors.New("reply from wrong device")
+
+// ErrUnsupported is returned when a device's dp layout has no dp for an
+// operation.
+var ErrUnsupported = errors.New("unsupported by device")
+
+// Command numbers.
+const (
+	cmdControl   = 0x07
+	cmdStatus    = 0x08
+	cmdHeartbeat = 0x09
+	cmdQuery     = 0x0a
+	cmdRefresh   = 0x12
+)
+
+type responseChan chan *net.Response
+
+// Hooks observe a Manager's requests, for metrics.
+type Hooks struct {
+	// Request, if not nil, is called after each request with the device ID,
+	// command number, how long the request took, and its error, if any.
+	Request func(deviceID string, cmd uint32, latency time.Duration, err error)
 }
 
-type responseChan chan response
+// A ManagerConfig holds a Manager's settings. They're fixed when the Manager
+// is created, since its read loop starts using them straight away.
+type ManagerConfig struct {
+	// Hooks observe the Manager's requests.
+	Hooks Hooks
+
+	// Now, if not nil, is used instead of time.Now for the timestamps sent
+	// in control requests, for deterministic tests and replays.
+	Now func() time.Time
+
+	// Logger receives messages about unexpected frames from the device.
+	// Nil means the Client's Logger, if any.
+	Logger net.Logger
+
+	// VerifyID rejects replies, and drops pushes, whose devId or gwId isn't
+	// the Manager's device ID, in case the connection reached the wrong
+	// device, as can happen with NAT hairpins and reused IPs. Replies
+	// without IDs are accepted.
+	VerifyID bool
+
+	// Timeout, if not zero, is how long each request may wait for rate
+	// limiters and the reply before returning ErrTimeout.
+	Timeout time.Duration
+
+	// IdleTimeout, if not zero, closes the Manager after that long without
+	// requests or frames from the device, counted from its first request,
+	// so idle connections don't linger half-dead until the next write
+	// fails. With IdleHeartbeat, a heartbeat is sent instead, and the
+	// Manager is closed only if it isn't answered.
+	IdleTimeout   time.Duration
+	IdleHeartbeat bool
+
+	// Codec marshals requests and unmarshals replies and pushes. Nil means
+	// the Client's Codec.
+	Codec net.Codec
+
+	// Tracer traces each request. Nil means the Client's Tracer, if any.
+	Tracer net.Tracer
+
+	// Passive is for devices that push their state but don't answer
+	// queries, such as battery-powered sensors: GetState returns the state
+	// accumulated from pushes instead of querying the device.
+	Passive bool
+}
 
 // A Manager handles request/response transactions with a device.
 type Manager struct {
+	config ManagerConfig
 	devID  string
 	client *net.Client
 
 	responseChans map[uint32]responseChan
+	watchers      map[chan State]struct{}
 	sync.Mutex
+
+	// Last known state, merged from query responses and pushes.
+	state State
+
 	closed  bool
 	readErr error
+
+	// Time of the last request or received frame, and the idle timer
+	// started by the first request.
+	lastActive time.Time
+	idle       *time.Timer
 }
 
-// NewManager creates a Manager for the given device ID and already-connected Client.
+// NewManager creates a Manager for the given device ID and already-connected
+// Client, with the default ManagerConfig.
 func NewManager(deviceID string, client *net.Client) *Manager {
+	return ManagerConfig{}.NewManager(deviceID, client)
+}
+
+// NewPassiveManager creates a Manager for devices that push their state but
+// don't answer queries; see ManagerConfig.Passive.
+func NewPassiveManager(deviceID string, client *net.Client) *Manager {
+	return ManagerConfig{Passive: true}.NewManager(deviceID, client)
+}
+
+// NewManager creates a Manager with this config for the given device ID and
+// already-connected Client.
+func (mc ManagerConfig) NewManager(deviceID string, client *net.Client) *Manager {
 	m := &Manager{
+		config:        mc,
 		devID:         deviceID,
 		client:        client,
 		responseChans: make(map[uint32]responseChan),
+		watchers:      make(map[chan State]struct{}),
 	}
 	m.start()
 	return m
@@ -50,7 +152,13 @@ func NewManager(deviceID string, client *net.Client) *Manager {
 func (m *Manager) Close() error {
 	m.Lock()
 	defer m.Unlock()
+	if m.closed {
+		return nil
+	}
 	m.closed = true
+	if m.idle != nil {
+		m.idle.Stop()
+	}
 	if m.readErr == nil {
 		m.readErr = ErrClosed
 	}
@@ -58,93 +166,425 @@ func (m *Manager) Close() error {
 		delete(m.responseChans, seq)
 		close(respChan)
 	}
+	for watcher := range m.watchers {
+		delete(m.watchers, watcher)
+		close(watcher)
+	}
 	return m.client.Close()
 }
 
+// Err returns the error that stopped the Manager, or nil if it is still
+// usable.
+func (m *Manager) Err() error {
+	m.Lock()
+	defer m.Unlock()
+	return m.readErr
+}
+
+// ID returns the device ID the Manager was created with.
+func (m *Manager) ID() string {
+	return m.devID
+}
+
 // Start a new goroutine for the client read loop.
 func (m *Manager) start() {
 	go func() {
 		defer m.Close()
+		// Frames are decoded into buf, which is reused for pushes and
+		// replaced after replies, whose payloads may share it.
+		buf := &net.Frame{}
 		for {
-			res, err := m.client.Read()
+			res, err := m.client.ReadFrame(buf)
 			m.Lock()
 			if m.closed {
-				break
+				m.Unlock()
+				return
 			}
+			if err != nil {
+				m.readErr = fmt.Errorf("Read: %w", err)
+				m.Unlock()
+				return
+			}
+			m.touch()
 			if respChan, ok := m.responseChans[res.Seq]; ok {
-				respChan <- response{
-					Response: res,
-					readErr:  err,
+				// Response channels hold one reply, and are unregistered
+				// once it's delivered, so this never blocks the read loop.
+				select {
+				case respChan <- res:
+					buf = &net.Frame{}
+				default:
+					m.logf(net.LevelWarn, "dropped extra reply to seq %d", res.Seq)
 				}
 				delete(m.responseChans, res.Seq)
+			} else if res.Cmd == cmdStatus {
+				m.push(res)
 			} else {
-				log.Printf("no request matching seq %d", res.Seq)
-			}
-			if err != nil {
-				m.readErr = fmt.Errorf("Read: %v", err)
-				break
+				m.logf(net.LevelWarn, "no request matching seq %d", res.Seq)
 			}
 			m.Unlock()
 		}
 	}()
 }
 
-// GetState requests the device state.
+// With VerifyID, check that a JSON payload's devId and gwId, if any, are the
+// Manager's device ID.
+func (m *Manager) verifyID(payload []byte) error {
+	if !m.config.VerifyID || len(payload) == 0 {
+		return nil
+	}
+	var ids struct {
+		DevID string `json:"devId"`
+		GwID  string `json:"gwId"`
+	}
+	if m.codec().Unmarshal(payload, &ids) != nil {
+		return nil
+	}
+	for _, id := range []string{ids.DevID, ids.GwID} {
+		if id != "" && id != m.devID {
+			return fmt.Errorf("%w: %s", ErrWrongDevice, id)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) codec() net.Codec {
+	if m.config.Codec != nil {
+		return m.config.Codec
+	}
+	return m.client.Codec()
+}
+
+// Log a message, prefixed with the device ID.
+func (m *Manager) logf(level net.Level, format string, args ...interface{}) {
+	logger := m.config.Logger
+	if logger == nil {
+		logger = m.client.Logger()
+	}
+	if logger != nil {
+		logger.Logf(level, "%s: %s", m.devID, fmt.Sprintf(format, args...))
+	}
+}
+
+// Deliver a pushed status update to watchers. Must be called with the lock held.
+func (m *Manager) push(res *net.Response) {
+	// Pushes may or may not carry a return code before the JSON.
+	payload := res.Payload
+	if len(payload) >= 4 && payload[0] == 0 {
+		if err := res.Err(); err != nil {
+			m.logf(net.LevelWarn, "push error: %v", err)
+			return
+		}
+		payload = payload[4:]
+	}
+	var push struct {
+		State State `json:"dps"`
+	}
+	if err := m.codec().Unmarshal(payload, &push); err != nil {
+		m.logf(net.LevelWarn, "push Unmarshal: %v", err)
+		return
+	}
+	if err := m.verifyID(payload); err != nil {
+		m.logf(net.LevelWarn, "push: %v", err)
+		return
+	}
+	m.mergeState(push.State)
+	for watcher := range m.watchers {
+		select {
+		case watcher <- push.State:
+		default:
+			// Don't let a slow watcher block the read loop.
+		}
+	}
+}
+
+// Watch returns a channel of state updates pushed by the device, such as
+// after a physical button press. Updates are dropped if the channel's buffer
+// is full. The channel is closed by calling the returned stop function or by
+// closing the Manager.
+func (m *Manager) Watch() (<-chan State, func()) {
+	m.Lock()
+	defer m.Unlock()
+	watcher := make(chan State, 16)
+	if m.closed {
+		close(watcher)
+		return watcher, func() {}
+	}
+	m.watchers[watcher] = struct{}{}
+	return watcher, func() {
+		m.Lock()
+		defer m.Unlock()
+		if _, ok := m.watchers[watcher]; ok {
+			delete(m.watchers, watcher)
+			close(watcher)
+		}
+	}
+}
+
+// Merge updated dps into the last known state. Must be called with the lock
+// held.
+func (m *Manager) mergeState(update State) {
+	if m.state == nil {
+		m.state = State{}
+	}
+	for dp, v := range update {
+		m.state[dp] = v
+	}
+}
+
+// LastState returns a copy of the last known device state, merged from query
+// responses and pushes, without contacting the device. It returns nil if no
+// state has been received.
+func (m *Manager) LastState() State {
+	m.Lock()
+	defer m.Unlock()
+	if m.state == nil {
+		return nil
+	}
+	state := make(State, len(m.state))
+	for dp, v := range m.state {
+		state[dp] = v
+	}
+	return state
+}
+
+// GetState requests the device state. For a passive Manager it returns the
+// last pushed state instead; see NewPassiveManager.
 func (m *Manager) GetState() (State, error) {
+	return m.GetStateContext(context.Background())
+}
+
+// GetStateContext is like GetState, but gives up waiting for the reply when
+// ctx is done, returning ctx.Err().
+func (m *Manager) GetStateContext(ctx context.Context) (State, error) {
+	if m.config.Passive {
+		state := m.LastState()
+		if state == nil {
+			return nil, ErrNoState
+		}
+		return state, nil
+	}
+
 	var res struct {
 		State State `json:"dps"`
 	}
-	err := m.request(0x0a, false, map[string]string{
+	err := m.request(ctx, cmdQuery, false, map[string]string{
 		"gwId":  m.devID,
 		"devId": m.devID,
 	}, &res)
+	if err == nil {
+		m.Lock()
+		m.mergeState(res.State)
+		m.Unlock()
+	}
 	return res.State, err
 }
 
 // SetState requests update(s) to the device state.
 func (m *Manager) SetState(state State) error {
-	return m.request(0x07, true, map[string]interface{}{
+	return m.SetStateContext(context.Background(), state)
+}
+
+// SetStateContext is like SetState, but gives up waiting for the reply when
+// ctx is done, returning ctx.Err(). The update may still be applied.
+func (m *Manager) SetStateContext(ctx context.Context, state State) error {
+	now := time.Now
+	if m.config.Now != nil {
+		now = m.config.Now
+	}
+	return m.request(ctx, cmdControl, true, map[string]interface{}{
 		"devId": m.devID,
 		"gwId":  m.devID,
 		"uid":   "",
-		"t":     time.Now().Unix(),
+		"t":     now().Unix(),
 		"dps":   state,
 	}, nil)
 }
 
+// Refresh asks the device to re-sample the given dps, such as energy readings,
+// which some devices otherwise only update every few minutes. It doesn't wait
+// for a reply; updated values show up in subsequent GetState calls.
+func (m *Manager) Refresh(dps ...uint32) error {
+	if err := m.Err(); err != nil {
+		return err
+	}
+	_, err := m.client.Write(cmdRefresh, true, map[string]interface{}{
+		"dpId": dps,
+	})
+	if err != nil {
+		return fmt.Errorf("refresh Write: %w", err)
+	}
+	return nil
+}
+
 // Manage a request write and a matching blocking response read.
 // The request is sent with the given `cmd` number, `req` payload, and
 // `encrypt` option (see net.Client.Write).
-func (m *Manager) request(cmd uint32, encrypt bool, req, res interface{}) error {
-	if m.readErr != nil {
-		return m.readErr
+func (m *Manager) request(ctx context.Context, cmd uint32, encrypt bool, req, res interface{}) error {
+	tracer := m.config.Tracer
+	if tracer == nil {
+		tracer = m.client.Tracer()
+	}
+	span := net.StartSpan(tracer, "tuya.request",
+		net.Attr{Key: net.AttrDeviceID, Value: m.devID},
+		net.Attr{Key: net.AttrCmd, Value: int(cmd)})
+	start := time.Now()
+	err := m.roundTrip(ctx, span, cmd, encrypt, req, res)
+	span.End(err)
+	if m.config.Hooks.Request != nil {
+		m.config.Hooks.Request(m.devID, cmd, time.Since(start), err)
 	}
+	return err
+}
 
-	// Write request
-	seq, err := m.client.Write(cmd, encrypt, req)
+func (m *Manager) roundTrip(ctx context.Context, span net.Span, cmd uint32, encrypt bool, req, res interface{}) error {
+	data, err := m.codec().Marshal(req)
 	if err != nil {
-		return fmt.Errorf("request Write: %v", err)
+		return fmt.Errorf("request Marshal: %w", err)
+	}
+	span.SetAttrs(net.Attr{Key: net.AttrRequestSize, Value: len(data)})
+
+	resp, err := m.exchange(ctx, m.client.NextSeq(), cmd, encrypt, data, m.config.Timeout)
+	if err != nil {
+		return err
+	}
+	span.SetAttrs(net.Attr{Key: net.AttrReplySize, Value: len(resp.Payload)})
+	if data, err := resp.Bytes(); err == nil {
+		if err := m.verifyID(data); err != nil {
+			return err
+		}
 	}
+	if res == nil {
+		return resp.Err()
+	}
+
+	// Decode response
+	if err := resp.Decode(m.codec(), res); err != nil {
+		return fmt.Errorf("response Decode: %w", err)
+	}
+	return nil
+}
 
-	// Register response channel with request seq number
+// Send a request with a seq number from the Client and wait for its reply,
+// for up to timeout if not zero, or until ctx is done. Abandoned requests
+// are unregistered, so late replies are dropped.
+func (m *Manager) exchange(ctx context.Context, seq, cmd uint32, encrypt bool, data []byte, timeout time.Duration) (*net.Response, error) {
+	// Register a response channel for the request's seq number before
+	// sending it, so a fast reply can't beat the registration.
+	// The channel is buffered so the read loop never blocks on it.
+	respChan := make(responseChan, 1)
 	m.Lock()
-	respChan := make(responseChan)
+	if m.readErr != nil {
+		m.Unlock()
+		return nil, m.readErr
+	}
 	m.responseChans[seq] = respChan
+	m.touch()
 	m.Unlock()
 
+	// The timeout covers waiting for rate limiters as well as the reply.
+	reqCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// Report our own timeout as ErrTimeout, and the caller's ctx as is.
+	reqErr := func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return ErrTimeout
+	}
+
+	// Write request
+	if err := m.client.WriteSeqContext(reqCtx, seq, cmd, encrypt, data); err != nil {
+		m.unregister(seq)
+		if reqCtx.Err() != nil {
+			return nil, reqErr()
+		}
+		return nil, fmt.Errorf("request Write: %w", err)
+	}
+
 	// Wait for response.
-	// TODO: add timeout (Context?)
-	resp := <-respChan
-	if resp.readErr != nil {
-		return fmt.Errorf("response: %v", err)
+	var resp *net.Response
+	var ok bool
+	select {
+	case resp, ok = <-respChan:
+	case <-reqCtx.Done():
+		m.unregister(seq)
+		return nil, reqErr()
 	}
-	if res == nil {
-		return resp.Err()
+	if !ok {
+		m.Lock()
+		defer m.Unlock()
+		return nil, m.readErr
 	}
+	return resp, nil
+}
 
-	// Decode response
-	if err := resp.DecodeJSON(res); err != nil {
-		return fmt.Errorf("response Decode: %v", err)
+// Stop waiting for a reply.
+func (m *Manager) unregister(seq uint32) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.responseChans, seq)
+}
+
+// Heartbeat sends a heartbeat and waits for the device's reply, for up to
+// Timeout if it's set.
+func (m *Manager) Heartbeat() error {
+	return m.heartbeat(m.config.Timeout)
+}
+
+func (m *Manager) heartbeat(timeout time.Duration) error {
+	data, _ := m.codec().Marshal(map[string]string{"gwId": m.devID, "devId": m.devID})
+	_, err := m.exchange(context.Background(), m.client.NextReservedSeq(), cmdHeartbeat, false, data, timeout)
+	return err
+}
+
+// Note activity, starting or resetting the idle timer. Must be called with
+// the lock held.
+func (m *Manager) touch() {
+	if m.config.IdleTimeout <= 0 {
+		return
 	}
-	return nil
+	m.lastActive = time.Now()
+	if m.idle == nil {
+		m.idle = time.AfterFunc(m.config.IdleTimeout, m.onIdle)
+	}
+}
+
+// Close the Manager, or send a heartbeat, if it has been idle for
+// IdleTimeout; otherwise wait until it could be.
+func (m *Manager) onIdle() {
+	m.Lock()
+	if m.closed {
+		m.Unlock()
+		return
+	}
+	if remaining := m.config.IdleTimeout - time.Since(m.lastActive); remaining > 0 {
+		m.idle.Reset(remaining)
+		m.Unlock()
+		return
+	}
+	m.Unlock()
+
+	if m.config.IdleHeartbeat {
+		timeout := m.config.Timeout
+		if timeout == 0 {
+			timeout = m.config.IdleTimeout
+		}
+		err := m.heartbeat(timeout)
+		if err == nil {
+			m.Lock()
+			if !m.closed {
+				m.idle.Reset(m.config.IdleTimeout)
+			}
+			m.Unlock()
+			return
+		}
+		m.logf(net.LevelInfo, "heartbeat: %v", err)
+	} else {
+		m.logf(net.LevelDebug, "closing after %v idle", m.config.IdleTimeout)
+	}
+	m.Close()
 }
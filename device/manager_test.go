@@ -0,0 +1,649 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+const testKey = "0123456789abcdef"
+
+// A device answering queries and control requests over net.Pipe, replying
+// out of order from separate goroutines.
+type testDevice struct {
+	conn   *net.Conn
+	silent bool   // don't reply
+	id     string // devId to include in replies, if any
+
+	mu    sync.Mutex
+	state State
+	sets  []State // control requests received
+}
+
+func newTestManager(t testing.TB, silent bool) (*Manager, *testDevice) {
+	return newTestManagerConfig(t, silent, ManagerConfig{})
+}
+
+func newTestManagerConfig(t testing.TB, silent bool, config ManagerConfig) (*Manager, *testDevice) {
+	client, d := newTestDevice(t, silent)
+	return config.NewManager("dev1", client), d
+}
+
+// Start a testDevice and return a client connected to it.
+func newTestDevice(t testing.TB, silent bool) (*net.Client, *testDevice) {
+	clientConn, deviceConn := stdnet.Pipe()
+	client, err := net.ClientConfig{Key: testKey, Version: net.Version33}.NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ServerConfig{Key: testKey, Version: net.Version33}.NewConn(deviceConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDevice{conn: conn, silent: silent, state: State{1: false}}
+	go d.serve()
+	return client, d
+}
+
+func (d *testDevice) serve() {
+	for {
+		f, err := d.conn.Read()
+		if err != nil {
+			return
+		}
+		if !d.silent {
+			go d.handle(f)
+		}
+	}
+}
+
+func (d *testDevice) handle(f *net.Frame) {
+	var msg struct {
+		DevID string `json:"devId,omitempty"`
+		DPs   State  `json:"dps"`
+	}
+	switch f.Cmd {
+	case cmdQuery:
+		d.mu.Lock()
+		msg.DevID = d.id
+		msg.DPs = d.state
+		data, _ := json.Marshal(msg)
+		d.mu.Unlock()
+		d.conn.Reply(f, 0, data)
+	case cmdControl:
+		json.Unmarshal(f.Payload, &msg)
+		d.mu.Lock()
+		for dp, v := range msg.DPs {
+			d.state[dp] = v
+		}
+		d.sets = append(d.sets, msg.DPs)
+		d.mu.Unlock()
+		d.conn.Reply(f, 0, nil)
+		d.conn.Push(msg)
+	case cmdHeartbeat:
+		d.conn.Reply(f, 0, nil)
+	}
+}
+
+// Start a testDevice in the given state and return a Manager for it.
+func newTestManagerState(t testing.TB, state State) (*Manager, *testDevice) {
+	m, d := newTestManager(t, false)
+	d.mu.Lock()
+	d.state = state
+	d.mu.Unlock()
+	return m, d
+}
+
+// Check the control requests the device has received, comparing values as
+// they'd arrive over JSON.
+func checkSets(t *testing.T, d *testDevice, want ...State) {
+	t.Helper()
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantSets []State
+	if err := json.Unmarshal(data, &wantSets); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.sets) != len(wantSets) || (len(wantSets) > 0 && !reflect.DeepEqual(d.sets, wantSets)) {
+		t.Errorf("device got sets %v, want %v", d.sets, wantSets)
+	}
+}
+
+// Return a dp of the device's state.
+func (d *testDevice) get(dp uint32) interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state[dp]
+}
+
+// Receive a pushed state from a Watch channel, failing the test if none
+// arrives.
+func nextPush(t *testing.T, watch <-chan State) State {
+	t.Helper()
+	select {
+	case state, ok := <-watch:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return state
+	case <-time.After(5 * time.Second):
+		t.Fatal("no push")
+		return nil
+	}
+}
+
+// Wait for a WaitGroup, failing the test if it takes too long.
+func waitFor(t *testing.T, wg *sync.WaitGroup) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out; requests hung")
+	}
+}
+
+func TestManagerConcurrentRequests(t *testing.T) {
+	m, d := newTestManager(t, false)
+	defer m.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				var err error
+				if j%2 == 0 {
+					_, err = m.GetState()
+				} else {
+					err = m.SetState(State{uint32(i + 2): float64(j)})
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				m.LastState()
+			}
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, stop := m.Watch()
+				stop()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 100; j++ {
+			d.conn.Push(map[string]interface{}{"dps": State{1: j%2 == 0}})
+		}
+	}()
+	waitFor(t, &wg)
+
+	if err := m.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+	state := m.LastState()
+	for i := 0; i < 16; i++ {
+		if state[uint32(i+2)] != 49.0 {
+			t.Errorf("dp %d = %v, want 49", i+2, state[uint32(i+2)])
+		}
+	}
+}
+
+func TestManagerCloseUnderLoad(t *testing.T) {
+	m, _ := newTestManager(t, false)
+	watch, _ := m.Watch()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if err := m.SetState(State{1: true}); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := m.Close(); err != nil {
+		t.Error(err)
+	}
+	waitFor(t, &wg)
+
+	if err := m.Err(); err != ErrClosed {
+		t.Errorf("Err() = %v, want ErrClosed", err)
+	}
+	for range watch {
+		// Drained until closed by Close.
+	}
+	if _, err := m.GetState(); err != ErrClosed {
+		t.Errorf("GetState after Close = %v", err)
+	}
+}
+
+func TestManagerReadError(t *testing.T) {
+	m, d := newTestManager(t, true)
+	defer m.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.GetState()
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	d.conn.Close()
+	waitFor(t, &wg)
+
+	close(errs)
+	for err := range errs {
+		if err == nil {
+			t.Error("request succeeded after the connection failed")
+		}
+	}
+	if err := m.Err(); err == nil || err == ErrClosed {
+		t.Errorf("Err() = %v, want a read error", err)
+	}
+}
+
+// A Logger sending messages to a channel.
+type chanLogger chan string
+
+func (l chanLogger) Logf(level net.Level, format string, args ...interface{}) {
+	l <- level.String() + ": " + fmt.Sprintf(format, args...)
+}
+
+func TestManagerLogger(t *testing.T) {
+	logs := make(chanLogger, 1)
+	m, d := newTestManagerConfig(t, true, ManagerConfig{Logger: logs})
+	defer m.Close()
+
+	d.conn.WriteFrame(&net.Frame{Seq: 99, Cmd: cmdQuery})
+	select {
+	case msg := <-logs:
+		if msg != "warn: dev1: no request matching seq 99" {
+			t.Errorf("logged %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing logged")
+	}
+}
+
+// A Tracer recording span attributes.
+type testTracer struct {
+	mu    sync.Mutex
+	spans []map[string]interface{}
+}
+
+type testSpan struct {
+	t     *testTracer
+	attrs map[string]interface{}
+}
+
+func (t *testTracer) StartSpan(name string, attrs ...net.Attr) net.Span {
+	s := &testSpan{t, map[string]interface{}{"name": name}}
+	s.SetAttrs(attrs...)
+	return s
+}
+
+func (s *testSpan) SetAttrs(attrs ...net.Attr) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *testSpan) End(err error) {
+	s.attrs["err"] = err
+	s.t.mu.Lock()
+	s.t.spans = append(s.t.spans, s.attrs)
+	s.t.mu.Unlock()
+}
+
+func TestManagerTracer(t *testing.T) {
+	tracer := &testTracer{}
+	m, _ := newTestManagerConfig(t, false, ManagerConfig{Tracer: tracer})
+	defer m.Close()
+	if _, err := m.GetState(); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracer.spans) != 1 {
+		t.Fatalf("got %d spans", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span["name"] != "tuya.request" || span[net.AttrDeviceID] != "dev1" || span[net.AttrCmd] != cmdQuery || span["err"] != nil {
+		t.Errorf("span = %v", span)
+	}
+	if span[net.AttrRequestSize] != len(`{"devId":"dev1","gwId":"dev1"}`) || span[net.AttrReplySize].(int) == 0 {
+		t.Errorf("span sizes = %v, %v", span[net.AttrRequestSize], span[net.AttrReplySize])
+	}
+}
+
+func TestManagerTimeout(t *testing.T) {
+	m, _ := newTestManagerConfig(t, true, ManagerConfig{Timeout: 20 * time.Millisecond})
+	defer m.Close()
+
+	_, err := m.GetState()
+	if err != ErrTimeout {
+		t.Fatalf("GetState = %v, want ErrTimeout", err)
+	}
+	if ne, ok := err.(stdnet.Error); !ok || !ne.Timeout() || !ne.Temporary() {
+		t.Errorf("%v isn't a temporary timeout net.Error", err)
+	}
+	if u, ok := err.(interface{ Unwrap() error }); !ok || u.Unwrap() != context.DeadlineExceeded {
+		t.Errorf("%v doesn't unwrap to context.DeadlineExceeded", err)
+	}
+	m.Lock()
+	pending := len(m.responseChans)
+	m.Unlock()
+	if pending != 0 {
+		t.Errorf("%d requests still pending after timeout", pending)
+	}
+	if err := m.Err(); err != nil {
+		t.Errorf("Err() = %v after timeout", err)
+	}
+}
+
+func TestManagerTimeoutRateLimit(t *testing.T) {
+	limiter, err := net.NewRateLimiter(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn, deviceConn := stdnet.Pipe()
+	client, err := net.ClientConfig{
+		Key:          testKey,
+		Version:      net.Version33,
+		RateLimiters: []*net.RateLimiter{limiter},
+	}.NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ServerConfig{Key: testKey, Version: net.Version33}.NewConn(deviceConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDevice{conn: conn, state: State{1: false}}
+	go d.serve()
+	m := ManagerConfig{Timeout: 20 * time.Millisecond}.NewManager("dev1", client)
+	defer m.Close()
+
+	if _, err := m.GetState(); err != nil {
+		t.Fatal(err)
+	}
+	// The next write waits a second for the limiter, longer than Timeout.
+	start := time.Now()
+	if _, err := m.GetState(); err != ErrTimeout {
+		t.Fatalf("GetState = %v, want ErrTimeout", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("rate limited GetState took %v", d)
+	}
+
+	// A caller's context ending first is reported as is.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.GetStateContext(ctx); err != context.Canceled {
+		t.Errorf("GetStateContext = %v, want context.Canceled", err)
+	}
+}
+
+func TestManagerCancel(t *testing.T) {
+	m, _ := newTestManager(t, true)
+	defer m.Close()
+
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.GetStateContext(ctx); err != context.Canceled {
+				t.Errorf("GetStateContext = %v, want context.Canceled", err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	waitFor(t, &wg)
+
+	m.Lock()
+	pending := len(m.responseChans)
+	m.Unlock()
+	if pending != 0 {
+		t.Errorf("%d requests still pending after cancellation", pending)
+	}
+	if err := m.Err(); err != nil {
+		t.Errorf("Err() = %v after cancellation", err)
+	}
+}
+
+// A Codec counting its calls.
+type countingCodec struct {
+	mu                   sync.Mutex
+	marshals, unmarshals int
+}
+
+func (c *countingCodec) Marshal(v interface{}) ([]byte, error) {
+	c.mu.Lock()
+	c.marshals++
+	c.mu.Unlock()
+	return json.Marshal(v)
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v interface{}) error {
+	c.mu.Lock()
+	c.unmarshals++
+	c.mu.Unlock()
+	return json.Unmarshal(data, v)
+}
+
+func TestManagerCodec(t *testing.T) {
+	codec := &countingCodec{}
+	m, _ := newTestManagerConfig(t, false, ManagerConfig{Codec: codec})
+	defer m.Close()
+	state, err := m.GetState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state[1] != false {
+		t.Errorf("GetState = %v", state)
+	}
+	if codec.marshals != 1 || codec.unmarshals != 1 {
+		t.Errorf("codec used for %d marshals, %d unmarshals; want 1 each", codec.marshals, codec.unmarshals)
+	}
+}
+
+func TestManagerVerifyID(t *testing.T) {
+	m, d := newTestManagerConfig(t, false, ManagerConfig{VerifyID: true})
+	defer m.Close()
+
+	d.id = "dev1"
+	if _, err := m.GetState(); err != nil {
+		t.Errorf("GetState = %v", err)
+	}
+	d.id = "dev2"
+	if _, err := m.GetState(); err == nil || !errors.Is(err, ErrWrongDevice) {
+		t.Errorf("GetState from wrong device = %v", err)
+	}
+
+	watch, stop := m.Watch()
+	defer stop()
+	d.conn.Push(map[string]interface{}{"devId": "dev2", "dps": State{1: true}})
+	d.conn.Push(map[string]interface{}{"devId": "dev1", "dps": State{1: false}})
+	select {
+	case state := <-watch:
+		if state[1] != false {
+			t.Errorf("got push %v from wrong device", state)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no push")
+	}
+}
+
+func TestManagerWatch(t *testing.T) {
+	m, d := newTestManager(t, false)
+	defer m.Close()
+
+	watch1, stop1 := m.Watch()
+	defer stop1()
+	watch2, stop2 := m.Watch()
+	d.conn.Push(map[string]interface{}{"dps": State{1: true}})
+	for _, watch := range []<-chan State{watch1, watch2} {
+		if state := nextPush(t, watch); state[1] != true {
+			t.Errorf("got push %v, want dp 1 true", state)
+		}
+	}
+
+	// Stopping closes only that watcher's channel.
+	stop2()
+	if _, ok := <-watch2; ok {
+		t.Error("stopped watcher's channel still open")
+	}
+	stop2()
+	d.conn.Push(map[string]interface{}{"dps": State{1: false}})
+	if state := nextPush(t, watch1); state[1] != false {
+		t.Errorf("got push %v, want dp 1 false", state)
+	}
+
+	// Closing the Manager closes the rest, and later watchers' channels
+	// start closed.
+	m.Close()
+	if _, ok := <-watch1; ok {
+		t.Error("watcher's channel open after Close")
+	}
+	watch3, stop3 := m.Watch()
+	defer stop3()
+	if _, ok := <-watch3; ok {
+		t.Error("Watch after Close returned an open channel")
+	}
+}
+
+func TestManagerSlowWatcher(t *testing.T) {
+	m, d := newTestManager(t, false)
+	defer m.Close()
+
+	slow, stopSlow := m.Watch()
+	defer stopSlow()
+	fast, stopFast := m.Watch()
+	defer stopFast()
+
+	// The slow watcher never reads, so once its buffer fills its updates are
+	// dropped, without holding up the fast watcher or requests.
+	const pushes = 64
+	for i := 0; i < pushes; i++ {
+		d.conn.Push(map[string]interface{}{"dps": State{2: float64(i)}})
+		if state := nextPush(t, fast); state[2] != float64(i) {
+			t.Fatalf("push %d: got %v", i, state)
+		}
+	}
+	if _, err := m.GetState(); err != nil {
+		t.Fatalf("GetState with a slow watcher = %v", err)
+	}
+	if n := len(slow); n != cap(slow) {
+		t.Errorf("slow watcher has %d updates, want a full buffer of %d", n, cap(slow))
+	}
+	// It keeps the oldest updates.
+	if state := <-slow; state[2] != 0.0 {
+		t.Errorf("slow watcher's first update = %v, want dp 2 = 0", state)
+	}
+}
+
+func TestPassiveManager(t *testing.T) {
+	// The device doesn't answer queries, only pushes.
+	client, d := newTestDevice(t, true)
+	m := NewPassiveManager("dev1", client)
+	defer m.Close()
+
+	if _, err := m.GetState(); err != ErrNoState {
+		t.Fatalf("GetState before a push = %v, want ErrNoState", err)
+	}
+
+	watch, stop := m.Watch()
+	defer stop()
+	d.conn.Push(map[string]interface{}{"dps": State{1: true, 2: 50.0}})
+	nextPush(t, watch)
+	state, err := m.GetState()
+	if err != nil {
+		t.Fatalf("GetState after a push = %v", err)
+	}
+	if state[1] != true || state[2] != 50.0 {
+		t.Errorf("GetState = %v, want the pushed state", state)
+	}
+}
+
+func TestManagerIdleTimeout(t *testing.T) {
+	m, _ := newTestManagerConfig(t, false, ManagerConfig{IdleTimeout: 50 * time.Millisecond})
+	defer m.Close()
+	if _, err := m.GetState(); err != nil {
+		t.Fatal(err)
+	}
+	// Activity keeps it open.
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if _, err := m.GetState(); err != nil {
+			t.Fatalf("GetState while active = %v", err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := m.Err(); err != ErrClosed {
+		t.Errorf("Err() = %v after idling, want ErrClosed", err)
+	}
+}
+
+func TestManagerIdleHeartbeat(t *testing.T) {
+	for _, silent := range []bool{false, true} {
+		m, _ := newTestManagerConfig(t, silent, ManagerConfig{IdleTimeout: 30 * time.Millisecond, IdleHeartbeat: true})
+		go m.GetState()
+		time.Sleep(200 * time.Millisecond)
+		err := m.Err()
+		if !silent && err != nil {
+			t.Errorf("Err() = %v; heartbeats should keep it open", err)
+		}
+		if silent && err != ErrClosed {
+			t.Errorf("Err() = %v with unanswered heartbeats, want ErrClosed", err)
+		}
+		m.Close()
+	}
+}
+
+func BenchmarkManagerGetState(b *testing.B) {
+	m, _ := newTestManager(b, false)
+	defer m.Close()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := m.GetState(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PowerStripDPs describes the dp layout of a power strip.
+type PowerStripDPs struct {
+	Outlets []uint32
+	USB     []uint32
+
+	// Master is a dp switching the whole strip, if the device has one.
+	Master uint32
+}
+
+// PowerStripDPsN returns a layout with outlets on consecutive dps starting at
+// 1, followed by the USB banks, which is how most strips number them.
+func PowerStripDPsN(outlets, usb int) PowerStripDPs {
+	var dps PowerStripDPs
+	dp := uint32(1)
+	for i := 0; i < outlets; i++ {
+		dps.Outlets = append(dps.Outlets, dp)
+		dp++
+	}
+	for i := 0; i < usb; i++ {
+		dps.USB = append(dps.USB, dp)
+		dp++
+	}
+	return dps
+}
+
+// A PowerStrip controls a multi-outlet power strip. Outlets and USB banks are
+// addressed by name: "outlet 3", "usb", "usb 2", or "master". Numbers are
+// 1-based, as printed on the strip.
+type PowerStrip struct {
+	*Manager
+	DPs PowerStripDPs
+}
+
+// NewPowerStrip creates a PowerStrip with the given dp layout.
+func NewPowerStrip(m *Manager, dps PowerStripDPs) *PowerStrip {
+	return &PowerStrip{Manager: m, DPs: dps}
+}
+
+// DP returns the dp for a named outlet, USB bank, or master switch.
+func (p *PowerStrip) DP(name string) (uint32, error) {
+	lower := strings.ToLower(strings.TrimSpace(name))
+	base := strings.TrimRight(lower, "0123456789")
+	kind := strings.TrimSpace(base)
+	num := 1
+	if numStr := lower[len(base):]; numStr != "" {
+		var err error
+		if num, err = strconv.Atoi(numStr); err != nil {
+			return 0, fmt.Errorf("bad name %q", name)
+		}
+	}
+
+	var dps []uint32
+	switch kind {
+	case "outlet":
+		dps = p.DPs.Outlets
+	case "usb":
+		dps = p.DPs.USB
+	case "master":
+		if p.DPs.Master == 0 {
+			return 0, ErrUnsupported
+		}
+		return p.DPs.Master, nil
+	default:
+		return 0, fmt.Errorf("bad name %q", name)
+	}
+	if num < 1 || num > len(dps) {
+		return 0, fmt.Errorf("no %s %d; have %d", kind, num, len(dps))
+	}
+	return dps[num-1], nil
+}
+
+// Set turns the named outlet, USB bank, or master switch on or off.
+func (p *PowerStrip) Set(name string, on bool) error {
+	dp, err := p.DP(name)
+	if err != nil {
+		return err
+	}
+	return p.SetState(State{dp: on})
+}
+
+// IsOn reports whether the named outlet, USB bank, or master switch is on.
+func (p *PowerStrip) IsOn(name string) (bool, error) {
+	dp, err := p.DP(name)
+	if err != nil {
+		return false, err
+	}
+	state, err := p.GetState()
+	if err != nil {
+		return false, err
+	}
+	return state.Bool(dp)
+}
+
+// Status returns the on/off state of every outlet and USB bank by name.
+func (p *PowerStrip) Status() (map[string]bool, error) {
+	state, err := p.GetState()
+	if err != nil {
+		return nil, err
+	}
+	status := make(map[string]bool)
+	add := func(kind string, dps []uint32) {
+		for i, dp := range dps {
+			if on, err := state.Bool(dp); err == nil {
+				status[fmt.Sprintf("%s %d", kind, i+1)] = on
+			}
+		}
+	}
+	add("outlet", p.DPs.Outlets)
+	add("usb", p.DPs.USB)
+	if on, err := state.Bool(p.DPs.Master); err == nil && p.DPs.Master != 0 {
+		status["master"] = on
+	}
+	return status, nil
+}
+
+// SetAll turns every outlet and USB bank on or off in a single request, so the
+// strip switches them together.
+func (p *PowerStrip) SetAll(on bool) error {
+	state := State{}
+	for _, dp := range p.DPs.Outlets {
+		state[dp] = on
+	}
+	for _, dp := range p.DPs.USB {
+		state[dp] = on
+	}
+	if p.DPs.Master != 0 {
+		state[p.DPs.Master] = on
+	}
+	return p.SetState(state)
+}
@@ -0,0 +1,72 @@
+package device
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPowerStrip(t *testing.T) {
+	withMaster := PowerStripDPsN(3, 1)
+	withMaster.Master = 10
+
+	for _, tc := range []struct {
+		name    string
+		dps     PowerStripDPs
+		call    func(*PowerStrip) error
+		want    []State
+		wantErr bool
+	}{
+		{"outlet", withMaster, func(p *PowerStrip) error { return p.Set("outlet 2", true) }, []State{{2: true}}, false},
+		{"outlet no space", withMaster, func(p *PowerStrip) error { return p.Set("Outlet3", false) }, []State{{3: false}}, false},
+		{"usb", withMaster, func(p *PowerStrip) error { return p.Set("USB", true) }, []State{{4: true}}, false},
+		{"usb numbered", withMaster, func(p *PowerStrip) error { return p.Set(" usb 1 ", false) }, []State{{4: false}}, false},
+		{"master", withMaster, func(p *PowerStrip) error { return p.Set("master", false) }, []State{{10: false}}, false},
+		{"no such outlet", withMaster, func(p *PowerStrip) error { return p.Set("outlet 4", true) }, nil, true},
+		{"outlet zero", withMaster, func(p *PowerStrip) error { return p.Set("outlet 0", true) }, nil, true},
+		{"bad name", withMaster, func(p *PowerStrip) error { return p.Set("lamp", true) }, nil, true},
+		{"all", withMaster, func(p *PowerStrip) error { return p.SetAll(true) }, []State{{1: true, 2: true, 3: true, 4: true, 10: true}}, false},
+		{"all without master", PowerStripDPsN(2, 0), func(p *PowerStrip) error { return p.SetAll(false) }, []State{{1: false, 2: false}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, d := newTestManagerState(t, State{})
+			defer m.Close()
+			if err := tc.call(NewPowerStrip(m, tc.dps)); (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+			checkSets(t, d, tc.want...)
+		})
+	}
+}
+
+func TestPowerStripNoMaster(t *testing.T) {
+	m, _ := newTestManagerState(t, State{})
+	defer m.Close()
+	if _, err := NewPowerStrip(m, PowerStripDPsN(3, 1)).DP("master"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("DP(master) = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestPowerStripStatus(t *testing.T) {
+	dps := PowerStripDPsN(3, 1)
+	dps.Master = 10
+	m, _ := newTestManagerState(t, State{1: true, 2: false, 4: true, 10: true})
+	defer m.Close()
+	p := NewPowerStrip(m, dps)
+
+	status, err := p.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Outlet 3 isn't in the state, so it's left out.
+	want := map[string]bool{"outlet 1": true, "outlet 2": false, "usb 1": true, "master": true}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("Status() = %v, want %v", status, want)
+	}
+	if on, err := p.IsOn("outlet 1"); !on || err != nil {
+		t.Errorf("IsOn(outlet 1) = %v, %v", on, err)
+	}
+	if _, err := p.IsOn("outlet 3"); err == nil {
+		t.Error("IsOn(outlet 3) succeeded with the dp missing")
+	}
+}
@@ -0,0 +1,386 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// A StatusReader reads device status broadcasts; see net.NewStatusListener.
+type StatusReader interface {
+	ReadStatus() (*net.Status, error)
+}
+
+// A Registry tracks devices seen in status broadcasts.
+//
+// It also supports low-power devices, such as battery sensors, which only
+// accept connections for a few seconds after waking and broadcasting: Queue
+// holds operations for a device until its next broadcast and runs them
+// immediately when it is seen.
+//
+// Broadcasts are unauthenticated, so anything on the LAN can announce itself
+// as any device. Setting OnAnomaly reports broadcasts that may be spoofed,
+// and holds back a known device's broadcasts from a new address until the
+// address is confirmed: Status keeps returning the last confirmed broadcast,
+// and queued operations keep waiting, so nothing connects to an address an
+// impostor announced.
+type Registry struct {
+	// OnAnomaly, if set, is called with each suspicious broadcast: the first
+	// from a device that isn't known, and the first from a known device at
+	// an IP or MAC address it hasn't been confirmed at. A new IP is
+	// confirmed without a report if LookupMAC finds the device's confirmed
+	// MAC there; otherwise, as when a device's DHCP lease changes without a
+	// known MAC, it must be confirmed with Accept. OnAnomaly is called from
+	// Update, so it shouldn't block. Set it before use.
+	OnAnomaly func(Anomaly)
+
+	// Known reports whether a device is expected on the network. If nil,
+	// devices given keys with SetKey are.
+	Known func(gwID string) bool
+
+	// LookupMAC, if set, returns the MAC address of an IP, or "" if it
+	// isn't known, so that a device's address changes are noticed even
+	// when its IP is reused.
+	LookupMAC func(ip string) string
+
+	mu       sync.Mutex
+	devices  map[string]*net.Status
+	keys     map[string]string
+	versions map[string]string
+	pending  map[string][]*pendingOp
+	addrs    map[string]map[string]string // confirmed MACs by IP, by gateway ID
+	reported map[string]bool              // unknown gateway IDs
+
+	// unconfirmed holds the latest broadcast of each known device from an
+	// address it hasn't been confirmed at.
+	unconfirmed map[string]unconfirmed
+
+	// dial connects to woken devices, whose requests time out after
+	// timeout; tests replace both.
+	dial    func(net.ClientConfig) (*net.Client, error)
+	timeout time.Duration
+}
+
+// Woken devices only accept connections for a few seconds, so a request to
+// one that takes longer isn't going to be answered.
+const wakeTimeout = 5 * time.Second
+
+// Anomaly kinds.
+const (
+	UnknownDevice = "unknown device"
+	NewAddress    = "new address"
+)
+
+// An Anomaly is a broadcast that may be spoofed.
+type Anomaly struct {
+	Kind   string
+	Status *net.Status
+	// MAC is the MAC address of Status.IP, if LookupMAC found it.
+	MAC string
+	// PrevIP and PrevMAC are the device's address in its previous broadcast,
+	// for NewAddress anomalies.
+	PrevIP, PrevMAC string
+	Time            time.Time
+}
+
+func (a Anomaly) String() string {
+	addr := a.Status.IP
+	if a.MAC != "" {
+		addr += " (" + a.MAC + ")"
+	}
+	if a.Kind == UnknownDevice {
+		return fmt.Sprintf("unknown device %s broadcasting from %s", a.Status.GatewayID, addr)
+	}
+	prev := a.PrevIP
+	if a.PrevMAC != "" {
+		prev += " (" + a.PrevMAC + ")"
+	}
+	return fmt.Sprintf("device %s broadcasting from new address %s, was %s", a.Status.GatewayID, addr, prev)
+}
+
+// A broadcast from an unconfirmed address, and the MAC found there.
+type unconfirmed struct {
+	status *net.Status
+	mac    string
+}
+
+// An operation waiting for a device to wake. Its timer delivers ErrTimeout
+// at expiry.
+type pendingOp struct {
+	fn     func(*Manager) error
+	timer  *time.Timer
+	result chan error
+	once   sync.Once
+}
+
+func (op *pendingOp) done(err error) {
+	op.once.Do(func() {
+		op.timer.Stop()
+		op.result <- err
+	})
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		devices:  make(map[string]*net.Status),
+		keys:     make(map[string]string),
+		versions: make(map[string]string),
+		pending:  make(map[string][]*pendingOp),
+		addrs:    make(map[string]map[string]string),
+		reported: make(map[string]bool),
+
+		unconfirmed: make(map[string]unconfirmed),
+
+		dial:    net.ClientConfig.Dial,
+		timeout: wakeTimeout,
+	}
+}
+
+// SetKey sets the local key used when the Registry connects to a device.
+func (r *Registry) SetKey(gwID, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[gwID] = key
+}
+
+// SetVersion remembers a device's protocol version, as found by
+// DetectVersion, for devices that don't broadcast it. Queued operations
+// connect with it when the device's broadcast has no version.
+func (r *Registry) SetVersion(gwID, version string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.versions[gwID] = version
+}
+
+// Version returns a device's protocol version from its most recent broadcast,
+// or as given to SetVersion.
+func (r *Registry) Version(gwID string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.devices[gwID]; ok && s.Version != "" {
+		return s.Version, true
+	}
+	version, ok := r.versions[gwID]
+	return version, ok
+}
+
+// Status returns the most recent broadcast seen from a device, at a confirmed
+// address if OnAnomaly is set.
+func (r *Registry) Status(gwID string) (*net.Status, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.devices[gwID]
+	return s, ok
+}
+
+// Statuses returns the most recent broadcast of every device seen, ordered by
+// gateway ID.
+func (r *Registry) Statuses() []*net.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]*net.Status, 0, len(r.devices))
+	for _, s := range r.devices {
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].GatewayID < statuses[j].GatewayID })
+	return statuses
+}
+
+// Run feeds broadcasts read from sr into the Registry until reading fails.
+func (r *Registry) Run(sr StatusReader) error {
+	for {
+		s, err := sr.ReadStatus()
+		if err != nil {
+			return err
+		}
+		r.Update(s)
+	}
+}
+
+// Update records a status broadcast and starts any operations queued for the
+// device. Statuses failing net.Status.Validate are ignored, and those from
+// unconfirmed addresses are held back; see Accept.
+func (r *Registry) Update(s *net.Status) {
+	if s.Validate() != nil {
+		return
+	}
+	// Call out before locking, since these may call back into the Registry.
+	var known, checkKnown bool
+	var mac string
+	if r.OnAnomaly != nil {
+		if r.Known != nil {
+			known, checkKnown = r.Known(s.GatewayID), true
+		}
+		if r.LookupMAC != nil {
+			mac = r.LookupMAC(s.IP)
+		}
+	}
+
+	r.mu.Lock()
+	var anomaly *Anomaly
+	confirmed := true
+	if r.OnAnomaly != nil {
+		if !checkKnown {
+			_, known = r.keys[s.GatewayID]
+		}
+		anomaly, confirmed = r.check(s, mac, known)
+	}
+	var ops []*pendingOp
+	var key, version string
+	if confirmed {
+		ops, key, version = r.record(s)
+	}
+	r.mu.Unlock()
+
+	if anomaly != nil {
+		r.OnAnomaly(*anomaly)
+	}
+	if len(ops) > 0 {
+		go r.wake(s, key, version, ops)
+	}
+}
+
+// Accept confirms the address of a known device's latest broadcast held back
+// by Update, typically after a NewAddress anomaly has been checked, and
+// records it as Update would have. It reports whether there was one.
+func (r *Registry) Accept(gwID string) bool {
+	r.mu.Lock()
+	u, ok := r.unconfirmed[gwID]
+	if !ok {
+		r.mu.Unlock()
+		return false
+	}
+	delete(r.unconfirmed, gwID)
+	r.addrs[gwID][u.status.IP] = u.mac
+	ops, key, version := r.record(u.status)
+	r.mu.Unlock()
+
+	if len(ops) > 0 {
+		go r.wake(u.status, key, version, ops)
+	}
+	return true
+}
+
+// Record a confirmed broadcast, returning the operations it wakes and what
+// they need to connect. The caller holds r.mu.
+func (r *Registry) record(s *net.Status) (ops []*pendingOp, key, version string) {
+	r.devices[s.GatewayID] = s
+	ops = r.pending[s.GatewayID]
+	delete(r.pending, s.GatewayID)
+	return ops, r.keys[s.GatewayID], r.versions[s.GatewayID]
+}
+
+// Check a broadcast's address, returning an Anomaly if it's suspicious and
+// whether the address is confirmed. Broadcasts from unconfirmed addresses are
+// kept for Accept. The caller holds r.mu.
+func (r *Registry) check(s *net.Status, mac string, known bool) (*Anomaly, bool) {
+	id := s.GatewayID
+	if !known {
+		// Nothing connects to unknown devices, so they aren't held back.
+		if r.reported[id] {
+			return nil, true
+		}
+		r.reported[id] = true
+		return &Anomaly{Kind: UnknownDevice, Status: s, MAC: mac, Time: time.Now()}, true
+	}
+
+	addrs := r.addrs[id]
+	if addrs == nil {
+		// The first broadcast seen gives the device's address.
+		r.addrs[id] = map[string]string{s.IP: mac}
+		return nil, true
+	}
+	seen, ok := addrs[s.IP]
+	// MACs aren't always found, so only differing ones count.
+	if ok && (seen == "" || mac == "" || seen == mac) {
+		if mac != "" {
+			addrs[s.IP] = mac
+		}
+		return nil, true
+	}
+	var prevIP, prevMAC string
+	if prev, found := r.devices[id]; found {
+		prevIP, prevMAC = prev.IP, addrs[prev.IP]
+	}
+	if !ok && mac != "" && mac == prevMAC {
+		// The device moved to a new IP, but it's the same hardware.
+		addrs[s.IP] = mac
+		return nil, true
+	}
+
+	if u, held := r.unconfirmed[id]; held && u.status.IP == s.IP && u.mac == mac {
+		// Already reported.
+		r.unconfirmed[id] = unconfirmed{s, mac}
+		return nil, false
+	}
+	r.unconfirmed[id] = unconfirmed{s, mac}
+	return &Anomaly{
+		Kind:    NewAddress,
+		Status:  s,
+		MAC:     mac,
+		PrevIP:  prevIP,
+		PrevMAC: prevMAC,
+		Time:    time.Now(),
+	}, false
+}
+
+// Queue schedules fn to run against a device the next time it broadcasts. If
+// the device isn't seen before expiry, ErrTimeout is delivered instead. The
+// returned channel receives fn's result.
+func (r *Registry) Queue(gwID string, expiry time.Duration, fn func(*Manager) error) <-chan error {
+	op := &pendingOp{
+		fn:     fn,
+		result: make(chan error, 1),
+	}
+	// Start the timer while holding the lock, so wake, which takes ops
+	// from r.pending, always sees it.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending[gwID] = append(r.pending[gwID], op)
+	op.timer = time.AfterFunc(expiry, func() {
+		r.mu.Lock()
+		ops := r.pending[gwID]
+		for i, pending := range ops {
+			if pending == op {
+				r.pending[gwID] = append(ops[:i:i], ops[i+1:]...)
+				break
+			}
+		}
+		if len(r.pending[gwID]) == 0 {
+			delete(r.pending, gwID)
+		}
+		r.mu.Unlock()
+		op.done(ErrTimeout)
+	})
+	return op.result
+}
+
+// Connect to a woken device and run its queued operations in order. version,
+// as given to SetVersion, is used if the broadcast doesn't carry one.
+func (r *Registry) wake(s *net.Status, key, version string, ops []*pendingOp) {
+	config := s.ClientConfig()
+	config.Key = key
+	if config.Version == "" {
+		config.Version = version
+	}
+	client, err := r.dial(config)
+	if err != nil {
+		for _, op := range ops {
+			op.done(fmt.Errorf("Dial: %w", err))
+		}
+		return
+	}
+	m := ManagerConfig{Timeout: r.timeout}.NewManager(s.GatewayID, client)
+	defer m.Close()
+	for _, op := range ops {
+		// An op whose timer has fired has already been given ErrTimeout.
+		if !op.timer.Stop() {
+			continue
+		}
+		op.done(op.fn(m))
+	}
+}
@@ -0,0 +1,284 @@
+package device
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+func TestRegistryAnomalies(t *testing.T) {
+	r := NewRegistry()
+	var anomalies []Anomaly
+	r.OnAnomaly = func(a Anomaly) { anomalies = append(anomalies, a) }
+	macs := map[string]string{"10.0.0.2": "aa:aa:aa:aa:aa:aa"}
+	r.LookupMAC = func(ip string) string { return macs[ip] }
+	r.SetKey("known", testKey)
+
+	update := func(id, ip string) {
+		r.Update(&net.Status{GatewayID: id, IP: ip, Version: net.Version33})
+	}
+	expect := func(kind, prevIP, prevMAC string) {
+		t.Helper()
+		if len(anomalies) != 1 {
+			t.Fatalf("got %d anomalies, want 1: %v", len(anomalies), anomalies)
+		}
+		a := anomalies[0]
+		if a.Kind != kind || a.PrevIP != prevIP || a.PrevMAC != prevMAC {
+			t.Errorf("got %+v, want %s from %s (%s)", a, kind, prevIP, prevMAC)
+		}
+		anomalies = nil
+	}
+
+	// The first address seen is trusted, and repeats are fine.
+	update("known", "10.0.0.2")
+	update("known", "10.0.0.2")
+	if len(anomalies) != 0 {
+		t.Fatalf("unexpected anomalies: %v", anomalies)
+	}
+
+	update("known", "10.0.0.3")
+	expect(NewAddress, "10.0.0.2", "aa:aa:aa:aa:aa:aa")
+	// The new address is reported once, but not used until it's accepted.
+	update("known", "10.0.0.3")
+	update("known", "10.0.0.2")
+	if len(anomalies) != 0 {
+		t.Fatalf("unexpected anomalies: %v", anomalies)
+	}
+	if s, _ := r.Status("known"); s.IP != "10.0.0.2" {
+		t.Errorf("unconfirmed address %s used", s.IP)
+	}
+	if !r.Accept("known") || r.Accept("known") {
+		t.Error("Accept didn't confirm the new address once")
+	}
+	if s, _ := r.Status("known"); s.IP != "10.0.0.3" {
+		t.Errorf("accepted address not used, got %s", s.IP)
+	}
+	// Both addresses are confirmed now.
+	update("known", "10.0.0.2")
+	update("known", "10.0.0.3")
+	if len(anomalies) != 0 {
+		t.Fatalf("unexpected anomalies: %v", anomalies)
+	}
+
+	// A MAC that goes missing from the ARP table is fine; a different one
+	// isn't.
+	macs["10.0.0.2"] = ""
+	update("known", "10.0.0.2")
+	if len(anomalies) != 0 {
+		t.Fatalf("unexpected anomalies: %v", anomalies)
+	}
+	macs["10.0.0.2"] = "bb:bb:bb:bb:bb:bb"
+	update("known", "10.0.0.2")
+	expect(NewAddress, "10.0.0.2", "aa:aa:aa:aa:aa:aa")
+
+	// A new IP with the confirmed MAC is the same device after a DHCP
+	// change.
+	macs["10.0.0.9"] = "aa:aa:aa:aa:aa:aa"
+	update("known", "10.0.0.9")
+	if len(anomalies) != 0 {
+		t.Fatalf("unexpected anomalies: %v", anomalies)
+	}
+	if s, _ := r.Status("known"); s.IP != "10.0.0.9" {
+		t.Errorf("address with the confirmed MAC not used, got %s", s.IP)
+	}
+
+	// Unknown devices are reported once.
+	update("stranger", "10.0.0.4")
+	expect(UnknownDevice, "", "")
+	update("stranger", "10.0.0.5")
+	if len(anomalies) != 0 {
+		t.Fatalf("unexpected anomalies: %v", anomalies)
+	}
+
+	// Known overrides keys.
+	r.Known = func(id string) bool { return id == "stranger" }
+	update("known", "10.0.0.2")
+	expect(UnknownDevice, "", "")
+	update("stranger", "10.0.0.6")
+	update("stranger", "10.0.0.7")
+	expect(NewAddress, "10.0.0.6", "")
+
+	// Invalid statuses aren't recorded or reported.
+	update("bad id!", "10.0.0.8")
+	if len(anomalies) != 0 {
+		t.Fatalf("unexpected anomalies: %v", anomalies)
+	}
+}
+
+func TestRegistryWakeVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name, broadcast, stored, want string
+	}{
+		{"stored", "", net.Version33, net.Version33},
+		{"broadcast", net.Version33, net.Version31, net.Version33},
+		{"neither", "", "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.SetKey("dev1", testKey)
+			if tc.stored != "" {
+				r.SetVersion("dev1", tc.stored)
+			}
+			dialed := make(chan net.ClientConfig, 1)
+			errRefused := errors.New("refused")
+			r.dial = func(config net.ClientConfig) (*net.Client, error) {
+				dialed <- config
+				return nil, errRefused
+			}
+
+			result := r.Queue("dev1", time.Minute, func(*Manager) error { return nil })
+			r.Update(&net.Status{GatewayID: "dev1", IP: "10.0.0.2", Version: tc.broadcast})
+			if err := <-result; !errors.Is(err, errRefused) {
+				t.Errorf("got %v, want the dial error", err)
+			}
+			config := <-dialed
+			if config.Version != tc.want || config.Key != testKey || config.Addr != "10.0.0.2:6668" {
+				t.Errorf("dialed %+v, want version %q", config, tc.want)
+			}
+		})
+	}
+}
+
+func TestRegistrySpoofedAddress(t *testing.T) {
+	r := NewRegistry()
+	r.OnAnomaly = func(Anomaly) {}
+	r.SetKey("dev1", testKey)
+	dialed := make(chan string, 1)
+	r.dial = func(config net.ClientConfig) (*net.Client, error) {
+		dialed <- config.Addr
+		return nil, errors.New("refused")
+	}
+	f := NewFleet()
+	defer f.Close()
+	f.Registry = r
+	f.Add("dev1", net.ClientConfig{Key: testKey})
+
+	r.Update(&net.Status{GatewayID: "dev1", IP: "127.0.0.1", Version: net.Version33})
+	result := r.Queue("dev1", time.Minute, func(*Manager) error { return nil })
+	r.Update(&net.Status{GatewayID: "dev1", IP: "127.0.0.2", Version: net.Version33})
+	select {
+	case addr := <-dialed:
+		t.Fatalf("queued operation dialed spoofed address %s", addr)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Nothing listens on the device port, so the error names the address.
+	if _, err := f.Manager("dev1"); err == nil || strings.Contains(err.Error(), "127.0.0.2") {
+		t.Errorf("Fleet.Manager: got %v, want an error dialing 127.0.0.1", err)
+	}
+
+	r.Update(&net.Status{GatewayID: "dev1", IP: "127.0.0.1", Version: net.Version33})
+	queueResult(t, result)
+	if addr := <-dialed; addr != "127.0.0.1:6668" {
+		t.Errorf("queued operation dialed %s, want the confirmed address", addr)
+	}
+}
+
+// Make r's queued operations connect to a testDevice, counting the dials.
+func wakeTestDevice(t *testing.T, r *Registry, silent bool) (*testDevice, *int32) {
+	client, d := newTestDevice(t, silent)
+	var dials int32
+	r.dial = func(net.ClientConfig) (*net.Client, error) {
+		atomic.AddInt32(&dials, 1)
+		return client, nil
+	}
+	return d, &dials
+}
+
+// Receive a queued operation's result, failing the test if it hangs.
+func queueResult(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a queued operation")
+		return nil
+	}
+}
+
+func TestRegistryQueue(t *testing.T) {
+	r := NewRegistry()
+	r.SetKey("dev1", testKey)
+	d, dials := wakeTestDevice(t, r, false)
+
+	set := r.Queue("dev1", time.Minute, func(m *Manager) error {
+		return m.SetState(State{1: true})
+	})
+	var got State
+	get := r.Queue("dev1", time.Minute, func(m *Manager) (err error) {
+		got, err = m.GetState()
+		return err
+	})
+	select {
+	case err := <-set:
+		t.Fatalf("ran before the device woke: %v", err)
+	default:
+	}
+
+	status := &net.Status{GatewayID: "dev1", IP: "10.0.0.2", Version: net.Version33}
+	r.Update(status)
+	if err := queueResult(t, set); err != nil {
+		t.Fatal(err)
+	}
+	if err := queueResult(t, get); err != nil {
+		t.Fatal(err)
+	}
+	// Operations run in the order they were queued.
+	if got[1] != true {
+		t.Errorf("got state %v after the queued SetState", got)
+	}
+	d.mu.Lock()
+	if d.state[1] != true {
+		t.Errorf("device state %v, want 1 set", d.state)
+	}
+	d.mu.Unlock()
+
+	// Operations only run once.
+	r.Update(status)
+	if n := atomic.LoadInt32(dials); n != 1 {
+		t.Errorf("dialed %d times, want 1", n)
+	}
+}
+
+func TestRegistryQueueExpiry(t *testing.T) {
+	r := NewRegistry()
+	r.SetKey("dev1", testKey)
+	_, dials := wakeTestDevice(t, r, false)
+
+	ran := false
+	result := r.Queue("dev1", 10*time.Millisecond, func(*Manager) error {
+		ran = true
+		return nil
+	})
+	if err := queueResult(t, result); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+
+	// The expired operation is no longer queued.
+	r.Update(&net.Status{GatewayID: "dev1", IP: "10.0.0.2", Version: net.Version33})
+	if n := atomic.LoadInt32(dials); n != 0 || ran {
+		t.Errorf("dialed %d times, ran %v; want an expired op dropped", n, ran)
+	}
+}
+
+func TestRegistryWakeTimeout(t *testing.T) {
+	r := NewRegistry()
+	r.SetKey("dev1", testKey)
+	r.timeout = 10 * time.Millisecond
+	wakeTestDevice(t, r, true)
+
+	// A device that stops answering doesn't hold the operation past the
+	// request timeout, even though it hasn't expired.
+	result := r.Queue("dev1", time.Minute, func(m *Manager) error {
+		_, err := m.GetState()
+		return err
+	})
+	r.Update(&net.Status{GatewayID: "dev1", IP: "10.0.0.2", Version: net.Version33})
+	if err := queueResult(t, result); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+}
@@ -0,0 +1,91 @@
+package device
+
+import (
+	"time"
+)
+
+// SirenDPs describes the dp layout of a siren or alarm. A zero dp means the
+// device has no such dp.
+type SirenDPs struct {
+	Alarm    uint32 // Boolean alarm switch
+	Volume   uint32 // Enum, e.g. "low", "middle", "high", "mute"
+	Duration uint32 // Alarm duration, in DurationUnit
+	Melody   uint32 // Enum ringtone, e.g. "1"-"10"
+
+	DurationUnit time.Duration
+}
+
+// SirenDPsDefault is the layout of common battery/mains sirens.
+var SirenDPsDefault = SirenDPs{
+	Alarm: 104, Volume: 116, Duration: 103, Melody: 102,
+	DurationUnit: time.Second,
+}
+
+// A Siren controls a siren or alarm sounder.
+type Siren struct {
+	*Manager
+	DPs SirenDPs
+}
+
+// NewSiren creates a Siren with the given dp layout.
+func NewSiren(m *Manager, dps SirenDPs) *Siren {
+	if dps.DurationUnit == 0 {
+		dps.DurationUnit = time.Second
+	}
+	return &Siren{Manager: m, DPs: dps}
+}
+
+// A SirenStatus is a decoded siren state.
+type SirenStatus struct {
+	Sounding bool
+	Volume   string
+	Duration time.Duration
+	Melody   string
+}
+
+// Status reads and decodes the siren state. Dps missing from the device state
+// are left zero.
+func (s *Siren) Status() (*SirenStatus, error) {
+	state, err := s.GetState()
+	if err != nil {
+		return nil, err
+	}
+	status := &SirenStatus{}
+	status.Sounding, _ = state.Bool(s.DPs.Alarm)
+	status.Volume, _ = state.String(s.DPs.Volume)
+	if n, err := state.Int(s.DPs.Duration); err == nil {
+		status.Duration = time.Duration(n) * s.DPs.DurationUnit
+	}
+	status.Melody, _ = state.String(s.DPs.Melody)
+	return status, nil
+}
+
+// Trigger sounds the alarm until Stop is called or the configured duration
+// elapses.
+func (s *Siren) Trigger() error {
+	return s.SetState(State{s.DPs.Alarm: true})
+}
+
+// Stop silences the alarm.
+func (s *Siren) Stop() error {
+	return s.SetState(State{s.DPs.Alarm: false})
+}
+
+// Configure sets the volume, duration, and melody used when the alarm is
+// triggered. Zero values are left unchanged.
+func (s *Siren) Configure(volume string, duration time.Duration, melody string) error {
+	state := State{}
+	if volume != "" && s.DPs.Volume != 0 {
+		state[s.DPs.Volume] = volume
+	}
+	if duration > 0 && s.DPs.Duration != 0 {
+		state[s.DPs.Duration] = int(duration / s.DPs.DurationUnit)
+	}
+	if melody != "" && s.DPs.Melody != 0 {
+		state[s.DPs.Melody] = melody
+	}
+	if len(state) == 0 {
+		return nil
+	}
+	return s.SetState(state)
+}
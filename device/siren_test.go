@@ -0,0 +1,69 @@
+package device
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSiren(t *testing.T) {
+	minutes := SirenDPsDefault
+	minutes.DurationUnit = time.Minute
+
+	for _, tc := range []struct {
+		name string
+		dps  SirenDPs
+		call func(*Siren) error
+		want []State
+	}{
+		{"trigger", SirenDPsDefault, (*Siren).Trigger, []State{{104: true}}},
+		{"stop", SirenDPsDefault, (*Siren).Stop, []State{{104: false}}},
+		{
+			"configure", SirenDPsDefault,
+			func(s *Siren) error { return s.Configure("high", 30*time.Second, "3") },
+			[]State{{116: "high", 103: 30, 102: "3"}},
+		},
+		{
+			"configure volume only", SirenDPsDefault,
+			func(s *Siren) error { return s.Configure("mute", 0, "") },
+			[]State{{116: "mute"}},
+		},
+		{
+			"configure minutes", minutes,
+			func(s *Siren) error { return s.Configure("", 90*time.Second, "") },
+			[]State{{103: 1}},
+		},
+		{
+			"configure nothing", SirenDPsDefault,
+			func(s *Siren) error { return s.Configure("", 0, "") },
+			nil,
+		},
+		{
+			"configure unsupported", SirenDPs{Alarm: 104},
+			func(s *Siren) error { return s.Configure("high", time.Minute, "3") },
+			nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, d := newTestManagerState(t, State{})
+			defer m.Close()
+			if err := tc.call(NewSiren(m, tc.dps)); err != nil {
+				t.Error(err)
+			}
+			checkSets(t, d, tc.want...)
+		})
+	}
+}
+
+func TestSirenStatus(t *testing.T) {
+	m, _ := newTestManagerState(t, State{104: true, 116: "middle", 103: 60, 102: "2"})
+	defer m.Close()
+	status, err := NewSiren(m, SirenDPsDefault).Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SirenStatus{Sounding: true, Volume: "middle", Duration: time.Minute, Melody: "2"}
+	if !reflect.DeepEqual(*status, want) {
+		t.Errorf("Status() = %+v, want %+v", *status, want)
+	}
+}
@@ -0,0 +1,65 @@
+package device
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// A State holds device state ("dps") data.
+type State map[uint32]interface{}
+
+// Bool returns the boolean value of a dp.
+func (s State) Bool(dp uint32) (bool, error) {
+	v, ok := s[dp]
+	if !ok {
+		return false, fmt.Errorf("dp %d missing", dp)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("dp %d: %T not a bool", dp, v)
+	}
+	return b, nil
+}
+
+// Int returns the integer value of a dp. JSON numbers decode as float64, so
+// any numeric value is accepted and truncated.
+func (s State) Int(dp uint32) (int, error) {
+	v, ok := s[dp]
+	if !ok {
+		return 0, fmt.Errorf("dp %d missing", dp)
+	}
+	switch n := v.(type) {
+	case float64:
+		return int(n), nil
+	case int:
+		return n, nil
+	}
+	return 0, fmt.Errorf("dp %d: %T not a number", dp, v)
+}
+
+// String returns the string value of a dp.
+func (s State) String(dp uint32) (string, error) {
+	v, ok := s[dp]
+	if !ok {
+		return "", fmt.Errorf("dp %d missing", dp)
+	}
+	str, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("dp %d: %T not a string", dp, v)
+	}
+	return str, nil
+}
+
+// Raw returns the decoded value of a raw dp, which is base64 encoded in
+// device messages.
+func (s State) Raw(dp uint32) ([]byte, error) {
+	str, err := s.String(dp)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, fmt.Errorf("dp %d: base64 Decode: %v", dp, err)
+	}
+	return data, nil
+}
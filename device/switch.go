@@ -0,0 +1,90 @@
+package device
+
+import (
+	"fmt"
+)
+
+// A Switch controls a relay device such as a smart plug or a (multi-gang) wall
+// switch. Channels are numbered from 0; each channel maps to a boolean dp.
+type Switch struct {
+	*Manager
+
+	// DPs maps channel index to dp number.
+	DPs []uint32
+}
+
+// NewSwitch creates a Switch using the given channel dps. With no dps it
+// assumes a single-channel device controlled by dp 1, which covers most plugs.
+// Multi-gang switches typically number their channels consecutively, e.g.
+// NewSwitch(m, 1, 2, 3).
+func NewSwitch(m *Manager, dps ...uint32) *Switch {
+	if len(dps) == 0 {
+		dps = []uint32{1}
+	}
+	return &Switch{Manager: m, DPs: dps}
+}
+
+// Channels returns the number of channels.
+func (s *Switch) Channels() int {
+	return len(s.DPs)
+}
+
+func (s *Switch) dp(channel int) (uint32, error) {
+	if channel < 0 || channel >= len(s.DPs) {
+		return 0, fmt.Errorf("bad channel %d; have %d", channel, len(s.DPs))
+	}
+	return s.DPs[channel], nil
+}
+
+// IsOn reports whether the given channel is on.
+func (s *Switch) IsOn(channel int) (bool, error) {
+	dp, err := s.dp(channel)
+	if err != nil {
+		return false, err
+	}
+	state, err := s.GetState()
+	if err != nil {
+		return false, err
+	}
+	return state.Bool(dp)
+}
+
+// Set turns the given channel on or off.
+func (s *Switch) Set(channel int, on bool) error {
+	dp, err := s.dp(channel)
+	if err != nil {
+		return err
+	}
+	return s.SetState(State{dp: on})
+}
+
+// On turns the given channel on.
+func (s *Switch) On(channel int) error {
+	return s.Set(channel, true)
+}
+
+// Off turns the given channel off.
+func (s *Switch) Off(channel int) error {
+	return s.Set(channel, false)
+}
+
+// Toggle inverts the given channel and returns its new state.
+func (s *Switch) Toggle(channel int) (bool, error) {
+	on, err := s.IsOn(channel)
+	if err != nil {
+		return false, err
+	}
+	if err := s.Set(channel, !on); err != nil {
+		return on, err
+	}
+	return !on, nil
+}
+
+// SetAll turns every channel on or off in a single request.
+func (s *Switch) SetAll(on bool) error {
+	state := State{}
+	for _, dp := range s.DPs {
+		state[dp] = on
+	}
+	return s.SetState(state)
+}
@@ -0,0 +1,94 @@
+package device
+
+import (
+	"testing"
+)
+
+func TestSwitch(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		dps     []uint32
+		state   State
+		call    func(*Switch) error
+		want    []State
+		wantErr bool
+	}{
+		{
+			name: "on",
+			call: func(s *Switch) error { return s.On(0) },
+			want: []State{{1: true}},
+		},
+		{
+			name: "off",
+			dps:  []uint32{1, 2, 3},
+			call: func(s *Switch) error { return s.Off(1) },
+			want: []State{{2: false}},
+		},
+		{
+			name: "set all",
+			dps:  []uint32{1, 2, 3},
+			call: func(s *Switch) error { return s.SetAll(true) },
+			want: []State{{1: true, 2: true, 3: true}},
+		},
+		{
+			name:  "toggle",
+			dps:   []uint32{1, 2},
+			state: State{1: false, 2: true},
+			call: func(s *Switch) error {
+				on, err := s.Toggle(1)
+				if on {
+					t.Error("Toggle returned on, want off")
+				}
+				return err
+			},
+			want: []State{{2: false}},
+		},
+		{
+			name:    "bad channel",
+			dps:     []uint32{1, 2},
+			call:    func(s *Switch) error { return s.On(2) },
+			wantErr: true,
+		},
+		{
+			name:    "negative channel",
+			call:    func(s *Switch) error { return s.Set(-1, true) },
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			state := tc.state
+			if state == nil {
+				state = State{}
+			}
+			m, d := newTestManagerState(t, state)
+			defer m.Close()
+			if err := tc.call(NewSwitch(m, tc.dps...)); (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+			checkSets(t, d, tc.want...)
+		})
+	}
+}
+
+func TestSwitchIsOn(t *testing.T) {
+	m, _ := newTestManagerState(t, State{1: true, 2: false, 3: "on"})
+	defer m.Close()
+	s := NewSwitch(m, 1, 2, 3, 4)
+	if n := s.Channels(); n != 4 {
+		t.Errorf("Channels() = %d, want 4", n)
+	}
+	for channel, want := range []struct {
+		on      bool
+		wantErr bool
+	}{
+		{on: true},
+		{on: false},
+		{wantErr: true}, // not a bool
+		{wantErr: true}, // missing
+	} {
+		on, err := s.IsOn(channel)
+		if on != want.on || (err != nil) != want.wantErr {
+			t.Errorf("IsOn(%d) = %v, %v", channel, on, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package device
+
+import (
+	"math"
+)
+
+// ThermostatDPs describes the dp layout of a thermostat or radiator valve
+// (TRV). A zero dp means the device has no such dp.
+type ThermostatDPs struct {
+	Switch, Target, Current, Mode, ChildLock uint32
+
+	// Scale is the number of raw units per degree; 10 for devices reporting
+	// tenths of a degree.
+	Scale float64
+}
+
+// ThermostatDPsDefault is a common layout for heating thermostats, reporting
+// temperatures in tenths of a degree. Layouts vary considerably between
+// products, so check the device's dps before relying on it.
+var ThermostatDPsDefault = ThermostatDPs{
+	Switch:    1,
+	Target:    2,
+	Current:   3,
+	Mode:      4,
+	ChildLock: 6,
+	Scale:     10,
+}
+
+// A Thermostat controls a heating thermostat or TRV. Temperatures are in
+// degrees, in whatever unit the device is configured for.
+type Thermostat struct {
+	*Manager
+	DPs ThermostatDPs
+}
+
+// NewThermostat creates a Thermostat with the given dp layout.
+func NewThermostat(m *Manager, dps ThermostatDPs) *Thermostat {
+	if dps.Scale == 0 {
+		dps.Scale = 1
+	}
+	return &Thermostat{Manager: m, DPs: dps}
+}
+
+// A ThermostatStatus is a decoded thermostat state.
+type ThermostatStatus struct {
+	On        bool
+	Target    float64
+	Current   float64
+	Mode      string
+	ChildLock bool
+}
+
+// Status reads and decodes the thermostat state. Dps missing from the device
+// state are left zero.
+func (t *Thermostat) Status() (*ThermostatStatus, error) {
+	state, err := t.GetState()
+	if err != nil {
+		return nil, err
+	}
+	status := &ThermostatStatus{}
+	status.On, _ = state.Bool(t.DPs.Switch)
+	if raw, err := state.Int(t.DPs.Target); err == nil {
+		status.Target = float64(raw) / t.DPs.Scale
+	}
+	if raw, err := state.Int(t.DPs.Current); err == nil {
+		status.Current = float64(raw) / t.DPs.Scale
+	}
+	status.Mode, _ = state.String(t.DPs.Mode)
+	status.ChildLock, _ = state.Bool(t.DPs.ChildLock)
+	return status, nil
+}
+
+// SetOn turns the thermostat on or off.
+func (t *Thermostat) SetOn(on bool) error {
+	if t.DPs.Switch == 0 {
+		return ErrUnsupported
+	}
+	return t.SetState(State{t.DPs.Switch: on})
+}
+
+// SetTarget sets the target temperature, rounded to the device's resolution.
+func (t *Thermostat) SetTarget(degrees float64) error {
+	return t.SetState(State{
+		t.DPs.Target: int(math.Round(degrees * t.DPs.Scale)),
+	})
+}
+
+// SetMode sets the operating mode, e.g. "auto" or "manual".
+func (t *Thermostat) SetMode(mode string) error {
+	if t.DPs.Mode == 0 {
+		return ErrUnsupported
+	}
+	return t.SetState(State{t.DPs.Mode: mode})
+}
+
+// SetChildLock enables or disables the device's physical controls lock.
+func (t *Thermostat) SetChildLock(locked bool) error {
+	if t.DPs.ChildLock == 0 {
+		return ErrUnsupported
+	}
+	return t.SetState(State{t.DPs.ChildLock: locked})
+}
@@ -0,0 +1,78 @@
+package device
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestThermostat(t *testing.T) {
+	minimal := ThermostatDPs{Target: 2, Current: 3}
+
+	for _, tc := range []struct {
+		name    string
+		dps     ThermostatDPs
+		call    func(*Thermostat) error
+		want    []State
+		wantErr error
+	}{
+		{"on", ThermostatDPsDefault, func(t *Thermostat) error { return t.SetOn(true) }, []State{{1: true}}, nil},
+		{"no switch", minimal, func(t *Thermostat) error { return t.SetOn(true) }, nil, ErrUnsupported},
+		{"target", ThermostatDPsDefault, func(t *Thermostat) error { return t.SetTarget(21.5) }, []State{{2: 215}}, nil},
+		{"target rounded", ThermostatDPsDefault, func(t *Thermostat) error { return t.SetTarget(20.26) }, []State{{2: 203}}, nil},
+		{"target unscaled", minimal, func(t *Thermostat) error { return t.SetTarget(20.6) }, []State{{2: 21}}, nil},
+		{"mode", ThermostatDPsDefault, func(t *Thermostat) error { return t.SetMode("manual") }, []State{{4: "manual"}}, nil},
+		{"no mode", minimal, func(t *Thermostat) error { return t.SetMode("manual") }, nil, ErrUnsupported},
+		{"child lock", ThermostatDPsDefault, func(t *Thermostat) error { return t.SetChildLock(true) }, []State{{6: true}}, nil},
+		{"no child lock", minimal, func(t *Thermostat) error { return t.SetChildLock(true) }, nil, ErrUnsupported},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, d := newTestManagerState(t, State{})
+			defer m.Close()
+			if err := tc.call(NewThermostat(m, tc.dps)); !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+			checkSets(t, d, tc.want...)
+		})
+	}
+}
+
+func TestThermostatStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		dps   ThermostatDPs
+		state State
+		want  ThermostatStatus
+	}{
+		{
+			name:  "full",
+			dps:   ThermostatDPsDefault,
+			state: State{1: true, 2: 215, 3: 198, 4: "auto", 6: false},
+			want:  ThermostatStatus{On: true, Target: 21.5, Current: 19.8, Mode: "auto"},
+		},
+		{
+			name:  "unscaled",
+			dps:   ThermostatDPs{Switch: 1, Target: 2, Current: 3},
+			state: State{1: false, 2: 21, 3: 19},
+			want:  ThermostatStatus{Target: 21, Current: 19},
+		},
+		{
+			name:  "missing dps",
+			dps:   ThermostatDPsDefault,
+			state: State{3: 200, 6: true},
+			want:  ThermostatStatus{Current: 20, ChildLock: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, _ := newTestManagerState(t, tc.state)
+			defer m.Close()
+			status, err := NewThermostat(m, tc.dps).Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*status, tc.want) {
+				t.Errorf("Status() = %+v, want %+v", *status, tc.want)
+			}
+		})
+	}
+}
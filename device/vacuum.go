@@ -0,0 +1,107 @@
+package device
+
+// VacuumDPs describes the dp layout of a robot vacuum. A zero dp means the
+// device has no such dp.
+type VacuumDPs struct {
+	Power, Start, Mode, Status, Battery, Suction uint32
+
+	// Mode values for cleaning and returning to the dock.
+	CleanMode, DockMode string
+
+	// Raw dps streaming the map and cleaning path. Their layout is model
+	// specific and they are often absent, so they default to unset.
+	Map, Path uint32
+}
+
+// VacuumDPsDefault is the standard robot vacuum layout.
+var VacuumDPsDefault = VacuumDPs{
+	Power: 1, Start: 2, Mode: 3, Status: 5, Battery: 6, Suction: 14,
+	CleanMode: "smart", DockMode: "chargego",
+}
+
+// A Vacuum controls a robot vacuum.
+type Vacuum struct {
+	*Manager
+	DPs VacuumDPs
+}
+
+// NewVacuum creates a Vacuum with the given dp layout.
+func NewVacuum(m *Manager, dps VacuumDPs) *Vacuum {
+	return &Vacuum{Manager: m, DPs: dps}
+}
+
+// A VacuumStatus is a decoded robot vacuum state. Status values are model
+// specific, e.g. "standby", "smart_clean", "charging".
+type VacuumStatus struct {
+	Running bool
+	Mode    string
+	Status  string
+	Battery int
+	Suction string
+}
+
+// Status reads and decodes the vacuum state. Dps missing from the device state
+// are left zero.
+func (v *Vacuum) Status() (*VacuumStatus, error) {
+	state, err := v.GetState()
+	if err != nil {
+		return nil, err
+	}
+	status := &VacuumStatus{}
+	status.Running, _ = state.Bool(v.DPs.Start)
+	status.Mode, _ = state.String(v.DPs.Mode)
+	status.Status, _ = state.String(v.DPs.Status)
+	status.Battery, _ = state.Int(v.DPs.Battery)
+	status.Suction, _ = state.String(v.DPs.Suction)
+	return status, nil
+}
+
+// Start starts a cleaning run.
+func (v *Vacuum) Start() error {
+	state := State{v.DPs.Start: true}
+	if v.DPs.Power != 0 {
+		state[v.DPs.Power] = true
+	}
+	if v.DPs.Mode != 0 && v.DPs.CleanMode != "" {
+		state[v.DPs.Mode] = v.DPs.CleanMode
+	}
+	return v.SetState(state)
+}
+
+// Pause pauses the current run in place.
+func (v *Vacuum) Pause() error {
+	return v.SetState(State{v.DPs.Start: false})
+}
+
+// Dock sends the vacuum back to its charging dock.
+func (v *Vacuum) Dock() error {
+	if v.DPs.Mode == 0 || v.DPs.DockMode == "" {
+		return ErrUnsupported
+	}
+	return v.SetState(State{v.DPs.Mode: v.DPs.DockMode})
+}
+
+// SetSuction sets the fan speed enum, e.g. "gentle", "normal", or "strong".
+func (v *Vacuum) SetSuction(level string) error {
+	if v.DPs.Suction == 0 {
+		return ErrUnsupported
+	}
+	return v.SetState(State{v.DPs.Suction: level})
+}
+
+// Map returns the decoded raw map dp data from a pushed state update; vacuums
+// stream these rather than returning them from queries.
+func (v *Vacuum) Map(state State) ([]byte, error) {
+	if v.DPs.Map == 0 {
+		return nil, ErrUnsupported
+	}
+	return state.Raw(v.DPs.Map)
+}
+
+// Path returns the decoded raw path dp data from a pushed state update.
+func (v *Vacuum) Path(state State) ([]byte, error) {
+	if v.DPs.Path == 0 {
+		return nil, ErrUnsupported
+	}
+	return state.Raw(v.DPs.Path)
+}
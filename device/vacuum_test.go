@@ -0,0 +1,73 @@
+package device
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestVacuum(t *testing.T) {
+	minimal := VacuumDPs{Start: 2}
+
+	for _, tc := range []struct {
+		name    string
+		dps     VacuumDPs
+		call    func(*Vacuum) error
+		want    []State
+		wantErr error
+	}{
+		{"start", VacuumDPsDefault, (*Vacuum).Start, []State{{1: true, 2: true, 3: "smart"}}, nil},
+		{"start minimal", minimal, (*Vacuum).Start, []State{{2: true}}, nil},
+		{"pause", VacuumDPsDefault, (*Vacuum).Pause, []State{{2: false}}, nil},
+		{"dock", VacuumDPsDefault, (*Vacuum).Dock, []State{{3: "chargego"}}, nil},
+		{"no dock", minimal, (*Vacuum).Dock, nil, ErrUnsupported},
+		{"suction", VacuumDPsDefault, func(v *Vacuum) error { return v.SetSuction("strong") }, []State{{14: "strong"}}, nil},
+		{"no suction", minimal, func(v *Vacuum) error { return v.SetSuction("strong") }, nil, ErrUnsupported},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, d := newTestManagerState(t, State{})
+			defer m.Close()
+			if err := tc.call(NewVacuum(m, tc.dps)); !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+			checkSets(t, d, tc.want...)
+		})
+	}
+}
+
+func TestVacuumStatus(t *testing.T) {
+	m, _ := newTestManagerState(t, State{2: true, 3: "smart", 5: "smart_clean", 6: 80, 14: "normal"})
+	defer m.Close()
+	status, err := NewVacuum(m, VacuumDPsDefault).Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := VacuumStatus{Running: true, Mode: "smart", Status: "smart_clean", Battery: 80, Suction: "normal"}
+	if !reflect.DeepEqual(*status, want) {
+		t.Errorf("Status() = %+v, want %+v", *status, want)
+	}
+}
+
+func TestVacuumMapPath(t *testing.T) {
+	m, _ := newTestManagerState(t, State{})
+	defer m.Close()
+	dps := VacuumDPsDefault
+	dps.Map, dps.Path = 15, 16
+	v := NewVacuum(m, dps)
+
+	push := State{15: "bWFw", 16: "cGF0aA=="}
+	if data, err := v.Map(push); err != nil || string(data) != "map" {
+		t.Errorf("Map() = %q, %v", data, err)
+	}
+	if data, err := v.Path(push); err != nil || string(data) != "path" {
+		t.Errorf("Path() = %q, %v", data, err)
+	}
+
+	v = NewVacuum(m, VacuumDPsDefault)
+	if _, err := v.Map(push); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Map() without a map dp = %v, want ErrUnsupported", err)
+	}
+	if _, err := v.Path(push); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Path() without a path dp = %v, want ErrUnsupported", err)
+	}
+}
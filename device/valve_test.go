@@ -0,0 +1,112 @@
+package device
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testValveSchedule = []ValveSchedule{
+	{Days: 0x2a, Start: 6*time.Hour + 30*time.Minute, Duration: 15 * time.Minute, Enabled: true},
+	{Days: 0x41, Duration: time.Hour},
+}
+
+const testValveScheduleRaw = "KgGGAA8BQQAAADwA"
+
+func TestValve(t *testing.T) {
+	scheduled := ValveDPsDefault
+	scheduled.Schedule = 11
+
+	for _, tc := range []struct {
+		name    string
+		dps     ValveDPs
+		call    func(*Valve) error
+		want    []State
+		wantErr error
+	}{
+		{"open", ValveDPsDefault, func(v *Valve) error { return v.Open(0) }, []State{{1: true}}, nil},
+		{"open for", ValveDPsDefault, func(v *Valve) error { return v.Open(10 * time.Minute) }, []State{{1: true, 9: 600}}, nil},
+		{"no countdown", ValveDPs{Switch: 1}, func(v *Valve) error { return v.Open(time.Minute) }, nil, ErrUnsupported},
+		{"close", ValveDPsDefault, (*Valve).Close, []State{{1: false}}, nil},
+		{
+			"schedule", scheduled,
+			func(v *Valve) error { return v.SetSchedule(testValveSchedule) },
+			[]State{{11: testValveScheduleRaw}}, nil,
+		},
+		{
+			"no schedule", ValveDPsDefault,
+			func(v *Valve) error { return v.SetSchedule(testValveSchedule) },
+			nil, ErrUnsupported,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, d := newTestManagerState(t, State{})
+			defer m.Close()
+			if err := tc.call(NewValve(m, tc.dps)); !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+			checkSets(t, d, tc.want...)
+		})
+	}
+}
+
+func TestValveStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		dps     ValveDPs
+		state   State
+		want    ValveStatus
+		wantErr bool
+	}{
+		{"running", ValveDPsDefault, State{1: true, 9: 300}, ValveStatus{Open: true, Remaining: 5 * time.Minute}, false},
+		{"closed", ValveDPsDefault, State{1: false, 9: 0}, ValveStatus{}, false},
+		{"no countdown", ValveDPs{Switch: 1}, State{1: true, 9: 300}, ValveStatus{Open: true}, false},
+		{"no switch", ValveDPsDefault, State{9: 300}, ValveStatus{}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, _ := newTestManagerState(t, tc.state)
+			defer m.Close()
+			status, err := NewValve(m, tc.dps).Status()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Status() error = %v", err)
+			}
+			if err == nil && !reflect.DeepEqual(*status, tc.want) {
+				t.Errorf("Status() = %+v, want %+v", *status, tc.want)
+			}
+		})
+	}
+}
+
+func TestValveSchedule(t *testing.T) {
+	dps := ValveDPsDefault
+	dps.Schedule = 11
+	m, _ := newTestManagerState(t, State{11: testValveScheduleRaw})
+	defer m.Close()
+	slots, err := NewValve(m, dps).Schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(slots, testValveSchedule) {
+		t.Errorf("Schedule() = %+v", slots)
+	}
+	if _, err := NewValve(m, ValveDPsDefault).Schedule(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Schedule() without a schedule dp = %v", err)
+	}
+}
+
+func TestValveScheduleErrors(t *testing.T) {
+	for _, slot := range []ValveSchedule{
+		{Start: -time.Minute, Duration: time.Minute},
+		{Start: 24 * time.Hour, Duration: time.Minute},
+		{Duration: 0},
+		{Duration: 0x10000 * time.Minute},
+	} {
+		if raw, err := EncodeValveSchedule([]ValveSchedule{slot}); err == nil {
+			t.Errorf("EncodeValveSchedule(%+v) = %q, want an error", slot, raw)
+		}
+	}
+	if _, err := DecodeValveSchedule(make([]byte, 7)); err == nil {
+		t.Error("DecodeValveSchedule accepted a partial slot")
+	}
+}
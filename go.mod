@@ -1,3 +1,3 @@
 module github.com/lann/tuya
 
-go 1.12
+go 1.18
@@ -0,0 +1,71 @@
+package history
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Export formats.
+const (
+	// CSV has a header row and columns time, id, dp, and value. Times are
+	// RFC 3339. Strings are written as is and other non-numeric values as
+	// JSON.
+	FormatCSV = "csv"
+	// JSONLines has one Record per line, as JSON.
+	FormatJSONLines = "jsonl"
+)
+
+// ContentType returns the MIME type of an export format.
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// Export writes records to w in a format, FormatCSV or FormatJSONLines.
+func Export(w io.Writer, records []Record, format string) error {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "id", "dp", "value"})
+		for _, r := range records {
+			cw.Write([]string{
+				r.Time.Format(time.RFC3339Nano),
+				r.ID,
+				strconv.FormatUint(uint64(r.DP), 10),
+				csvValue(r.Value),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	case FormatJSONLines:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,182 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often a FileStore checks for expired files.
+const pruneInterval = time.Hour
+
+// The layout of a FileStore file's name, before ".jsonl".
+const fileDate = "2006-01-02"
+
+// A FileStore is a Store in a directory of JSON lines files, one per UTC day.
+// Files can be read by other tools, and copied or deleted while the store
+// is open.
+type FileStore struct {
+	// Retention, if not zero, is how long records are kept. Whole days are
+	// deleted once all their records have expired.
+	Retention time.Duration
+
+	dir string
+
+	mu        sync.Mutex
+	file      *os.File
+	fileDay   string
+	lastPrune time.Time
+}
+
+// OpenFileStore opens a FileStore in dir, creating the directory if needed.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Append implements Store.
+func (f *FileStore) Append(records ...Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range records {
+		day := r.Time.UTC().Format(fileDate)
+		if day != f.fileDay {
+			if f.file != nil {
+				f.file.Close()
+				f.file = nil
+			}
+			file, err := os.OpenFile(f.path(day), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			f.file, f.fileDay = file, day
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := f.file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	if f.Retention > 0 && time.Since(f.lastPrune) > pruneInterval {
+		f.lastPrune = time.Now()
+		return f.prune(f.lastPrune.Add(-f.Retention))
+	}
+	return nil
+}
+
+// Delete files whose records are all before cutoff.
+func (f *FileStore) prune(cutoff time.Time) error {
+	days, err := f.days()
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		t, _ := time.Parse(fileDate, day)
+		if t.AddDate(0, 0, 1).After(cutoff) {
+			break
+		}
+		if day == f.fileDay {
+			f.file.Close()
+			f.file, f.fileDay = nil, ""
+		}
+		if err := os.Remove(f.path(day)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query implements Store. Records in a file are assumed to be in order.
+func (f *FileStore) Query(q Query) ([]Record, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Retention > 0 {
+		if cutoff := time.Now().Add(-f.Retention); q.From.Before(cutoff) {
+			q.From = cutoff
+		}
+	}
+	days, err := f.days()
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for _, day := range days {
+		t, _ := time.Parse(fileDate, day)
+		if !q.From.IsZero() && !t.AddDate(0, 0, 1).After(q.From) || !q.To.IsZero() && !t.Before(q.To) {
+			continue
+		}
+		if records, err = f.read(day, q, records); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Append a day's matching records to records.
+func (f *FileStore) read(day string, q Query, records []Record) ([]Record, error) {
+	file, err := os.Open(f.path(day))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// A line cut short by a crash shouldn't hide the rest.
+			continue
+		}
+		if q.Match(r) {
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", f.path(day), err)
+	}
+	return records, nil
+}
+
+// Return the days with files, in order.
+func (f *FileStore) days() ([]string, error) {
+	infos, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, info := range infos {
+		day := strings.TrimSuffix(info.Name(), ".jsonl")
+		if _, err := time.Parse(fileDate, day); err == nil && day != info.Name() {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+func (f *FileStore) path(day string) string {
+	return filepath.Join(f.dir, day+".jsonl")
+}
+
+// Close closes the current file.
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file, f.fileDay = nil, ""
+	return err
+}
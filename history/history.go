@@ -0,0 +1,143 @@
+// Package history stores dp values over time.
+//
+// A Store records each dp change as a Record and answers Queries by device,
+// dp, and time range. MemoryStore keeps records in memory; FileStore keeps
+// them in a directory of daily JSON lines files. Both drop records older than
+// their Retention.
+package history
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Record is a dp's value at a time.
+type Record struct {
+	Time  time.Time   `json:"time"`
+	ID    string      `json:"id"`
+	DP    uint32      `json:"dp"`
+	Value interface{} `json:"value"`
+}
+
+// A Query selects records. Zero fields match anything.
+type Query struct {
+	ID   string
+	DP   uint32
+	From time.Time // inclusive
+	To   time.Time // exclusive
+}
+
+// Match reports whether a record is selected by the Query.
+func (q Query) Match(r Record) bool {
+	return (q.ID == "" || r.ID == q.ID) &&
+		(q.DP == 0 || r.DP == q.DP) &&
+		(q.From.IsZero() || !r.Time.Before(q.From)) &&
+		(q.To.IsZero() || r.Time.Before(q.To))
+}
+
+// A Store records dp values.
+type Store interface {
+	// Append adds records, which should be in time order.
+	Append(records ...Record) error
+	// Query returns matching records in time order.
+	Query(q Query) ([]Record, error)
+}
+
+// A MemoryStore is a Store in memory.
+type MemoryStore struct {
+	// Retention, if not zero, is how long records are kept.
+	Retention time.Duration
+
+	mu      sync.Mutex
+	records []Record
+}
+
+// Append implements Store.
+func (m *MemoryStore) Append(records ...Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = append(m.records, records...)
+	if m.Retention > 0 {
+		cutoff := time.Now().Add(-m.Retention)
+		i := sort.Search(len(m.records), func(i int) bool { return !m.records[i].Time.Before(cutoff) })
+		m.records = append(m.records[:0], m.records[i:]...)
+	}
+	return nil
+}
+
+// Query implements Store.
+func (m *MemoryStore) Query(q Query) ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var records []Record
+	for _, r := range m.records {
+		if q.Match(r) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// A Summary describes a dp's numeric values over a time range. Values are
+// taken to hold until the next record, or the end of the range, so Mean is
+// weighted by time and Integral is the area under the values in value-hours:
+// for a power dp in watts, it's the energy used in watt-hours.
+type Summary struct {
+	Count    int       `json:"count"`
+	Min      float64   `json:"min"`
+	Max      float64   `json:"max"`
+	Mean     float64   `json:"mean"`
+	Integral float64   `json:"integral"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+}
+
+// Summarize returns a Summary of each dp's numeric values in one device's
+// records, which must be in time order, up to end. Non-numeric values are
+// skipped.
+func Summarize(records []Record, end time.Time) map[uint32]Summary {
+	sums := make(map[uint32]*Summary)
+	prev := make(map[uint32]Record)
+	add := func(s *Summary, v float64, from, to time.Time) {
+		if to.After(from) {
+			s.Integral += v * to.Sub(from).Hours()
+		}
+	}
+	for _, r := range records {
+		v, ok := r.Value.(float64)
+		if !ok {
+			continue
+		}
+		s := sums[r.DP]
+		if s == nil {
+			s = &Summary{Min: v, Max: v, First: r.Time}
+			sums[r.DP] = s
+		}
+		if p, ok := prev[r.DP]; ok {
+			add(s, p.Value.(float64), p.Time, r.Time)
+		}
+		prev[r.DP] = r
+		s.Count++
+		if v < s.Min {
+			s.Min = v
+		}
+		if v > s.Max {
+			s.Max = v
+		}
+		s.Last = r.Time
+	}
+	for _, p := range prev {
+		add(sums[p.DP], p.Value.(float64), p.Time, end)
+	}
+	result := make(map[uint32]Summary, len(sums))
+	for dp, s := range sums {
+		if hours := end.Sub(s.First).Hours(); hours > 0 {
+			s.Mean = s.Integral / hours
+		} else {
+			s.Mean = s.Max
+		}
+		result[dp] = *s
+	}
+	return result
+}
@@ -0,0 +1,145 @@
+package history
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	start := time.Date(2020, 1, 1, 23, 0, 0, 0, time.UTC)
+	var records []Record
+	for i := 0; i < 4; i++ {
+		// Crosses midnight, so a FileStore uses two files.
+		at := start.Add(time.Duration(i) * 30 * time.Minute)
+		records = append(records,
+			Record{Time: at, ID: "plug", DP: 19, Value: float64(i * 10)},
+			Record{Time: at, ID: "lamp", DP: 1, Value: i%2 == 0})
+	}
+	if err := s.Append(records...); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		q    Query
+		want []Record
+	}{
+		{Query{}, records},
+		{Query{ID: "plug"}, []Record{records[0], records[2], records[4], records[6]}},
+		{Query{ID: "lamp", DP: 1, From: start.Add(time.Hour)}, []Record{records[5], records[7]}},
+		{Query{From: start.Add(30 * time.Minute), To: start.Add(time.Hour)}, records[2:4]},
+		{Query{DP: 2}, nil},
+	} {
+		got, err := s.Query(tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%+v: got %v, want %v", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, &MemoryStore{})
+
+	s := &MemoryStore{Retention: time.Hour}
+	s.Append(Record{Time: time.Now().Add(-2 * time.Hour), ID: "old"}, Record{Time: time.Now(), ID: "new"})
+	if got, _ := s.Query(Query{}); len(got) != 1 || got[0].ID != "new" {
+		t.Errorf("after retention: %v", got)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+	s.Close()
+	for _, name := range []string{"2020-01-01.jsonl", "2020-01-02.jsonl"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Old files are deleted when retention is set.
+	s.Retention = 24 * time.Hour
+	if err := s.Append(Record{Time: time.Now(), ID: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Query(Query{}); len(got) != 1 || got[0].ID != "new" {
+		t.Errorf("after retention: %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2020-01-01.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("expired file: %v", err)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: start, DP: 19, Value: 100.0},
+		{Time: start, DP: 1, Value: true},
+		{Time: start.Add(time.Hour), DP: 19, Value: 300.0},
+		{Time: start.Add(3 * time.Hour), DP: 19, Value: 0.0},
+	}
+	got := Summarize(records, start.Add(4*time.Hour))
+	want := map[uint32]Summary{19: {
+		Count:    3,
+		Min:      0,
+		Max:      300,
+		Mean:     175, // (100*1 + 300*2 + 0*1) / 4
+		Integral: 700,
+		First:    start,
+		Last:     start.Add(3 * time.Hour),
+	}}
+	if math.Abs(got[19].Mean-want[19].Mean) > 1e-9 {
+		t.Errorf("mean %v, want %v", got[19].Mean, want[19].Mean)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestExport(t *testing.T) {
+	at := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: at, ID: "plug", DP: 19, Value: 12.5},
+		{Time: at, ID: "plug", DP: 1, Value: true},
+		{Time: at, ID: "plug", DP: 2, Value: "a,b"},
+	}
+	for _, tc := range []struct {
+		format, want string
+	}{
+		{FormatCSV, "time,id,dp,value\n" +
+			"2020-01-01T12:00:00Z,plug,19,12.5\n" +
+			"2020-01-01T12:00:00Z,plug,1,true\n" +
+			"2020-01-01T12:00:00Z,plug,2,\"a,b\"\n"},
+		{FormatJSONLines, `{"time":"2020-01-01T12:00:00Z","id":"plug","dp":19,"value":12.5}` + "\n" +
+			`{"time":"2020-01-01T12:00:00Z","id":"plug","dp":1,"value":true}` + "\n" +
+			`{"time":"2020-01-01T12:00:00Z","id":"plug","dp":2,"value":"a,b"}` + "\n"},
+	} {
+		var buf bytes.Buffer
+		if err := Export(&buf, records, tc.format); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.format, buf.String(), tc.want)
+		}
+	}
+	if err := Export(ioutil.Discard, records, "xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
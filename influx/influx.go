@@ -0,0 +1,213 @@
+// Package influx exports dp values of devices served by a server.Server in
+// InfluxDB line protocol.
+//
+// Each value is one line, tagged by device ID, device name, dp number, and dp
+// name if known:
+//
+//	tuya,id=abc123,name=desk-plug,dp=19,dp_name=power value=12.5 1500000000000000000
+//
+// Values keep their JSON type, each in its own field, since InfluxDB fixes a
+// field's type per measurement: numbers are floats in value, bools are
+// booleans in value_bool, and strings are strings in value_str. Other values,
+// like objects, are skipped.
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/internal/httpclient"
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/server"
+)
+
+// An Exporter writes dp values as they change and, optionally, on an
+// interval. The Server must be started for the Exporter to see changes.
+type Exporter struct {
+	Server *server.Server
+
+	// Output receives each batch of lines in a single Write.
+	Output io.Writer
+
+	// Measurement is the measurement name. Empty means "tuya".
+	Measurement string
+
+	// DPNames maps device IDs to their dp names, for the dp_name tag.
+	DPNames map[string]map[uint32]string
+
+	// Interval, if not zero, is how often every device's full state is
+	// queried and written, in addition to changes.
+	Interval time.Duration
+
+	// Logger, if not nil, receives failed queries and writes.
+	Logger net.Logger
+
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// Run writes changes, and states every Interval, until the Exporter is
+// closed.
+func (e *Exporter) Run() error {
+	e.mu.Lock()
+	if e.stop == nil {
+		e.stop = make(chan struct{})
+	}
+	stop := e.stop
+	e.mu.Unlock()
+
+	events, unsubscribe := e.Server.Subscribe()
+	defer unsubscribe()
+	var tick <-chan time.Time
+	if e.Interval > 0 {
+		ticker := time.NewTicker(e.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			e.write(e.lines(ev.ID, ev.Name, ev.DPs, ev.Time))
+		case <-tick:
+			e.poll()
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// Close stops Run.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stop == nil {
+		e.stop = make(chan struct{})
+	}
+	select {
+	case <-e.stop:
+	default:
+		close(e.stop)
+	}
+	return nil
+}
+
+// Query every device and write their states as one batch.
+func (e *Exporter) poll() {
+	var buf bytes.Buffer
+	for _, dev := range e.Server.Devices() {
+		state, err := e.Server.GetState(dev.ID)
+		if err != nil {
+			e.logf(net.LevelWarn, "influx: %s: %v", dev.ID, err)
+			continue
+		}
+		buf.Write(e.lines(dev.ID, dev.Name, state, time.Now()))
+	}
+	e.write(buf.Bytes())
+}
+
+func (e *Exporter) write(lines []byte) {
+	if len(lines) == 0 {
+		return
+	}
+	if _, err := e.Output.Write(lines); err != nil {
+		e.logf(net.LevelError, "influx: %v", err)
+	}
+}
+
+func (e *Exporter) logf(level net.Level, format string, args ...interface{}) {
+	if e.Logger != nil {
+		e.Logger.Logf(level, format, args...)
+	}
+}
+
+// Format a device's dps as lines.
+func (e *Exporter) lines(id, name string, state device.State, t time.Time) []byte {
+	measurement := e.Measurement
+	if measurement == "" {
+		measurement = "tuya"
+	}
+	dps := make([]uint32, 0, len(state))
+	for dp := range state {
+		dps = append(dps, dp)
+	}
+	sort.Slice(dps, func(i, j int) bool { return dps[i] < dps[j] })
+
+	var buf bytes.Buffer
+	for _, dp := range dps {
+		var field, value string
+		switch v := state[dp].(type) {
+		case float64:
+			field, value = "value", strconv.FormatFloat(v, 'g', -1, 64)
+		case bool:
+			field, value = "value_bool", strconv.FormatBool(v)
+		case string:
+			field, value = "value_str", `"`+fieldEscaper.Replace(v)+`"`
+		default:
+			continue
+		}
+		buf.WriteString(measurementEscaper.Replace(measurement))
+		buf.WriteString(",id=" + tagEscaper.Replace(id))
+		if name != "" {
+			buf.WriteString(",name=" + tagEscaper.Replace(name))
+		}
+		fmt.Fprintf(&buf, ",dp=%d", dp)
+		if dpName := e.DPNames[id][dp]; dpName != "" {
+			buf.WriteString(",dp_name=" + tagEscaper.Replace(dpName))
+		}
+		fmt.Fprintf(&buf, " %s=%s %d\n", field, value, t.UnixNano())
+	}
+	return buf.Bytes()
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+	fieldEscaper       = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// An HTTPWriter writes lines to an InfluxDB write endpoint, such as
+// http://localhost:8086/api/v2/write?org=home&bucket=tuya (v2) or
+// http://localhost:8086/write?db=tuya (v1).
+type HTTPWriter struct {
+	URL string
+
+	// Token, if set, is sent as an InfluxDB v2 API token.
+	Token string
+
+	// Client is used for requests. Nil means a default client with a timeout.
+	Client *http.Client
+}
+
+// Write posts one batch of lines.
+func (w *HTTPWriter) Write(lines []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(lines))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.Token)
+	}
+	res, err := httpclient.Or(w.Client).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	if res.StatusCode/100 != 2 {
+		return 0, fmt.Errorf("write: %s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	return len(lines), nil
+}
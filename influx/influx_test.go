@@ -0,0 +1,52 @@
+package influx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+)
+
+func TestLines(t *testing.T) {
+	e := &Exporter{DPNames: map[string]map[uint32]string{"abc": {19: "power"}}}
+	state := device.State{1: true, 19: 12.5, 5: `say "hi"`, 6: map[string]interface{}{}}
+	got := string(e.lines("abc", "desk plug", state, time.Unix(1500000000, 0)))
+	// Each type has its own field, so they don't conflict in InfluxDB.
+	want := `tuya,id=abc,name=desk\ plug,dp=1 value_bool=true 1500000000000000000
+tuya,id=abc,name=desk\ plug,dp=5 value_str="say \"hi\"" 1500000000000000000
+tuya,id=abc,name=desk\ plug,dp=19,dp_name=power value=12.5 1500000000000000000
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHTTPWriter(t *testing.T) {
+	var body, auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body, auth = string(data), r.Header.Get("Authorization")
+		if r.URL.Query().Get("bucket") != "tuya" {
+			http.Error(w, "no bucket", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	w := &HTTPWriter{URL: ts.URL + "/api/v2/write?bucket=tuya", Token: "secret"}
+	if _, err := w.Write([]byte("tuya value=1 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if body != "tuya value=1 1\n" || auth != "Token secret" {
+		t.Errorf("got body %q auth %q", body, auth)
+	}
+
+	w.URL = ts.URL + "/api/v2/write"
+	if _, err := w.Write([]byte("x")); err == nil {
+		t.Error("expected error for 404")
+	}
+}
@@ -0,0 +1,20 @@
+// Package httpclient holds the HTTP client used by packages that make
+// outgoing requests when their caller doesn't supply one.
+package httpclient
+
+import (
+	"net/http"
+	"time"
+)
+
+// Default has a timeout, unlike http.DefaultClient, so that an unresponsive
+// endpoint can't hold up deliveries indefinitely.
+var Default = &http.Client{Timeout: 10 * time.Second}
+
+// Or returns c, or Default if c is nil.
+func Or(c *http.Client) *http.Client {
+	if c == nil {
+		return Default
+	}
+	return c
+}
@@ -0,0 +1,103 @@
+package mcu
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/lann/tuya/device"
+)
+
+// A Conn speaks the MCU protocol over a serial port or other ReadWriter,
+// taking the Wi-Fi module's side of the conversation by default.
+type Conn struct {
+	// Version is sent in each frame's version byte; set it to VersionMCU to
+	// emulate the MCU side instead.
+	Version byte
+
+	r *bufio.Reader
+	w io.Writer
+
+	// Serializes writes.
+	mu sync.Mutex
+}
+
+// NewConn creates a Conn on the given ReadWriter.
+func NewConn(rw io.ReadWriter) *Conn {
+	return &Conn{
+		Version: VersionModule,
+		r:       bufio.NewReader(rw),
+		w:       rw,
+	}
+}
+
+// WriteFrame sends a frame with the given command and data. WriteFrame may be
+// called from multiple goroutines.
+func (c *Conn) WriteFrame(cmd byte, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := &Frame{Version: c.Version, Cmd: cmd, Data: data}
+	return f.Encode(c.w)
+}
+
+// ReadFrame reads the next frame. It is *not* safe to call from multiple
+// goroutines.
+func (c *Conn) ReadFrame() (*Frame, error) {
+	return DecodeFrame(c.r)
+}
+
+// Heartbeat sends a heartbeat, which the MCU answers with CmdHeartbeat.
+func (c *Conn) Heartbeat() error {
+	return c.WriteFrame(CmdHeartbeat, nil)
+}
+
+// QueryProductInfo requests the MCU's product info, which it answers with
+// CmdProductInfo; see DecodeProductInfo.
+func (c *Conn) QueryProductInfo() error {
+	return c.WriteFrame(CmdProductInfo, nil)
+}
+
+// QueryState asks the MCU to report all dps, which it answers with one or
+// more CmdReportDP frames.
+func (c *Conn) QueryState() error {
+	return c.WriteFrame(CmdQueryDP, nil)
+}
+
+// SetState sends dp updates to the MCU. See EncodeState for value types.
+func (c *Conn) SetState(state device.State) error {
+	data, err := EncodeState(state)
+	if err != nil {
+		return err
+	}
+	return c.WriteFrame(CmdSendDP, data)
+}
+
+// ReportState sends a dp report, as the MCU does after a change.
+func (c *Conn) ReportState(state device.State) error {
+	data, err := EncodeState(state)
+	if err != nil {
+		return err
+	}
+	return c.WriteFrame(CmdReportDP, data)
+}
+
+// ProductInfo is the MCU's product info response.
+type ProductInfo struct {
+	ProductKey string `json:"p"`
+	Version    string `json:"v"`
+	Mode       int    `json:"m"`
+}
+
+// DecodeProductInfo decodes a CmdProductInfo frame from the MCU.
+func DecodeProductInfo(f *Frame) (*ProductInfo, error) {
+	if f.Cmd != CmdProductInfo {
+		return nil, fmt.Errorf("not a product info frame: cmd %d", f.Cmd)
+	}
+	info := &ProductInfo{}
+	if err := json.Unmarshal(f.Data, info); err != nil {
+		return nil, fmt.Errorf("Unmarshal: %v", err)
+	}
+	return info, nil
+}
@@ -0,0 +1,128 @@
+package mcu
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/lann/tuya/device"
+)
+
+// DP data types.
+const (
+	TypeRaw    = 0x00
+	TypeBool   = 0x01
+	TypeValue  = 0x02
+	TypeString = 0x03
+	TypeEnum   = 0x04
+	TypeBitmap = 0x05
+)
+
+// An Enum is an enum dp value, which the MCU protocol sends as an index.
+type Enum uint8
+
+// A Bitmap is a fault/bitmap dp value.
+type Bitmap uint32
+
+// EncodeState encodes dp values into DP units: <dp id> <type> <length> <value>.
+// Value types map to dp types as follows: bool to bool, int and float64 to
+// value, string to string, []byte to raw, Enum to enum, and Bitmap to bitmap.
+func EncodeState(state device.State) ([]byte, error) {
+	var buf []byte
+	for dp, v := range state {
+		if dp > 0xff {
+			return nil, fmt.Errorf("dp %d out of range", dp)
+		}
+		var typ byte
+		var value []byte
+		switch v := v.(type) {
+		case bool:
+			typ = TypeBool
+			value = []byte{0}
+			if v {
+				value[0] = 1
+			}
+		case int:
+			typ = TypeValue
+			value = make([]byte, 4)
+			binary.BigEndian.PutUint32(value, uint32(int32(v)))
+		case float64:
+			typ = TypeValue
+			value = make([]byte, 4)
+			binary.BigEndian.PutUint32(value, uint32(int32(v)))
+		case string:
+			typ = TypeString
+			value = []byte(v)
+		case []byte:
+			typ = TypeRaw
+			value = v
+		case Enum:
+			typ = TypeEnum
+			value = []byte{byte(v)}
+		case Bitmap:
+			typ = TypeBitmap
+			value = make([]byte, 4)
+			binary.BigEndian.PutUint32(value, uint32(v))
+		default:
+			return nil, fmt.Errorf("dp %d: unsupported type %T", dp, v)
+		}
+		if len(value) > 0xffff {
+			return nil, fmt.Errorf("dp %d: value too large", dp)
+		}
+		buf = append(buf, byte(dp), typ, byte(len(value)>>8), byte(len(value)))
+		buf = append(buf, value...)
+	}
+	return buf, nil
+}
+
+// DecodeState decodes DP units into dp values, using the Go types described
+// in EncodeState; values decode to int rather than float64.
+func DecodeState(data []byte) (device.State, error) {
+	state := device.State{}
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated dp header")
+		}
+		dp, typ := uint32(data[0]), data[1]
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		data = data[4:]
+		if len(data) < length {
+			return nil, fmt.Errorf("dp %d: truncated value", dp)
+		}
+		value := data[:length]
+		data = data[length:]
+
+		switch typ {
+		case TypeRaw:
+			state[dp] = append([]byte{}, value...)
+		case TypeBool:
+			if length != 1 {
+				return nil, fmt.Errorf("dp %d: bad bool length %d", dp, length)
+			}
+			state[dp] = value[0] != 0
+		case TypeValue:
+			if length != 4 {
+				return nil, fmt.Errorf("dp %d: bad value length %d", dp, length)
+			}
+			state[dp] = int(int32(binary.BigEndian.Uint32(value)))
+		case TypeString:
+			state[dp] = string(value)
+		case TypeEnum:
+			if length != 1 {
+				return nil, fmt.Errorf("dp %d: bad enum length %d", dp, length)
+			}
+			state[dp] = Enum(value[0])
+		case TypeBitmap:
+			if length < 1 || length > 4 {
+				return nil, fmt.Errorf("dp %d: bad bitmap length %d", dp, length)
+			}
+			var bits uint32
+			for _, b := range value {
+				bits = bits<<8 | uint32(b)
+			}
+			state[dp] = Bitmap(bits)
+		default:
+			return nil, fmt.Errorf("dp %d: unknown type %d", dp, typ)
+		}
+	}
+	return state, nil
+}
@@ -0,0 +1,42 @@
+package mcu
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/lann/tuya/device"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	state := device.State{
+		1: true,
+		2: 250,
+		3: "hello",
+		4: Enum(2),
+		5: Bitmap(0x0102),
+		6: []byte{0xde, 0xad},
+	}
+	data, err := EncodeState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeState(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, state) {
+		t.Errorf("got %v want %v", decoded, state)
+	}
+}
+
+func TestEncodeStateValue(t *testing.T) {
+	data, err := EncodeState(device.State{2: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x02, TypeValue, 0x00, 0x04, 0xff, 0xff, 0xff, 0xff}
+	if !bytes.Equal(data, want) {
+		t.Errorf("got %x want %x", data, want)
+	}
+}
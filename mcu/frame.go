@@ -0,0 +1,110 @@
+// Package mcu implements the Tuya MCU serial protocol spoken between a Tuya
+// Wi-Fi module and a product's microcontroller.
+package mcu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+const (
+	// Magic values that start each frame.
+	prefix0 = 0x55
+	prefix1 = 0xaa
+
+	// Prefix, version, cmd, and length bytes.
+	headerSize = 6
+)
+
+// Versions sent in the frame version byte.
+const (
+	VersionModule = 0x00 // Sent by the Wi-Fi module
+	VersionMCU    = 0x03 // Sent by the MCU
+)
+
+// Command numbers.
+const (
+	CmdHeartbeat   = 0x00
+	CmdProductInfo = 0x01
+	CmdWorkingMode = 0x02
+	CmdWifiStatus  = 0x03
+	CmdResetWifi   = 0x04
+	CmdSendDP      = 0x06
+	CmdReportDP    = 0x07
+	CmdQueryDP     = 0x08
+)
+
+// A Frame is a serial message: "55aa" <version> <cmd> <length> <data> <checksum>.
+type Frame struct {
+	Version byte
+	Cmd     byte
+	Data    []byte
+}
+
+// Compute the frame checksum: the low byte of the sum of all preceding bytes.
+func checksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return sum
+}
+
+// DecodeFrame reads the next Frame from a Reader, skipping any bytes before
+// the next frame prefix so that a stream can resynchronize after noise.
+func DecodeFrame(r *bufio.Reader) (*Frame, error) {
+	// Find prefix
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != prefix0 {
+			continue
+		}
+		next, err := r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if next[0] == prefix1 {
+			break
+		}
+	}
+
+	buf := make([]byte, headerSize)
+	buf[0] = prefix0
+	if _, err := io.ReadFull(r, buf[1:]); err != nil {
+		return nil, fmt.Errorf("header Read: %v", err)
+	}
+	length := int(buf[4])<<8 | int(buf[5])
+	buf = append(buf, make([]byte, length+1)...)
+	if _, err := io.ReadFull(r, buf[headerSize:]); err != nil {
+		return nil, fmt.Errorf("data Read: %v", err)
+	}
+	sum := buf[len(buf)-1]
+	if expected := checksum(buf[:len(buf)-1]); sum != expected {
+		return nil, fmt.Errorf("checksum mismatch; %x != %x", sum, expected)
+	}
+	return &Frame{
+		Version: buf[2],
+		Cmd:     buf[3],
+		Data:    buf[headerSize : len(buf)-1],
+	}, nil
+}
+
+// Encode writes the Frame to a Writer.
+func (f *Frame) Encode(w io.Writer) error {
+	if len(f.Data) > 0xffff {
+		return fmt.Errorf("data too large; %d > %d", len(f.Data), 0xffff)
+	}
+	buf := make([]byte, 0, headerSize+len(f.Data)+1)
+	buf = append(buf, prefix0, prefix1, f.Version, f.Cmd,
+		byte(len(f.Data)>>8), byte(len(f.Data)))
+	buf = append(buf, f.Data...)
+	buf = append(buf, checksum(buf))
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("Write: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package mcu
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+var (
+	testHeartbeat      = []byte{0x55, 0xaa, 0x00, 0x00, 0x00, 0x00, 0xff}
+	testHeartbeatReply = []byte{0x55, 0xaa, 0x03, 0x00, 0x00, 0x01, 0x00, 0x03}
+)
+
+func TestFrameEncode(t *testing.T) {
+	var buf bytes.Buffer
+	f := &Frame{Version: VersionModule, Cmd: CmdHeartbeat}
+	if err := f.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), testHeartbeat) {
+		t.Errorf("got %x want %x", buf.Bytes(), testHeartbeat)
+	}
+}
+
+func TestDecodeFrameResync(t *testing.T) {
+	data := append([]byte{0x00, 0x55, 0x12}, testHeartbeatReply...)
+	f, err := DecodeFrame(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Version != VersionMCU || f.Cmd != CmdHeartbeat || !bytes.Equal(f.Data, []byte{0}) {
+		t.Errorf("bad frame %+v", f)
+	}
+}
+
+func TestDecodeFrameBadChecksum(t *testing.T) {
+	data := append([]byte{}, testHeartbeatReply...)
+	data[len(data)-1]++
+	if _, err := DecodeFrame(bufio.NewReader(bytes.NewReader(data))); err == nil {
+		t.Error("expected checksum error")
+	}
+}
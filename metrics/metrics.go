@@ -0,0 +1,367 @@
+// Package metrics collects device metrics and writes them in the Prometheus
+// text exposition format.
+//
+// Metrics are labeled by device ID; tuya_device_info maps IDs to names:
+//
+//	tuya_device_info{id, name}                  always 1
+//	tuya_device_reachable{id}                   1 if connected, else 0
+//	tuya_request_duration_seconds{id, cmd}      histogram of request latency
+//	tuya_request_errors_total{id, cmd}          failed requests
+//	tuya_dp_value{id, dp}                       last numeric or bool dp value
+//	tuya_frames_sent_total{id, cmd}             frames sent by Clients
+//	tuya_frames_received_total{id, cmd}         frames received by Clients
+//	tuya_bytes_sent_total{id}                   frame bytes sent
+//	tuya_bytes_received_total{id}               frame bytes received
+//	tuya_decode_errors_total{id}                frames that failed to decrypt
+//	tuya_reconnects_total{id}                   reconnections after failures
+//
+// Frame, byte, error, and reconnect counts are collected from Clients given
+// the net.Metrics returned by Client.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// Upper bounds of the request latency histogram buckets, in seconds.
+var latencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	id  string
+	cmd uint32
+}
+
+type dpKey struct {
+	id string
+	dp uint32
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Metrics collects device metrics. The zero value is not usable; use New.
+type Metrics struct {
+	mu        sync.Mutex
+	names     map[string]string
+	reachable map[string]bool
+	latencies map[requestKey]*histogram
+	errors    map[requestKey]uint64
+	dps       map[dpKey]float64
+	sent      map[requestKey]uint64
+	received  map[requestKey]uint64
+	clients   map[string]*clientCounts
+}
+
+// Per-device Client counts.
+type clientCounts struct {
+	bytesSent, bytesReceived uint64
+	decodeErrors, reconnects uint64
+}
+
+// New creates an empty Metrics.
+func New() *Metrics {
+	return &Metrics{
+		names:     make(map[string]string),
+		reachable: make(map[string]bool),
+		latencies: make(map[requestKey]*histogram),
+		errors:    make(map[requestKey]uint64),
+		dps:       make(map[dpKey]float64),
+		sent:      make(map[requestKey]uint64),
+		received:  make(map[requestKey]uint64),
+		clients:   make(map[string]*clientCounts),
+	}
+}
+
+// SetName records a device's name for tuya_device_info.
+func (m *Metrics) SetName(id, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.names[id] = name
+}
+
+// Remove forgets a device's metrics.
+func (m *Metrics) Remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.names, id)
+	delete(m.reachable, id)
+	for k := range m.latencies {
+		if k.id == id {
+			delete(m.latencies, k)
+		}
+	}
+	for k := range m.errors {
+		if k.id == id {
+			delete(m.errors, k)
+		}
+	}
+	for k := range m.dps {
+		if k.id == id {
+			delete(m.dps, k)
+		}
+	}
+	for k := range m.sent {
+		if k.id == id {
+			delete(m.sent, k)
+		}
+	}
+	for k := range m.received {
+		if k.id == id {
+			delete(m.received, k)
+		}
+	}
+	delete(m.clients, id)
+}
+
+// Client returns a net.Metrics counting a device's frames, for its
+// net.ClientConfig.
+func (m *Metrics) Client(id string) net.Metrics {
+	return clientMetrics{m, id}
+}
+
+type clientMetrics struct {
+	m  *Metrics
+	id string
+}
+
+// Return a device's Client counts. Must be called with the lock held.
+func (m *Metrics) counts(id string) *clientCounts {
+	c := m.clients[id]
+	if c == nil {
+		c = &clientCounts{}
+		m.clients[id] = c
+	}
+	return c
+}
+
+func (c clientMetrics) FrameSent(cmd uint32, size int) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.sent[requestKey{c.id, cmd}]++
+	c.m.counts(c.id).bytesSent += uint64(size)
+}
+
+func (c clientMetrics) FrameReceived(cmd uint32, size int) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.received[requestKey{c.id, cmd}]++
+	c.m.counts(c.id).bytesReceived += uint64(size)
+}
+
+func (c clientMetrics) DecodeError(cmd uint32, err error) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.counts(c.id).decodeErrors++
+}
+
+func (c clientMetrics) Reconnected() {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.counts(c.id).reconnects++
+}
+
+// SetReachable records whether a device is connected.
+func (m *Metrics) SetReachable(id string, reachable bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reachable[id] = reachable
+}
+
+// ObserveRequest records a request's latency and error. It has the signature
+// of device.Hooks.Request.
+func (m *Metrics) ObserveRequest(id string, cmd uint32, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := requestKey{id, cmd}
+	h := m.latencies[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[key] = h
+	}
+	seconds := latency.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+	if err != nil {
+		m.errors[key]++
+	}
+}
+
+// SetDPs records dp values. Only numbers and bools, as 0 or 1, are kept.
+func (m *Metrics) SetDPs(id string, dps map[uint32]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dp, v := range dps {
+		switch v := v.(type) {
+		case float64:
+			m.dps[dpKey{id, dp}] = v
+		case bool:
+			m.dps[dpKey{id, dp}] = 0
+			if v {
+				m.dps[dpKey{id, dp}] = 1
+			}
+		}
+	}
+}
+
+// ServeHTTP writes the metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+
+	header(&b, "tuya_device_info", "gauge", "Device names by ID.")
+	for _, id := range sortedKeys(m.names) {
+		fmt.Fprintf(&b, "tuya_device_info{id=%s,name=%s} 1\n", quote(id), quote(m.names[id]))
+	}
+
+	header(&b, "tuya_device_reachable", "gauge", "Whether the device is connected.")
+	ids := make([]string, 0, len(m.reachable))
+	for id := range m.reachable {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		v := 0
+		if m.reachable[id] {
+			v = 1
+		}
+		fmt.Fprintf(&b, "tuya_device_reachable{id=%s} %d\n", quote(id), v)
+	}
+
+	keys := make([]requestKey, 0, len(m.latencies))
+	for k := range m.latencies {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].id != keys[j].id {
+			return keys[i].id < keys[j].id
+		}
+		return keys[i].cmd < keys[j].cmd
+	})
+	header(&b, "tuya_request_duration_seconds", "histogram", "Latency of device requests.")
+	for _, k := range keys {
+		h := m.latencies[k]
+		labels := fmt.Sprintf("id=%s,cmd=\"%d\"", quote(k.id), k.cmd)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "tuya_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "tuya_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(&b, "tuya_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(&b, "tuya_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	header(&b, "tuya_request_errors_total", "counter", "Failed device requests.")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "tuya_request_errors_total{id=%s,cmd=\"%d\"} %d\n", quote(k.id), k.cmd, m.errors[k])
+	}
+
+	dps := make([]dpKey, 0, len(m.dps))
+	for k := range m.dps {
+		dps = append(dps, k)
+	}
+	sort.Slice(dps, func(i, j int) bool {
+		if dps[i].id != dps[j].id {
+			return dps[i].id < dps[j].id
+		}
+		return dps[i].dp < dps[j].dp
+	})
+	header(&b, "tuya_dp_value", "gauge", "Last numeric or boolean value of a dp.")
+	for _, k := range dps {
+		fmt.Fprintf(&b, "tuya_dp_value{id=%s,dp=\"%d\"} %s\n", quote(k.id), k.dp, formatFloat(m.dps[k]))
+	}
+
+	writeFrameCounts(&b, "tuya_frames_sent_total", "Frames sent to devices.", m.sent)
+	writeFrameCounts(&b, "tuya_frames_received_total", "Frames received from devices.", m.received)
+
+	ids = ids[:0]
+	for id := range m.clients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, metric := range []struct {
+		name, help string
+		value      func(*clientCounts) uint64
+	}{
+		{"tuya_bytes_sent_total", "Frame bytes sent to devices.", func(c *clientCounts) uint64 { return c.bytesSent }},
+		{"tuya_bytes_received_total", "Frame bytes received from devices.", func(c *clientCounts) uint64 { return c.bytesReceived }},
+		{"tuya_decode_errors_total", "Received frames that failed to decrypt.", func(c *clientCounts) uint64 { return c.decodeErrors }},
+		{"tuya_reconnects_total", "Reconnections after connection failures.", func(c *clientCounts) uint64 { return c.reconnects }},
+	} {
+		header(&b, metric.name, "counter", metric.help)
+		for _, id := range ids {
+			fmt.Fprintf(&b, "%s{id=%s} %d\n", metric.name, quote(id), metric.value(m.clients[id]))
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeFrameCounts(b *strings.Builder, name, help string, counts map[requestKey]uint64) {
+	keys := make([]requestKey, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].id != keys[j].id {
+			return keys[i].id < keys[j].id
+		}
+		return keys[i].cmd < keys[j].cmd
+	})
+	header(b, name, "counter", help)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s{id=%s,cmd=\"%d\"} %d\n", name, quote(k.id), k.cmd, counts[k])
+	}
+}
+
+func header(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Quote a label value.
+func quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
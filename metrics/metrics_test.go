@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTo(t *testing.T) {
+	m := New()
+	m.SetName("abc", `lamp "one"`)
+	m.SetReachable("abc", true)
+	m.ObserveRequest("abc", 10, 30*time.Millisecond, nil)
+	m.ObserveRequest("abc", 10, 20*time.Second, errors.New("timed out"))
+	m.SetDPs("abc", map[uint32]interface{}{1: true, 19: 1234.5, 5: "white"})
+	c := m.Client("abc")
+	c.FrameSent(10, 50)
+	c.FrameReceived(10, 80)
+	c.FrameReceived(8, 100)
+	c.DecodeError(8, errors.New("bad padding"))
+	c.Reconnected()
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		`tuya_device_info{id="abc",name="lamp \"one\""} 1`,
+		`tuya_device_reachable{id="abc"} 1`,
+		`tuya_request_duration_seconds_bucket{id="abc",cmd="10",le="0.025"} 0`,
+		`tuya_request_duration_seconds_bucket{id="abc",cmd="10",le="0.05"} 1`,
+		`tuya_request_duration_seconds_bucket{id="abc",cmd="10",le="10"} 1`,
+		`tuya_request_duration_seconds_bucket{id="abc",cmd="10",le="+Inf"} 2`,
+		`tuya_request_duration_seconds_sum{id="abc",cmd="10"} 20.03`,
+		`tuya_request_duration_seconds_count{id="abc",cmd="10"} 2`,
+		`tuya_request_errors_total{id="abc",cmd="10"} 1`,
+		`tuya_dp_value{id="abc",dp="1"} 1`,
+		`tuya_dp_value{id="abc",dp="19"} 1234.5`,
+		`tuya_frames_sent_total{id="abc",cmd="10"} 1`,
+		`tuya_frames_received_total{id="abc",cmd="8"} 1`,
+		`tuya_frames_received_total{id="abc",cmd="10"} 1`,
+		`tuya_bytes_sent_total{id="abc"} 50`,
+		`tuya_bytes_received_total{id="abc"} 180`,
+		`tuya_decode_errors_total{id="abc"} 1`,
+		`tuya_reconnects_total{id="abc"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+	if strings.Contains(out, `dp="5"`) {
+		t.Errorf("string dp exported")
+	}
+
+	m.Remove("abc")
+	b.Reset()
+	m.WriteTo(&b)
+	if strings.Contains(b.String(), "abc") {
+		t.Errorf("removed device still exported:\n%s", b.String())
+	}
+}
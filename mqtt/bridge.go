@@ -0,0 +1,304 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/server"
+)
+
+// Delay before reconnecting to the broker after a failure.
+const retryDelay = 10 * time.Second
+
+// A Bridge publishes the state of a Server's devices to an MQTT broker and
+// applies commands received from it. With the default prefix "tuya", and
+// devices named by their Server name or else their ID, the topics are:
+//
+//	tuya/status                "online" or "offline"; the bridge's will
+//	tuya/{device}/availability "online" or "offline"
+//	tuya/{device}/state        JSON object of all known dps
+//	tuya/{device}/dp/{dp}      JSON value of one dp, on change
+//	tuya/{device}/set          command: JSON object of dps to set
+//	tuya/{device}/dp/{dp}/set  command: JSON value of one dp to set
+//
+// Status, availability, and state messages are published with Retain, so new
+// subscribers get the last known values; dp messages are not.
+//
+// The Server must be started for the Bridge to see state changes.
+//
+// MQTT doesn't tell the Bridge which client published a command, so
+// restricting clients is up to the broker. To give some clients limited
+// access, run a second Bridge with its own Prefix and an ACL, and allow those
+// clients only that prefix's topics in the broker's ACLs.
+type Bridge struct {
+	Server *server.Server
+
+	// Prefix is the first topic level. Empty means "tuya".
+	Prefix string
+
+	// QoS and Retain apply to published messages. QoS also applies to
+	// command subscriptions.
+	QoS    byte
+	Retain bool
+
+	// AvailabilityInterval is how often device availability is checked.
+	// Zero means 30 seconds.
+	AvailabilityInterval time.Duration
+
+	// ACL, if not nil, limits the devices and dps published and the
+	// commands accepted.
+	ACL *server.ACL
+
+	// Logger, if not nil, receives broker connection errors and commands
+	// that fail.
+	Logger net.Logger
+
+	mu        sync.Mutex
+	state     map[string]device.State // by topic name
+	available map[string]bool
+	stop      chan struct{}
+}
+
+func (b *Bridge) logf(level net.Level, format string, args ...interface{}) {
+	if b.Logger != nil {
+		b.Logger.Logf(level, format, args...)
+	}
+}
+
+func (b *Bridge) prefix() string {
+	if b.Prefix == "" {
+		return "tuya"
+	}
+	return b.Prefix
+}
+
+// Run connects to a broker with the given Config, reconnecting after
+// failures, until the Bridge is closed. The Config's Will is replaced with
+// the bridge's status topic.
+func (b *Bridge) Run(config Config) error {
+	b.mu.Lock()
+	if b.stop == nil {
+		b.stop = make(chan struct{})
+	}
+	stop := b.stop
+	b.mu.Unlock()
+
+	config.Will = &Message{
+		Topic:   b.prefix() + "/status",
+		Payload: []byte("offline"),
+		QoS:     b.QoS,
+		Retain:  true,
+	}
+	for {
+		client, err := b.connect(config)
+		if err != nil {
+			b.logf(net.LevelWarn, "mqtt: %v", err)
+		} else {
+			b.serve(client, stop)
+			client.Close()
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// Close stops Run.
+func (b *Bridge) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stop == nil {
+		b.stop = make(chan struct{})
+	}
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+	}
+	return nil
+}
+
+func (b *Bridge) connect(config Config) (*Client, error) {
+	client, err := config.Dial()
+	if err != nil {
+		return nil, err
+	}
+	prefix := b.prefix()
+	for _, filter := range []string{prefix + "/+/set", prefix + "/+/dp/+/set"} {
+		err := client.Subscribe(filter, b.QoS, func(m Message) {
+			// Commands wait on devices, so don't hold up the read loop.
+			go b.command(m)
+		})
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	err = client.Publish(Message{Topic: prefix + "/status", Payload: []byte("online"), QoS: b.QoS, Retain: true})
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	// The broker may have stale availability; publish it all again.
+	b.mu.Lock()
+	b.available = make(map[string]bool)
+	b.mu.Unlock()
+	return client, nil
+}
+
+// Publish events until the client fails or the Bridge is closed.
+func (b *Bridge) serve(client *Client, stop chan struct{}) {
+	events, unsubscribe := b.Server.Subscribe()
+	defer unsubscribe()
+
+	interval := b.AvailabilityInterval
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	b.publishAvailability(client)
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := b.publishEvent(client, e); err != nil {
+				b.logf(net.LevelWarn, "mqtt: %v", err)
+			}
+		case <-ticker.C:
+			b.publishAvailability(client)
+		case <-client.Done():
+			b.logf(net.LevelWarn, "mqtt: %v", client.Err())
+			return
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Return a device's topic level.
+func topicName(dev server.Device) string {
+	if dev.Name != "" {
+		return dev.Name
+	}
+	return dev.ID
+}
+
+// Publish availability changes since the last call.
+func (b *Bridge) publishAvailability(client *Client) {
+	for _, dev := range b.Server.Devices() {
+		if !b.ACL.CanRead(dev, 0) {
+			continue
+		}
+		name := topicName(dev)
+		connected := b.Server.Fleet.Connected(dev.ID)
+		b.mu.Lock()
+		old, ok := b.available[name]
+		b.available[name] = connected
+		b.mu.Unlock()
+		if ok && old == connected {
+			continue
+		}
+		payload := "offline"
+		if connected {
+			payload = "online"
+		}
+		client.Publish(Message{
+			Topic:   b.prefix() + "/" + name + "/availability",
+			Payload: []byte(payload),
+			QoS:     b.QoS,
+			Retain:  true,
+		})
+	}
+}
+
+// Publish an event's dps and the device's merged state.
+func (b *Bridge) publishEvent(client *Client, e server.Event) error {
+	dev := server.Device{ID: e.ID, Name: e.Name}
+	e.DPs = b.ACL.Filter(dev, e.DPs)
+	if len(e.DPs) == 0 {
+		return nil
+	}
+	name := topicName(dev)
+	topic := b.prefix() + "/" + name
+
+	b.mu.Lock()
+	if b.state == nil {
+		b.state = make(map[string]device.State)
+	}
+	state := b.state[name]
+	if state == nil {
+		state = device.State{}
+		b.state[name] = state
+	}
+	for dp, v := range e.DPs {
+		state[dp] = v
+	}
+	data, err := json.Marshal(state)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for dp, v := range e.DPs {
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		m := Message{Topic: topic + "/dp/" + strconv.Itoa(int(dp)), Payload: value, QoS: b.QoS, Retain: b.Retain}
+		if err := client.Publish(m); err != nil {
+			return err
+		}
+	}
+	return client.Publish(Message{Topic: topic + "/state", Payload: data, QoS: b.QoS, Retain: true})
+}
+
+// Parse a command topic into a device and, for single-dp commands, a dp.
+func (b *Bridge) parseCommand(topic string) (dev string, dp uint32, single, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(topic, b.prefix()+"/"), "/")
+	switch {
+	case len(parts) == 2 && parts[1] == "set":
+		return parts[0], 0, false, true
+	case len(parts) == 4 && parts[1] == "dp" && parts[3] == "set":
+		n, err := strconv.ParseUint(parts[2], 10, 32)
+		if err != nil {
+			return "", 0, false, false
+		}
+		return parts[0], uint32(n), true, true
+	}
+	return "", 0, false, false
+}
+
+// Apply a command message.
+func (b *Bridge) command(m Message) {
+	dev, dp, single, ok := b.parseCommand(m.Topic)
+	if !ok {
+		return
+	}
+	var state device.State
+	if single {
+		var value interface{}
+		if err := json.Unmarshal(m.Payload, &value); err != nil {
+			// Accept bare strings, e.g. "white" published without quotes.
+			value = string(m.Payload)
+		}
+		state = device.State{dp: value}
+	} else if err := json.Unmarshal(m.Payload, &state); err != nil {
+		b.logf(net.LevelWarn, "mqtt: %s: %v", m.Topic, err)
+		return
+	}
+	if err := b.Server.SetStateACL(dev, state, b.ACL); err != nil {
+		b.logf(net.LevelWarn, "mqtt: %s: %v", m.Topic, err)
+	}
+}
@@ -0,0 +1,92 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/server"
+)
+
+func TestBridgePublishEvent(t *testing.T) {
+	broker := newFakeBroker(t)
+	defer broker.l.Close()
+	c, err := Config{Addr: broker.l.Addr().String()}.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	b := &Bridge{Server: server.New(), Prefix: "home"}
+	e := server.Event{Time: time.Now(), ID: "abc", Name: "lamp", DPs: device.State{1: true}}
+	if err := b.publishEvent(c, e); err != nil {
+		t.Fatal(err)
+	}
+	e.DPs = device.State{2: 10.0}
+	if err := b.publishEvent(c, e); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []struct{ topic, payload string }{
+		{"home/lamp/dp/1", "true"},
+		{"home/lamp/state", `{"1":true}`},
+		{"home/lamp/dp/2", "10"},
+		{"home/lamp/state", `{"1":true,"2":10}`},
+	} {
+		m := broker.receive(t)
+		if m.Topic != want.topic || string(m.Payload) != want.payload {
+			t.Errorf("got %s %s, want %s %s", m.Topic, m.Payload, want.topic, want.payload)
+		}
+	}
+}
+
+func TestBridgeParseCommand(t *testing.T) {
+	b := &Bridge{}
+	for _, tc := range []struct {
+		topic  string
+		dev    string
+		dp     uint32
+		single bool
+		ok     bool
+	}{
+		{"tuya/lamp/set", "lamp", 0, false, true},
+		{"tuya/lamp/dp/20/set", "lamp", 20, true, true},
+		{"tuya/lamp/dp/x/set", "", 0, false, false},
+		{"tuya/lamp/state", "", 0, false, false},
+	} {
+		dev, dp, single, ok := b.parseCommand(tc.topic)
+		if dev != tc.dev || dp != tc.dp || single != tc.single || ok != tc.ok {
+			t.Errorf("parseCommand(%q) = %q %d %v %v", tc.topic, dev, dp, single, ok)
+		}
+	}
+}
+
+func TestBridgeACL(t *testing.T) {
+	broker := newFakeBroker(t)
+	defer broker.l.Close()
+	c, err := Config{Addr: broker.l.Addr().String()}.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	acl := &server.ACL{Rules: []server.Rule{{Device: "lamp", DPs: []uint32{1}}}}
+	b := &Bridge{Server: server.New(), Prefix: "guest", ACL: acl}
+	for _, e := range []server.Event{
+		{ID: "def", Name: "lock", DPs: device.State{1: true}},
+		{ID: "abc", Name: "lamp", DPs: device.State{1: true, 2: 10.0}},
+	} {
+		if err := b.publishEvent(c, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []struct{ topic, payload string }{
+		{"guest/lamp/dp/1", "true"},
+		{"guest/lamp/state", `{"1":true}`},
+	} {
+		m := broker.receive(t)
+		if m.Topic != want.topic || string(m.Payload) != want.payload {
+			t.Errorf("got %s %s, want %s %s", m.Topic, m.Payload, want.topic, want.payload)
+		}
+	}
+}
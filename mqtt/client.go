@@ -0,0 +1,369 @@
+// Package mqtt bridges devices served by a server.Server to an MQTT broker.
+//
+// It includes a minimal MQTT 3.1.1 client supporting what the bridge needs:
+// QoS 0 and 1 publishing and subscriptions, retained messages, and wills.
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by operations on a closed Client.
+var ErrClosed = errors.New("closed")
+
+// ErrTimeout is returned when the broker doesn't acknowledge a request in
+// time.
+var ErrTimeout = errors.New("timed out")
+
+// How long to wait for the broker to acknowledge a request.
+const ackTimeout = 10 * time.Second
+
+// CONNACK return codes.
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// A Message is an application message.
+type Message struct {
+	Topic   string
+	Payload []byte
+	// QoS is 0 (at most once) or 1 (at least once).
+	QoS    byte
+	Retain bool
+}
+
+// A Config holds configuration for a Client connection.
+type Config struct {
+	// Addr is the broker's address, e.g. "localhost:1883".
+	Addr string
+
+	// TLS, if not nil, is used to connect over TLS.
+	TLS *tls.Config
+
+	// ClientID identifies the client to the broker. Empty asks the broker to
+	// assign one.
+	ClientID string
+
+	Username string
+	Password string
+
+	// KeepAlive is the interval between pings. Zero means one minute.
+	KeepAlive time.Duration
+
+	// Will, if not nil, is published by the broker if the connection is lost
+	// without the Client being closed.
+	Will *Message
+}
+
+// A subscription's topic filter and handler.
+type subscription struct {
+	filter  string
+	handler func(Message)
+}
+
+// A Client is a connection to an MQTT broker. Sessions are clean: the broker
+// forgets subscriptions when the connection ends.
+type Client struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	keepAlive time.Duration
+
+	writeMu sync.Mutex
+
+	mu            sync.Mutex
+	nextID        uint16
+	pending       map[uint16]chan *packet
+	subscriptions []subscription
+	err           error
+	done          chan struct{}
+}
+
+// Dial connects to a broker using the Config.
+func (c Config) Dial() (*Client, error) {
+	keepAlive := c.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = time.Minute
+	}
+	dialer := &net.Dialer{Timeout: ackTimeout}
+	var conn net.Conn
+	var err error
+	if c.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.Addr, c.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", c.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Variable header: protocol name and level, flags, keep alive.
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, 0x02) // level 4 (3.1.1), clean session
+	body = appendUint16(body, uint16(keepAlive/time.Second))
+	body = appendString(body, c.ClientID)
+	if w := c.Will; w != nil {
+		body[7] |= 0x04 | w.QoS<<3
+		if w.Retain {
+			body[7] |= 0x20
+		}
+		body = appendString(body, w.Topic)
+		body = appendString(body, string(w.Payload))
+	}
+	if c.Username != "" {
+		body[7] |= 0x80
+		body = appendString(body, c.Username)
+	}
+	if c.Password != "" {
+		body[7] |= 0x40
+		body = appendString(body, c.Password)
+	}
+	conn.SetDeadline(time.Now().Add(ackTimeout))
+	if _, err := conn.Write((&packet{typ: typeConnect, body: body}).encode()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	p, err := readPacket(r)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("read CONNACK: %v", err)
+	}
+	if p.typ != typeConnack || len(p.body) != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("expected CONNACK, got packet type %d", p.typ)
+	}
+	if code := p.body[1]; code != 0 {
+		conn.Close()
+		if msg, ok := connackErrors[code]; ok {
+			return nil, fmt.Errorf("connection refused: %s", msg)
+		}
+		return nil, fmt.Errorf("connection refused: code %d", code)
+	}
+	conn.SetDeadline(time.Time{})
+
+	client := &Client{
+		conn:      conn,
+		r:         r,
+		keepAlive: keepAlive,
+		pending:   make(map[uint16]chan *packet),
+		done:      make(chan struct{}),
+	}
+	go client.readLoop()
+	go client.pingLoop()
+	return client, nil
+}
+
+// Publish publishes a message. For QoS 1 it waits for the broker's
+// acknowledgement.
+func (c *Client) Publish(m Message) error {
+	switch m.QoS {
+	case 0:
+		return c.write(publishPacket(m, 0))
+	case 1:
+		id, ack := c.expect()
+		return c.request(publishPacket(m, id), id, ack)
+	}
+	return fmt.Errorf("unsupported QoS %d", m.QoS)
+}
+
+// Subscribe subscribes to a topic filter, which may contain + and #
+// wildcards, with a maximum QoS of 0 or 1. The handler is called with each
+// matching message from the Client's read loop, so it must not block.
+func (c *Client) Subscribe(filter string, qos byte, handler func(Message)) error {
+	if qos > 1 {
+		return fmt.Errorf("unsupported QoS %d", qos)
+	}
+	c.mu.Lock()
+	c.subscriptions = append(c.subscriptions, subscription{filter, handler})
+	c.mu.Unlock()
+
+	id, ack := c.expect()
+	body := appendUint16(nil, id)
+	body = appendString(body, filter)
+	body = append(body, qos)
+	return c.request(&packet{typ: typeSubscribe, flags: 0x02, body: body}, id, ack)
+}
+
+// Allocate a packet ID and a channel for its acknowledgement.
+func (c *Client) expect() (uint16, chan *packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		c.nextID++
+		if _, ok := c.pending[c.nextID]; c.nextID != 0 && !ok {
+			break
+		}
+	}
+	ack := make(chan *packet, 1)
+	c.pending[c.nextID] = ack
+	return c.nextID, ack
+}
+
+// Write a packet and wait for its acknowledgement.
+func (c *Client) request(p *packet, id uint16, ack chan *packet) error {
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+	if err := c.write(p); err != nil {
+		return err
+	}
+	timer := time.NewTimer(ackTimeout)
+	defer timer.Stop()
+	select {
+	case reply := <-ack:
+		if reply.typ == typeSuback && len(reply.body) >= 3 && reply.body[2] == 0x80 {
+			return errors.New("subscription refused")
+		}
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-timer.C:
+		return ErrTimeout
+	}
+}
+
+func (c *Client) write(p *packet) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	select {
+	case <-c.done:
+		return c.Err()
+	default:
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(ackTimeout))
+	if _, err := c.conn.Write(p.encode()); err != nil {
+		c.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) readLoop() {
+	for {
+		// The broker answers pings, so silence for longer than the keep
+		// alive interval means the connection is dead.
+		c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		p, err := readPacket(c.r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch p.typ {
+		case typePublish:
+			m, id, err := parsePublish(p)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			if m.QoS == 1 {
+				c.write(&packet{typ: typePuback, body: appendUint16(nil, id)})
+			}
+			c.dispatch(m)
+		case typePuback, typeSuback:
+			if len(p.body) < 2 {
+				c.fail(errMalformed)
+				return
+			}
+			c.mu.Lock()
+			if ack, ok := c.pending[binary.BigEndian.Uint16(p.body)]; ok {
+				select {
+				case ack <- p:
+				default:
+					// Duplicate acknowledgement.
+				}
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *Client) dispatch(m Message) {
+	c.mu.Lock()
+	var handlers []func(Message)
+	for _, sub := range c.subscriptions {
+		if match(sub.filter, m.Topic) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	c.mu.Unlock()
+	for _, handler := range handlers {
+		handler(m)
+	}
+}
+
+func (c *Client) pingLoop() {
+	ticker := time.NewTicker(c.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.write(&packet{typ: typePingreq})
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Stop the Client with the given error, if it isn't already stopped.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.conn.Close()
+}
+
+// Done returns a channel that's closed when the Client stops, either because
+// it was closed or the connection failed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that stopped the Client, or nil if it's running.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close disconnects from the broker. The will message isn't published.
+func (c *Client) Close() error {
+	c.write(&packet{typ: typeDisconnect})
+	c.fail(ErrClosed)
+	return nil
+}
+
+// Report whether a topic matches a topic filter.
+func match(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && !strings.HasPrefix(filter, "$") {
+		// Wildcards don't match system topics.
+		return false
+	}
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
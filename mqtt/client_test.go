@@ -0,0 +1,149 @@
+package mqtt
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// A fake broker that accepts one connection, acknowledges everything, and
+// records published messages.
+type fakeBroker struct {
+	l         net.Listener
+	connect   chan *packet
+	published chan Message
+	conn      chan net.Conn
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{
+		l:         l,
+		connect:   make(chan *packet, 1),
+		published: make(chan Message, 100),
+		conn:      make(chan net.Conn, 1),
+	}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) serve() {
+	conn, err := b.l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	b.conn <- conn
+	r := bufio.NewReader(conn)
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		var reply *packet
+		switch p.typ {
+		case typeConnect:
+			b.connect <- p
+			reply = &packet{typ: typeConnack, body: []byte{0, 0}}
+		case typeSubscribe:
+			reply = &packet{typ: typeSuback, body: append(p.body[:2:2], 1)}
+		case typePublish:
+			m, id, _ := parsePublish(p)
+			b.published <- m
+			if m.QoS == 1 {
+				reply = &packet{typ: typePuback, body: appendUint16(nil, id)}
+			}
+		case typePingreq:
+			reply = &packet{typ: typePingresp}
+		}
+		if reply != nil {
+			conn.Write(reply.encode())
+		}
+	}
+}
+
+func (b *fakeBroker) receive(t *testing.T) Message {
+	select {
+	case m := <-b.published:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for publish")
+	}
+	return Message{}
+}
+
+func TestClient(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.l.Close()
+
+	c, err := Config{
+		Addr:     b.l.Addr().String(),
+		ClientID: "test",
+		Username: "user",
+		Password: "pass",
+		Will:     &Message{Topic: "will", Payload: []byte("gone"), Retain: true},
+	}.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if p := <-b.connect; p.body[7] != 0xe6 {
+		t.Errorf("CONNECT flags %#x, want 0xe6", p.body[7])
+	}
+
+	if err := c.Publish(Message{Topic: "a/b", Payload: []byte("x"), QoS: 1, Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+	if m := b.receive(t); m.Topic != "a/b" || string(m.Payload) != "x" || m.QoS != 1 || !m.Retain {
+		t.Errorf("published %+v", m)
+	}
+
+	received := make(chan Message, 1)
+	if err := c.Subscribe("cmd/+", 1, func(m Message) { received <- m }); err != nil {
+		t.Fatal(err)
+	}
+	conn := <-b.conn
+	conn.Write(publishPacket(Message{Topic: "other", Payload: []byte("no")}, 0).encode())
+	conn.Write(publishPacket(Message{Topic: "cmd/x", Payload: []byte("yes"), QoS: 1}, 9).encode())
+	select {
+	case m := <-received:
+		if m.Topic != "cmd/x" || string(m.Payload) != "yes" {
+			t.Errorf("received %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "$SYS/x", false},
+		{"+/b", "a/b", true},
+	} {
+		if got := match(tc.filter, tc.topic); got != tc.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tc.filter, tc.topic, got, tc.want)
+		}
+	}
+}
+
+func TestPacketEncoding(t *testing.T) {
+	p := &packet{typ: typePublish, flags: 3, body: make([]byte, 200)}
+	data := p.encode()
+	if data[1] != 0xc8 || data[2] != 0x01 {
+		t.Errorf("remaining length encoded as %x", data[1:3])
+	}
+}
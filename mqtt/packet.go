@@ -0,0 +1,134 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Packet types.
+const (
+	typeConnect    = 1
+	typeConnack    = 2
+	typePublish    = 3
+	typePuback     = 4
+	typeSubscribe  = 8
+	typeSuback     = 9
+	typePingreq    = 12
+	typePingresp   = 13
+	typeDisconnect = 14
+)
+
+// Maximum size of a received packet.
+const maxPacketSize = 1 << 20
+
+var errMalformed = errors.New("malformed packet")
+
+// A packet is a control packet's type, flags, and variable header and payload.
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+func readPacket(r *bufio.Reader) (*packet, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var size, shift uint
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size |= uint(c&0x7f) << shift
+		if c&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return nil, errMalformed
+		}
+	}
+	if size > maxPacketSize {
+		return nil, errors.New("packet too large")
+	}
+	p := &packet{typ: b >> 4, flags: b & 0x0f, body: make([]byte, size)}
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *packet) encode() []byte {
+	buf := []byte{p.typ<<4 | p.flags}
+	size := len(p.body)
+	for {
+		c := byte(size & 0x7f)
+		if size >>= 7; size > 0 {
+			c |= 0x80
+		}
+		buf = append(buf, c)
+		if size == 0 {
+			break
+		}
+	}
+	return append(buf, p.body...)
+}
+
+// Append a length-prefixed string.
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, byte(len(s)>>8), byte(len(s)))
+	return append(buf, s...)
+}
+
+// Read a length-prefixed string, returning the rest of data.
+func readString(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, errMalformed
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return "", nil, errMalformed
+	}
+	return string(data[2 : 2+n]), data[2+n:], nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+// Encode a PUBLISH packet. id is ignored for QoS 0.
+func publishPacket(m Message, id uint16) *packet {
+	p := &packet{typ: typePublish, flags: m.QoS << 1}
+	if m.Retain {
+		p.flags |= 1
+	}
+	p.body = appendString(nil, m.Topic)
+	if m.QoS > 0 {
+		p.body = appendUint16(p.body, id)
+	}
+	p.body = append(p.body, m.Payload...)
+	return p
+}
+
+// Decode a PUBLISH packet.
+func parsePublish(p *packet) (m Message, id uint16, err error) {
+	m.QoS = (p.flags >> 1) & 3
+	m.Retain = p.flags&1 != 0
+	var rest []byte
+	m.Topic, rest, err = readString(p.body)
+	if err != nil {
+		return
+	}
+	if m.QoS > 0 {
+		if len(rest) < 2 {
+			err = errMalformed
+			return
+		}
+		id, rest = binary.BigEndian.Uint16(rest), rest[2:]
+	}
+	m.Payload = rest
+	return
+}
@@ -0,0 +1,150 @@
+package net
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Command number of status broadcasts.
+const cmdBroadcast = 0x13
+
+// EncodeStatus encodes a Status broadcast packet as a device of its Version
+// sends it: plaintext before 3.3, encrypted with BroadcastKey for 3.3 and
+// 3.4, and in a 6699 frame from 3.5.
+func EncodeStatus(s *Status) ([]byte, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("Marshal: %w", err)
+	}
+	if s.Version >= "3.5" {
+		return encodeGCMStatus(data)
+	}
+	if s.Version >= Version33 {
+		c, err := NewCipher(BroadcastKey[:])
+		if err != nil {
+			return nil, fmt.Errorf("NewCipher: %w", err)
+		}
+		data = c.Seal(data)
+	}
+	var buf bytes.Buffer
+	f := &Frame{Cmd: cmdBroadcast, Payload: append(make([]byte, 4), data...)}
+	if err := f.Encode(&buf); err != nil {
+		return nil, fmt.Errorf("frame Encode: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode a 6699 frame with a return code and data.
+func encodeGCMStatus(data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(BroadcastKey[:])
+	if err != nil {
+		return nil, fmt.Errorf("NewCipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("NewGCM: %w", err)
+	}
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	plaintext := append(make([]byte, 4), data...)
+	length := gcmNonceSize + len(plaintext) + gcm.Overhead() + 4
+
+	packet := make([]byte, gcmHeaderSize, gcmHeaderSize+length)
+	binary.BigEndian.PutUint32(packet, gcmPrefixValue)
+	binary.BigEndian.PutUint32(packet[10:], cmdBroadcast)
+	binary.BigEndian.PutUint32(packet[14:], uint32(length))
+	packet = append(packet, nonce...)
+	packet = gcm.Seal(packet, nonce, plaintext, packet[4:gcmHeaderSize])
+	var suffix [4]byte
+	binary.BigEndian.PutUint32(suffix[:], gcmSuffixValue)
+	return append(packet, suffix[:]...), nil
+}
+
+// Return the port a device of the given version broadcasts to.
+func statusPort(version string) int {
+	switch {
+	case version >= "3.5":
+		return AppStatusPort
+	case version >= Version33:
+		return EncryptedStatusPort
+	}
+	return StatusPort
+}
+
+// An Announcer periodically broadcasts a Status.
+type Announcer struct {
+	conn     net.Conn
+	packet   []byte
+	interval time.Duration
+
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// Announce broadcasts status on the local network every interval, on the
+// port listeners expect for its Version, until the Announcer is closed. If
+// status.IP is empty, the address broadcasts are sent from is used. The first
+// broadcast is sent before Announce returns, and its error returned; later
+// errors are ignored.
+func Announce(status *Status, interval time.Duration) (*Announcer, error) {
+	return announce(fmt.Sprintf("255.255.255.255:%d", statusPort(status.Version)), status, interval)
+}
+
+func announce(addr string, status *Status, interval time.Duration) (*Announcer, error) {
+	conn, err := net.Dial("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Dial: %w", err)
+	}
+	s := *status
+	if s.IP == "" {
+		s.IP = conn.LocalAddr().(*net.UDPAddr).IP.String()
+	}
+	packet, err := EncodeStatus(&s)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := conn.Write(packet); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Write: %w", err)
+	}
+	a := &Announcer{conn: conn, packet: packet, interval: interval, stop: make(chan struct{})}
+	go a.run()
+	return a, nil
+}
+
+func (a *Announcer) run() {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.conn.Write(a.packet)
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Close stops broadcasting.
+func (a *Announcer) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-a.stop:
+		return nil
+	default:
+	}
+	close(a.stop)
+	return a.conn.Close()
+}
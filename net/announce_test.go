@@ -0,0 +1,68 @@
+package net
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEncodeStatus(t *testing.T) {
+	broadcast, err := NewCipher(BroadcastKey[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		version string
+		cipher  *Cipher
+		port    int
+	}{
+		{"3.1", nil, StatusPort},
+		{"3.3", broadcast, EncryptedStatusPort},
+		{"3.4", broadcast, EncryptedStatusPort},
+		{"3.5", nil, AppStatusPort},
+	} {
+		status := &Status{IP: "10.0.0.2", GatewayID: "abc", Version: test.version}
+		packet, err := EncodeStatus(status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := &statusListener{cipher: test.cipher}
+		got, err := l.decode(packet)
+		if err != nil {
+			t.Errorf("%s: %v", test.version, err)
+		} else if *got != *status {
+			t.Errorf("%s: decoded %+v", test.version, got)
+		}
+		if port := statusPort(test.version); port != test.port {
+			t.Errorf("%s: port %d, want %d", test.version, port, test.port)
+		}
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	a, err := announce(conn.LocalAddr().String(), &Status{GatewayID: "abc", Version: "3.1"}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	l := &statusListener{conn: conn, buf: make([]byte, maxPacketSize)}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := 0; i < 2; i++ {
+		s, err := l.ReadStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.GatewayID != "abc" || s.IP != "127.0.0.1" {
+			t.Errorf("status %+v", s)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,18 +1,52 @@
 package net
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"time"
 )
 
 // ErrNoKey is returned when a cryptographic operation is required but no key
 // was specified in ClientConfig.
 var ErrNoKey = errors.New("no Key in ClientConfig")
 
+// ErrUnsupportedVersion is returned by Dial for protocol versions this package
+// can't speak, wrapped with the version; check for it with errors.Is.
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+// ErrInsecure is returned when ClientConfig.Strict refuses a connection or
+// message that wouldn't be encrypted, wrapped with the reason; check for it
+// with errors.Is.
+var ErrInsecure = errors.New("refused by strict mode")
+
+// Protocol versions supported by Client.
+const (
+	Version31 = "3.1"
+	Version33 = "3.3"
+)
+
+// Protocol 3.3 prefixes encrypted payloads with the version and 12 unused
+// bytes, except for these commands.
+var v33NoHeader = map[uint32]bool{
+	0x09: true, // heartbeat
+	0x0a: true, // query
+	0x10: true, // query (newer)
+	0x12: true, // refresh
+}
+
+const v33HeaderSize = 15
+
+// Sequence numbers from SeqReserved up are reserved for a Client's internal
+// messages, like heartbeats, numbered by NextReservedSeq; NextSeq wraps from
+// SeqReserved-1 back to 1. Sequence number 0 is never sent: devices use it
+// for pushes.
+const SeqReserved uint32 = 0xffff0000
+
 // A ClientConfig holds configuration for a Client connection. It may be reused
 // for multiple connections.
 type ClientConfig struct {
@@ -23,106 +57,485 @@ type ClientConfig struct {
 	// Note that while keys may appear to be hex encoded, they are actually raw
 	// bytes that happen to only use hex characters.
 	Key string
+
+	// Version is the device's protocol version, as reported in its Status
+	// broadcast: Version31 or Version33. Empty means Version31.
+	Version string
+
+	// Hooks observe the connection's frames, for debugging or metrics.
+	Hooks Hooks
+
+	// Seq, if not nil, returns the sequence number of each message sent,
+	// instead of counting up from 1, for deterministic tests and replays.
+	// It's called with the Client's lock held.
+	Seq func() uint32
+
+	// Logger, if not nil, receives debug messages about the connection's
+	// frames and errors. The Key is redacted from messages.
+	Logger Logger
+
+	// Tracer, if not nil, traces Dial, and requests by Managers using the
+	// Client.
+	Tracer Tracer
+
+	// Metrics, if not nil, counts the connection's frames and errors.
+	Metrics Metrics
+
+	// RateLimiters limit writes: each write waits for all of them to allow
+	// it. Sharing RateLimiters between configs limits them together.
+	RateLimiters []*RateLimiter
+
+	// Codec marshals payloads, and unmarshals them for Managers. Nil means
+	// StdCodec.
+	Codec Codec
+
+	// CoalesceWrites, if positive, batches frames written within this long
+	// of the first into a single write to the connection, for devices, like
+	// gateways, that handle bursts of small packets poorly. Each write still
+	// waits until its frame is sent.
+	CoalesceWrites time.Duration
+
+	// MaxPayloadSize limits the size of frame payloads read; reading a
+	// larger frame fails without allocating for it, and the connection
+	// can't be used after. Zero means the package's MaxPayloadSize, the
+	// largest a frame can carry in one packet.
+	MaxPayloadSize int
+
+	// Strict refuses to operate insecurely instead of silently degrading:
+	// Dial and NewClient fail with ErrInsecure unless Key is set and
+	// Version is Version33, under which every message sent is encrypted,
+	// and reading a frame whose payload isn't encrypted fails too.
+	Strict bool
+}
+
+// Hooks are called by a Client with each frame it sends and receives. Either
+// may be nil. Frames carry payloads as sent over the wire; plaintext is the
+// payload before encryption or after decryption, and is the same as the frame
+// payload for unencrypted messages. Received is also called with frames that
+// fail to decrypt. Sent is called before the frame is written, so a request
+// is always seen before its reply. Hooks must not modify the frames or
+// payloads, or keep them after returning, as their buffers may be reused.
+type Hooks struct {
+	Sent     func(f *Frame, plaintext []byte)
+	Received func(f *Frame, plaintext []byte, err error)
 }
 
 // Dial connects to a device using the ClientConfig.
-func (cc ClientConfig) Dial() (*Client, error) {
-	var cipher *Cipher
-	if cc.Key != "" {
-		var err error
-		cipher, err = NewCipher([]byte(cc.Key))
-		if err != nil {
-			return nil, fmt.Errorf("NewCipher: %v", err)
-		}
+func (cc ClientConfig) Dial() (client *Client, err error) {
+	span := StartSpan(cc.Tracer, "tuya.Dial", Attr{AttrAddr, cc.Addr})
+	defer func() { span.End(err) }()
+	version, _, err := setup(cc.Version, cc.Key)
+	if err != nil {
+		return nil, err
 	}
-
+	if err := cc.checkStrict(version); err != nil {
+		return nil, err
+	}
+	span.SetAttrs(Attr{AttrVersion, version})
 	conn, err := net.Dial("tcp", cc.Addr)
 	if err != nil {
-		return nil, fmt.Errorf("Dial: %v", err)
+		return nil, fmt.Errorf("Dial: %w", err)
 	}
+	return cc.NewClient(conn)
+}
 
+// NewClient returns a Client using an existing connection, such as one end
+// of a net.Pipe; Addr is ignored.
+func (cc ClientConfig) NewClient(conn net.Conn) (*Client, error) {
+	version, cipher, err := setup(cc.Version, cc.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := cc.checkStrict(version); err != nil {
+		return nil, err
+	}
 	return &Client{
-		conn:   conn,
-		cipher: cipher,
+		conn:     conn,
+		cipher:   cipher,
+		version:  version,
+		hooks:    cc.Hooks,
+		nextSeq:  cc.Seq,
+		logger:   RedactLogger(cc.Logger, cc.Key),
+		tracer:   cc.Tracer,
+		metrics:  cc.Metrics,
+		limits:   cc.RateLimiters,
+		codec:    cc.Codec,
+		coalesce: cc.CoalesceWrites,
+		strict:   cc.Strict,
+		maxRead:  cc.MaxPayloadSize,
 	}, nil
 }
 
+// Return a configured payload size limit, or the default for zero.
+func payloadLimit(limit int) int {
+	if limit <= 0 {
+		return MaxPayloadSize
+	}
+	return limit
+}
+
+// Check that a connection with the given version meets cc.Strict.
+func (cc ClientConfig) checkStrict(version string) error {
+	if !cc.Strict {
+		return nil
+	}
+	if cc.Key == "" {
+		return fmt.Errorf("%w: no Key", ErrInsecure)
+	}
+	if version != Version33 {
+		return fmt.Errorf("%w: protocol version %s; %s is required", ErrInsecure, version, Version33)
+	}
+	return nil
+}
+
+// Check a protocol version, defaulting to Version31, and make a Cipher for
+// key, if given.
+func setup(version, key string) (string, *Cipher, error) {
+	switch version {
+	case "":
+		version = Version31
+	case Version31, Version33:
+	default:
+		return "", nil, fmt.Errorf("%w: %q", ErrUnsupportedVersion, version)
+	}
+	if key == "" {
+		return version, nil, nil
+	}
+	// Keys are strings in configs and can't be wiped, but at least the
+	// copy made here needn't outlive the Cipher's own.
+	keyBytes := []byte(key)
+	cipher, err := NewCipher(keyBytes)
+	wipe(keyBytes)
+	if err != nil {
+		return "", nil, fmt.Errorf("NewCipher: %w", err)
+	}
+	return version, cipher, nil
+}
+
 // A Client is a Tuya device client. Its lifetime is tied to an underlying TCP
 // connection; once that connection is closed the Client may no longer be used.
 type Client struct {
-	conn   net.Conn
-	cipher *Cipher
+	conn    net.Conn
+	cipher  *Cipher
+	version string
+	hooks   Hooks
+	logger  Logger
+	tracer  Tracer
+	metrics Metrics
+	limits  []*RateLimiter
+	codec   Codec
+	strict  bool
+	maxRead int
+
+	// Frames waiting to be written together, if coalescing.
+	coalesce time.Duration
+	pending  *writeBatch
 
 	// Incremented for each message; reply messages match a request seq number.
-	seq uint32
+	seq      uint32
+	reserved uint32
+	nextSeq  func() uint32
 
 	// Protects `conn` and `seq` from multiple writers.
 	sync.Mutex
 }
 
-// Close closes the Client connection.
+// Logger returns the Client's Logger from its ClientConfig, with the key
+// redacted, or nil if it has none.
+func (c *Client) Logger() Logger {
+	return c.logger
+}
+
+// Codec returns the Client's Codec from its ClientConfig, or StdCodec if it
+// has none.
+func (c *Client) Codec() Codec {
+	if c.codec == nil {
+		return StdCodec
+	}
+	return c.codec
+}
+
+// Tracer returns the Client's Tracer from its ClientConfig, or nil if it has
+// none.
+func (c *Client) Tracer() Tracer {
+	return c.tracer
+}
+
+func (c *Client) logf(level Level, format string, args ...interface{}) {
+	if c.logger != nil {
+		c.logger.Logf(level, format, args...)
+	}
+}
+
+// Close closes the Client connection and wipes its copy of the key.
 func (c *Client) Close() error {
-	return c.conn.Close()
+	err := c.conn.Close()
+	if c.cipher != nil {
+		c.cipher.Close()
+	}
+	return err
 }
 
 // Write sends a message to the connected device. The message is constructed
 // from the `cmd` number and `payload`, which may be a JSON-serializable
 // object or a []byte containing a raw message. If `encrypt` is true, the
-// message will be encrypted. Write may be called from multiple goroutines.
+// message will be encrypted; protocol 3.3 devices expect every message to be
+// encrypted, so it is implied for them when the Client has a key. Write may be
+// called from multiple goroutines.
 func (c *Client) Write(cmd uint32, encrypt bool, payload interface{}) (seq uint32, err error) {
+	seq = c.NextSeq()
+	if err := c.WriteSeq(seq, cmd, encrypt, payload); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// NextSeq reserves a sequence number for a message sent with WriteSeq, so a
+// reply can be expected before the message is sent. Numbers count up from 1,
+// wrapping before SeqReserved, unless ClientConfig.Seq is set.
+func (c *Client) NextSeq() uint32 {
+	c.Lock()
+	defer c.Unlock()
+	if c.nextSeq != nil {
+		c.seq = c.nextSeq()
+	} else {
+		c.seq += 1
+		if c.seq == 0 || c.seq >= SeqReserved {
+			c.seq = 1
+		}
+	}
+	return c.seq
+}
+
+// NextReservedSeq is like NextSeq, but returns a number from SeqReserved up,
+// for internal messages whose replies shouldn't be confused with replies to
+// requests.
+func (c *Client) NextReservedSeq() uint32 {
+	c.Lock()
+	defer c.Unlock()
+	if c.reserved < SeqReserved || c.reserved == ^uint32(0) {
+		c.reserved = SeqReserved
+	} else {
+		c.reserved += 1
+	}
+	return c.reserved
+}
+
+// WriteSeq is like Write, but sends the message with a sequence number
+// from NextSeq.
+func (c *Client) WriteSeq(seq, cmd uint32, encrypt bool, payload interface{}) error {
+	return c.WriteSeqContext(context.Background(), seq, cmd, encrypt, payload)
+}
+
+// WriteSeqContext is like WriteSeq, but stops waiting for rate limiters when
+// ctx ends, returning its error without writing.
+func (c *Client) WriteSeqContext(ctx context.Context, seq, cmd uint32, encrypt bool, payload interface{}) error {
+	if c.version == Version33 && c.cipher != nil {
+		encrypt = true
+	}
 	if encrypt && c.cipher == nil {
-		return 0, ErrNoKey
+		return ErrNoKey
 	}
 
+	b := writeBuffers.Get().(*writeBuffer)
+	defer writeBuffers.Put(b)
+
 	// Marshal JSON (if necessary)
+	var err error
 	data, isBytes := payload.([]byte)
 	if !isBytes {
-		var err error
-		data, err = json.Marshal(payload)
+		data, err = appendMarshal(c.Codec(), b.plaintext[:0], payload)
 		if err != nil {
-			return 0, fmt.Errorf("payload Marshal: %v", err)
+			return fmt.Errorf("payload Marshal: %w", err)
+		}
+		b.plaintext = data
+	}
+
+	plaintext := data
+
+	for _, l := range c.limits {
+		if err := l.Wait(ctx); err != nil {
+			return err
 		}
 	}
 
 	// Encrypt payload (if requested)
-	if encrypt {
-		data = c.cipher.Encrypt(data)
+	if encrypt && c.version == Version33 {
+		data = b.payload[:0]
+		if !v33NoHeader[cmd] {
+			data = append(data, Version33...)
+			data = append(data, make([]byte, v33HeaderSize-len(Version33))...)
+		}
+		data, err = c.cipher.sealAppend(data, plaintext)
+		b.payload = data
+	} else if encrypt {
+		data, err = c.cipher.encryptAppend(b.payload[:0], plaintext)
+		b.payload = data
 	}
+	if err != nil {
+		return fmt.Errorf("payload Encrypt: %w", err)
+	}
+
+	b.frame = Frame{Seq: seq, Cmd: cmd, Payload: data}
+	wire, err := b.frame.AppendEncode(b.wire[:0])
+	if err != nil {
+		return fmt.Errorf("frame Encode: %w", err)
+	}
+	b.wire = wire
 
 	// Write frame
 	c.Lock()
-	defer c.Unlock()
-	c.seq += 1
-	frame := &Frame{
-		Seq:     c.seq,
-		Cmd:     cmd,
-		Payload: data,
+	if c.hooks.Sent != nil {
+		c.hooks.Sent(&b.frame, plaintext)
+	}
+	if c.coalesce > 0 {
+		err = c.writeCoalesced(wire)
+	} else {
+		_, err = c.conn.Write(wire)
+		c.Unlock()
+	}
+	if err != nil {
+		c.logf(LevelError, "write seq %d cmd %#x: %v", seq, cmd, err)
+		return fmt.Errorf("frame Write: %w", err)
+	}
+	if c.logger != nil {
+		// Checked here to avoid boxing the arguments.
+		c.logf(LevelDebug, "sent seq %d cmd %#x, %d bytes", seq, cmd, len(data))
+	}
+	if c.metrics != nil {
+		c.metrics.FrameSent(cmd, frameSize(len(data)))
+	}
+	return nil
+}
+
+// Frames written together by a coalescing Client.
+type writeBatch struct {
+	buf  []byte
+	err  error
+	done chan struct{} // closed once buf is written
+}
+
+// Add a frame to the pending batch, starting one if needed, and wait for it
+// to be written. Called with c locked; returns with it unlocked.
+func (c *Client) writeCoalesced(wire []byte) error {
+	batch := c.pending
+	if batch == nil {
+		batch = &writeBatch{done: make(chan struct{})}
+		c.pending = batch
+		time.AfterFunc(c.coalesce, func() {
+			c.Lock()
+			defer c.Unlock()
+			c.flush(batch)
+		})
 	}
-	if err := frame.Encode(c.conn); err != nil {
-		return 0, fmt.Errorf("frame Encode: %v", err)
+	batch.buf = append(batch.buf, wire...)
+	if len(batch.buf) >= maxPacketSize {
+		c.flush(batch)
 	}
-	return c.seq, nil
+	c.Unlock()
+	<-batch.done
+	return batch.err
 }
 
+// Write a batch, unless it already has been. Called with c locked.
+func (c *Client) flush(batch *writeBatch) {
+	if c.pending != batch {
+		return
+	}
+	c.pending = nil
+	_, batch.err = c.conn.Write(batch.buf)
+	close(batch.done)
+}
+
+// Buffers for building messages in WriteSeq, pooled so that writes don't
+// allocate once warmed up.
+type writeBuffer struct {
+	plaintext, payload, wire []byte
+	frame                    Frame
+}
+
+var writeBuffers = sync.Pool{New: func() interface{} { return &writeBuffer{} }}
+
 // Read reads a Response from the connected device; it will block until it reads
 // a full message or encounters invalid message data. It is *not* safe to call
 // from multiple goroutines.
 func (c *Client) Read() (*Response, error) {
-	f, err := DecodeFrame(c.conn)
-	if err != nil {
-		return nil, fmt.Errorf("DecodeFrame: %v", err)
+	return c.ReadFrame(&Frame{})
+}
+
+// ReadFrame is like Read, but decodes the frame into f, reusing its Payload
+// buffer if it's big enough, to save allocations in read loops. The
+// Response's Payload may share the buffer, so it's only valid until f is
+// reused.
+func (c *Client) ReadFrame(f *Frame) (*Response, error) {
+	if err := f.DecodeLimit(c.conn, payloadLimit(c.maxRead)); err != nil {
+		return nil, fmt.Errorf("DecodeFrame: %w", err)
 	}
 
 	// Decrypt, if needed.
-	if detectEncryption(f.Payload) {
-		plaintext, err := c.cipher.Decrypt(f.Payload)
-		if err != nil {
-			return nil, fmt.Errorf("Decrypt: %v", err)
+	raw := f.Payload
+	payload := raw
+	var err error
+	if c.version == Version33 {
+		payload, err = c.open33(raw)
+	} else if detectEncryption(raw) {
+		if c.cipher == nil {
+			err = ErrNoKey
+		} else {
+			payload, err = c.cipher.Decrypt(raw)
+		}
+	}
+	if c.hooks.Received != nil {
+		c.hooks.Received(f, payload, err)
+	}
+	if c.metrics != nil {
+		c.metrics.FrameReceived(f.Cmd, frameSize(len(raw)))
+	}
+	if err != nil {
+		c.logf(LevelWarn, "decrypt seq %d cmd %#x: %v", f.Seq, f.Cmd, err)
+		if c.metrics != nil {
+			c.metrics.DecodeError(f.Cmd, err)
 		}
-		f.Payload = plaintext
 	}
+	if err == ErrNoKey {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt: %w", err)
+	}
+	c.logf(LevelDebug, "received seq %d cmd %#x, %d bytes", f.Seq, f.Cmd, len(raw))
+
+	return &Response{&Frame{Seq: f.Seq, Cmd: f.Cmd, Payload: payload}}, nil
+}
 
-	return &Response{f}, nil
+// Decrypt a protocol 3.3 payload, keeping any leading return code. Payloads
+// that can't be ciphertext, like empty acknowledgements, are returned as is.
+func (c *Client) open33(payload []byte) ([]byte, error) {
+	var code []byte
+	data := payload
+	if len(data) >= 4 && data[0] == 0 {
+		code, data = data[:4:4], data[4:]
+	}
+	if bytes.HasPrefix(data, []byte(Version33)) && len(data) >= v33HeaderSize {
+		data = data[v33HeaderSize:]
+	}
+	if len(data) == 0 {
+		return payload, nil
+	}
+	if len(data)%16 != 0 {
+		if c.strict {
+			return nil, fmt.Errorf("%w: unencrypted payload", ErrInsecure)
+		}
+		return payload, nil
+	}
+	if c.cipher == nil {
+		return nil, ErrNoKey
+	}
+	plaintext, err := c.cipher.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(code, plaintext...), nil
 }
 
 // A Response represents a partially-decoded message from a device. Consumers
@@ -158,12 +571,17 @@ func (r *Response) Bytes() ([]byte, error) {
 
 // DecodeJSON unmarshals the payload into an object with `json.Unmarshal`.
 func (r *Response) DecodeJSON(v interface{}) error {
+	return r.Decode(StdCodec, v)
+}
+
+// Decode is like DecodeJSON, but unmarshals with a Codec.
+func (r *Response) Decode(codec Codec, v interface{}) error {
 	data, err := r.Bytes()
 	if err != nil {
 		return err
 	}
-	if err := json.Unmarshal(data, v); err != nil {
-		return fmt.Errorf("Unmarshal: %v", err)
+	if err := codec.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("Unmarshal: %w", err)
 	}
 	return nil
 }
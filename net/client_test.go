@@ -1,7 +1,13 @@
 package net
 
 import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var (
@@ -32,3 +38,428 @@ func TestResponseError(t *testing.T) {
 		t.Errorf("ResponseError.Message '%s' != 'error msg'", resErr.Message)
 	}
 }
+
+func TestClientV33(t *testing.T) {
+	cipher, err := NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn, deviceConn := net.Pipe()
+	defer clientConn.Close()
+	defer deviceConn.Close()
+	c := &Client{conn: clientConn, cipher: cipher, version: Version33}
+
+	// Control requests are encrypted with a version header, even if
+	// encryption wasn't requested.
+	go c.Write(0x07, false, []byte(testJSON))
+	f, err := DecodeFrame(deviceConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(f.Payload, []byte("3.3\x00")) {
+		t.Fatalf("missing version header: %q", f.Payload)
+	}
+	plaintext, err := cipher.Open(f.Payload[v33HeaderSize:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != testJSON {
+		t.Errorf("got %q want %q", plaintext, testJSON)
+	}
+
+	// Responses are decrypted, keeping the return code.
+	reply := &Frame{Seq: f.Seq, Cmd: 0x0a,
+		Payload: append([]byte{0, 0, 0, 0}, cipher.Seal([]byte(testJSON))...)}
+	go reply.Encode(deviceConn)
+	res, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res.Payload, testPayload) {
+		t.Errorf("got %q want %q", res.Payload, testPayload)
+	}
+}
+
+func TestClientStrict(t *testing.T) {
+	for _, cc := range []ClientConfig{
+		{Key: string(testKey)},
+		{Key: string(testKey), Version: Version31},
+		{Version: Version33},
+	} {
+		cc.Strict = true
+		if _, err := cc.NewClient(nil); err == nil || !errors.Is(err, ErrInsecure) {
+			t.Errorf("NewClient(%+v) = %v, want ErrInsecure", cc, err)
+		}
+	}
+
+	clientConn, deviceConn := net.Pipe()
+	defer clientConn.Close()
+	defer deviceConn.Close()
+	c, err := ClientConfig{Key: string(testKey), Version: Version33, Strict: true}.NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := &Frame{Cmd: 0x08, Payload: []byte(testJSON)}
+	go push.Encode(deviceConn)
+	if _, err := c.Read(); err == nil || !errors.Is(err, ErrInsecure) {
+		t.Errorf("Read of unencrypted push = %v, want ErrInsecure", err)
+	}
+}
+
+func TestClientMaxPayloadSize(t *testing.T) {
+	clientConn, deviceConn := net.Pipe()
+	defer clientConn.Close()
+	defer deviceConn.Close()
+	c, err := ClientConfig{MaxPayloadSize: 16}.NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go (&Frame{Cmd: 0x08, Payload: []byte("small")}).Encode(deviceConn)
+	if _, err := c.Read(); err != nil {
+		t.Fatal(err)
+	}
+	go (&Frame{Cmd: 0x08, Payload: make([]byte, 17)}).Encode(deviceConn)
+	if _, err := c.Read(); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Read of large frame = %v", err)
+	}
+}
+
+func TestClientHooks(t *testing.T) {
+	cipher, err := NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn, deviceConn := net.Pipe()
+	defer clientConn.Close()
+	defer deviceConn.Close()
+	var sent, received [][]byte
+	c := &Client{conn: clientConn, cipher: cipher, version: Version31, hooks: Hooks{
+		Sent: func(f *Frame, plaintext []byte) {
+			sent = append(sent, append([]byte(nil), f.Payload...), append([]byte(nil), plaintext...))
+		},
+		Received: func(f *Frame, plaintext []byte, err error) {
+			received = append(received, f.Payload, plaintext)
+		},
+	}}
+
+	go func() {
+		f, err := DecodeFrame(deviceConn)
+		if err != nil {
+			return
+		}
+		f.Payload = cipher.Encrypt([]byte(testJSON))
+		f.Encode(deviceConn)
+	}()
+	if _, err := c.Write(0x07, true, []byte(testJSON)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sent) != 2 || !bytes.HasPrefix(sent[0], version) || string(sent[1]) != testJSON {
+		t.Errorf("bad Sent calls: %q", sent)
+	}
+	if len(received) != 2 || !bytes.HasPrefix(received[0], version) || string(received[1]) != testJSON {
+		t.Errorf("bad Received calls: %q", received)
+	}
+}
+
+func TestClientSeq(t *testing.T) {
+	clientConn, deviceConn := net.Pipe()
+	defer clientConn.Close()
+	defer deviceConn.Close()
+	seq := uint32(100)
+	c, err := ClientConfig{Seq: func() uint32 { seq += 10; return seq }}.NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []uint32{110, 120} {
+		go c.Write(0x0a, false, []byte(testJSON))
+		f, err := DecodeFrame(deviceConn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Seq != want {
+			t.Errorf("seq %d, want %d", f.Seq, want)
+		}
+	}
+}
+
+type countingConn struct {
+	net.Conn
+	mu     sync.Mutex
+	writes int
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.writes++
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func TestClientCoalesceWrites(t *testing.T) {
+	clientConn, deviceConn := net.Pipe()
+	defer clientConn.Close()
+	defer deviceConn.Close()
+	conn := &countingConn{Conn: clientConn}
+	c, err := ClientConfig{CoalesceWrites: 50 * time.Millisecond}.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 5
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := c.Write(0x0a, false, []byte(testJSON))
+			errs <- err
+		}()
+	}
+	seen := make(map[uint32]bool)
+	for i := 0; i < n; i++ {
+		f, err := DecodeFrame(deviceConn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[f.Seq] = true
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if len(seen) != n {
+		t.Errorf("got seqs %v", seen)
+	}
+	if conn.writes >= n {
+		t.Errorf("%d writes for %d frames", conn.writes, n)
+	}
+}
+
+func TestClientSeqWrap(t *testing.T) {
+	c, err := ClientConfig{}.NewClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq := c.NextSeq(); seq != 1 {
+		t.Errorf("first seq %d, want 1", seq)
+	}
+	for _, tc := range []struct{ last, want uint32 }{
+		{SeqReserved - 2, SeqReserved - 1},
+		{SeqReserved - 1, 1},
+		{^uint32(0), 1},
+	} {
+		c.seq = tc.last
+		if seq := c.NextSeq(); seq != tc.want {
+			t.Errorf("seq after %#x = %#x, want %#x", tc.last, seq, tc.want)
+		}
+	}
+
+	if seq := c.NextReservedSeq(); seq != SeqReserved {
+		t.Errorf("first reserved seq %#x, want %#x", seq, SeqReserved)
+	}
+	if seq := c.NextReservedSeq(); seq != SeqReserved+1 {
+		t.Errorf("second reserved seq %#x", seq)
+	}
+	c.reserved = ^uint32(0)
+	if seq := c.NextReservedSeq(); seq != SeqReserved {
+		t.Errorf("reserved seq after max = %#x, want %#x", seq, SeqReserved)
+	}
+}
+
+// Benchmark a request and reply between a Client and a Conn.
+func benchmarkRoundTrip(b *testing.B, version string) {
+	clientConn, deviceConn := net.Pipe()
+	defer clientConn.Close()
+	defer deviceConn.Close()
+	c, err := ClientConfig{Key: string(testKey), Version: version}.NewClient(clientConn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	d, err := ServerConfig{Key: string(testKey), Version: version}.NewConn(deviceConn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		for {
+			req, err := d.Read()
+			if err != nil {
+				return
+			}
+			d.Reply(req, 0, testPlaintext)
+		}
+	}()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Write(0x07, true, testPlaintext); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := c.Read(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type discardConn struct{ net.Conn }
+
+func (discardConn) Write(b []byte) (int, error) { return len(b), nil }
+
+func benchmarkWrite(b *testing.B, version string) {
+	c := &Client{conn: discardConn{}, cipher: mustCipher(b), version: version}
+	dps := map[string]interface{}{"1": true}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Write(0x07, true, dps); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWrite31(b *testing.B) { benchmarkWrite(b, Version31) }
+func BenchmarkWrite33(b *testing.B) { benchmarkWrite(b, Version33) }
+
+func BenchmarkRoundTrip31(b *testing.B) { benchmarkRoundTrip(b, Version31) }
+func BenchmarkRoundTrip33(b *testing.B) { benchmarkRoundTrip(b, Version33) }
+
+// A Metrics counting calls.
+type testMetrics struct {
+	sent, received, sentBytes, receivedBytes, decodeErrors int
+}
+
+func (m *testMetrics) FrameSent(cmd uint32, size int) {
+	m.sent++
+	m.sentBytes += size
+}
+
+func (m *testMetrics) FrameReceived(cmd uint32, size int) {
+	m.received++
+	m.receivedBytes += size
+}
+
+func (m *testMetrics) DecodeError(cmd uint32, err error) { m.decodeErrors++ }
+func (m *testMetrics) Reconnected()                      {}
+
+func TestClientMetrics(t *testing.T) {
+	clientConn, deviceConn := net.Pipe()
+	defer deviceConn.Close()
+	metrics := &testMetrics{}
+	c, err := ClientConfig{Key: string(testKey), Version: Version33, Metrics: metrics}.NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	go func() {
+		f, _ := DecodeFrame(deviceConn)
+		f.Payload = []byte("\x00\x00\x00\x00")
+		f.Encode(deviceConn)
+		(&Frame{Cmd: cmdStatus, Payload: []byte("3.3\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00garbage!garbage!")}).Encode(deviceConn)
+	}()
+	if _, err := c.Write(0x0a, false, map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read(); err == nil {
+		t.Error("expected decrypt error")
+	}
+	want := testMetrics{
+		sent: 1, sentBytes: 24 + 16,
+		received: 2, receivedBytes: 24 + 4 + 24 + 31,
+		decodeErrors: 1,
+	}
+	if *metrics != want {
+		t.Errorf("metrics = %+v, want %+v", *metrics, want)
+	}
+}
+
+// Benchmark reading 3.3 pushes, allocating or reusing frames.
+func benchmarkReadPush(b *testing.B, reuse bool) {
+	conn := &bufConn{}
+	device := &Conn{conn: conn, cipher: mustCipher(b), version: Version33}
+	if err := device.Push([]byte(`{"devId":"dev1","dps":{"1":true,"19":1234}}`)); err != nil {
+		b.Fatal(err)
+	}
+	frame := append([]byte(nil), conn.buf.Bytes()...)
+	c, err := ClientConfig{Key: string(testKey), Version: Version33}.NewClient(conn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	f := &Frame{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.buf.Reset()
+		conn.buf.Write(frame)
+		if reuse {
+			_, err = c.ReadFrame(f)
+		} else {
+			_, err = c.Read()
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func mustCipher(b *testing.B) *Cipher {
+	c, err := NewCipher(testKey)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return c
+}
+
+func BenchmarkRead(b *testing.B)      { benchmarkReadPush(b, false) }
+func BenchmarkReadFrame(b *testing.B) { benchmarkReadPush(b, true) }
+
+func TestClientWriteAfterCipherClose(t *testing.T) {
+	for _, version := range []string{Version31, Version33} {
+		clientConn, _ := net.Pipe()
+		conn := &countingConn{Conn: clientConn}
+		c, err := ClientConfig{Key: string(testKey), Version: version}.NewClient(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.cipher.Close()
+		if _, err := c.Write(0x07, true, []byte(testJSON)); !errors.Is(err, ErrCipherClosed) {
+			t.Errorf("%s: Write after cipher Close = %v, want ErrCipherClosed", version, err)
+		}
+		if conn.writes != 0 {
+			t.Errorf("%s: %d frames written with a closed cipher", version, conn.writes)
+		}
+		clientConn.Close()
+	}
+}
+
+func TestClientErrorsWrapCauses(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, err = ClientConfig{Addr: addr, Key: string(testKey)}.Dial()
+	var oe *net.OpError
+	if !errors.As(err, &oe) || oe.Op != "dial" {
+		t.Errorf("Dial to a closed port = %v, want a wrapped *net.OpError", err)
+	}
+
+	clientConn, _ := net.Pipe()
+	defer clientConn.Close()
+	c, err := ClientConfig{Key: string(testKey)}.NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn.SetReadDeadline(time.Now())
+	_, err = c.Read()
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("Read past the deadline = %v, want a wrapped timeout", err)
+	}
+}
@@ -0,0 +1,67 @@
+package net
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// A Codec marshals and unmarshals JSON payloads. Clients and Managers use
+// encoding/json by default; a faster implementation, such as jsoniter, can
+// be given in ClientConfig.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// An AppendCodec is a Codec that can marshal into an existing buffer, which
+// lets a Client avoid allocating a payload per message.
+type AppendCodec interface {
+	Codec
+	// AppendMarshal appends the encoding of v to dst and returns the
+	// extended buffer.
+	AppendMarshal(dst []byte, v interface{}) ([]byte, error)
+}
+
+// StdCodec is the Codec using encoding/json. It implements AppendCodec.
+var StdCodec Codec = stdCodec{}
+
+type stdCodec struct{}
+
+func (stdCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (stdCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type jsonEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var jsonEncoders = sync.Pool{New: func() interface{} {
+	e := &jsonEncoder{}
+	e.enc = json.NewEncoder(&e.buf)
+	return e
+}}
+
+func (stdCodec) AppendMarshal(dst []byte, v interface{}) ([]byte, error) {
+	e := jsonEncoders.Get().(*jsonEncoder)
+	defer jsonEncoders.Put(e)
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		return dst, err
+	}
+	// Encode adds a newline that Marshal doesn't.
+	data := e.buf.Bytes()
+	return append(dst, data[:len(data)-1]...), nil
+}
+
+// Marshal v with codec, appending to dst if the codec supports it.
+func appendMarshal(codec Codec, dst []byte, v interface{}) ([]byte, error) {
+	if ac, ok := codec.(AppendCodec); ok {
+		return ac.AppendMarshal(dst, v)
+	}
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, data...), nil
+}
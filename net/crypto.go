@@ -10,14 +10,16 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync"
 )
 
 const (
-	supportedVersion = "3.1" // TODO: support other versions?
+	supportedVersion = "3.1" // Version of the Encrypt/Decrypt format.
 	tagSize          = 16
 )
 
 var (
+	ErrCipherClosed    = errors.New("cipher closed")
 	ErrPadding         = errors.New("padding error")
 	ErrTagVerification = errors.New("tag verification failed")
 	ErrTooSmall        = errors.New("ciphertext too small")
@@ -33,110 +35,325 @@ func detectEncryption(payload []byte) bool {
 
 // A Cipher implements Tuya's authenticated encryption cipher.
 type Cipher struct {
+	// Held for reading by operations and for writing by Close, so key
+	// material isn't wiped while in use.
+	mu  sync.RWMutex
 	key []byte
 	aes cipher.Block
 }
 
-// NewCipher creates a new Cipher.
+// NewCipher creates a new Cipher. It keeps its own copy of key, so the caller
+// may wipe theirs.
 func NewCipher(key []byte) (*Cipher, error) {
 	aes, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
-	return &Cipher{key: key, aes: aes}, nil
+	return &Cipher{key: append([]byte(nil), key...), aes: aes}, nil
+}
+
+// Close wipes the Cipher's copy of the key. Afterwards, Decrypt and Open
+// return ErrCipherClosed, and Encrypt and Seal return dst unchanged. The
+// expanded key held by crypto/aes can't be wiped, but is dropped for the
+// garbage collector. Close may be called more than once.
+func (c *Cipher) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wipe(c.key)
+	c.key = nil
+	c.aes = nil
+	return nil
+}
+
+// Overwrite b with zeros.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
 }
 
 // Encrypt encrypts the given plaintext, which is not modified.
 func (c *Cipher) Encrypt(plaintext []byte) []byte {
-	blockSize := c.aes.BlockSize()
-	padSize := blockSize - (len(plaintext) % blockSize)
-	ciphertext := make([]byte, len(plaintext)+padSize)
-	copy(ciphertext, plaintext)
+	return c.EncryptAppend(nil, plaintext)
+}
 
-	// PKCS#7 padding
-	for i := len(plaintext); i < len(ciphertext); i++ {
-		ciphertext[i] = byte(padSize)
-	}
+// EncryptAppend is like Encrypt, but appends the ciphertext to dst and
+// returns the extended buffer. If dst has enough capacity, it doesn't
+// allocate.
+func (c *Cipher) EncryptAppend(dst, plaintext []byte) []byte {
+	dst, _ = c.encryptAppend(dst, plaintext)
+	return dst
+}
 
-	// AES ECB
-	for i := 0; i < len(ciphertext); i += blockSize {
-		c.aes.Encrypt(ciphertext[i:], ciphertext[i:])
+// Like EncryptAppend, but returns ErrCipherClosed after Close, so a Client
+// doesn't send an empty payload.
+func (c *Cipher) encryptAppend(dst, plaintext []byte) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.aes == nil {
+		return dst, ErrCipherClosed
 	}
-
-	// Output buffer: <version><hex(tag)><base64(ciphertext)>
-	outputSize := len(version) + tagSize + b64.EncodedLen(len(ciphertext))
-	output := make([]byte, outputSize)
+	// Output: <version><hex(tag)><base64(ciphertext)>
+	outputSize := len(version) + tagSize + b64.EncodedLen(c.sealedSize(len(plaintext)))
+	start := len(dst)
+	dst = grow(dst, outputSize)
+	output := dst[start:]
 	copy(output, version)
 
 	// Base64 ciphertext
 	encoded := output[len(version)+tagSize:]
-	b64.Encode(encoded, ciphertext)
+	c.sealEncode(encoded, plaintext)
 
 	// Tuya MAC
 	macTag(output[len(version):], c.key, encoded)
 
-	return output
+	return dst, nil
+}
+
+// A multiple of both the AES block size and base64's 3 byte groups, so chunks
+// encode without padding.
+const sealChunkSize = 3 * aes.BlockSize
+
+// Pooled rather than on the stack, which the cipher.Block interface would
+// make escape anyway.
+var chunkBuffers = sync.Pool{New: func() interface{} { return new([sealChunkSize]byte) }}
+
+// Seal plaintext and base64 encode the ciphertext into dst, a chunk at a time
+// so the raw ciphertext never needs a buffer of its own.
+func (c *Cipher) sealEncode(dst, plaintext []byte) {
+	chunk := chunkBuffers.Get().(*[sealChunkSize]byte)
+	defer chunkBuffers.Put(chunk)
+	for {
+		n := copy(chunk[:], plaintext)
+		plaintext = plaintext[n:]
+		last := n < len(chunk)
+		if last {
+			// PKCS#7 padding, which always fits in a partial chunk.
+			padSize := aes.BlockSize - n%aes.BlockSize
+			for i := 0; i < padSize; i++ {
+				chunk[n] = byte(padSize)
+				n++
+			}
+		}
+
+		// AES ECB
+		for i := 0; i < n; i += aes.BlockSize {
+			c.aes.Encrypt(chunk[i:], chunk[i:])
+		}
+		b64.Encode(dst, chunk[:n])
+		dst = dst[b64.EncodedLen(n):]
+		if last {
+			return
+		}
+	}
 }
 
 // Decrypt decrypts the given ciphertext, which is not modified.
 func (c *Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	return c.DecryptAppend(nil, ciphertext)
+}
+
+// DecryptAppend is like Decrypt, but appends the plaintext to dst and returns
+// the extended buffer. If dst has enough capacity, it doesn't allocate, so a
+// connection can reuse one buffer for every message. On error, dst is
+// returned unextended.
+func (c *Cipher) DecryptAppend(dst, ciphertext []byte) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.aes == nil {
+		return dst, ErrCipherClosed
+	}
 	blockSize := c.aes.BlockSize()
 	if len(ciphertext) < len(version)+tagSize+b64.EncodedLen(blockSize) {
-		return nil, ErrTooSmall
+		return dst, ErrTooSmall
 	}
 
 	// Version
 	if !bytes.HasPrefix(ciphertext, version) {
-		return nil, fmt.Errorf("ciphertext doesn't start with %s", version)
+		return dst, fmt.Errorf("ciphertext doesn't start with %s", version)
 	}
 	ciphertext = ciphertext[len(version):]
 
 	// Tuya MAC
 	tag := ciphertext[:tagSize]
-	expectedTag := macTag(nil, c.key, ciphertext[tagSize:])
-	if subtle.ConstantTimeCompare(tag, expectedTag) != 1 {
-		return nil, ErrTagVerification
+	var expectedTag [tagSize]byte
+	macTag(expectedTag[:], c.key, ciphertext[tagSize:])
+	if subtle.ConstantTimeCompare(tag, expectedTag[:]) != 1 {
+		return dst, ErrTagVerification
 	}
 
 	// Base64 data
-	b64data := ciphertext[tagSize:]
-	plaintext := make([]byte, b64.DecodedLen(len(b64data)))
-	n, err := b64.Decode(plaintext, b64data)
+	plaintext, err := c.decodeOpen(reserve(dst, b64.DecodedLen(len(ciphertext)-tagSize)), ciphertext[tagSize:])
 	if err != nil {
-		return nil, fmt.Errorf("base64 Decode: %v", err)
+		return dst, err
 	}
-	plaintext = plaintext[:n]
+	return plaintext, nil
+}
 
-	// AES ECB
-	for i := 0; i < len(plaintext); i += blockSize {
-		c.aes.Decrypt(plaintext[i:], plaintext[i:])
+// Base64 decode and decrypt src, appending the plaintext to dst. The data is
+// decoded straight into dst's spare capacity and decrypted in place, so it
+// needs no scratch buffer. Called with c.mu held.
+func (c *Cipher) decodeOpen(dst, src []byte) ([]byte, error) {
+	start := len(dst)
+	data := dst[start : start+b64.DecodedLen(len(src))]
+	n, err := b64.Decode(data, src)
+	if err != nil {
+		return nil, fmt.Errorf("base64 Decode: %w", err)
+	}
+	data = data[:n]
+	if n%aes.BlockSize != 0 {
+		return nil, ErrPadding
+	}
+	c.decryptBlocks(data)
+	plaintext, err := unpad(data)
+	if err != nil {
+		return nil, err
+	}
+	return dst[:start+len(plaintext)], nil
+}
+
+// Seal encrypts the given plaintext with bare AES-ECB and PKCS#7 padding, as
+// used by protocol 3.3 without Encrypt's encoding and MAC. The plaintext is
+// not modified.
+func (c *Cipher) Seal(plaintext []byte) []byte {
+	return c.SealAppend(nil, plaintext)
+}
+
+// SealAppend is like Seal, but appends the ciphertext to dst and returns the
+// extended buffer. If dst has enough capacity, it doesn't allocate.
+func (c *Cipher) SealAppend(dst, plaintext []byte) []byte {
+	dst, _ = c.sealAppend(dst, plaintext)
+	return dst
+}
+
+// Like SealAppend, but returns ErrCipherClosed after Close.
+func (c *Cipher) sealAppend(dst, plaintext []byte) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.aes == nil {
+		return dst, ErrCipherClosed
 	}
+	blockSize := c.aes.BlockSize()
+	padSize := blockSize - (len(plaintext) % blockSize)
+	start := len(dst)
+	dst = append(dst, plaintext...)
 
 	// PKCS#7 padding
-	padSize := int(plaintext[len(plaintext)-1])
-	if padSize < 1 || padSize > blockSize {
+	for i := 0; i < padSize; i++ {
+		dst = append(dst, byte(padSize))
+	}
+
+	// AES ECB
+	ciphertext := dst[start:]
+	for i := 0; i < len(ciphertext); i += blockSize {
+		c.aes.Encrypt(ciphertext[i:], ciphertext[i:])
+	}
+	return dst, nil
+}
+
+// The length of plaintext once padded and sealed.
+func (c *Cipher) sealedSize(n int) int {
+	blockSize := c.aes.BlockSize()
+	return n + blockSize - n%blockSize
+}
+
+// Extend dst by n bytes, reusing its capacity if possible.
+func grow(dst []byte, n int) []byte {
+	if len(dst)+n <= cap(dst) {
+		return dst[:len(dst)+n]
+	}
+	return append(dst, make([]byte, n)...)
+}
+
+// Open decrypts ciphertext produced by Seal. The ciphertext is not modified.
+func (c *Cipher) Open(ciphertext []byte) ([]byte, error) {
+	return c.OpenAppend(nil, ciphertext)
+}
+
+// OpenAppend is like Open, but appends the plaintext to dst and returns the
+// extended buffer. If dst has enough capacity, it doesn't allocate. On error,
+// dst is returned unextended.
+func (c *Cipher) OpenAppend(dst, ciphertext []byte) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.aes == nil {
+		return dst, ErrCipherClosed
+	}
+	blockSize := c.aes.BlockSize()
+	if len(ciphertext) < blockSize {
+		return dst, ErrTooSmall
+	}
+	if len(ciphertext)%blockSize != 0 {
+		return dst, fmt.Errorf("ciphertext length %d not a multiple of %d",
+			len(ciphertext), blockSize)
+	}
+	start := len(dst)
+	out := append(dst, ciphertext...)
+	c.decryptBlocks(out[start:])
+	plaintext, err := unpad(out[start:])
+	if err != nil {
+		return dst, err
+	}
+	return out[:start+len(plaintext)], nil
+}
+
+// Reserve room for n more bytes in dst, allocating at most once.
+func reserve(dst []byte, n int) []byte {
+	if len(dst)+n <= cap(dst) {
+		return dst
+	}
+	return append(make([]byte, 0, len(dst)+n), dst...)
+}
+
+// AES ECB decrypt whole blocks in place. Called with c.mu held.
+func (c *Cipher) decryptBlocks(data []byte) {
+	for i := 0; i < len(data); i += aes.BlockSize {
+		c.aes.Decrypt(data[i:], data[i:])
+	}
+}
+
+// Strip PKCS#7 padding, checked in constant time over the last block so
+// timing doesn't reveal where the padding went wrong.
+func unpad(data []byte) ([]byte, error) {
+	blockSize := aes.BlockSize
+	if len(data) == 0 || len(data)%blockSize != 0 {
 		return nil, ErrPadding
 	}
-	for i := len(plaintext) - padSize; i < len(plaintext)-1; i++ {
-		if plaintext[i] != byte(padSize) {
-			return nil, ErrPadding
-		}
+	padSize := int(data[len(data)-1])
+	good := subtle.ConstantTimeLessOrEq(1, padSize) & subtle.ConstantTimeLessOrEq(padSize, blockSize)
+	for i := 1; i <= blockSize; i++ {
+		inPadding := subtle.ConstantTimeLessOrEq(i, padSize)
+		matches := subtle.ConstantTimeByteEq(data[len(data)-i], byte(padSize))
+		good &= subtle.ConstantTimeSelect(inPadding, matches, 1)
 	}
-	return plaintext[:len(plaintext)-padSize], nil
+	if good != 1 {
+		return nil, ErrPadding
+	}
+	return data[:len(data)-padSize], nil
 }
 
+// MAC input pieces, streamed into the digest around the data so the payload
+// is never copied.
+var (
+	macData = []byte("data=")
+	macLPV  = []byte("||lpv=")
+	macSep  = []byte("||")
+)
+
 func macTag(dst, key, data []byte) []byte {
 	// hex(md5("data=" <data> "||lpv=3.1||" <key>)[4:12])
 	h := md5.New()
-	h.Write([]byte("data="))
+	h.Write(macData)
 	h.Write(data)
-	h.Write([]byte("||lpv="))
+	h.Write(macLPV)
 	h.Write(version)
-	h.Write([]byte("||"))
+	h.Write(macSep)
 	h.Write(key)
+	var sum [md5.Size]byte
+	h.Sum(sum[:0])
 	if dst == nil {
 		dst = make([]byte, tagSize)
 	}
-	hex.Encode(dst, h.Sum(nil)[4:12])
+	hex.Encode(dst, sum[4:12])
 	return dst
 }
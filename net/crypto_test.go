@@ -22,6 +22,23 @@ func TestEncrypt(t *testing.T) {
 	}
 }
 
+func TestEncryptLengths(t *testing.T) {
+	c, err := NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := bytes.Repeat([]byte("0123456789"), 12)
+	for n := 0; n <= len(plaintext); n++ {
+		got, err := c.Decrypt(c.Encrypt(plaintext[:n]))
+		if err != nil {
+			t.Fatalf("length %d: %v", n, err)
+		}
+		if !bytes.Equal(got, plaintext[:n]) {
+			t.Errorf("length %d: got %q", n, got)
+		}
+	}
+}
+
 func TestDecrypt(t *testing.T) {
 	c, err := NewCipher(testKey)
 	if err != nil {
@@ -56,3 +73,190 @@ func TestMAC(t *testing.T) {
 		t.Errorf("%s != %s", tag, expectedTag)
 	}
 }
+
+func TestSealOpen(t *testing.T) {
+	c, err := NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := c.Seal(testPlaintext)
+	if len(ciphertext)%16 != 0 {
+		t.Errorf("ciphertext length %d not a multiple of 16", len(ciphertext))
+	}
+	plaintext, err := c.Open(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, testPlaintext) {
+		t.Errorf("got:\n%q\nwant:\n%q", plaintext, testPlaintext)
+	}
+	if _, err := c.Open(ciphertext[:len(ciphertext)-1]); err == nil {
+		t.Error("expected error for truncated ciphertext")
+	}
+}
+
+func TestAppend(t *testing.T) {
+	c, err := NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := []byte("prefix")
+	if got, want := c.SealAppend(prefix, testPlaintext), c.Seal(testPlaintext); !bytes.Equal(got, append(prefix, want...)) {
+		t.Errorf("SealAppend got:\n%q\nwant prefix and:\n%q", got, want)
+	}
+	if got, want := c.EncryptAppend(prefix, testPlaintext), c.Encrypt(testPlaintext); !bytes.Equal(got, append(prefix, want...)) {
+		t.Errorf("EncryptAppend got:\n%q\nwant prefix and:\n%q", got, want)
+	}
+	got, err := c.DecryptAppend(prefix, c.Encrypt(testPlaintext))
+	if err != nil || !bytes.Equal(got, append(prefix, testPlaintext...)) {
+		t.Errorf("DecryptAppend got %q, %v", got, err)
+	}
+	got, err = c.OpenAppend(prefix, c.Seal(testPlaintext))
+	if err != nil || !bytes.Equal(got, append(prefix, testPlaintext...)) {
+		t.Errorf("OpenAppend got %q, %v", got, err)
+	}
+	if got, err := c.DecryptAppend(prefix, testCiphertext[:len(testCiphertext)-1]); err == nil || !bytes.Equal(got, prefix) {
+		t.Errorf("DecryptAppend of bad ciphertext = %q, %v; want prefix and error", got, err)
+	}
+}
+
+func TestCipherAllocs(t *testing.T) {
+	c, err := NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := c.Seal(testPlaintext)
+	buf := make([]byte, 0, 1024)
+	for name, fn := range map[string]func(){
+		"EncryptAppend": func() { c.EncryptAppend(buf, testPlaintext) },
+		"DecryptAppend": func() { c.DecryptAppend(buf, testCiphertext) },
+		"SealAppend":    func() { c.SealAppend(buf, testPlaintext) },
+		"OpenAppend":    func() { c.OpenAppend(buf, sealed) },
+		"macTag":        func() { macTag(buf[:tagSize], c.key, testPlaintext) },
+	} {
+		if n := testing.AllocsPerRun(100, fn); n != 0 {
+			t.Errorf("%s: %v allocs, want 0", name, n)
+		}
+	}
+}
+
+func benchmarkCipher(b *testing.B, fn func(c *Cipher) error) {
+	c, err := NewCipher(testKey)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(testPlaintext)))
+	for i := 0; i < b.N; i++ {
+		if err := fn(c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	benchmarkCipher(b, func(c *Cipher) error {
+		c.Encrypt(testPlaintext)
+		return nil
+	})
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	benchmarkCipher(b, func(c *Cipher) error {
+		_, err := c.Decrypt(testCiphertext)
+		return err
+	})
+}
+
+func BenchmarkDecryptAppend(b *testing.B) {
+	buf := make([]byte, 0, len(testCiphertext))
+	benchmarkCipher(b, func(c *Cipher) error {
+		_, err := c.DecryptAppend(buf, testCiphertext)
+		return err
+	})
+}
+
+func BenchmarkSeal(b *testing.B) {
+	benchmarkCipher(b, func(c *Cipher) error {
+		c.Seal(testPlaintext)
+		return nil
+	})
+}
+
+func BenchmarkOpen(b *testing.B) {
+	c, err := NewCipher(testKey)
+	if err != nil {
+		b.Fatal(err)
+	}
+	sealed := c.Seal(testPlaintext)
+	benchmarkCipher(b, func(c *Cipher) error {
+		_, err := c.Open(sealed)
+		return err
+	})
+}
+
+func BenchmarkOpenAppend(b *testing.B) {
+	c, err := NewCipher(testKey)
+	if err != nil {
+		b.Fatal(err)
+	}
+	sealed := c.Seal(testPlaintext)
+	buf := make([]byte, 0, len(sealed))
+	benchmarkCipher(b, func(c *Cipher) error {
+		_, err := c.OpenAppend(buf, sealed)
+		return err
+	})
+}
+
+func TestOpenBadPadding(t *testing.T) {
+	c, err := NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pad := range [][]byte{
+		{0},                          // Too small
+		{17},                         // Too large
+		{1, 2, 2, 2, 2, 2, 2, 2, 3},  // Mismatched byte
+		bytes.Repeat([]byte{16}, 15), // Short
+	} {
+		block := make([]byte, 16)
+		copy(block[16-len(pad):], pad)
+		c.aes.Encrypt(block, block)
+		if _, err := c.Open(block); err != ErrPadding {
+			t.Errorf("padding %v: got %v, want ErrPadding", pad, err)
+		}
+	}
+}
+
+func TestCipherClose(t *testing.T) {
+	key := append([]byte(nil), testKey...)
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := c.Seal(testPlaintext)
+	ciphertext := c.Encrypt(testPlaintext)
+	keyCopy := c.key
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, testKey) {
+		t.Error("Close wiped the caller's key")
+	}
+	if !bytes.Equal(keyCopy, make([]byte, len(keyCopy))) {
+		t.Errorf("key not wiped: %q", keyCopy)
+	}
+	if _, err := c.Decrypt(ciphertext); err != ErrCipherClosed {
+		t.Errorf("Decrypt: got %v, want ErrCipherClosed", err)
+	}
+	if _, err := c.Open(sealed); err != ErrCipherClosed {
+		t.Errorf("Open: got %v, want ErrCipherClosed", err)
+	}
+	if out := c.Seal(testPlaintext); len(out) != 0 {
+		t.Errorf("Seal after Close = %q", out)
+	}
+	if _, err := c.encryptAppend([]byte("prefix"), testPlaintext); err != ErrCipherClosed {
+		t.Errorf("encryptAppend: got %v, want ErrCipherClosed", err)
+	}
+	c.Close()
+}
@@ -1,7 +1,6 @@
 package net
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
@@ -54,6 +53,12 @@ func DecodeFrame(r io.Reader) (*Frame, error) {
 
 // Decode decodes from a Reader into an existing Frame.
 func (f *Frame) Decode(r io.Reader) error {
+	return f.DecodeLimit(r, MaxPayloadSize)
+}
+
+// DecodeLimit is like Decode, but fails without reading the payload if it's
+// longer than limit bytes, bounding the memory a peer can make it allocate.
+func (f *Frame) DecodeLimit(r io.Reader, limit int) error {
 	// Prepare CRC
 	crc := crc32.NewIEEE()
 	rCRC := io.TeeReader(r, crc)
@@ -61,7 +66,7 @@ func (f *Frame) Decode(r io.Reader) error {
 	// Read header
 	var h header
 	if err := binary.Read(rCRC, binary.BigEndian, &h); err != nil {
-		return fmt.Errorf("header Read: %v", err)
+		return fmt.Errorf("header Read: %w", err)
 	}
 	if h.Prefix != prefixValue {
 		return fmt.Errorf("bad prefix %x", h.Prefix)
@@ -71,11 +76,13 @@ func (f *Frame) Decode(r io.Reader) error {
 
 	// Try to reuse the existing Payload []byte if it is big enough.
 	payloadSize := int(h.Length) - trailerSize
+	if payloadSize < 0 {
+		return fmt.Errorf("length too small; %d < %d", h.Length, trailerSize)
+	}
+	if payloadSize > limit {
+		return fmt.Errorf("payload too large; %d > %d", payloadSize, limit)
+	}
 	if cap(f.Payload) < payloadSize {
-		if payloadSize > MaxPayloadSize {
-			return fmt.Errorf("payload too large; %d > %d",
-				payloadSize, MaxPayloadSize)
-		}
 		f.Payload = make([]byte, payloadSize)
 	} else {
 		f.Payload = f.Payload[:payloadSize]
@@ -83,13 +90,13 @@ func (f *Frame) Decode(r io.Reader) error {
 
 	// Read payload
 	if _, err := io.ReadFull(rCRC, f.Payload); err != nil {
-		return fmt.Errorf("payload Read: %v", err)
+		return fmt.Errorf("payload Read: %w", err)
 	}
 
 	// Read trailer
 	var t trailer
 	if err := binary.Read(r, binary.BigEndian, &t); err != nil {
-		return fmt.Errorf("trailer Read: %v", err)
+		return fmt.Errorf("trailer Read: %w", err)
 	}
 	if t.Suffix != suffixValue {
 		return fmt.Errorf("bad suffix %x", t.Suffix)
@@ -105,44 +112,40 @@ func (f *Frame) Decode(r io.Reader) error {
 
 // Encode writes the Frame to a Writer.
 func (f *Frame) Encode(w io.Writer) error {
-	buf, err := f.buffer()
+	data, err := f.AppendEncode(nil)
 	if err != nil {
 		return err
 	}
-	if _, err := buf.WriteTo(w); err != nil {
-		return fmt.Errorf("WriteTo: %v", err)
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("Write: %w", err)
 	}
 	return nil
 }
 
-// Return a Buffer containing a wire serialization of the Frame.
-func (f *Frame) buffer() (*bytes.Buffer, error) {
+// AppendEncode appends the wire serialization of the Frame to dst and returns
+// the extended buffer.
+func (f *Frame) AppendEncode(dst []byte) ([]byte, error) {
 	if len(f.Payload) > MaxPayloadSize {
-		return nil, fmt.Errorf("payload too large; %d > %d",
+		return dst, fmt.Errorf("payload too large; %d > %d",
 			len(f.Payload), MaxPayloadSize)
 	}
 	length := len(f.Payload) + trailerSize
-	buf := bytes.NewBuffer(make([]byte, 0, headerSize+length))
-
-	// Write header
-	binary.Write(buf, binary.BigEndian, header{
-		Prefix: prefixValue,
-		Seq:    f.Seq,
-		Cmd:    f.Cmd,
-		Length: uint32(length),
-	})
+	start := len(dst)
 
-	// Write payload
-	buf.Write(f.Payload)
+	// Header
+	dst = appendUint32(dst, prefixValue)
+	dst = appendUint32(dst, f.Seq)
+	dst = appendUint32(dst, f.Cmd)
+	dst = appendUint32(dst, uint32(length))
 
-	// Calculate CRC
-	crc := crc32.ChecksumIEEE(buf.Bytes())
+	// Payload
+	dst = append(dst, f.Payload...)
 
-	// Write trailer
-	binary.Write(buf, binary.BigEndian, trailer{
-		CRC:    crc,
-		Suffix: suffixValue,
-	})
+	// Trailer
+	dst = appendUint32(dst, crc32.ChecksumIEEE(dst[start:]))
+	return appendUint32(dst, suffixValue), nil
+}
 
-	return buf, nil
+func appendUint32(dst []byte, v uint32) []byte {
+	return append(dst, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
 }
@@ -2,6 +2,8 @@ package net
 
 import (
 	"bytes"
+	"encoding/binary"
+	"io/ioutil"
 	"strings"
 	"testing"
 )
@@ -66,6 +68,28 @@ func TestFrameDecodePreallocate(t *testing.T) {
 	}
 }
 
+func TestFrameDecodeShortLength(t *testing.T) {
+	data := append([]byte(nil), testData...)
+	binary.BigEndian.PutUint32(data[12:], 4) // less than the trailer
+	for _, f := range []*Frame{{}, {Payload: make([]byte, 64)}} {
+		if err := f.Decode(bytes.NewReader(data)); err == nil {
+			t.Error("expected error for length shorter than the trailer")
+		}
+	}
+}
+
+func TestFrameDecodeLimit(t *testing.T) {
+	size := len(testData) - headerSize - trailerSize
+	if err := new(Frame).DecodeLimit(bytes.NewReader(testData), size); err != nil {
+		t.Fatal(err)
+	}
+	// A buffer big enough doesn't lift the limit.
+	f := &Frame{Payload: make([]byte, 1024)}
+	if err := f.DecodeLimit(bytes.NewReader(testData), size-1); err == nil {
+		t.Error("expected error for payload over the limit")
+	}
+}
+
 func TestFrameEncode(t *testing.T) {
 	f := &Frame{Payload: testData[16 : len(testData)-8]}
 	var buf bytes.Buffer
@@ -76,3 +100,39 @@ func TestFrameEncode(t *testing.T) {
 		t.Errorf("got:\n%x\nwant:\n%x", buf.Bytes(), testData)
 	}
 }
+
+func TestFrameAppendEncode(t *testing.T) {
+	f := &Frame{Payload: testData[16 : len(testData)-8]}
+	prefix := []byte("prefix")
+	got, err := f.AppendEncode(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, append([]byte("prefix"), testData...)) {
+		t.Errorf("got:\n%x\nwant prefix and:\n%x", got, testData)
+	}
+}
+
+func BenchmarkFrameEncode(b *testing.B) {
+	f := &Frame{Payload: testData[16 : len(testData)-8]}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(testData)))
+	for i := 0; i < b.N; i++ {
+		if err := f.Encode(ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFrameDecode(b *testing.B) {
+	r := bytes.NewReader(testData)
+	f := &Frame{}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(testData)))
+	for i := 0; i < b.N; i++ {
+		r.Reset(testData)
+		if err := f.Decode(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
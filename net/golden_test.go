@@ -0,0 +1,226 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "regenerate testdata/frames.json")
+
+const goldenPath = "testdata/frames.json"
+
+// A frame in testdata/frames.json. Frames are encrypted with testKey. The
+// captured broadcast is from a real device; the rest are produced by this
+// package in the formats devices use, so changes to encoding show up as
+// diffs to the corpus.
+type goldenFrame struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// From is "client", "device", or "broadcast".
+	From      string  `json:"from"`
+	Cmd       uint32  `json:"cmd"`
+	Seq       uint32  `json:"seq"`
+	Code      *uint32 `json:"code,omitempty"`
+	Plaintext string  `json:"plaintext"`
+	Frame     string  `json:"frame"`
+	Captured  bool    `json:"captured,omitempty"`
+}
+
+// Whether the frame is a 6699 frame rather than 55aa.
+func (g *goldenFrame) gcm() bool {
+	return g.From == "broadcast" && g.Version >= "3.5"
+}
+
+// Whether encoding the frame again gives the same bytes: not for captures,
+// or 6699 frames, which have random nonces.
+func (g *goldenFrame) deterministic() bool {
+	return !g.Captured && !g.gcm()
+}
+
+// A net.Conn reading and writing a buffer.
+type bufConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *bufConn) Read(p []byte) (int, error)  { return c.buf.Read(p) }
+func (c *bufConn) Write(p []byte) (int, error) { return c.buf.Write(p) }
+
+func goldenCipher(t *testing.T, g *goldenFrame) *Cipher {
+	key := testKey
+	if g.From == "broadcast" {
+		key = BroadcastKey[:]
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Encode a golden frame's plaintext as its sender would.
+func encodeGolden(t *testing.T, g *goldenFrame) []byte {
+	cipher := goldenCipher(t, g)
+	conn := &bufConn{}
+	var err error
+	switch g.From {
+	case "client":
+		c, err := ClientConfig{Key: string(testKey), Version: g.Version,
+			Seq: func() uint32 { return g.Seq }}.NewClient(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypt := g.Cmd == 0x07 || g.Cmd == 0x12
+		_, err = c.Write(g.Cmd, encrypt, []byte(g.Plaintext))
+	case "device":
+		c := &Conn{conn: conn, cipher: cipher, version: g.Version}
+		var payload interface{}
+		if g.Plaintext != "" {
+			payload = []byte(g.Plaintext)
+		}
+		if g.Cmd == cmdStatus {
+			err = c.Push(payload)
+		} else {
+			err = c.Reply(&Frame{Seq: g.Seq, Cmd: g.Cmd}, *g.Code, payload)
+		}
+	case "broadcast":
+		var s Status
+		if err := json.Unmarshal([]byte(g.Plaintext), &s); err != nil {
+			t.Fatal(err)
+		}
+		var packet []byte
+		packet, err = EncodeStatus(&s)
+		conn.Write(packet)
+	default:
+		t.Fatalf("bad from %q", g.From)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn.buf.Bytes()
+}
+
+// Decode a golden frame as its receiver would, returning the plaintext and
+// any return code.
+func decodeGolden(t *testing.T, g *goldenFrame, frame []byte) (string, *uint32) {
+	cipher := goldenCipher(t, g)
+	conn := &bufConn{}
+	conn.buf.Write(frame)
+	switch g.From {
+	case "client":
+		c := &Conn{conn: conn, cipher: cipher, version: g.Version}
+		f, err := c.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(f.Payload), nil
+
+	case "device":
+		c := &Client{conn: conn, cipher: cipher, version: g.Version}
+		res, err := c.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := res.Payload
+		if g.Cmd == cmdStatus && !(len(payload) >= 4 && payload[0] == 0) {
+			// 3.1 pushes have no return code.
+			return string(payload), nil
+		}
+		if len(payload) < 4 {
+			t.Fatalf("payload too short: %q", payload)
+		}
+		code := binary.BigEndian.Uint32(payload)
+		return string(payload[4:]), &code
+
+	case "broadcast":
+		l := &statusListener{}
+		if g.Version >= Version33 && g.Version < "3.5" {
+			l.cipher = cipher
+		}
+		s, err := l.decode(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data), nil
+	}
+	t.Fatalf("bad from %q", g.From)
+	return "", nil
+}
+
+func TestGoldenFrames(t *testing.T) {
+	data, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var golden []*goldenFrame
+	if err := json.Unmarshal(data, &golden); err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		for _, g := range golden {
+			if !g.Captured && (g.deterministic() || g.Frame == "") {
+				g.Frame = hex.EncodeToString(encodeGolden(t, g))
+			}
+		}
+		data, err := json.MarshalIndent(golden, "", " ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(goldenPath, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, g := range golden {
+		t.Run(g.Name, func(t *testing.T) {
+			frame, err := hex.DecodeString(g.Frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Frames round trip.
+			if !g.gcm() {
+				f, err := DecodeFrame(bytes.NewReader(frame))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f.Seq != g.Seq || f.Cmd != g.Cmd {
+					t.Errorf("seq %d cmd %#x, want %d %#x", f.Seq, f.Cmd, g.Seq, g.Cmd)
+				}
+				var buf bytes.Buffer
+				if err := f.Encode(&buf); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(buf.Bytes(), frame) {
+					t.Errorf("re-encoded frame differs:\n%x", buf.Bytes())
+				}
+			}
+
+			// Receivers decrypt the plaintext.
+			plaintext, code := decodeGolden(t, g, frame)
+			if plaintext != g.Plaintext {
+				t.Errorf("plaintext %q, want %q", plaintext, g.Plaintext)
+			}
+			if (code == nil) != (g.Code == nil) || code != nil && *code != *g.Code {
+				t.Errorf("return code %v, want %v", code, g.Code)
+			}
+
+			// Senders produce the same frame.
+			if g.deterministic() {
+				if encoded := encodeGolden(t, g); !bytes.Equal(encoded, frame) {
+					t.Errorf("encoded frame differs:\n%x", encoded)
+				}
+			}
+		})
+	}
+}
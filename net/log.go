@@ -0,0 +1,91 @@
+package net
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+// A Level is the severity of a log message.
+type Level int
+
+// Log levels, from least to most severe.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel parses a level name, as returned by Level.String.
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelError; l++ {
+		if s == l.String() {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// A Logger receives debug and error messages from Clients and Managers,
+// which otherwise log nothing. Logf may be called from multiple goroutines.
+type Logger interface {
+	Logf(level Level, format string, args ...interface{})
+}
+
+// A StdLogger writes messages at or above its Level to a log.Logger.
+type StdLogger struct {
+	// Logger is the destination; nil means the log package's standard
+	// logger.
+	Logger *log.Logger
+	Level  Level
+	// Prefix is written before each message, like "tuya: ".
+	Prefix string
+}
+
+// Logf implements Logger.
+func (l *StdLogger) Logf(level Level, format string, args ...interface{}) {
+	if level < l.Level {
+		return
+	}
+	msg := fmt.Sprintf("%s%s: %s", l.Prefix, level, fmt.Sprintf(format, args...))
+	if l.Logger == nil {
+		log.Output(2, msg)
+	} else {
+		l.Logger.Output(2, msg)
+	}
+}
+
+// RedactLogger returns a Logger replacing any occurrence of key in messages
+// with asterisks before passing them to l. It returns nil if l is nil.
+func RedactLogger(l Logger, key string) Logger {
+	if l == nil || key == "" {
+		return l
+	}
+	return redactLogger{l, []byte(key)}
+}
+
+type redactLogger struct {
+	Logger
+	key []byte
+}
+
+func (r redactLogger) Logf(level Level, format string, args ...interface{}) {
+	msg := []byte(fmt.Sprintf(format, args...))
+	msg = bytes.Replace(msg, r.key, bytes.Repeat([]byte("*"), len(r.key)), -1)
+	r.Logger.Logf(level, "%s", msg)
+}
@@ -0,0 +1,65 @@
+package net
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A Logger collecting messages.
+type testLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *testLogger) Logf(level Level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, level.String()+": "+fmt.Sprintf(format, args...))
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := &StdLogger{Logger: log.New(&buf, "", 0), Level: LevelWarn, Prefix: "tuya: "}
+	l.Logf(LevelDebug, "hidden")
+	l.Logf(LevelError, "shown %d", 1)
+	if got := buf.String(); got != "tuya: error: shown 1\n" {
+		t.Errorf("logged %q", got)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for l := LevelDebug; l <= LevelError; l++ {
+		if got, err := ParseLevel(l.String()); err != nil || got != l {
+			t.Errorf("ParseLevel(%q) = %v, %v", l, got, err)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestClientLoggerRedacts(t *testing.T) {
+	l := &testLogger{}
+	conn := &bufConn{}
+	c, err := ClientConfig{Key: string(testKey), Version: Version33, Logger: l}.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Logger().Logf(LevelWarn, "key is %s", testKey)
+	if _, err := c.Write(0x0a, false, map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.msgs) != 2 {
+		t.Fatalf("logged %q", l.msgs)
+	}
+	if bytes.Contains([]byte(l.msgs[0]), testKey) || !strings.Contains(l.msgs[0], "****") {
+		t.Errorf("key not redacted: %q", l.msgs[0])
+	}
+	if !strings.HasPrefix(l.msgs[1], "debug: sent seq 1 cmd 0xa") {
+		t.Errorf("logged %q", l.msgs[1])
+	}
+}
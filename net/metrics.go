@@ -0,0 +1,19 @@
+package net
+
+// Metrics receives counts of a Client's traffic, for monitoring. Sizes are
+// of whole frames as sent over the wire. Methods may be called from multiple
+// goroutines.
+type Metrics interface {
+	FrameSent(cmd uint32, size int)
+	FrameReceived(cmd uint32, size int)
+	// DecodeError is called for received frames that fail to decrypt.
+	DecodeError(cmd uint32, err error)
+	// Reconnected is called before a device.Fleet redials a device whose
+	// connection failed.
+	Reconnected()
+}
+
+// The size of a frame with a payload of n bytes.
+func frameSize(n int) int {
+	return headerSize + n + trailerSize
+}
@@ -0,0 +1,83 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A RateLimiter is a token bucket limiting how often Clients write, to keep
+// floods of commands from locking up device firmware. One RateLimiter may be
+// shared by many Clients to limit them together.
+type RateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing rate writes per second on
+// average, and bursts of up to burst writes; burst is at least 1. The rate
+// must be positive.
+func NewRateLimiter(rate float64, burst int) (*RateLimiter, error) {
+	if !(rate > 0) {
+		return nil, fmt.Errorf("rate limit %v must be positive", rate)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst)}, nil
+}
+
+// Wait blocks until the limit allows another write, and takes its token. If
+// ctx ends first, Wait returns its error without taking a token.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d := l.reserve(time.Now())
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.unreserve()
+		return ctx.Err()
+	}
+}
+
+// Take a token, which may not be available yet, returning how long to wait
+// for it.
+func (l *RateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Give back a token taken by reserve that won't be used.
+func (l *RateLimiter) unreserve() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens++
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
@@ -0,0 +1,109 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// Make a RateLimiter, failing the test on error.
+func newRateLimiter(t *testing.T, rate float64, burst int) *RateLimiter {
+	t.Helper()
+	l, err := NewRateLimiter(rate, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(t, 10, 2)
+	now := time.Unix(0, 0)
+	for i, want := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if d := l.reserve(now); d != want {
+			t.Errorf("write %d waits %v, want %v", i, d, want)
+		}
+	}
+
+	// Tokens refill up to the burst size.
+	now = now.Add(10 * time.Second)
+	for i, want := range []time.Duration{0, 0, 100 * time.Millisecond} {
+		if d := l.reserve(now); d != want {
+			t.Errorf("write %d after refill waits %v, want %v", i, d, want)
+		}
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	conn := &bufConn{}
+	l := newRateLimiter(t, 100, 1)
+	c, err := ClientConfig{RateLimiters: []*RateLimiter{l}}.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := c.Write(0x0a, false, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 25*time.Millisecond {
+		t.Errorf("4 writes at 100/s took %v", d)
+	}
+}
+
+func TestNewRateLimiterRate(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		if _, err := NewRateLimiter(rate, 1); err == nil {
+			t.Errorf("NewRateLimiter(%v) succeeded", rate)
+		}
+	}
+}
+
+func TestRateLimiterWaitCancel(t *testing.T) {
+	l := newRateLimiter(t, 1, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next token is a second away.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("cancelled Wait took %v", d)
+	}
+
+	// The cancelled wait gave its token back, so the next write waits no
+	// longer than it would have.
+	if d := l.reserve(time.Now()); d > time.Second {
+		t.Errorf("write after a cancelled wait waits %v", d)
+	}
+}
+
+func TestClientWriteSeqContext(t *testing.T) {
+	conn := &bufConn{}
+	l := newRateLimiter(t, 1, 1)
+	c, err := ClientConfig{RateLimiters: []*RateLimiter{l}}.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(0x0a, false, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	n := conn.buf.Len()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.WriteSeqContext(ctx, c.NextSeq(), 0x0a, false, []byte("{}")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want Canceled", err)
+	}
+	if conn.buf.Len() != n {
+		t.Error("wrote after the context was cancelled")
+	}
+}
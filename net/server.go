@@ -0,0 +1,235 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Command number of status pushes.
+const cmdStatus = 0x08
+
+// A ServerConfig holds configuration for the device side of connections,
+// as used by emulators and proxies. It may be reused for multiple
+// connections.
+type ServerConfig struct {
+	// Key is the device's local key. Without one, only unencrypted
+	// messages can be read and written.
+	Key string
+
+	// Version is the protocol version to speak: Version31 or Version33.
+	// Empty means Version31.
+	Version string
+
+	// Hooks observe each connection's frames.
+	Hooks Hooks
+
+	// MaxPayloadSize limits the size of frame payloads read, as for
+	// ClientConfig.
+	MaxPayloadSize int
+}
+
+// Listen listens for client connections on a TCP address.
+func (sc ServerConfig) Listen(addr string) (*Server, error) {
+	if _, _, err := setup(sc.Version, sc.Key); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Listen: %w", err)
+	}
+	return &Server{listener: l, config: sc}, nil
+}
+
+// NewConn returns a Conn speaking the device side of the protocol over an
+// existing connection.
+func (sc ServerConfig) NewConn(conn net.Conn) (*Conn, error) {
+	version, cipher, err := setup(sc.Version, sc.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn, cipher: cipher, version: version, hooks: sc.Hooks, maxRead: sc.MaxPayloadSize}, nil
+}
+
+// A Server accepts client connections.
+type Server struct {
+	listener net.Listener
+	config   ServerConfig
+}
+
+// Accept waits for and returns the next client connection.
+func (s *Server) Accept() (*Conn, error) {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return nil, fmt.Errorf("Accept: %w", err)
+	}
+	return s.config.NewConn(conn)
+}
+
+// Addr returns the address the Server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops listening. Accepted Conns are not closed.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// A Conn is the device side of a client connection: it reads requests and
+// writes replies and pushes, en/decrypting them as a device of its version
+// would.
+type Conn struct {
+	conn    net.Conn
+	cipher  *Cipher
+	version string
+	hooks   Hooks
+	maxRead int
+
+	// Protects `conn` from multiple writers.
+	mu sync.Mutex
+}
+
+// Close closes the connection and wipes its copy of the key.
+func (c *Conn) Close() error {
+	err := c.conn.Close()
+	if c.cipher != nil {
+		c.cipher.Close()
+	}
+	return err
+}
+
+// RemoteAddr returns the client's address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Read reads a request from the client, decrypting its payload if needed.
+// It is *not* safe to call from multiple goroutines.
+func (c *Conn) Read() (*Frame, error) {
+	f := &Frame{}
+	err := f.DecodeLimit(c.conn, payloadLimit(c.maxRead))
+	if err != nil {
+		return nil, fmt.Errorf("DecodeFrame: %w", err)
+	}
+
+	raw := f.Payload
+	if c.version == Version33 {
+		data := f.Payload
+		if bytes.HasPrefix(data, []byte(Version33)) && len(data) >= v33HeaderSize {
+			data = data[v33HeaderSize:]
+		}
+		if len(data) > 0 {
+			if c.cipher == nil {
+				err = ErrNoKey
+			} else {
+				f.Payload, err = c.cipher.Open(data)
+			}
+		}
+	} else if detectEncryption(f.Payload) {
+		if c.cipher == nil {
+			err = ErrNoKey
+		} else {
+			f.Payload, err = c.cipher.Decrypt(f.Payload)
+		}
+	}
+	if c.hooks.Received != nil {
+		c.hooks.Received(&Frame{Seq: f.Seq, Cmd: f.Cmd, Payload: raw}, f.Payload, err)
+	}
+	if err == ErrNoKey {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt: %w", err)
+	}
+	return f, nil
+}
+
+// Reply answers a request with a return code and payload, which may be nil,
+// a []byte, or a JSON-serializable object. Protocol 3.3 payloads are
+// encrypted; 3.1 replies are sent in plaintext. Reply may be called from
+// multiple goroutines.
+func (c *Conn) Reply(req *Frame, code uint32, payload interface{}) error {
+	data, err := marshalPayload(payload)
+	if err != nil {
+		return err
+	}
+	plaintext := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(plaintext, code)
+	plaintext = append(plaintext, data...)
+
+	out := plaintext
+	if c.version == Version33 && len(data) > 0 {
+		if c.cipher == nil {
+			return ErrNoKey
+		}
+		if out, err = c.cipher.sealAppend(plaintext[:4:4], data); err != nil {
+			return err
+		}
+	}
+	return c.write(&Frame{Seq: req.Seq, Cmd: req.Cmd, Payload: out}, plaintext)
+}
+
+// Push sends an unsolicited status message, such as {"devId": ..., "dps":
+// {...}}, with sequence number 0 so it can't be mistaken for a reply. The
+// payload is a []byte or JSON-serializable object, and is always encrypted.
+// Push may be called from multiple goroutines.
+func (c *Conn) Push(payload interface{}) error {
+	data, err := marshalPayload(payload)
+	if err != nil {
+		return err
+	}
+	if c.cipher == nil {
+		return ErrNoKey
+	}
+	var out []byte
+	if c.version == Version33 {
+		// Return code and version header
+		out = make([]byte, 4+v33HeaderSize, 4+v33HeaderSize+len(data)+16)
+		copy(out[4:], Version33)
+		out, err = c.cipher.sealAppend(out, data)
+	} else {
+		out, err = c.cipher.encryptAppend(nil, data)
+	}
+	if err != nil {
+		return err
+	}
+	return c.write(&Frame{Cmd: cmdStatus, Payload: out}, data)
+}
+
+// WriteFrame writes a frame as is, without encryption. WriteFrame may be
+// called from multiple goroutines.
+func (c *Conn) WriteFrame(f *Frame) error {
+	return c.write(f, f.Payload)
+}
+
+func (c *Conn) write(f *Frame, plaintext []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := f.Encode(c.conn); err != nil {
+		return fmt.Errorf("frame Encode: %w", err)
+	}
+	if c.hooks.Sent != nil {
+		c.hooks.Sent(f, plaintext)
+	}
+	return nil
+}
+
+// Return a payload given as []byte as is, or marshal it as JSON. Nil is an
+// empty payload.
+func marshalPayload(payload interface{}) ([]byte, error) {
+	if payload == nil {
+		return nil, nil
+	}
+	if data, ok := payload.([]byte); ok {
+		return data, nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload Marshal: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,81 @@
+package net
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConn(t *testing.T) {
+	for _, v := range []string{Version31, Version33} {
+		t.Run(v, func(t *testing.T) {
+			clientConn, deviceConn := net.Pipe()
+			defer clientConn.Close()
+			defer deviceConn.Close()
+			version, cipher, err := setup(v, string(testKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &Client{conn: clientConn, cipher: cipher, version: version}
+			d, err := ServerConfig{Key: string(testKey), Version: v}.NewConn(deviceConn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, cmd := range []uint32{0x07, 0x0a} {
+				go c.Write(cmd, true, []byte(testJSON))
+				req, err := d.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if req.Cmd != cmd || string(req.Payload) != testJSON {
+					t.Errorf("Read = %d %q", req.Cmd, req.Payload)
+				}
+
+				go d.Reply(req, 0, []byte(testJSON))
+				res, err := c.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Seq != req.Seq || string(res.Payload) != string(testPayload) {
+					t.Errorf("reply = %d %q", res.Seq, res.Payload)
+				}
+			}
+
+			go d.Push([]byte(testJSON))
+			res, err := c.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload := string(res.Payload)
+			if v == Version33 {
+				payload = payload[4:] // return code
+			}
+			if res.Seq != 0 || res.Cmd != 0x08 || payload != testJSON {
+				t.Errorf("push = %d %d %q", res.Seq, res.Cmd, res.Payload)
+			}
+		})
+	}
+}
+
+func TestConnReplyError(t *testing.T) {
+	clientConn, deviceConn := net.Pipe()
+	defer clientConn.Close()
+	defer deviceConn.Close()
+	c := &Client{conn: clientConn, version: Version31}
+	d, err := ServerConfig{}.NewConn(deviceConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go d.Reply(&Frame{Seq: 3, Cmd: 0x07}, 1, []byte("error msg"))
+	res, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if re, ok := res.Err().(ResponseError); !ok || re.Code != 1 || re.Message != "error msg" {
+		t.Errorf("Err() = %v", res.Err())
+	}
+	if err := d.Push([]byte(testJSON)); err != ErrNoKey {
+		t.Errorf("Push without key = %v", err)
+	}
+}
@@ -2,15 +2,51 @@ package net
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 )
 
 const (
 	StatusPort = 6666
 	ClientPort = 6668
+
+	// Protocol 3.3 devices broadcast encrypted status messages to this port.
+	EncryptedStatusPort = 6667
+
+	// Protocol 3.5 devices broadcast status messages in AES-GCM "6699"
+	// frames to this port, which the vendor app also uses.
+	AppStatusPort = 7000
+)
+
+// 6699 frames: <prefix> <reserved> <seq> <cmd> <length> <iv> <ciphertext>
+// <tag> <suffix>, with the header after the prefix as additional data.
+const (
+	gcmPrefixValue = 0x6699
+	gcmSuffixValue = 0x9966
+	gcmHeaderSize  = 18
+	gcmNonceSize   = 12
+)
+
+// BroadcastKey is the well-known key encrypting status broadcasts from
+// protocol 3.3 and later devices.
+var BroadcastKey = md5.Sum([]byte("yeahyeahyeahyeah"))
+
+// ErrInvalidStatus is returned for broadcasts whose Status fails Validate,
+// or that claim an IP other than the one they were sent from, wrapped with
+// the offending field; check for it with errors.Is.
+var ErrInvalidStatus = errors.New("invalid status")
+
+// Limits on Status fields, which come from unauthenticated broadcasts.
+const (
+	maxStatusIDLength      = 64
+	maxStatusVersionLength = 8
 )
 
 // A Status message is read from a UDP broadcast by a device.
@@ -27,26 +63,127 @@ type Status struct {
 
 // Build a ClientConfig from the Status.
 func (s *Status) ClientConfig() ClientConfig {
-	// TODO: check Version?
 	return ClientConfig{
-		Addr: fmt.Sprintf("%s:%d", s.IP, ClientPort),
+		Addr:    fmt.Sprintf("%s:%d", s.IP, ClientPort),
+		Version: s.Version,
 	}
 }
 
+// Validate returns an error wrapping ErrInvalidStatus unless the Status is
+// plausibly from a device: its IP is a unicast IPv4 address, its GatewayID
+// and ProductKey are short and alphanumeric, and its Version, if any, looks
+// like "3.3". Broadcasts are unauthenticated, so anyone on the network can
+// send one; status listeners only return valid Statuses.
+func (s *Status) Validate() error {
+	ip := net.ParseIP(s.IP)
+	if ip == nil || ip.To4() == nil || ip.IsUnspecified() || ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+		return fmt.Errorf("%w: ip %q", ErrInvalidStatus, s.IP)
+	}
+	if s.GatewayID == "" || !isStatusID(s.GatewayID) {
+		return fmt.Errorf("%w: gwId %q", ErrInvalidStatus, s.GatewayID)
+	}
+	if !isStatusID(s.ProductKey) {
+		return fmt.Errorf("%w: productKey %q", ErrInvalidStatus, s.ProductKey)
+	}
+	if !isStatusVersion(s.Version) {
+		return fmt.Errorf("%w: version %q", ErrInvalidStatus, s.Version)
+	}
+	return nil
+}
+
+// Report whether s is a plausible ID: short, and only letters, digits, '-',
+// and '_'.
+func isStatusID(s string) bool {
+	if len(s) > maxStatusIDLength {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Report whether s is empty or a version like "3.3": digits with single dots
+// between them.
+func isStatusVersion(s string) bool {
+	if len(s) > maxStatusVersionLength {
+		return false
+	}
+	digit := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case '0' <= c && c <= '9':
+			digit = true
+		case c == '.' && digit:
+			digit = false
+		default:
+			return false
+		}
+	}
+	return s == "" || digit
+}
+
+// CheckStrict returns an error wrapping ErrInsecure if the broadcast
+// announces a device that ClientConfig.Strict would refuse: one not
+// encrypting its messages, or using a protocol version below Version33.
+func (s *Status) CheckStrict() error {
+	if !s.Encrypt {
+		return fmt.Errorf("%w: %s announces encrypt: false", ErrInsecure, s.GatewayID)
+	}
+	if s.Version != Version33 {
+		return fmt.Errorf("%w: %s announces protocol version %q; %s is required", ErrInsecure, s.GatewayID, s.Version, Version33)
+	}
+	return nil
+}
+
 // A UDP broadcast listener that decodes Status messages.
 type statusListener struct {
-	conn net.PacketConn
-	buf  []byte
+	conn   net.PacketConn
+	buf    []byte
+	cipher *Cipher
+
+	// Reused between packets.
+	reader    bytes.Reader
+	frame     Frame
+	gcm       cipher.AEAD
+	plaintext []byte
 }
 
 // NewStatusListener makes a broadcast status message listener.
 func NewStatusListener() (*statusListener, error) {
-	conn, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", StatusPort))
+	return newStatusListener(StatusPort, nil)
+}
+
+// NewEncryptedStatusListener makes a listener for the encrypted status
+// broadcasts of protocol 3.3 devices.
+func NewEncryptedStatusListener() (*statusListener, error) {
+	return newEncryptedStatusListener(EncryptedStatusPort)
+}
+
+// NewAppStatusListener makes a listener for the status broadcasts of protocol
+// 3.5 devices.
+func NewAppStatusListener() (*statusListener, error) {
+	return newEncryptedStatusListener(AppStatusPort)
+}
+
+func newEncryptedStatusListener(port int) (*statusListener, error) {
+	cipher, err := NewCipher(BroadcastKey[:])
 	if err != nil {
-		return nil, fmt.Errorf("ListenPacket: %v", err)
+		return nil, fmt.Errorf("NewCipher: %w", err)
+	}
+	return newStatusListener(port, cipher)
+}
+
+func newStatusListener(port int, cipher *Cipher) (*statusListener, error) {
+	conn, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("ListenPacket: %w", err)
 	}
 	buf := make([]byte, maxPacketSize)
-	return &statusListener{conn: conn, buf: buf}, nil
+	return &statusListener{conn: conn, buf: buf, cipher: cipher}, nil
 }
 
 // Close closes the status listener.
@@ -56,26 +193,141 @@ func (l *statusListener) Close() error {
 
 // ReadStatus blocks on reading UDP broadcast packet and decodes a Status from it.
 func (l *statusListener) ReadStatus() (*Status, error) {
-	n, _, err := l.conn.ReadFrom(l.buf)
-	if err != nil {
-		return nil, fmt.Errorf("ReadFrom: %v", err)
+	status := &Status{}
+	if err := l.ReadStatusInto(status); err != nil {
+		return nil, err
 	}
+	return status, nil
+}
 
-	f, err := DecodeFrame(bytes.NewReader(l.buf[:n]))
+// ReadStatusInto is like ReadStatus but decodes into an existing Status,
+// reusing the listener's buffers so that a long-running scan doesn't allocate
+// per broadcast. The Status is reset before decoding.
+//
+// Broadcasts that fail Status.Validate, or that claim an IP other than the
+// one they were sent from, are rejected with ErrInvalidStatus, so another
+// host can't direct connections to itself by announcing a device's ID.
+// Broadcasts sent from this host are exempt from the address check, since
+// an emulated device may announce the address it listens on.
+func (l *statusListener) ReadStatusInto(status *Status) error {
+	n, addr, err := l.conn.ReadFrom(l.buf)
 	if err != nil {
-		return nil, fmt.Errorf("DecodeFrame: %v", err)
+		return fmt.Errorf("ReadFrom: %w", err)
+	}
+
+	if err := l.decodeInto(l.buf[:n], status); err != nil {
+		return err
+	}
+	if udp, ok := addr.(*net.UDPAddr); ok && !udp.IP.Equal(net.ParseIP(status.IP)) && !isLocalIP(udp.IP) {
+		return fmt.Errorf("%w: broadcast from %s claims ip %s", ErrInvalidStatus, udp.IP, status.IP)
 	}
+	return nil
+}
+
+var (
+	localIPsOnce sync.Once
+	localIPs     []net.IP
+)
 
-	if len(f.Payload) < 4 {
-		return nil, fmt.Errorf("payload too small; %d < 4", len(f.Payload))
+// Report whether ip is one of this host's addresses.
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
 	}
-	if returnCode := binary.BigEndian.Uint32(f.Payload); returnCode != 0 {
-		return nil, fmt.Errorf("nonzero return code %d", returnCode)
+	localIPsOnce.Do(func() {
+		addrs, _ := net.InterfaceAddrs()
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				localIPs = append(localIPs, ipNet.IP)
+			}
+		}
+	})
+	for _, local := range localIPs {
+		if local.Equal(ip) {
+			return true
+		}
 	}
+	return false
+}
 
+// Decode a Status from a broadcast packet.
+func (l *statusListener) decode(packet []byte) (*Status, error) {
 	status := &Status{}
-	if err := json.Unmarshal(f.Payload[4:], status); err != nil {
-		return nil, fmt.Errorf("Unmarshal: %v", err)
+	if err := l.decodeInto(packet, status); err != nil {
+		return nil, err
 	}
 	return status, nil
 }
+
+func (l *statusListener) decodeInto(packet []byte, status *Status) error {
+	if len(packet) >= 4 && binary.BigEndian.Uint32(packet) == gcmPrefixValue {
+		return l.decodeGCMStatus(packet, status)
+	}
+
+	l.reader.Reset(packet)
+	if err := l.frame.Decode(&l.reader); err != nil {
+		return fmt.Errorf("DecodeFrame: %w", err)
+	}
+
+	payload := l.frame.Payload
+	if len(payload) < 4 {
+		return fmt.Errorf("payload too small; %d < 4", len(payload))
+	}
+	if returnCode := binary.BigEndian.Uint32(payload); returnCode != 0 {
+		return fmt.Errorf("nonzero return code %d", returnCode)
+	}
+
+	data := payload[4:]
+	if l.cipher != nil {
+		var err error
+		if data, err = l.cipher.Open(data); err != nil {
+			return fmt.Errorf("Decrypt: %w", err)
+		}
+	}
+	return unmarshalStatus(data, status)
+}
+
+// Decode a Status from a 6699 frame.
+func (l *statusListener) decodeGCMStatus(packet []byte, status *Status) error {
+	if len(packet) < gcmHeaderSize {
+		return fmt.Errorf("packet too small; %d < %d", len(packet), gcmHeaderSize)
+	}
+	length := int(binary.BigEndian.Uint32(packet[14:]))
+	body := packet[gcmHeaderSize:]
+	if length > len(body) || length < gcmNonceSize+16+4 {
+		return fmt.Errorf("bad length %d", length)
+	}
+	body = body[:length]
+	if suffix := binary.BigEndian.Uint32(body[length-4:]); suffix != gcmSuffixValue {
+		return fmt.Errorf("bad suffix %x", suffix)
+	}
+
+	if l.gcm == nil {
+		block, err := aes.NewCipher(BroadcastKey[:])
+		if err != nil {
+			return fmt.Errorf("NewCipher: %w", err)
+		}
+		if l.gcm, err = cipher.NewGCM(block); err != nil {
+			return fmt.Errorf("NewGCM: %w", err)
+		}
+	}
+	nonce, sealed := body[:gcmNonceSize], body[gcmNonceSize:length-4]
+	data, err := l.gcm.Open(l.plaintext[:0], nonce, sealed, packet[4:gcmHeaderSize])
+	if err != nil {
+		return fmt.Errorf("Decrypt: %w", err)
+	}
+	l.plaintext = data
+	if len(data) >= 4 && data[0] == 0 {
+		// Return code
+		data = data[4:]
+	}
+	return unmarshalStatus(data, status)
+}
+
+func unmarshalStatus(data []byte, status *Status) error {
+	*status = Status{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return fmt.Errorf("Unmarshal: %w", err)
+	}
+	return status.Validate()
+}
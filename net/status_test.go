@@ -0,0 +1,235 @@
+package net
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+)
+
+var testStatusJSON = []byte(`{"ip":"10.0.0.2","gwId":"abc","version":"3.3"}`)
+
+func statusPacket(t testing.TB, payload []byte) []byte {
+	var buf bytes.Buffer
+	f := &Frame{Cmd: 0x13, Payload: append([]byte{0, 0, 0, 0}, payload...)}
+	if err := f.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkStatus(t *testing.T, s *Status, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.IP != "10.0.0.2" || s.GatewayID != "abc" || s.Version != "3.3" {
+		t.Errorf("bad status %+v", s)
+	}
+}
+
+func TestDecodeStatus(t *testing.T) {
+	l := &statusListener{}
+	s, err := l.decode(statusPacket(t, testStatusJSON))
+	checkStatus(t, s, err)
+}
+
+func TestDecodeEncryptedStatus(t *testing.T) {
+	c, err := NewCipher(BroadcastKey[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &statusListener{cipher: c}
+	s, err := l.decode(statusPacket(t, c.Seal(testStatusJSON)))
+	checkStatus(t, s, err)
+}
+
+// Build a 6699 status packet.
+func gcmStatusPacket(t testing.TB, payload []byte) []byte {
+	block, err := aes.NewCipher(BroadcastKey[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcmNonceSize)
+	plaintext := append([]byte{0, 0, 0, 0}, payload...)
+	length := gcmNonceSize + len(plaintext) + gcm.Overhead() + 4
+
+	packet := make([]byte, gcmHeaderSize)
+	binary.BigEndian.PutUint32(packet, gcmPrefixValue)
+	binary.BigEndian.PutUint32(packet[10:], 0x13)
+	binary.BigEndian.PutUint32(packet[14:], uint32(length))
+	packet = append(packet, nonce...)
+	packet = gcm.Seal(packet, nonce, plaintext, packet[4:gcmHeaderSize])
+	return append(packet, 0, 0, 0x99, 0x66)
+}
+
+func TestDecodeGCMStatus(t *testing.T) {
+	packet := gcmStatusPacket(t, testStatusJSON)
+	l := &statusListener{}
+	s, err := l.decode(packet)
+	checkStatus(t, s, err)
+
+	packet[20] ^= 1
+	if _, err := l.decode(packet); err == nil {
+		t.Error("expected error for corrupted packet")
+	}
+}
+
+func TestDecodeStatusInto(t *testing.T) {
+	l := &statusListener{}
+	s := &Status{Active: 2, ProductKey: "stale"}
+	err := l.decodeInto(statusPacket(t, testStatusJSON), s)
+	checkStatus(t, s, err)
+	if s.Active != 0 || s.ProductKey != "" {
+		t.Errorf("status not reset: %+v", s)
+	}
+}
+
+func BenchmarkDecodeStatusInto(b *testing.B) {
+	var buf bytes.Buffer
+	f := &Frame{Cmd: 0x13, Payload: append([]byte{0, 0, 0, 0}, testStatusJSON...)}
+	if err := f.Encode(&buf); err != nil {
+		b.Fatal(err)
+	}
+	packet := buf.Bytes()
+	l := &statusListener{}
+	var s Status
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := l.decodeInto(packet, &s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStatusValidate(t *testing.T) {
+	for _, tc := range []struct {
+		status Status
+		ok     bool
+	}{
+		{Status{IP: "10.0.0.2", GatewayID: "abc", ProductKey: "key_1-x", Version: "3.3"}, true},
+		{Status{IP: "10.0.0.2", GatewayID: "abc"}, true},
+		{Status{IP: "", GatewayID: "abc"}, false},
+		{Status{IP: "example.com", GatewayID: "abc"}, false},
+		{Status{IP: "::1", GatewayID: "abc"}, false},
+		{Status{IP: "0.0.0.0", GatewayID: "abc"}, false},
+		{Status{IP: "255.255.255.255", GatewayID: "abc"}, false},
+		{Status{IP: "224.0.0.1", GatewayID: "abc"}, false},
+		{Status{IP: "10.0.0.2", GatewayID: ""}, false},
+		{Status{IP: "10.0.0.2", GatewayID: "a/../b"}, false},
+		{Status{IP: "10.0.0.2", GatewayID: strings.Repeat("a", 65)}, false},
+		{Status{IP: "10.0.0.2", GatewayID: "abc", ProductKey: "<script>"}, false},
+		{Status{IP: "10.0.0.2", GatewayID: "abc", Version: "3."}, false},
+		{Status{IP: "10.0.0.2", GatewayID: "abc", Version: "3..3"}, false},
+		{Status{IP: "10.0.0.2", GatewayID: "abc", Version: "v3.3"}, false},
+	} {
+		err := tc.status.Validate()
+		if (err == nil) != tc.ok || (err != nil && !errors.Is(err, ErrInvalidStatus)) {
+			t.Errorf("Validate(%+v) = %v", tc.status, err)
+		}
+	}
+}
+
+// A PacketConn reading one packet from a given address.
+type fakePacketConn struct {
+	net.PacketConn
+	packet []byte
+	addr   net.Addr
+}
+
+func (c *fakePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return copy(b, c.packet), c.addr, nil
+}
+
+func TestReadStatusSource(t *testing.T) {
+	packet := statusPacket(t, testStatusJSON)
+	for _, tc := range []struct {
+		from string
+		ok   bool
+	}{
+		{"10.0.0.2", true},
+		{"10.0.0.3", false},
+		{"127.0.0.1", true}, // this host
+	} {
+		conn := &fakePacketConn{packet: packet, addr: &net.UDPAddr{IP: net.ParseIP(tc.from), Port: StatusPort}}
+		l := &statusListener{conn: conn, buf: make([]byte, maxPacketSize)}
+		if _, err := l.ReadStatus(); (err == nil) != tc.ok {
+			t.Errorf("broadcast from %s: %v", tc.from, err)
+		}
+	}
+}
+
+// Decode randomly mutated broadcasts, checking that nothing panics and that
+// whatever decodes is valid. See FuzzStatus for coverage-guided fuzzing.
+func TestDecodeStatusMutations(t *testing.T) {
+	c, err := NewCipher(BroadcastKey[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeds := []struct {
+		packet []byte
+		cipher *Cipher
+	}{
+		{statusPacket(t, testStatusJSON), nil},
+		{statusPacket(t, c.Seal(testStatusJSON)), c},
+		{gcmStatusPacket(t, testStatusJSON), nil},
+	}
+	// A fixed seed keeps failures reproducible; FuzzDecodeStatus explores
+	// further.
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		s := seeds[i%len(seeds)]
+		packet := append([]byte(nil), s.packet...)
+		for n := rng.Intn(4) + 1; n > 0; n-- {
+			switch rng.Intn(3) {
+			case 0:
+				packet[rng.Intn(len(packet))] = byte(rng.Intn(256))
+			case 1:
+				packet = packet[:rng.Intn(len(packet))+1]
+			case 2:
+				at := rng.Intn(len(packet))
+				packet = append(packet[:at], append([]byte{byte(rng.Intn(256))}, packet[at:]...)...)
+			}
+		}
+		l := &statusListener{cipher: s.cipher}
+		status, err := l.decode(packet)
+		if err == nil {
+			if err := status.Validate(); err != nil {
+				t.Fatalf("decoded invalid status from %x: %v", packet, err)
+			}
+		}
+	}
+}
+
+// Status broadcasts are unauthenticated packets from anyone on the network,
+// so decoding must never panic or return a Status failing Validate:
+//
+//	go test -fuzz FuzzDecodeStatus ./net
+func FuzzDecodeStatus(f *testing.F) {
+	c, err := NewCipher(BroadcastKey[:])
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(statusPacket(f, testStatusJSON))
+	f.Add(statusPacket(f, c.Seal(testStatusJSON)))
+	f.Add(gcmStatusPacket(f, testStatusJSON))
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		for _, l := range []*statusListener{{}, {cipher: c}} {
+			status, err := l.decode(packet)
+			if err != nil {
+				continue
+			}
+			if err := status.Validate(); err != nil {
+				t.Fatalf("decoded invalid status from %x: %v", packet, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package net
+
+// A Tracer starts spans for distributed tracing. This package has no
+// dependency on a tracing library; an adapter implements Tracer with, for
+// example, an OpenTelemetry trace.Tracer, choosing each span's parent.
+type Tracer interface {
+	StartSpan(name string, attrs ...Attr) Span
+}
+
+// A Span is an operation started by a Tracer.
+type Span interface {
+	// SetAttrs adds attributes learned during the operation.
+	SetAttrs(attrs ...Attr)
+	// End ends the span, with the error that failed the operation, if any.
+	End(err error)
+}
+
+// An Attr is a span attribute. Values are strings, ints, or bools.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Span attribute keys.
+const (
+	AttrAddr        = "net.peer.name"
+	AttrDeviceID    = "tuya.device.id"
+	AttrVersion     = "tuya.version"
+	AttrCmd         = "tuya.cmd"
+	AttrRequestSize = "tuya.request.size"
+	AttrReplySize   = "tuya.reply.size"
+)
+
+// StartSpan starts a span with tracer, or returns a Span that does nothing
+// if tracer is nil.
+func StartSpan(tracer Tracer, name string, attrs ...Attr) Span {
+	if tracer == nil {
+		return nopSpan{}
+	}
+	return tracer.StartSpan(name, attrs...)
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttrs(...Attr) {}
+func (nopSpan) End(error)        {}
@@ -0,0 +1,60 @@
+package net
+
+import (
+	"sync"
+	"testing"
+)
+
+// A Tracer recording ended spans.
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+type testSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (t *testTracer) StartSpan(name string, attrs ...Attr) Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &testSpan{name: name, attrs: map[string]interface{}{}}
+	s.SetAttrs(attrs...)
+	t.spans = append(t.spans, s)
+	return s
+}
+
+func (s *testSpan) SetAttrs(attrs ...Attr) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *testSpan) End(err error) {
+	s.err = err
+	s.ended = true
+}
+
+func TestDialTrace(t *testing.T) {
+	tracer := &testTracer{}
+	_, err := ClientConfig{Addr: "127.0.0.1:1", Version: "9.9", Tracer: tracer}.Dial()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(tracer.spans) != 1 {
+		t.Fatalf("got %d spans", len(tracer.spans))
+	}
+	s := tracer.spans[0]
+	if s.name != "tuya.Dial" || !s.ended || s.err != err || s.attrs[AttrAddr] != "127.0.0.1:1" {
+		t.Errorf("span = %+v", s)
+	}
+}
+
+func TestStartSpanNil(t *testing.T) {
+	s := StartSpan(nil, "x")
+	s.SetAttrs(Attr{"k", 1})
+	s.End(nil)
+}
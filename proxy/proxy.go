@@ -0,0 +1,225 @@
+// Package proxy relays connections between a Tuya client, such as the vendor
+// app, and a device, reporting the frames in both directions, decrypted when
+// the device's key is known. It's the usual way to discover undocumented dps:
+// operate the device from the app and watch what's sent.
+//
+// Steering the client to the proxy, as by ARP spoofing or DNS, is left to the
+// user. Bytes are relayed unchanged, even when they can't be decoded.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	stdnet "net"
+	"sync"
+	"syscall"
+
+	"github.com/lann/tuya/net"
+)
+
+// A Proxy relays client connections to a device.
+type Proxy struct {
+	// Addr is the device's address, like "10.0.0.2:6668".
+	Addr string
+
+	// Key is the device's local key, used to decrypt frames for Hooks.
+	// Without it, encrypted payloads are reported as is.
+	Key string
+
+	// Version is the device's protocol version: net.Version31 or
+	// net.Version33. Empty means net.Version31.
+	Version string
+
+	// Hooks observe relayed frames: Sent is called with frames from the
+	// client and Received with frames from the device, each with the
+	// plaintext as its recipient would decrypt it. Frames from the client
+	// that fail to decrypt are given to Sent with their payload as the
+	// plaintext. Hooks may be called from multiple goroutines.
+	Hooks net.Hooks
+
+	// Logger receives messages about connections and frames that can't be
+	// decoded.
+	Logger net.Logger
+}
+
+// ListenAndServe listens on a TCP address, usually ":6668", and relays
+// connections to the device.
+func (p *Proxy) ListenAndServe(addr string) error {
+	l, err := stdnet.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Listen: %w", err)
+	}
+	defer l.Close()
+	return p.Serve(l)
+}
+
+// Serve relays connections accepted from l to the device until accepting
+// fails.
+func (p *Proxy) Serve(l stdnet.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("Accept: %w", err)
+		}
+		go func() {
+			if err := p.ServeConn(conn); err != nil {
+				p.logf(net.LevelWarn, "%s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// ServeConn connects to the device and relays between it and conn until
+// either closes its connection.
+func (p *Proxy) ServeConn(conn stdnet.Conn) error {
+	defer conn.Close()
+	devConn, err := stdnet.Dial("tcp", p.Addr)
+	if err != nil {
+		return fmt.Errorf("Dial: %w", err)
+	}
+	defer devConn.Close()
+	p.logf(net.LevelInfo, "relaying %s to %s", conn.RemoteAddr(), p.Addr)
+
+	// Frames are decoded as the other end would: the device's side of the
+	// protocol reads the client's frames, and a Client reads the device's.
+	var fromClient, fromDevice relay
+	server, err := net.ServerConfig{
+		Key:     p.Key,
+		Version: p.Version,
+		Hooks:   net.Hooks{Received: fromClient.hook(sentHook(p.Hooks.Sent))},
+	}.NewConn(fromClient.tee(conn, devConn))
+	if err != nil {
+		return err
+	}
+	client, err := net.ClientConfig{
+		Key:     p.Key,
+		Version: p.Version,
+		Hooks:   net.Hooks{Received: fromDevice.hook(p.Hooks.Received)},
+	}.NewClient(fromDevice.tee(devConn, conn))
+	if err != nil {
+		return err
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- fromClient.run(p, func() error {
+			_, err := server.Read()
+			return err
+		})
+	}()
+	go func() {
+		errc <- fromDevice.run(p, func() error {
+			_, err := client.Read()
+			return err
+		})
+	}()
+	// When either side is done, close both to stop the other.
+	err = <-errc
+	conn.Close()
+	devConn.Close()
+	<-errc
+	return err
+}
+
+// One direction of a relayed connection. Bytes are forwarded as they're read
+// for decoding, so decoding never changes what's sent.
+type relay struct {
+	src     io.Reader
+	dst     io.Writer
+	mu      sync.Mutex
+	decoded bool // whether the current frame has been decoded
+}
+
+// Return a Conn reading from src through the relay, which forwards to dst.
+func (r *relay) tee(src stdnet.Conn, dst io.Writer) stdnet.Conn {
+	r.src, r.dst = src, dst
+	return teeConn{src, io.TeeReader(src, dst)}
+}
+
+// Return a Received hook noting decoded frames and passing them on to fn.
+func (r *relay) hook(fn func(*net.Frame, []byte, error)) func(*net.Frame, []byte, error) {
+	return func(f *net.Frame, plaintext []byte, err error) {
+		r.mu.Lock()
+		r.decoded = true
+		r.mu.Unlock()
+		if fn != nil {
+			fn(f, plaintext, err)
+		}
+	}
+}
+
+// Adapt a Sent hook to report received frames, with their payloads as
+// plaintext if they fail to decrypt.
+func sentHook(sent func(*net.Frame, []byte)) func(*net.Frame, []byte, error) {
+	if sent == nil {
+		return nil
+	}
+	return func(f *net.Frame, plaintext []byte, err error) {
+		if err != nil {
+			plaintext = f.Payload
+		}
+		sent(f, plaintext)
+	}
+}
+
+// Read frames with read until the connection fails. Frames that fail to
+// decrypt are still relayed; after bytes that aren't a frame, the rest of
+// the stream is relayed undecoded.
+func (r *relay) run(p *Proxy, read func() error) error {
+	for {
+		r.mu.Lock()
+		r.decoded = false
+		r.mu.Unlock()
+		err := read()
+		if err == nil {
+			continue
+		}
+		r.mu.Lock()
+		decoded := r.decoded
+		r.mu.Unlock()
+		if decoded {
+			p.logf(net.LevelDebug, "relayed undecryptable frame: %v", err)
+			continue
+		}
+		if isClosed(err) {
+			return nil
+		}
+		p.logf(net.LevelWarn, "relaying undecoded: %v", err)
+		_, err = io.Copy(r.dst, r.src)
+		if isClosed(err) {
+			return nil
+		}
+		return err
+	}
+}
+
+// Report whether err is from a connection closing, which ends a relay
+// normally.
+func isClosed(err error) bool {
+	if err == nil {
+		return true
+	}
+	for _, cause := range []error{io.EOF, io.ErrUnexpectedEOF, stdnet.ErrClosed, syscall.EPIPE, syscall.ECONNRESET} {
+		if errors.Is(err, cause) {
+			return true
+		}
+	}
+	return false
+}
+
+// A Conn whose reads come from r.
+type teeConn struct {
+	stdnet.Conn
+	r io.Reader
+}
+
+func (c teeConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (p *Proxy) logf(level net.Level, format string, args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Logf(level, format, args...)
+	}
+}
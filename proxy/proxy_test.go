@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	stdnet "net"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/tuyatest"
+)
+
+const testKey = "0123456789abcdef"
+
+// Frames seen by a Proxy's hooks.
+type frames struct {
+	mu       sync.Mutex
+	sent     [][]byte
+	received [][]byte
+}
+
+func (fs *frames) hooks() net.Hooks {
+	return net.Hooks{
+		Sent: func(f *net.Frame, plaintext []byte) {
+			fs.mu.Lock()
+			defer fs.mu.Unlock()
+			fs.sent = append(fs.sent, append([]byte(nil), plaintext...))
+		},
+		Received: func(f *net.Frame, plaintext []byte, err error) {
+			fs.mu.Lock()
+			defer fs.mu.Unlock()
+			fs.received = append(fs.received, append([]byte(nil), plaintext...))
+		},
+	}
+}
+
+func contains(payloads [][]byte, s string) bool {
+	for _, p := range payloads {
+		if bytes.Contains(p, []byte(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestProxy(t *testing.T) {
+	for _, version := range []string{net.Version31, net.Version33} {
+		t.Run(version, func(t *testing.T) {
+			d, err := tuyatest.New("dev1", testKey, version, device.State{1: false})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Listen("127.0.0.1:0"); err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+
+			var seen frames
+			p := &Proxy{Addr: d.Addr(), Key: testKey, Version: version, Hooks: seen.hooks()}
+			l, err := stdnet.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			go p.Serve(l)
+
+			config := d.ClientConfig()
+			config.Addr = l.Addr().String()
+			client, err := config.Dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := device.NewManager("dev1", client)
+			defer m.Close()
+			if err := m.SetState(device.State{1: true}); err != nil {
+				t.Fatal(err)
+			}
+			state, err := m.GetState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state[1] != true {
+				t.Errorf("state = %v", state)
+			}
+
+			seen.mu.Lock()
+			defer seen.mu.Unlock()
+			if !contains(seen.sent, `"dps":{"1":true}`) {
+				t.Errorf("control request not seen decrypted: %q", seen.sent)
+			}
+			if !contains(seen.received, `"dps":{"1":true}`) {
+				t.Errorf("state reply not seen decrypted: %q", seen.received)
+			}
+		})
+	}
+}
+
+func TestProxyUndecodable(t *testing.T) {
+	// A "device" that echoes whatever it's sent.
+	dl, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dl.Close()
+	go func() {
+		conn, err := dl.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	p := &Proxy{Addr: dl.Addr().String()}
+	clientConn, proxyConn := stdnet.Pipe()
+	defer clientConn.Close()
+	go p.ServeConn(proxyConn)
+
+	garbage := []byte("not a tuya frame at all")
+	if _, err := clientConn.Write(garbage); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(garbage))
+	for n := 0; n < len(got); {
+		m, err := clientConn.Read(got[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += m
+	}
+	if !bytes.Equal(got, garbage) {
+		t.Errorf("got %q, want %q", got, garbage)
+	}
+}
+
+func TestIsClosed(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, true},
+		{fmt.Errorf("header Read: %w", io.EOF), true},
+		{fmt.Errorf("ReadFrame: %w", &stdnet.OpError{Op: "read", Err: syscall.ECONNRESET}), true},
+		{&stdnet.OpError{Op: "write", Err: syscall.EPIPE}, true},
+		{fmt.Errorf("Read: %w", stdnet.ErrClosed), true},
+		{net.ErrTagVerification, false},
+		{errors.New("connection reset by peer"), false},
+	} {
+		if got := isClosed(tc.err); got != tc.want {
+			t.Errorf("isClosed(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Package record captures a Client's traffic to a file and plays it back.
+//
+// A Recorder's Hooks write each frame a Client sends and receives, with its
+// time and plaintext, as JSON lines after a Header line. Recordings can be
+// replayed to a client under test by Replay, acting as the device, or
+// re-sent to a device by Resend, to reproduce intermittent device bugs.
+//
+// Recordings hold decrypted payloads but not keys.
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// The Header Format of recordings written by this package.
+const Format = "tuya-record/1"
+
+// Entry directions.
+const (
+	Sent     = "sent"
+	Received = "received"
+)
+
+// A Header is the first line of a recording.
+type Header struct {
+	Format  string    `json:"format"`
+	ID      string    `json:"id,omitempty"`
+	Addr    string    `json:"addr,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Start   time.Time `json:"start"`
+}
+
+// An Entry is a recorded frame.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Dir is Sent or Received, from the Client's side.
+	Dir string `json:"dir"`
+	Seq uint32 `json:"seq"`
+	Cmd uint32 `json:"cmd"`
+	// Payload is as sent over the wire.
+	Payload []byte `json:"payload"`
+	// Plaintext is the payload before encryption or after decryption; it's
+	// omitted if the same as Payload or decryption failed.
+	Plaintext []byte `json:"plaintext,omitempty"`
+	// Error is why a received payload couldn't be decrypted.
+	Error string `json:"error,omitempty"`
+}
+
+// Frame returns the Entry as a Frame as it was sent over the wire.
+func (e *Entry) Frame() *net.Frame {
+	return &net.Frame{Seq: e.Seq, Cmd: e.Cmd, Payload: e.Payload}
+}
+
+// Encrypted reports whether the payload was encrypted.
+func (e *Entry) Encrypted() bool {
+	return e.Plaintext != nil || e.Error != ""
+}
+
+// Decrypted returns the payload's plaintext.
+func (e *Entry) Decrypted() []byte {
+	if e.Plaintext != nil {
+		return e.Plaintext
+	}
+	return e.Payload
+}
+
+// A Recorder writes a recording.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder writes a header to w and returns a Recorder writing entries
+// after it. The Header's Format and Start are filled in.
+func NewRecorder(w io.Writer, h Header) (*Recorder, error) {
+	h.Format = Format
+	if h.Start.IsZero() {
+		h.Start = time.Now()
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(h); err != nil {
+		return nil, err
+	}
+	return &Recorder{enc: enc}, nil
+}
+
+// Hooks returns Hooks recording a Client's frames; see net.ClientConfig.
+func (r *Recorder) Hooks() net.Hooks {
+	return net.Hooks{
+		Sent: func(f *net.Frame, plaintext []byte) {
+			r.record(Sent, f, plaintext, nil)
+		},
+		Received: func(f *net.Frame, plaintext []byte, err error) {
+			r.record(Received, f, plaintext, err)
+		},
+	}
+}
+
+// Err returns the first error writing the recording, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(dir string, f *net.Frame, plaintext []byte, err error) {
+	e := Entry{Time: time.Now(), Dir: dir, Seq: f.Seq, Cmd: f.Cmd, Payload: f.Payload}
+	if err != nil {
+		e.Error = err.Error()
+	} else if !bytes.Equal(plaintext, f.Payload) {
+		e.Plaintext = plaintext
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(e)
+	}
+}
+
+// Read reads a recording.
+func Read(r io.Reader) (*Header, []*Entry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errors.New("empty recording")
+	}
+	var h Header
+	if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+		return nil, nil, fmt.Errorf("header: %v", err)
+	}
+	if h.Format != Format {
+		return nil, nil, fmt.Errorf("unknown format %q", h.Format)
+	}
+	var entries []*Entry
+	for line := 2; scanner.Scan(); line++ {
+		e := &Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return &h, entries, nil
+}
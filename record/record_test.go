@@ -0,0 +1,144 @@
+package record
+
+import (
+	"bytes"
+	stdnet "net"
+	"testing"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/tuyatest"
+)
+
+const testKey = "0123456789abcdef"
+
+// Run a session against a manager: a query, then turning dp 1 on.
+func session(t *testing.T, m *device.Manager) {
+	state, err := m.GetState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state[1] != false {
+		t.Errorf("GetState = %v", state)
+	}
+	watch, stop := m.Watch()
+	defer stop()
+	if err := m.SetState(device.State{1: true}); err != nil {
+		t.Fatal(err)
+	}
+	if push := <-watch; push[1] != true {
+		t.Errorf("push = %v", push)
+	}
+}
+
+// Record a session with an emulated device.
+func recordSession(t *testing.T, version string) ([]byte, *tuyatest.Device) {
+	d, err := tuyatest.New("dev1", testKey, version, device.State{1: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf, Header{ID: "dev1", Version: version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn, deviceConn := stdnet.Pipe()
+	go d.Serve(deviceConn)
+	config := d.ClientConfig()
+	config.Hooks = rec.Hooks()
+	client, err := config.NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := device.NewManager("dev1", client)
+	session(t, m)
+	m.Close()
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), d
+}
+
+func TestRecordReplay(t *testing.T) {
+	for _, version := range []string{net.Version31, net.Version33} {
+		t.Run(version, func(t *testing.T) {
+			data, d := recordSession(t, version)
+			d.Close()
+			h, entries, err := Read(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.ID != "dev1" || h.Version != version {
+				t.Errorf("header = %+v", h)
+			}
+			if len(entries) != 5 {
+				t.Fatalf("got %d entries, want 5:\n%s", len(entries), data)
+			}
+			if e := entries[2]; e.Dir != Sent || e.Cmd != 0x07 || !e.Encrypted() || !bytes.Contains(e.Decrypted(), []byte(`"dps":{"1":true}`)) {
+				t.Errorf("control entry = %+v", e)
+			}
+
+			// Replay the device's side to a new client.
+			clientConn, deviceConn := stdnet.Pipe()
+			conn, err := net.ServerConfig{Key: testKey, Version: version}.NewConn(deviceConn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error, 1)
+			go func() { done <- (&Replay{Entries: entries}).Serve(conn) }()
+			client, err := net.ClientConfig{Key: testKey, Version: version, Seq: func() uint32 { return 100 }}.NewClient(clientConn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := device.NewManager("dev1", client)
+			defer m.Close()
+			session(t, m)
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestResend(t *testing.T) {
+	data, d := recordSession(t, net.Version33)
+	defer d.Close()
+	d.Set(device.State{1: false})
+	_, entries, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, deviceConn := stdnet.Pipe()
+	go d.Serve(deviceConn)
+	client, err := d.ClientConfig().NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	go func() {
+		for {
+			if _, err := client.Read(); err != nil {
+				return
+			}
+		}
+	}()
+	if err := (&Replay{Entries: entries, Speed: 10}).Resend(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write(0x0a, false, map[string]string{"devId": "dev1"}); err != nil {
+		t.Fatal(err)
+	}
+	if d.State()[1] != true {
+		t.Errorf("device state = %v after resend", d.State())
+	}
+}
+
+func TestReadBadFormat(t *testing.T) {
+	if _, _, err := Read(bytes.NewReader([]byte(`{"format":"other"}`))); err == nil {
+		t.Error("expected error")
+	}
+	if _, _, err := Read(bytes.NewReader(nil)); err == nil {
+		t.Error("expected error for empty recording")
+	}
+}
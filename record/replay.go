@@ -0,0 +1,79 @@
+package record
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// A Replay plays back a recording's entries.
+type Replay struct {
+	Entries []*Entry
+
+	// Speed scales the recorded delays between frames; 2 plays back twice
+	// as fast. Zero sends frames without delay.
+	Speed float64
+}
+
+// Serve acts as the recorded device on conn: it expects the client under
+// test to send the recorded requests, in order, and answers them with the
+// recorded frames, renumbered to match the client's sequence numbers.
+// Recorded payloads are sent as they were over the wire, so the client must
+// use the recording's key. Serve returns when the recording ends, or with
+// an error if the client diverges from it.
+func (r *Replay) Serve(conn *net.Conn) error {
+	seqs := map[uint32]uint32{}
+	for i, e := range r.Entries {
+		switch e.Dir {
+		case Sent:
+			f, err := conn.Read()
+			if err != nil {
+				return fmt.Errorf("entry %d: %v", i, err)
+			}
+			if f.Cmd != e.Cmd {
+				return fmt.Errorf("entry %d: got cmd %#x, recorded %#x", i, f.Cmd, e.Cmd)
+			}
+			seqs[e.Seq] = f.Seq
+		case Received:
+			r.wait(i)
+			f := e.Frame()
+			if seq, ok := seqs[e.Seq]; ok && e.Seq != 0 {
+				f.Seq = seq
+			}
+			if err := conn.WriteFrame(f); err != nil {
+				return fmt.Errorf("entry %d: %v", i, err)
+			}
+		default:
+			return fmt.Errorf("entry %d: bad dir %q", i, e.Dir)
+		}
+	}
+	return nil
+}
+
+// Resend sends the recorded requests to a device with the recorded timing,
+// encrypting them with the client's key as they were originally. Replies
+// are left for the caller to Read.
+func (r *Replay) Resend(client *net.Client) error {
+	for i, e := range r.Entries {
+		if e.Dir != Sent {
+			continue
+		}
+		r.wait(i)
+		if _, err := client.Write(e.Cmd, e.Encrypted(), e.Decrypted()); err != nil {
+			return fmt.Errorf("entry %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Sleep for the recorded time between entry i and the one before it.
+func (r *Replay) wait(i int) {
+	if r.Speed <= 0 || i == 0 {
+		return
+	}
+	d := r.Entries[i].Time.Sub(r.Entries[i-1].Time)
+	if d > 0 {
+		time.Sleep(time.Duration(float64(d) / r.Speed))
+	}
+}
@@ -0,0 +1,450 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+// An expr is a compiled condition.
+type expr interface {
+	eval(env *env) (interface{}, error)
+}
+
+// Parse a condition, reporting syntax errors.
+func compile(src string) (expr, error) {
+	p := &parser{src: src}
+	p.next()
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("unexpected %q", p.tok)
+	}
+	return e, nil
+}
+
+// A recursive descent parser. tok is the current token, empty at the end of
+// input; kind is one of 'n' (number), 's' (string), 'i' (identifier), or 'o'
+// (operator or punctuation).
+type parser struct {
+	src  string
+	pos  int
+	tok  string
+	kind byte
+	err  error
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// Advance to the next token.
+func (p *parser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	p.tok, p.kind = "", 0
+	if p.pos >= len(p.src) {
+		return
+	}
+	start := p.pos
+	c := p.src[p.pos]
+	switch {
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.kind = 'n'
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		p.kind = 'i'
+	case c == '"':
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != '"' {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			p.err = p.errorf("unterminated string")
+			return
+		}
+		p.pos++
+		p.kind = 's'
+	default:
+		p.pos++
+		if p.pos < len(p.src) {
+			switch two := p.src[start : p.pos+1]; two {
+			case "==", "!=", "<=", ">=", "&&", "||":
+				p.pos++
+			}
+		}
+		p.kind = 'o'
+	}
+	p.tok = p.src[start:p.pos]
+}
+
+// Consume an operator if it's the current token.
+func (p *parser) accept(op string) bool {
+	if p.kind == 'o' && p.tok == op {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (expr, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") {
+		var right expr
+		right, err = p.and()
+		left = &logical{op: "||", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) and() (expr, error) {
+	left, err := p.compare()
+	for err == nil && p.accept("&&") {
+		var right expr
+		right, err = p.compare()
+		left = &logical{op: "&&", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) compare() (expr, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.tok; op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		if p.kind != 'o' {
+			break
+		}
+		p.next()
+		right, err := p.sum()
+		return &binary{op: op, left: left, right: right}, err
+	}
+	return left, nil
+}
+
+func (p *parser) sum() (expr, error) {
+	left, err := p.product()
+	for err == nil && p.kind == 'o' && (p.tok == "+" || p.tok == "-") {
+		op := p.tok
+		p.next()
+		var right expr
+		right, err = p.product()
+		left = &binary{op: op, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) product() (expr, error) {
+	left, err := p.unary()
+	for err == nil && p.kind == 'o' && (p.tok == "*" || p.tok == "/" || p.tok == "%") {
+		op := p.tok
+		p.next()
+		var right expr
+		right, err = p.unary()
+		left = &binary{op: op, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.accept("!") {
+		e, err := p.unary()
+		return &not{e}, err
+	}
+	if p.accept("-") {
+		e, err := p.unary()
+		return &binary{op: "-", left: literal{0.0}, right: e}, err
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	tok := p.tok
+	switch p.kind {
+	case 'n':
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %q", tok)
+		}
+		p.next()
+		return literal{f}, nil
+	case 's':
+		s, err := strconv.Unquote(tok)
+		if err != nil {
+			return nil, p.errorf("bad string %s", tok)
+		}
+		p.next()
+		return literal{s}, nil
+	case 'i':
+		p.next()
+		switch tok {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		if !p.accept("(") {
+			if _, ok := variables[tok]; !ok {
+				return nil, p.errorf("unknown variable %q", tok)
+			}
+			return variable(tok), nil
+		}
+		if tok != "dp" {
+			return nil, p.errorf("unknown function %q", tok)
+		}
+		var args []expr
+		for !p.accept(")") {
+			if len(args) > 0 && !p.accept(",") {
+				return nil, p.errorf("expected , or )")
+			}
+			arg, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		if len(args) < 1 || len(args) > 2 {
+			return nil, p.errorf("dp takes 1 or 2 arguments")
+		}
+		return &dpCall{args}, nil
+	case 'o':
+		if p.accept("(") {
+			e, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, p.errorf("expected )")
+			}
+			return e, nil
+		}
+		return nil, p.errorf("unexpected %q", tok)
+	}
+	return nil, p.errorf("unexpected end of condition")
+}
+
+type literal struct{ v interface{} }
+
+func (l literal) eval(*env) (interface{}, error) { return l.v, nil }
+
+// The variables a condition can use, with their descriptions.
+var variables = map[string]string{
+	"value":   "the triggering dp's new value",
+	"device":  "the triggering device's ID",
+	"hour":    "the local hour, 0-23",
+	"minute":  "the local minute, 0-59",
+	"weekday": "the local day of the week, 0 (Sunday) to 6",
+}
+
+type variable string
+
+func (v variable) eval(env *env) (interface{}, error) {
+	switch v {
+	case "value":
+		return env.value, nil
+	case "device":
+		return env.device, nil
+	case "hour":
+		return float64(env.now.Hour()), nil
+	case "minute":
+		return float64(env.now.Minute()), nil
+	default:
+		return float64(env.now.Weekday()), nil
+	}
+}
+
+// dp(n) is dp n of the triggering device; dp("name", n) is dp n of the named
+// device. Unknown dps are null.
+type dpCall struct{ args []expr }
+
+func (c *dpCall) eval(env *env) (interface{}, error) {
+	vals := make([]interface{}, len(c.args))
+	for i, arg := range c.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	device := env.device
+	if len(vals) == 2 {
+		s, ok := vals[0].(string)
+		if !ok {
+			return nil, errors.New("dp: device must be a string")
+		}
+		device = s
+	}
+	n, ok := vals[len(vals)-1].(float64)
+	if !ok || n < 0 || n != float64(uint32(n)) {
+		return nil, fmt.Errorf("dp: bad dp %v", vals[len(vals)-1])
+	}
+	if device == "" {
+		return nil, errors.New("dp: no triggering device")
+	}
+	return env.dp(device, uint32(n))
+}
+
+type not struct{ e expr }
+
+func (n *not) eval(env *env) (interface{}, error) {
+	v, err := n.e.eval(env)
+	return !truthy(v), err
+}
+
+// && and || short-circuit and produce bools.
+type logical struct {
+	op          string
+	left, right expr
+}
+
+func (l *logical) eval(env *env) (interface{}, error) {
+	v, err := l.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(v) == (l.op == "||") {
+		return truthy(v), nil
+	}
+	v, err = l.right.eval(env)
+	return truthy(v), err
+}
+
+type binary struct {
+	op          string
+	left, right expr
+}
+
+func (b *binary) eval(env *env) (interface{}, error) {
+	l, err := b.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := b.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	}
+
+	// Strings support + and ordering; everything else needs numbers.
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			switch b.op {
+			case "+":
+				return ls + rs, nil
+			case "<":
+				return ls < rs, nil
+			case "<=":
+				return ls <= rs, nil
+			case ">":
+				return ls > rs, nil
+			case ">=":
+				return ls >= rs, nil
+			}
+		}
+	}
+	lf, lok := l.(float64)
+	rf, rok := r.(float64)
+	if !lok || !rok {
+		// Comparisons with missing values are false rather than errors, so
+		// dp(1) > 5 is simply false until dp 1 is known.
+		switch b.op {
+		case "<", "<=", ">", ">=":
+			if l == nil || r == nil {
+				return false, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: can't apply to %s and %s", b.op, typeName(l), typeName(r))
+	}
+	switch b.op {
+	case "<":
+		return lf < rf, nil
+	case "<=":
+		return lf <= rf, nil
+	case ">":
+		return lf > rf, nil
+	case ">=":
+		return lf >= rf, nil
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/", "%":
+		if rf == 0 {
+			return nil, errors.New("division by zero")
+		}
+		if b.op == "%" {
+			return math.Mod(lf, rf), nil
+		}
+		return lf / rf, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", b.op)
+}
+
+// Compare values as JSON would: numbers by value, and other types only to
+// themselves.
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case nil, bool, float64, string:
+		return a == b
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// Report whether a value counts as true: false, null, 0, and "" don't.
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,77 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExpr(t *testing.T) {
+	states := map[string]map[uint32]interface{}{
+		"lamp":  {1: true, 20: 150.0},
+		"plug":  {1: false, 19: 12.5, 2: "auto"},
+		"empty": {},
+	}
+	env := &env{
+		now:    time.Date(2020, 1, 5, 18, 30, 0, 0, time.Local), // a Sunday
+		device: "lamp",
+		value:  150.0,
+		dp: func(device string, dp uint32) (interface{}, error) {
+			state, ok := states[device]
+			if !ok {
+				return nil, errors.New("unknown device")
+			}
+			return state[dp], nil
+		},
+	}
+	for _, tc := range []struct {
+		src  string
+		want interface{}
+	}{
+		{`true`, true},
+		{`1 + 2 * 3`, 7.0},
+		{`(1 + 2) * 3`, 9.0},
+		{`-value / 2`, -75.0},
+		{`7 % 4`, 3.0},
+		{`7.5 % 2`, 1.5},
+		{`dp("plug", 19) % 0.5`, 0.0},
+		{`dp(1) && !dp("plug", 1)`, true},
+		{`dp(20) > 100 && dp("plug", 19) <= 12.5`, true},
+		{`dp("plug", 2) == "auto"`, true},
+		{`"a" + "b" < "b"`, true},
+		{`dp("empty", 1) == null`, true},
+		{`dp("empty", 1) > 5`, false},
+		{`hour >= 18 && weekday == 0 && minute == 30`, true},
+		{`device == "lamp"`, true},
+		{`false || 0`, false},
+		{`1 == 1 && 2 != 2`, false},
+	} {
+		e, err := compile(tc.src)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+			continue
+		}
+		got, err := e.eval(env)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+		} else if got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.src, got, tc.want)
+		}
+	}
+
+	for _, src := range []string{``, `1 +`, `(1`, `"abc`, `foo`, `bar(1)`, `dp()`, `dp(1, 2, 3)`, `1 2`} {
+		if _, err := compile(src); err == nil {
+			t.Errorf("%s: expected syntax error", src)
+		}
+	}
+	for _, src := range []string{`1 / 0`, `1 % 0`, `"a" - 1`, `dp("missing", 1)`, `dp(-1)`} {
+		e, err := compile(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if _, err := e.eval(env); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+// Package rules runs automations for devices served by a server.Server.
+//
+// A Rule has a trigger, an optional condition, and actions:
+//
+//	{
+//	  "name": "porch light at dusk",
+//	  "when": {"device": "porch-sensor", "dp": 101},
+//	  "if": "value < 20 && dp(\"porch-light\", 1) == false",
+//	  "then": [{"device": "porch-light", "set": {"1": true}}]
+//	}
+//
+// Triggers are one of:
+//
+//	{"device": "lamp", "dp": 1}     a dp's value changes; device and dp are
+//	                                optional, matching any
+//	{"device": "lamp", "offline": "5m"}
+//	                                a device has been unreachable for a while
+//	{"every": "1h"}                 an interval passes
+//	{"cron": "30 7 * * 1-5"}        a cron schedule matches, in local time
+//	{"sun": "sunset", "offset": "-30m"}
+//	                                the sun rises or sets, at the Engine's
+//	                                Latitude and Longitude
+//
+// Scheduled triggers (every, cron, and sun) may also have a device, which
+// dp(n) in the condition refers to. With a StatePath, their last run times
+// are saved, so intervals survive restarts and runs missed by less than an
+// hour while stopped are made up.
+//
+// Actions either set dps, {"device": "lamp", "set": {"1": true}}, or POST the
+// rule name and triggering event as JSON, {"webhook": "https://..."}. A rule
+// with several actions works as a scene.
+//
+// Conditions are expressions over numbers, strings, bools, and null, with
+// the operators ! && || == != < <= > >= + - * / % and parentheses. dp(n) is
+// dp n of the triggering device and dp("name", n) is dp n of any device, by
+// name or ID. The variables value (the triggering dp's value), device (the
+// triggering device's ID), hour, minute, and weekday (0 is Sunday) are also
+// available. A rule whose condition isn't truthy doesn't run; false, null, 0,
+// and "" aren't truthy.
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/internal/httpclient"
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/server"
+)
+
+// How often offline and scheduled triggers are checked.
+const tickInterval = time.Second
+
+// A Duration is a time.Duration written in JSON as a string like "1h30m".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5m\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// A Rule is an automation; see the package docs.
+type Rule struct {
+	Name string   `json:"name,omitempty"`
+	When Trigger  `json:"when"`
+	If   string   `json:"if,omitempty"`
+	Then []Action `json:"then"`
+}
+
+// A Trigger is when a Rule runs.
+type Trigger struct {
+	// Device is a device ID or name. It's required for Offline; for
+	// scheduled triggers it sets the device dp(n) refers to.
+	Device  string   `json:"device,omitempty"`
+	DP      uint32   `json:"dp,omitempty"`
+	Offline Duration `json:"offline,omitempty"`
+	Every   Duration `json:"every,omitempty"`
+	Cron    string   `json:"cron,omitempty"`
+	// Sun is "sunrise" or "sunset", optionally shifted by Offset.
+	Sun    string   `json:"sun,omitempty"`
+	Offset Duration `json:"offset,omitempty"`
+}
+
+// An Action is something a Rule does: either set a device's dps or call a
+// webhook.
+type Action struct {
+	Device  string       `json:"device,omitempty"`
+	Set     device.State `json:"set,omitempty"`
+	Webhook string       `json:"webhook,omitempty"`
+}
+
+// A compiled Rule and its trigger state.
+type rule struct {
+	Rule
+	cond expr
+	cron *cron
+
+	next     time.Time // when a scheduled trigger is next due
+	last     time.Time // when a scheduled trigger last ran
+	downFrom time.Time // when an Offline trigger's device was last seen up
+	fired    bool      // whether an Offline trigger fired for this outage
+}
+
+// An Engine runs Rules. The Server must be started for change triggers to
+// fire.
+type Engine struct {
+	Server *server.Server
+
+	// Client is used for webhooks. Nil means a default client with a timeout.
+	Client *http.Client
+
+	// Latitude and Longitude, in degrees, locate sun triggers.
+	Latitude, Longitude float64
+
+	// StatePath, if set, is a file to save scheduled rules' last run times
+	// in.
+	StatePath string
+
+	// Logger, if not nil, receives errors from conditions and actions, and
+	// from saving StatePath.
+	Logger net.Logger
+
+	rules  []*rule
+	states map[string]device.State // known dps by device ID; only used by Run
+
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+func (e *Engine) logf(level net.Level, format string, args ...interface{}) {
+	if e.Logger != nil {
+		e.Logger.Logf(level, format, args...)
+	}
+}
+
+// New returns an Engine for rules, or an error if any rule is invalid.
+func New(s *server.Server, rules []Rule) (*Engine, error) {
+	e := &Engine{Server: s, states: make(map[string]device.State)}
+	names := make(map[string]bool)
+	for i, r := range rules {
+		name := r.Name
+		if name == "" {
+			name = "rule " + strconv.Itoa(i+1)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("%s: duplicate name", name)
+		}
+		names[name] = true
+		c, err := compileRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		c.Name = name
+		e.rules = append(e.rules, c)
+	}
+	return e, nil
+}
+
+func compileRule(r Rule) (*rule, error) {
+	c := &rule{Rule: r}
+	switch {
+	case r.When.Every < 0 || r.When.Offline < 0:
+		return nil, errors.New("durations must be positive")
+	case r.When.Offline > 0 && r.When.Device == "":
+		return nil, errors.New("offline needs a device")
+	}
+	if err := c.compileSchedule(); err != nil {
+		return nil, err
+	}
+	if r.If != "" {
+		cond, err := compile(r.If)
+		if err != nil {
+			return nil, fmt.Errorf("if: %v", err)
+		}
+		c.cond = cond
+	}
+	if len(r.Then) == 0 {
+		return nil, errors.New("no actions")
+	}
+	for i, a := range r.Then {
+		if (a.Webhook == "") == (a.Device == "" && a.Set == nil) {
+			return nil, fmt.Errorf("action %d: needs either webhook or device and set", i+1)
+		}
+		if a.Webhook == "" && (a.Device == "" || len(a.Set) == 0) {
+			return nil, fmt.Errorf("action %d: needs both device and set", i+1)
+		}
+	}
+	return c, nil
+}
+
+// Run runs rules until the Engine is closed.
+func (e *Engine) Run() error {
+	for _, r := range e.rules {
+		if r.When.Sun != "" && e.Latitude == 0 && e.Longitude == 0 {
+			return fmt.Errorf("%s: sun triggers need a Latitude and Longitude", r.Name)
+		}
+	}
+	e.mu.Lock()
+	if e.stop == nil {
+		e.stop = make(chan struct{})
+	}
+	stop := e.stop
+	e.mu.Unlock()
+
+	events, unsubscribe := e.Server.Subscribe()
+	defer unsubscribe()
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	e.initSchedules(time.Now())
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			e.handle(ev)
+		case now := <-ticker.C:
+			e.tick(now)
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// Close stops Run.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stop == nil {
+		e.stop = make(chan struct{})
+	}
+	select {
+	case <-e.stop:
+	default:
+		close(e.stop)
+	}
+	return nil
+}
+
+// Run change triggers matching an event. Triggers fire only for dps whose
+// values differ from those last seen.
+func (e *Engine) handle(ev server.Event) {
+	old := e.states[ev.ID]
+	changed := make(device.State)
+	for dp, v := range ev.DPs {
+		if prev, ok := old[dp]; !ok || !equal(prev, v) {
+			changed[dp] = v
+		}
+	}
+	if old == nil {
+		old = make(device.State)
+		e.states[ev.ID] = old
+	}
+	for dp, v := range ev.DPs {
+		old[dp] = v
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	for _, r := range e.rules {
+		w := r.When
+		if r.scheduled() || w.Offline > 0 {
+			continue
+		}
+		if w.Device != "" && w.Device != ev.ID && w.Device != ev.Name {
+			continue
+		}
+		env := e.env(ev.ID, ev.Time)
+		if w.DP != 0 {
+			v, ok := changed[w.DP]
+			if !ok {
+				continue
+			}
+			env.value = v
+		}
+		e.fire(r, env, server.Event{Time: ev.Time, ID: ev.ID, Name: ev.Name, DPs: changed})
+	}
+}
+
+// Run due schedule and offline triggers.
+func (e *Engine) tick(now time.Time) {
+	ran := false
+	for _, r := range e.rules {
+		w := r.When
+		switch {
+		case r.scheduled():
+			if r.next.IsZero() || now.Before(r.next) {
+				continue
+			}
+			r.last = now
+			r.next = e.nextRun(r, now)
+			ran = true
+			id := ""
+			if w.Device != "" {
+				id = e.resolve(w.Device)
+			}
+			e.fire(r, e.env(id, now), server.Event{Time: now, ID: id})
+
+		case w.Offline > 0:
+			id := e.resolve(w.Device)
+			if e.Server.Fleet.Connected(id) || r.downFrom.IsZero() {
+				r.downFrom = now
+				r.fired = false
+				continue
+			}
+			if r.fired || now.Sub(r.downFrom) < time.Duration(w.Offline) {
+				continue
+			}
+			r.fired = true
+			e.fire(r, e.env(id, now), server.Event{Time: now, ID: id, Name: w.Device})
+		}
+	}
+	if ran {
+		if err := e.saveSchedules(); err != nil {
+			e.logf(net.LevelError, "rules: %v", err)
+		}
+	}
+}
+
+// Return the ID of a device given its ID or name. Unknown devices are
+// returned as is.
+func (e *Engine) resolve(idOrName string) string {
+	for _, dev := range e.Server.Devices() {
+		if dev.ID == idOrName || dev.Name == idOrName {
+			return dev.ID
+		}
+	}
+	return idOrName
+}
+
+// What a condition is evaluated against.
+type env struct {
+	now    time.Time
+	device string
+	value  interface{}
+	dp     func(device string, dp uint32) (interface{}, error)
+}
+
+func (e *Engine) env(id string, now time.Time) *env {
+	return &env{now: now.Local(), device: id, dp: e.dp}
+}
+
+// Return a device's dp value, querying the device if nothing is known of it
+// yet.
+func (e *Engine) dp(idOrName string, dp uint32) (interface{}, error) {
+	id := e.resolve(idOrName)
+	state, ok := e.states[id]
+	if !ok {
+		var err error
+		state, err = e.Server.GetState(id)
+		if err != nil {
+			return nil, err
+		}
+		e.states[id] = state
+	}
+	return state[dp], nil
+}
+
+// Check a rule's condition and, if it holds, run its actions in the
+// background.
+func (e *Engine) fire(r *rule, env *env, ev server.Event) {
+	if r.cond != nil {
+		v, err := r.cond.eval(env)
+		if err != nil {
+			e.logf(net.LevelError, "rules: %s: if: %v", r.Name, err)
+			return
+		}
+		if !truthy(v) {
+			return
+		}
+	}
+	go func() {
+		for _, a := range r.Then {
+			if err := e.do(r, a, ev); err != nil {
+				e.logf(net.LevelError, "rules: %s: %v", r.Name, err)
+			}
+		}
+	}()
+}
+
+// The body POSTed to webhooks.
+type webhookEvent struct {
+	Rule string `json:"rule"`
+	server.Event
+}
+
+// Run an action.
+func (e *Engine) do(r *rule, a Action, ev server.Event) error {
+	if a.Webhook == "" {
+		return e.Server.SetState(a.Device, a.Set)
+	}
+	body, err := json.Marshal(webhookEvent{r.Name, ev})
+	if err != nil {
+		return err
+	}
+	res, err := httpclient.Or(e.Client).Post(a.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", a.Webhook, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package rules
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/server"
+)
+
+func TestEngine(t *testing.T) {
+	calls := make(chan webhookEvent, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev webhookEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		calls <- ev
+	}))
+	defer ts.Close()
+
+	var rules []Rule
+	err := json.NewDecoder(strings.NewReader(`[
+		{"name": "hot", "when": {"device": "sensor", "dp": 2}, "if": "value > 30", "then": [{"webhook": "` + ts.URL + `"}]},
+		{"name": "hourly", "when": {"every": "1h"}, "then": [{"webhook": "` + ts.URL + `"}]}
+	]`)).Decode(&rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New()
+	defer s.Close()
+	e, err := New(s, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(rule string) {
+		t.Helper()
+		select {
+		case ev := <-calls:
+			if ev.Rule != rule {
+				t.Errorf("got %q, want %q", ev.Rule, rule)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no webhook call for %q", rule)
+		}
+	}
+	now := time.Now()
+	e.handle(server.Event{Time: now, ID: "abc", Name: "sensor", DPs: device.State{2: 25.0}})
+	e.handle(server.Event{Time: now, ID: "abc", Name: "sensor", DPs: device.State{2: 35.0}})
+	expect("hot")
+	// Unchanged values and other devices don't trigger.
+	e.handle(server.Event{Time: now, ID: "abc", Name: "sensor", DPs: device.State{2: 35.0}})
+	e.handle(server.Event{Time: now, ID: "def", Name: "other", DPs: device.State{2: 40.0}})
+
+	e.initSchedules(now)
+	e.tick(now)
+	e.tick(now.Add(time.Hour))
+	expect("hourly")
+	select {
+	case ev := <-calls:
+		t.Errorf("unexpected call %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	s := server.New()
+	defer s.Close()
+	set := []Action{{Device: "lamp", Set: device.State{1: true}}}
+	for _, r := range []Rule{
+		{When: Trigger{Device: "lamp"}},
+		{When: Trigger{Offline: Duration(time.Minute)}, Then: set},
+		{When: Trigger{Every: Duration(time.Minute), DP: 1}, Then: set},
+		{If: "1 +", Then: set},
+		{Then: []Action{{Device: "lamp"}}},
+		{Then: []Action{{Webhook: "http://x", Device: "lamp", Set: device.State{1: true}}}},
+	} {
+		if _, err := New(s, []Rule{r}); err == nil {
+			t.Errorf("%+v: expected error", r)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// Scheduled runs missed by less than this while the Engine was stopped are
+// made up when it starts.
+const missedGrace = time.Hour
+
+// A cron schedule: the allowed minutes, hours, days of the month, months,
+// and days of the week, as bit sets.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// Whether the day of the month and week were restricted; if both are,
+	// either may match, as in crontab(5).
+	domStar, dowStar bool
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse a five field cron expression: minute, hour, day of month, month,
+// and day of week (0 or 7 is Sunday). Fields are *, numbers, ranges like
+// 1-5, lists like 1,3,5, and steps like */15 or 8-18/2.
+func parseCron(s string) (*cron, error) {
+	if alias, ok := cronAliases[s]; ok {
+		s = alias
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: want 5 fields", s)
+	}
+	c := &cron{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	for i, f := range []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	} {
+		set, err := parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %v", s, err)
+		}
+		*f.set = set
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Return the first matching minute after t, or the zero time if there's none
+// within five years (like February 30th).
+func (c *cron) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Return the next sunrise or sunset after t at a location, or the zero time
+// if there's none within a year, as near the poles.
+func nextSun(event string, t time.Time, lat, lon float64, offset time.Duration) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	for i := 0; i < 367; i++ {
+		rise, set, ok := sunTimes(day.AddDate(0, 0, i), lat, lon)
+		if !ok {
+			continue
+		}
+		at := rise
+		if event == "sunset" {
+			at = set
+		}
+		if at = at.Add(offset); at.After(t) {
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+// Calculate sunrise and sunset on a UTC day with the sunrise equation,
+// accurate to a minute or two. ok is false if the sun doesn't rise or set.
+func sunTimes(day time.Time, lat, lon float64) (rise, set time.Time, ok bool) {
+	const rad = math.Pi / 180
+	julian := float64(day.Unix())/86400 + 2440587.5
+	n := math.Floor(julian - 2451545 + 0.0008 + 0.5)
+	meanSolarNoon := n - lon/360
+	m := math.Mod(357.5291+0.98560028*meanSolarNoon, 360)
+	center := 1.9148*math.Sin(m*rad) + 0.02*math.Sin(2*m*rad) + 0.0003*math.Sin(3*m*rad)
+	lambda := math.Mod(m+center+180+102.9372, 360)
+	transit := 2451545 + meanSolarNoon + 0.0053*math.Sin(m*rad) - 0.0069*math.Sin(2*lambda*rad)
+	sinDecl := math.Sin(lambda*rad) * math.Sin(23.4397*rad)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	cosHour := (math.Sin(-0.833*rad) - math.Sin(lat*rad)*sinDecl) / (math.Cos(lat*rad) * cosDecl)
+	if cosHour < -1 || cosHour > 1 {
+		return rise, set, false
+	}
+	hour := math.Acos(cosHour) / rad / 360
+	toTime := func(j float64) time.Time {
+		return time.Unix(0, int64((j-2440587.5)*86400*1e9))
+	}
+	return toTime(transit - hour), toTime(transit + hour), true
+}
+
+// Return when a scheduled rule next runs after t, or the zero time if never.
+func (e *Engine) nextRun(r *rule, t time.Time) time.Time {
+	w := r.When
+	switch {
+	case w.Every > 0:
+		return t.Add(time.Duration(w.Every))
+	case r.cron != nil:
+		return r.cron.next(t.In(time.Local))
+	default:
+		return nextSun(w.Sun, t, e.Latitude, e.Longitude, time.Duration(w.Offset))
+	}
+}
+
+// Set each scheduled rule's first run. With saved state, intervals continue
+// from their last run, and runs missed within missedGrace happen now.
+func (e *Engine) initSchedules(now time.Time) {
+	last := make(map[string]time.Time)
+	if e.StatePath != "" {
+		data, err := ioutil.ReadFile(e.StatePath)
+		if err == nil {
+			err = json.Unmarshal(data, &last)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			e.logf(net.LevelError, "rules: %s: %v", e.StatePath, err)
+		}
+	}
+	for _, r := range e.rules {
+		if !r.scheduled() {
+			continue
+		}
+		r.last = last[r.Name]
+		r.next = e.nextRun(r, now)
+		if r.last.IsZero() {
+			continue
+		}
+		if missed := e.nextRun(r, r.last); !missed.IsZero() && missed.Before(now) &&
+			(r.When.Every > 0 || now.Sub(missed) < missedGrace) {
+			r.next = now
+		} else if r.When.Every > 0 {
+			r.next = missed
+		}
+	}
+}
+
+// Save scheduled rules' last run times.
+func (e *Engine) saveSchedules() error {
+	if e.StatePath == "" {
+		return nil
+	}
+	last := make(map[string]time.Time)
+	for _, r := range e.rules {
+		if !r.last.IsZero() {
+			last[r.Name] = r.last
+		}
+	}
+	data, err := json.MarshalIndent(last, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := e.StatePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.StatePath)
+}
+
+// Check a rule's schedule fields.
+func (r *rule) compileSchedule() error {
+	w := r.When
+	kinds := 0
+	for _, set := range []bool{w.Every > 0, w.Cron != "", w.Sun != "", w.Offline > 0} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		return errors.New("only one of every, cron, sun, and offline may be set")
+	}
+	if kinds > 0 && w.DP != 0 {
+		return errors.New("dp can only be combined with device")
+	}
+	if w.Offset != 0 && w.Sun == "" {
+		return errors.New("offset needs sun")
+	}
+	switch w.Sun {
+	case "", "sunrise", "sunset":
+	default:
+		return fmt.Errorf("sun must be sunrise or sunset, not %q", w.Sun)
+	}
+	if w.Cron != "" {
+		c, err := parseCron(w.Cron)
+		if err != nil {
+			return err
+		}
+		r.cron = c
+	}
+	return nil
+}
+
+// Report whether a rule runs on a schedule.
+func (r *rule) scheduled() bool {
+	return r.When.Every > 0 || r.When.Cron != "" || r.When.Sun != ""
+}
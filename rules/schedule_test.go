@@ -0,0 +1,131 @@
+package rules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/server"
+)
+
+func TestCron(t *testing.T) {
+	at := func(s string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	// 2020-01-03 is a Friday.
+	for _, tc := range []struct {
+		expr, from, want string
+	}{
+		{"* * * * *", "2020-01-03 10:00", "2020-01-03 10:01"},
+		{"30 7 * * 1-5", "2020-01-03 08:00", "2020-01-06 07:30"},
+		{"*/15 * * * *", "2020-01-03 10:01", "2020-01-03 10:15"},
+		{"0 8-18/2 * * *", "2020-01-03 18:00", "2020-01-04 08:00"},
+		{"0 0 1,15 * *", "2020-01-03 00:00", "2020-01-15 00:00"},
+		{"0 12 13 * 5", "2020-01-03 13:00", "2020-01-10 12:00"}, // day of month or week
+		{"0 0 * * 7", "2020-01-03 00:00", "2020-01-05 00:00"},
+		{"@monthly", "2020-01-03 00:00", "2020-02-01 00:00"},
+		{"0 0 29 2 *", "2020-03-01 00:00", "2024-02-29 00:00"},
+	} {
+		c, err := parseCron(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if got := c.next(at(tc.from)); !got.Equal(at(tc.want)) {
+			t.Errorf("%s after %s = %v, want %s", tc.expr, tc.from, got, tc.want)
+		}
+	}
+	// Hours step in local time, not UTC, so half-hour zones still match.
+	kolkata := time.FixedZone("IST", 5*3600+30*60)
+	c, _ := parseCron("0 12 * * *")
+	from := time.Date(2020, 1, 1, 9, 15, 0, 0, kolkata)
+	if got, want := c.next(from), time.Date(2020, 1, 1, 12, 0, 0, 0, kolkata); !got.Equal(want) {
+		t.Errorf("next(%v) = %v, want %v", from, got, want)
+	}
+	if c, _ := parseCron("0 0 30 2 *"); !c.next(at("2020-01-01 00:00")).IsZero() {
+		t.Error("February 30th matched")
+	}
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+	}
+}
+
+func TestSunTimes(t *testing.T) {
+	// London on the summer solstice: sunrise 03:43 and sunset 20:21 UTC.
+	from := time.Date(2020, 6, 21, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		event string
+		want  time.Time
+	}{
+		{"sunrise", time.Date(2020, 6, 21, 3, 43, 0, 0, time.UTC)},
+		{"sunset", time.Date(2020, 6, 21, 20, 21, 0, 0, time.UTC)},
+	} {
+		got := nextSun(tc.event, from, 51.5, -0.13, 0)
+		if d := got.Sub(tc.want); d < -2*time.Minute || d > 2*time.Minute {
+			t.Errorf("%s = %v, want %v", tc.event, got, tc.want)
+		}
+	}
+	// The sun doesn't set at the North Pole until the equinox.
+	if got := nextSun("sunset", from, 89, 0, 0); got.Month() != time.September && got.Month() != time.October {
+		t.Errorf("polar sunset = %v", got)
+	}
+}
+
+func TestSchedulePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := server.New()
+	defer s.Close()
+	// The conditions are false so the actions don't run.
+	set := []Action{{Device: "x", Set: device.State{1: true}}}
+	rules := []Rule{
+		{Name: "interval", When: Trigger{Every: Duration(time.Hour)}, If: "false", Then: set},
+		{Name: "nightly", When: Trigger{Cron: "0 0 * * *"}, If: "false", Then: set},
+	}
+	newEngine := func() *Engine {
+		e, err := New(s, rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.StatePath = filepath.Join(dir, "state.json")
+		return e
+	}
+
+	midnight := time.Date(2020, 1, 3, 0, 0, 0, 0, time.Local)
+	e := newEngine()
+	e.initSchedules(midnight.Add(-time.Minute))
+	e.tick(midnight)
+	e.tick(midnight.Add(59 * time.Minute))
+	if !e.rules[1].last.Equal(midnight) {
+		t.Fatalf("nightly didn't run: %+v", e.rules[1])
+	}
+
+	// Restarting continues the interval.
+	e = newEngine()
+	e.initSchedules(midnight.Add(90 * time.Minute))
+	if want := midnight.Add(119 * time.Minute); !e.rules[0].next.Equal(want) {
+		t.Errorf("interval next = %v, want %v", e.rules[0].next, want)
+	}
+
+	// A run missed while stopped is made up within the grace period.
+	e.initSchedules(midnight.Add(24*time.Hour + 10*time.Minute))
+	if want := midnight.Add(24*time.Hour + 10*time.Minute); !e.rules[1].next.Equal(want) {
+		t.Errorf("nightly next = %v, want %v", e.rules[1].next, want)
+	}
+	e.initSchedules(midnight.Add(26 * time.Hour))
+	if want := midnight.Add(48 * time.Hour); !e.rules[1].next.Equal(want) {
+		t.Errorf("nightly next = %v, want %v", e.rules[1].next, want)
+	}
+}
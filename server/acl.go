@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/lann/tuya/device"
+)
+
+// An ACL limits the devices and dps a client may read and write. A nil *ACL
+// allows everything; an empty one allows nothing.
+type ACL struct {
+	Rules []Rule `json:"rules"`
+}
+
+// A Rule grants access to a device's dps.
+type Rule struct {
+	// Device is a device ID or name, or "*" for every device.
+	Device string `json:"device"`
+
+	// DPs, if not empty, limits the rule to these dps.
+	DPs []uint32 `json:"dps,omitempty"`
+
+	// Write allows setting the dps as well as reading them.
+	Write bool `json:"write,omitempty"`
+}
+
+func (r Rule) matches(dev Device) bool {
+	return r.Device == "*" || r.Device == dev.ID || (dev.Name != "" && r.Device == dev.Name)
+}
+
+// Report whether the rule covers dp, or the whole device if dp is 0.
+func (r Rule) covers(dp uint32) bool {
+	if len(r.DPs) == 0 {
+		return true
+	}
+	if dp == 0 {
+		return false
+	}
+	for _, d := range r.DPs {
+		if d == dp {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRead reports whether the ACL allows reading a dp of a device, or any
+// of its dps if dp is 0.
+func (a *ACL) CanRead(dev Device, dp uint32) bool {
+	if a == nil {
+		return true
+	}
+	for _, r := range a.Rules {
+		if r.matches(dev) && (dp == 0 || r.covers(dp)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanWrite reports whether the ACL allows setting a dp of a device.
+func (a *ACL) CanWrite(dev Device, dp uint32) bool {
+	if a == nil {
+		return true
+	}
+	for _, r := range a.Rules {
+		if r.Write && r.matches(dev) && r.covers(dp) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the dps of state the ACL allows reading. Unless the ACL is
+// nil, the result is a copy.
+func (a *ACL) Filter(dev Device, state device.State) device.State {
+	if a == nil {
+		return state
+	}
+	filtered := make(device.State, len(state))
+	for dp, v := range state {
+		if a.CanRead(dev, dp) {
+			filtered[dp] = v
+		}
+	}
+	return filtered
+}
+
+// Return an event with only the dps the ACL allows reading, and whether any
+// are left.
+func (a *ACL) filterEvent(e Event) (Event, bool) {
+	if a == nil {
+		return e, true
+	}
+	e.DPs = a.Filter(Device{ID: e.ID, Name: e.Name}, e.DPs)
+	return e, len(e.DPs) > 0
+}
+
+// Return an error unless the ACL allows reading the device.
+func (a *ACL) checkRead(dev *Device) error {
+	if !a.CanRead(*dev, 0) {
+		return errorf(http.StatusForbidden, "not allowed to read %s", dev.ID)
+	}
+	return nil
+}
+
+// Return an error unless the ACL allows setting every dp of state.
+func (a *ACL) checkWrite(dev *Device, state device.State) error {
+	for dp := range state {
+		if !a.CanWrite(*dev, dp) {
+			return errorf(http.StatusForbidden, "not allowed to set dp %d of %s", dp, dev.ID)
+		}
+	}
+	return nil
+}
+
+// Return an error unless the ACL is unrestricted, as needed to add, remove,
+// and rekey devices.
+func (a *ACL) checkAdmin() error {
+	if a != nil {
+		return errorf(http.StatusForbidden, "not allowed to administer devices")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+)
+
+func TestACL(t *testing.T) {
+	lamp := Device{ID: "a", Name: "lamp"}
+	lock := Device{ID: "b", Name: "lock"}
+	acl := &ACL{Rules: []Rule{
+		{Device: "lamp", Write: true},
+		{Device: "b", DPs: []uint32{2}},
+	}}
+	for _, tc := range []struct {
+		dev         Device
+		dp          uint32
+		read, write bool
+	}{
+		{lamp, 0, true, false},
+		{lamp, 1, true, true},
+		{lock, 0, true, false},
+		{lock, 1, false, false},
+		{lock, 2, true, false},
+	} {
+		if got := acl.CanRead(tc.dev, tc.dp); got != tc.read {
+			t.Errorf("CanRead(%s, %d) = %v", tc.dev.Name, tc.dp, got)
+		}
+		if tc.dp != 0 {
+			if got := acl.CanWrite(tc.dev, tc.dp); got != tc.write {
+				t.Errorf("CanWrite(%s, %d) = %v", tc.dev.Name, tc.dp, got)
+			}
+		}
+	}
+	if got := acl.Filter(lock, device.State{1: true, 2: 5.0}); len(got) != 1 || got[2] != 5.0 {
+		t.Errorf("Filter = %v", got)
+	}
+	var none *ACL
+	if !none.CanWrite(lock, 1) {
+		t.Error("nil ACL should allow everything")
+	}
+	if (&ACL{}).CanRead(lamp, 0) {
+		t.Error("empty ACL should allow nothing")
+	}
+}
+
+func TestServerACL(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Fleet.Close()
+	s.AddDevice(Device{ID: "lockid", Name: "lock"}, net.ClientConfig{Addr: d.l.Addr().String(), Key: testKey})
+	s.Tokens = []string{"admin"}
+	s.ACLs = map[string]ACL{"guest": {Rules: []Rule{{Device: "lamp", Write: true}}}}
+
+	for _, tc := range []struct {
+		method, path, token, body string
+		code                      int
+	}{
+		{"GET", "/devices/lamp/state", "guest", "", http.StatusOK},
+		{"PUT", "/devices/lamp/state", "guest", `{"1": true}`, http.StatusOK},
+		{"GET", "/devices/lock/state", "guest", "", http.StatusNotFound},
+		{"POST", "/devices/lock/dps/1", "guest", "true", http.StatusNotFound},
+		{"DELETE", "/devices/lamp", "guest", "", http.StatusForbidden},
+		{"GET", "/metrics", "guest", "", http.StatusForbidden},
+		{"GET", "/devices/lock/state", "admin", "", http.StatusOK},
+		{"GET", "/devices", "other", "", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s as %s: got %d, want %d: %s", tc.method, tc.path, tc.token, w.Code, tc.code, w.Body)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/devices", nil)
+	r.Header.Set("Authorization", "Bearer guest")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if body := w.Body.String(); strings.Contains(body, "lock") || !strings.Contains(body, "lamp") {
+		t.Errorf("guest device list: %s", body)
+	}
+
+	s.ACLs["guest"] = ACL{Rules: []Rule{{Device: "lamp", DPs: []uint32{2}, Write: true}}}
+	code, _ := request(t, s, "PUT", "/devices/lamp/state", `{"1": true}`)
+	if code != http.StatusUnauthorized {
+		t.Errorf("no token: got %d", code)
+	}
+	r = httptest.NewRequest("PUT", "/devices/lamp/state", strings.NewReader(`{"1": true, "2": 3}`))
+	r.Header.Set("Authorization", "Bearer guest")
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("setting a dp outside the ACL: got %d", w.Code)
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	stdnet "net"
+	"net/http"
+	"strconv"
+
+	"github.com/lann/tuya/net"
+)
+
+// A DeviceConfig is a device's connection details, as added through the API
+// and saved to a Store.
+type DeviceConfig struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// IP is an IP address or host name. It's optional; without it the
+	// device is found by its broadcast, which requires the Fleet to have a
+	// Registry.
+	IP      string `json:"ip,omitempty"`
+	Key     string `json:"key"`
+	Version string `json:"version,omitempty"`
+}
+
+// ClientConfig returns the device's net.ClientConfig.
+func (c DeviceConfig) ClientConfig() net.ClientConfig {
+	config := net.ClientConfig{Key: c.Key, Version: c.Version}
+	if c.IP != "" {
+		config.Addr = stdnet.JoinHostPort(c.IP, strconv.Itoa(net.ClientPort))
+	}
+	return config
+}
+
+func (c DeviceConfig) validate() error {
+	if c.ID == "" {
+		return errorf(http.StatusBadRequest, "id is required")
+	}
+	if c.Key != "" && len(c.Key) != 16 {
+		return errorf(http.StatusBadRequest, "key must be 16 bytes")
+	}
+	switch c.Version {
+	case "", net.Version31, net.Version33:
+	default:
+		return errorf(http.StatusBadRequest, "unsupported version %q", c.Version)
+	}
+	return nil
+}
+
+// A Store persists changes made to a Server's devices through its API.
+type Store interface {
+	// PutDevice saves a device, replacing any with the same ID.
+	PutDevice(config DeviceConfig) error
+	// DeleteDevice removes a device.
+	DeleteDevice(id string) error
+}
+
+// POST /devices: add or replace a device.
+func (s *Server) putDevice(config DeviceConfig) (interface{}, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.Key == "" {
+		return nil, errorf(http.StatusBadRequest, "key is required")
+	}
+	if dev, ok := s.lookup(config.Name); ok && config.Name != "" && dev.ID != config.ID {
+		return nil, errorf(http.StatusConflict, "name %q is taken by %s", config.Name, dev.ID)
+	}
+	if dev, ok := s.lookup(config.ID); ok && dev.ID != config.ID {
+		return nil, errorf(http.StatusConflict, "id %q is another device's name", config.ID)
+	}
+	if s.Store != nil {
+		if err := s.Store.PutDevice(config); err != nil {
+			return nil, errorf(http.StatusInternalServerError, "save device: %v", err)
+		}
+	}
+	if err := s.AddDeviceConfig(config); err != nil {
+		return nil, err
+	}
+	return deviceResponse{Device: Device{ID: config.ID, Name: config.Name}}, nil
+}
+
+// DELETE /devices/{id}
+func (s *Server) deleteDevice(dev *Device) (interface{}, error) {
+	if s.Store != nil {
+		if err := s.Store.DeleteDevice(dev.ID); err != nil {
+			return nil, errorf(http.StatusInternalServerError, "delete device: %v", err)
+		}
+	}
+	s.RemoveDevice(dev.ID)
+	return deviceResponse{Device: *dev}, nil
+}
+
+// PUT /devices/{id}/key: replace a device's key, reconnecting with it.
+func (s *Server) rotateKey(dev *Device, key string) (interface{}, error) {
+	s.mu.Lock()
+	config, ok := s.configs[dev.ID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errorf(http.StatusConflict, "device %s wasn't added through the API or with AddDeviceConfig", dev.ID)
+	}
+	config.Key = key
+	if _, err := s.putDevice(config); err != nil {
+		return nil, err
+	}
+	return deviceResponse{Device: *dev}, nil
+}
+
+// AddDeviceConfig adds or updates a device from a DeviceConfig, which allows
+// its key to be rotated through the API. It doesn't save the device to the
+// Store.
+func (s *Server) AddDeviceConfig(config DeviceConfig) error {
+	if err := config.validate(); err != nil {
+		return fmt.Errorf("device %s: %v", config.ID, err)
+	}
+	s.AddDevice(Device{ID: config.ID, Name: config.Name}, config.ClientConfig())
+	s.mu.Lock()
+	s.configs[config.ID] = config
+	s.mu.Unlock()
+	return nil
+}
+
+// A SyncResult lists the IDs of devices changed by Sync.
+type SyncResult struct {
+	Added, Updated, Removed []string
+}
+
+// Sync makes the Server's devices match configs: new devices are added,
+// changed ones updated, and devices missing from configs removed. Unchanged
+// devices keep their connections. Nothing is changed if any config is
+// invalid. The Store isn't updated.
+func (s *Server) Sync(configs []DeviceConfig) (SyncResult, error) {
+	var result SyncResult
+	wanted := make(map[string]bool)
+	for _, config := range configs {
+		if err := config.validate(); err != nil {
+			return result, fmt.Errorf("device %s: %v", config.ID, err)
+		}
+		wanted[config.ID] = true
+	}
+
+	s.mu.Lock()
+	current := make(map[string]DeviceConfig, len(s.configs))
+	for id, config := range s.configs {
+		current[id] = config
+	}
+	exists := make(map[string]bool, len(s.devices))
+	var removed []string
+	for id := range s.devices {
+		exists[id] = true
+		if !wanted[id] {
+			removed = append(removed, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range removed {
+		s.RemoveDevice(id)
+		result.Removed = append(result.Removed, id)
+	}
+	for _, config := range configs {
+		if old, ok := current[config.ID]; ok && old == config {
+			continue
+		}
+		s.AddDeviceConfig(config)
+		if exists[config.ID] {
+			result.Updated = append(result.Updated, config.ID)
+		} else {
+			result.Added = append(result.Added, config.ID)
+		}
+	}
+	return result, nil
+}
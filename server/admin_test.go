@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// A Store that records saved devices.
+type memStore map[string]DeviceConfig
+
+func (m memStore) PutDevice(c DeviceConfig) error {
+	m[c.ID] = c
+	return nil
+}
+
+func (m memStore) DeleteDevice(id string) error {
+	delete(m, id)
+	return nil
+}
+
+func TestServerAdmin(t *testing.T) {
+	s := New()
+	defer s.Close()
+	store := memStore{}
+	s.Store = store
+
+	code, v := request(t, s, "POST", "/devices", `{"id": "`+testID+`", "name": "lamp", "key": "wrongwrongwrong!"}`)
+	if code != http.StatusOK || v["key"] != nil {
+		t.Fatalf("POST device: %d %v", code, v)
+	}
+	if store[testID].Key != "wrongwrongwrong!" {
+		t.Errorf("stored %+v", store[testID])
+	}
+
+	// Rotating the key keeps the name and updates the store.
+	code, v = request(t, s, "PUT", "/devices/lamp/key", `{"key": "`+testKey+`"}`)
+	if code != http.StatusOK || store[testID].Key != testKey || store[testID].Name != "lamp" {
+		t.Fatalf("PUT key: %d %v %+v", code, v, store[testID])
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"POST", "/devices", `{"key": "` + testKey + `"}`, http.StatusBadRequest},
+		{"POST", "/devices", `{"id": "x"}`, http.StatusBadRequest},
+		{"POST", "/devices", `{"id": "x", "key": "short"}`, http.StatusBadRequest},
+		{"POST", "/devices", `{"id": "x", "key": "` + testKey + `", "version": "9.9"}`, http.StatusBadRequest},
+		{"POST", "/devices", `{"id": "x", "name": "lamp", "key": "` + testKey + `"}`, http.StatusConflict},
+		{"PUT", "/devices/lamp/key", `{"key": "short"}`, http.StatusBadRequest},
+		{"PATCH", "/devices/lamp", ``, http.StatusMethodNotAllowed},
+	} {
+		code, v := request(t, s, tc.method, tc.path, tc.body)
+		if code != tc.code {
+			t.Errorf("%s %s %s: got %d %v, want %d", tc.method, tc.path, tc.body, code, v, tc.code)
+		}
+	}
+
+	if code, v := request(t, s, "DELETE", "/devices/lamp", ""); code != http.StatusOK {
+		t.Fatalf("DELETE: %d %v", code, v)
+	}
+	if _, ok := store[testID]; ok {
+		t.Error("device still stored")
+	}
+	if code, _ := request(t, s, "GET", "/devices/lamp/state", ""); code != http.StatusNotFound {
+		t.Errorf("GET deleted device: %d", code)
+	}
+}
+
+func TestServerSync(t *testing.T) {
+	s := New()
+	defer s.Close()
+	a := DeviceConfig{ID: "a", Name: "one", Key: testKey}
+	b := DeviceConfig{ID: "b", Name: "two", Key: testKey}
+	s.AddDeviceConfig(a)
+	s.AddDeviceConfig(b)
+
+	// Swap names, drop a, and add c.
+	b.Name = "one"
+	c := DeviceConfig{ID: "c", Name: "two", Key: testKey}
+	result, err := s.Sync([]DeviceConfig{b, c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, SyncResult{Added: []string{"c"}, Updated: []string{"b"}, Removed: []string{"a"}}) {
+		t.Errorf("got %+v", result)
+	}
+	for name, id := range map[string]string{"one": "b", "two": "c"} {
+		if dev, ok := s.lookup(name); !ok || dev.ID != id {
+			t.Errorf("lookup(%q) = %v, want %s", name, dev, id)
+		}
+	}
+
+	if result, _ := s.Sync([]DeviceConfig{b, c}); len(result.Added)+len(result.Updated)+len(result.Removed) > 0 {
+		t.Errorf("unchanged sync: %+v", result)
+	}
+	if _, err := s.Sync([]DeviceConfig{{ID: "d", Key: "short"}}); err == nil {
+		t.Error("expected error for invalid config")
+	}
+	if len(s.Devices()) != 2 {
+		t.Errorf("invalid sync changed devices: %v", s.Devices())
+	}
+}
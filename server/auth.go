@@ -0,0 +1,46 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Authorize a request, returning the ACL for its token and whether it may
+// proceed at all. Without Tokens or ACLs, every request is allowed. Tokens
+// in ACLs are restricted by their ACL, even if also in Tokens.
+//
+// Browsers can't set headers on WebSocket requests, so /ws also accepts a
+// token query parameter.
+func (s *Server) authorize(r *http.Request) (*ACL, bool) {
+	if len(s.Tokens) == 0 && len(s.ACLs) == 0 {
+		return nil, true
+	}
+	token := ""
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		token = auth[7:]
+	} else if r.URL.Path == "/ws" {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		return nil, false
+	}
+	// Compare hashes, which are all the same length, so timing reveals
+	// neither which token matched nor the tokens' lengths.
+	sum := sha256.Sum256([]byte(token))
+	ok := 0
+	for _, t := range s.Tokens {
+		tokenSum := sha256.Sum256([]byte(t))
+		ok |= subtle.ConstantTimeCompare(sum[:], tokenSum[:])
+	}
+	var acl *ACL
+	for t, a := range s.ACLs {
+		a := a
+		tokenSum := sha256.Sum256([]byte(t))
+		if subtle.ConstantTimeCompare(sum[:], tokenSum[:]) == 1 {
+			acl = &a
+		}
+	}
+	return acl, ok == 1 || acl != nil
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"net/http"
+)
+
+// A device seen broadcasting, as listed by GET /discovered.
+type discoveredResponse struct {
+	ID         string `json:"id"`
+	IP         string `json:"ip"`
+	Version    string `json:"version"`
+	ProductKey string `json:"productKey,omitempty"`
+	Configured bool   `json:"configured"`
+}
+
+// GET /discovered
+func (s *Server) listDiscovered() []discoveredResponse {
+	list := []discoveredResponse{}
+	if s.Fleet.Registry == nil {
+		return list
+	}
+	for _, status := range s.Fleet.Registry.Statuses() {
+		_, configured := s.lookup(status.GatewayID)
+		list = append(list, discoveredResponse{
+			ID:         status.GatewayID,
+			IP:         status.IP,
+			Version:    status.Version,
+			ProductKey: status.ProductKey,
+			Configured: configured,
+		})
+	}
+	return list
+}
+
+// Serve the dashboard page. It holds no data, so it's served without a
+// token; the page asks for one if the API needs it.
+func (s *Server) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, errorf(http.StatusMethodNotAllowed, "method not allowed"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self' ws: wss:")
+	w.Write([]byte(dashboardHTML))
+}
+
+// The dashboard: a single page using the JSON API and WebSocket.
+const dashboardHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Tuya devices</title>
+<style>
+body { font: 15px system-ui, sans-serif; margin: 0; background: #f4f4f4; color: #222; }
+header { background: #333; color: #fff; padding: 10px 16px; display: flex; gap: 16px; align-items: center; }
+header h1 { font-size: 18px; margin: 0; flex: 1; }
+main { padding: 16px; display: grid; grid-template-columns: repeat(auto-fill, minmax(280px, 1fr)); gap: 12px; }
+section { background: #fff; border-radius: 6px; padding: 12px; box-shadow: 0 1px 2px #0002; }
+section h2 { font-size: 16px; margin: 0 0 8px; display: flex; align-items: center; gap: 6px; }
+.dot { width: 10px; height: 10px; border-radius: 50%; background: #c33; display: inline-block; }
+.dot.up { background: #3a3; }
+.id { color: #888; font-size: 12px; }
+table { width: 100%; border-collapse: collapse; }
+td { padding: 3px 0; vertical-align: middle; }
+td:first-child { color: #666; width: 40px; }
+input[type=number], input[type=text] { width: 110px; }
+.error { color: #c33; font-size: 13px; }
+#discovered { grid-column: 1 / -1; }
+</style>
+</head>
+<body>
+<header><h1>Tuya devices</h1><span id="health"></span><span id="live"></span></header>
+<main id="devices"></main>
+<main><section id="discovered"><h2>Discovered</h2><table id="found"></table></section></main>
+<script>
+"use strict";
+const cards = {};
+
+function token() { return localStorage.getItem("tuyaToken") || ""; }
+
+async function api(method, path, body) {
+  for (;;) {
+    const headers = {"Content-Type": "application/json"};
+    if (token()) headers.Authorization = "Bearer " + token();
+    const res = await fetch(path, {method, headers, body: body === undefined ? undefined : JSON.stringify(body)});
+    if (res.status === 401) {
+      const t = prompt("API token");
+      if (!t) throw new Error("unauthorized");
+      localStorage.setItem("tuyaToken", t);
+      continue;
+    }
+    const data = await res.json();
+    if (!res.ok) throw new Error(data.error || res.statusText);
+    return data;
+  }
+}
+
+function el(tag, props, ...children) {
+  const e = Object.assign(document.createElement(tag), props || {});
+  for (const c of children) e.append(c);
+  return e;
+}
+
+function card(dev) {
+  let c = cards[dev.id];
+  if (!c) {
+    c = cards[dev.id] = {dot: el("span", {className: "dot"}), dps: el("table"), error: el("div", {className: "error"}), inputs: {}};
+    const title = el("h2", {}, c.dot, dev.name || dev.id);
+    document.getElementById("devices").append(el("section", {}, title, el("div", {className: "id"}, dev.id + (dev.ip ? " · " + dev.ip : "")), c.dps, c.error));
+    refresh(dev.id);
+  }
+  c.dot.className = "dot" + (dev.connected ? " up" : "");
+  c.dot.title = dev.connected ? "connected" : "not connected";
+  return c;
+}
+
+async function refresh(id) {
+  try {
+    const state = await api("GET", "/devices/" + encodeURIComponent(id) + "/state");
+    update(id, state.dps);
+    cards[id].error.textContent = "";
+  } catch (e) {
+    cards[id].error.textContent = e.message;
+  }
+}
+
+async function setDP(id, dp, value) {
+  const c = cards[id];
+  try {
+    await api("POST", "/devices/" + encodeURIComponent(id) + "/dps/" + dp, value);
+    c.error.textContent = "";
+  } catch (e) {
+    c.error.textContent = e.message;
+    refresh(id);
+  }
+}
+
+function update(id, dps) {
+  const c = cards[id];
+  if (!c) return;
+  for (const dp of Object.keys(dps)) {
+    const value = dps[dp];
+    let input = c.inputs[dp];
+    if (!input) {
+      if (typeof value === "boolean") {
+        input = el("input", {type: "checkbox", onchange: () => setDP(id, dp, input.checked)});
+      } else if (typeof value === "number") {
+        const slider = el("input", {type: "range", min: 0, max: Math.max(1000, value)});
+        const box = el("input", {type: "number"});
+        const send = v => { slider.value = box.value = v; setDP(id, dp, Number(v)); };
+        slider.oninput = () => { box.value = slider.value; };
+        slider.onchange = () => send(slider.value);
+        box.onchange = () => send(box.value);
+        input = el("span", {}, slider, " ", box);
+        input.set = v => { slider.value = box.value = v; };
+      } else if (typeof value === "string") {
+        input = el("input", {type: "text", onchange: () => setDP(id, dp, input.value)});
+      } else {
+        input = el("code");
+        input.set = v => { input.textContent = JSON.stringify(v); };
+      }
+      c.inputs[dp] = input;
+      const rows = Array.from(c.dps.rows);
+      const row = el("tr", {}, el("td", {}, dp), el("td", {}, input));
+      const next = rows.find(r => Number(r.cells[0].textContent) > Number(dp));
+      c.dps.insertBefore(row, next || null);
+    }
+    if (input.set) input.set(value);
+    else if (input.type === "checkbox") input.checked = value;
+    else if (document.activeElement !== input) input.value = value;
+  }
+}
+
+async function load() {
+  try {
+    for (const dev of await api("GET", "/devices")) card(dev);
+    const found = document.getElementById("found");
+    found.replaceChildren();
+    for (const d of await api("GET", "/discovered")) {
+      if (d.configured) continue;
+      const add = el("button", {textContent: "Add", onclick: async () => {
+        const key = prompt("Local key for " + d.id);
+        if (!key) return;
+        const name = prompt("Name (optional)") || undefined;
+        try { await api("POST", "/devices", {id: d.id, name, key, ip: d.ip, version: d.version}); load(); }
+        catch (e) { alert(e.message); }
+      }});
+      found.append(el("tr", {}, el("td", {}, d.id + " · " + d.ip + " · v" + d.version), el("td", {}, add)));
+    }
+    const health = await fetch("/readyz").then(r => r.json());
+    document.getElementById("health").textContent = health.connected + "/" + health.configured + " connected" + (health.ready ? "" : " · not ready");
+  } catch (e) {
+    document.getElementById("health").textContent = e.message;
+  }
+}
+
+function live() {
+  const scheme = location.protocol === "https:" ? "wss:" : "ws:";
+  const ws = new WebSocket(scheme + "//" + location.host + "/ws" + (token() ? "?token=" + encodeURIComponent(token()) : ""));
+  const status = document.getElementById("live");
+  ws.onopen = () => { status.textContent = "live"; };
+  ws.onmessage = m => {
+    const msg = JSON.parse(m.data);
+    if (msg.type === "event") update(msg.id, msg.dps);
+  };
+  ws.onclose = () => { status.textContent = "reconnecting"; setTimeout(live, 5000); };
+}
+
+load().then(live);
+setInterval(load, 10000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/device"
+)
+
+// Delay before reconnecting a watched device whose connection failed.
+const watchRetryDelay = 5 * time.Second
+
+// An Event is a state change pushed by a device.
+type Event struct {
+	Time time.Time    `json:"time"`
+	ID   string       `json:"id"`
+	Name string       `json:"name,omitempty"`
+	DPs  device.State `json:"dps"`
+}
+
+// Subscribe returns a channel of Events from all devices, which is closed by
+// calling the returned function or closing the Server. Events are only
+// produced after Start. Slow subscribers miss events rather than blocking
+// other subscribers.
+func (s *Server) Subscribe() (<-chan Event, func()) {
+	return s.subscribe(64, nil)
+}
+
+// Subscribe with a buffer of size events. If overflow isn't nil, an event
+// that doesn't fit ends the subscription instead of being dropped: overflow
+// is called, with the lock held, and then the channel is closed, so the
+// reader still gets the events buffered before it.
+func (s *Server) subscribe(size int, overflow func()) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+	s.mu.Lock()
+	if s.closed {
+		close(ch)
+	} else {
+		s.subscribers[ch] = overflow
+	}
+	s.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if _, ok := s.subscribers[ch]; ok {
+				delete(s.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+}
+
+func (s *Server) publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch, overflow := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+			if overflow != nil {
+				overflow()
+				delete(s.subscribers, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// Start keeps a connection open to every device, current and later added,
+// publishing their pushed state changes to subscribers.
+func (s *Server) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || s.closed {
+		return
+	}
+	s.started = true
+	for id := range s.devices {
+		s.startWatch(id)
+	}
+}
+
+// Start watching a device. Must be called with the lock held.
+func (s *Server) startWatch(id string) {
+	if _, ok := s.watchStops[id]; ok {
+		return
+	}
+	stop := make(chan struct{})
+	s.watchStops[id] = stop
+	go s.watch(id, stop)
+}
+
+// Stop watching a device. Must be called with the lock held.
+func (s *Server) stopWatch(id string) {
+	if stop, ok := s.watchStops[id]; ok {
+		delete(s.watchStops, id)
+		close(stop)
+	}
+}
+
+// Watch a device until stopped, reconnecting as needed.
+func (s *Server) watch(id string, stop chan struct{}) {
+	for {
+		if m, err := s.Fleet.Manager(id); err == nil {
+			updates, unwatch := m.Watch()
+		loop:
+			for {
+				select {
+				case state, ok := <-updates:
+					if !ok {
+						break loop
+					}
+					dev, _ := s.lookup(id)
+					e := Event{Time: time.Now(), ID: id, DPs: state}
+					if dev != nil {
+						e.Name = dev.Name
+					}
+					s.Metrics.SetDPs(id, state)
+					s.recordHistory(e)
+					s.publish(e)
+				case <-stop:
+					unwatch()
+					return
+				}
+			}
+		}
+		select {
+		case <-time.After(watchRetryDelay):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Close stops watching devices, closes subscriptions, and closes the Fleet.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for id := range s.watchStops {
+		s.stopWatch(id)
+	}
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+	s.mu.Unlock()
+	return s.Fleet.Close()
+}
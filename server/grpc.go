@@ -0,0 +1,228 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Path prefix of the gRPC service defined in tuya.proto.
+const grpcService = "/tuya.Devices/"
+
+// gRPC status codes.
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcDeadlineExceeded  = 4
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// Events a Watch stream may fall behind by.
+const grpcWatchQueue = 256
+
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// Serve a gRPC call. The request must be HTTP/2, which net/http only
+// negotiates over TLS.
+func (s *Server) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		writeError(w, errorf(http.StatusHTTPVersionNotSupported, "gRPC requires HTTP/2 over TLS"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	acl, ok := s.authorize(r)
+	if !ok {
+		writeGRPCStatus(w, errorf(http.StatusUnauthorized, "missing or invalid API token"))
+		return
+	}
+	writeGRPCStatus(w, s.grpcCall(w, r, acl))
+}
+
+func (s *Server) grpcCall(w http.ResponseWriter, r *http.Request, acl *ACL) error {
+	method := strings.TrimPrefix(r.URL.Path, grpcService)
+	if r.Method != http.MethodPost || method == r.URL.Path {
+		return errorf(http.StatusNotImplemented, "unknown method %s", r.URL.Path)
+	}
+	req, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return err
+	}
+
+	switch method {
+	case "ListDevices":
+		return writeGRPCMessage(w, encodeDeviceList(s.listDevices(acl)))
+	case "GetState":
+		id, err := decodeID(req)
+		if err != nil {
+			return errorf(http.StatusBadRequest, "%v", err)
+		}
+		dev, ok := s.lookup(id)
+		if !ok || !acl.CanRead(*dev, 0) {
+			return errorf(http.StatusNotFound, "no device %q", id)
+		}
+		res, err := s.getState(dev, acl)
+		if err != nil {
+			return err
+		}
+		state := res.(stateResponse)
+		return writeGRPCMessage(w, encodeState(state.ID, state.DPs, 0))
+	case "SetState":
+		id, state, err := decodeState(req)
+		if err != nil {
+			return errorf(http.StatusBadRequest, "%v", err)
+		}
+		dev, ok := s.lookup(id)
+		if !ok || !acl.CanRead(*dev, 0) {
+			return errorf(http.StatusNotFound, "no device %q", id)
+		}
+		if _, err := s.setState(dev, state, acl); err != nil {
+			return err
+		}
+		return writeGRPCMessage(w, encodeState(dev.ID, state, 0))
+	case "Watch":
+		ids, err := decodeIDs(req)
+		if err != nil {
+			return errorf(http.StatusBadRequest, "%v", err)
+		}
+		return s.grpcWatch(w, r, ids, acl)
+	}
+	return errorf(http.StatusNotImplemented, "unknown method %s", r.URL.Path)
+}
+
+// Stream events for the given devices, or all devices if none are given,
+// until the client cancels the call. If the client reads too slowly for
+// grpcWatchQueue events to cover, the stream ends with ResourceExhausted.
+func (s *Server) grpcWatch(w http.ResponseWriter, r *http.Request, ids []string, acl *ACL) error {
+	watched := make(map[string]bool)
+	for _, id := range ids {
+		dev, ok := s.lookup(id)
+		if !ok || !acl.CanRead(*dev, 0) {
+			return errorf(http.StatusNotFound, "no device %q", id)
+		}
+		watched[dev.ID] = true
+	}
+
+	// A stream that falls behind ends rather than silently missing events.
+	var overflowed bool
+	events, unsubscribe := s.subscribe(grpcWatchQueue, func() { overflowed = true })
+	defer unsubscribe()
+	w.WriteHeader(http.StatusOK)
+	flush(w)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				if overflowed {
+					return errorf(http.StatusTooManyRequests, "watch: client too slow; %d events queued", grpcWatchQueue)
+				}
+				return nil
+			}
+			if len(watched) > 0 && !watched[e.ID] {
+				continue
+			}
+			if e, ok = acl.filterEvent(e); !ok {
+				continue
+			}
+			msg := encodeState(e.ID, e.DPs, e.Time.UnixNano()/1e6)
+			if err := writeGRPCMessage(w, msg); err != nil {
+				return err
+			}
+			flush(w)
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Read a length-prefixed gRPC message. Compression isn't supported.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, errorf(http.StatusBadRequest, "read message: %v", err)
+	}
+	if hdr[0] != 0 {
+		return nil, errorf(http.StatusNotImplemented, "compressed messages unsupported")
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size > maxBodySize {
+		return nil, errorf(http.StatusRequestEntityTooLarge, "message too large")
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errorf(http.StatusBadRequest, "read message: %v", err)
+	}
+	return msg, nil
+}
+
+func writeGRPCMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+// Set the call's status trailers from an error returned by grpcCall.
+func writeGRPCStatus(w http.ResponseWriter, err error) {
+	code := grpcOK
+	if err != nil {
+		code = grpcInternal
+		if he, ok := err.(httpError); ok {
+			code = grpcCode(he.code)
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcEscape(err.Error()))
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+}
+
+// Map the HTTP status codes of httpErrors to gRPC status codes.
+func grpcCode(httpCode int) int {
+	switch httpCode {
+	case http.StatusBadRequest:
+		return grpcInvalidArgument
+	case http.StatusNotFound:
+		return grpcNotFound
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusNotImplemented, http.StatusMethodNotAllowed:
+		return grpcUnimplemented
+	case http.StatusBadGateway:
+		return grpcUnavailable
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	}
+	return grpcInternal
+}
+
+// Percent-encode a grpc-message value.
+func grpcEscape(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
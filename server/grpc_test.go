@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+)
+
+func grpcRequest(ctx context.Context, method string, msg []byte) *http.Request {
+	var body bytes.Buffer
+	writeGRPCMessage(&body, msg)
+	r := httptest.NewRequest("POST", grpcService+method, &body).WithContext(ctx)
+	r.ProtoMajor, r.ProtoMinor = 2, 0
+	r.Header.Set("Content-Type", "application/grpc")
+	return r
+}
+
+// Make a unary gRPC call, returning the response message and grpc-status.
+func grpcCall(t *testing.T, s *Server, method string, msg []byte) ([]byte, string) {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, grpcRequest(context.Background(), method, msg))
+	res := w.Result()
+	if w.Body.Len() == 0 {
+		return nil, res.Trailer.Get("Grpc-Status")
+	}
+	reply, err := readGRPCMessage(w.Body)
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	return reply, res.Trailer.Get("Grpc-Status")
+}
+
+func TestStateProtoRoundTrip(t *testing.T) {
+	state := device.State{1: true, 2: 42.5, 3: "white", 4: nil, 5: map[string]interface{}{"a": 1.0}}
+	id, got, err := decodeState(encodeState("abc", state, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "abc" || !reflect.DeepEqual(got, state) {
+		t.Errorf("got %q %v, want %v", id, got, state)
+	}
+	if err := pbFields([]byte{0x0a, 0x05, 'a'}, func(int, int, uint64, []byte) error { return nil }); err != errBadProto {
+		t.Errorf("truncated message: got %v", err)
+	}
+}
+
+func TestGRPCState(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Close()
+
+	var req pbBuffer
+	req.string(1, "lamp")
+	reply, status := grpcCall(t, s, "GetState", req)
+	if status != "0" {
+		t.Fatalf("GetState status %s", status)
+	}
+	id, state, err := decodeState(reply)
+	if err != nil || id != testID || state[1] != false {
+		t.Fatalf("GetState: %q %v %v", id, state, err)
+	}
+
+	_, status = grpcCall(t, s, "SetState", encodeState("lamp", device.State{1: true}, 0))
+	if status != "0" {
+		t.Fatalf("SetState status %s", status)
+	}
+	d.mu.Lock()
+	if d.state[1] != true {
+		t.Errorf("device state %v", d.state)
+	}
+	d.mu.Unlock()
+
+	for _, tc := range []struct {
+		method string
+		msg    []byte
+		status string
+	}{
+		{"GetState", encodeState("nope", nil, 0), "5"},
+		{"SetState", encodeState("lamp", nil, 0), "3"},
+		{"GetState", []byte{0xff}, "3"},
+		{"Nope", nil, "12"},
+	} {
+		if _, status := grpcCall(t, s, tc.method, tc.msg); status != tc.status {
+			t.Errorf("%s: got status %s, want %s", tc.method, status, tc.status)
+		}
+	}
+}
+
+func TestGRPCWatch(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Close()
+
+	var req pbBuffer
+	req.string(1, "lamp")
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		s.ServeHTTP(w, grpcRequest(context.Background(), "Watch", req))
+		close(done)
+	}()
+
+	// Wait for the call to subscribe.
+	for subscribed := false; !subscribed; time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		subscribed = len(s.subscribers) > 0
+		s.mu.Unlock()
+	}
+	at := time.Unix(1500000000, 0)
+	s.publish(Event{Time: at, ID: "other", DPs: device.State{1: false}})
+	s.publish(Event{Time: at, ID: testID, DPs: device.State{1: true}})
+	// Closing ends the stream after the buffered events.
+	s.Close()
+	<-done
+
+	msg, err := readGRPCMessage(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := encodeState(testID, device.State{1: true}, at.UnixNano()/1e6); !bytes.Equal(msg, want) {
+		t.Errorf("got event %x, want %x", msg, want)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("unexpected extra events")
+	}
+}
+
+// A ResponseWriter whose first Write waits for release.
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	writing, release chan struct{}
+	once             sync.Once
+}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.writing)
+		<-w.release
+	})
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestGRPCWatchOverflow(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Close()
+
+	w := &stalledWriter{
+		ResponseRecorder: httptest.NewRecorder(),
+		writing:          make(chan struct{}),
+		release:          make(chan struct{}),
+	}
+	done := make(chan struct{})
+	go func() {
+		s.ServeHTTP(w, grpcRequest(context.Background(), "Watch", nil))
+		close(done)
+	}()
+	for subscribed := false; !subscribed; time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		subscribed = len(s.subscribers) > 0
+		s.mu.Unlock()
+	}
+
+	// The first event stalls in Write; the rest fill the stream's queue
+	// and then overflow it.
+	e := Event{Time: time.Unix(1500000000, 0), ID: testID, DPs: device.State{1: true}}
+	s.publish(e)
+	<-w.writing
+	for i := 0; i <= grpcWatchQueue; i++ {
+		s.publish(e)
+	}
+	close(w.release)
+	<-done
+
+	// Events queued before the overflow are still sent.
+	var n int
+	for w.Body.Len() > 0 {
+		if _, err := readGRPCMessage(w.Body); err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 1+grpcWatchQueue {
+		t.Errorf("got %d events, want %d", n, 1+grpcWatchQueue)
+	}
+	if got := w.Result().Trailer.Get("Grpc-Status"); got != "8" {
+		t.Errorf("grpc-status %q, want 8 (ResourceExhausted)", got)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.subscribers) != 0 {
+		t.Errorf("overflowed subscription wasn't removed")
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// A health check's name and function.
+type check struct {
+	name string
+	fn   func() error
+}
+
+// AddCheck adds a readiness check, such as whether a listener is still
+// running. GET /readyz fails while any check returns an error.
+func (s *Server) AddCheck(name string, fn func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks = append(s.checks, check{name, fn})
+}
+
+// The body of /readyz.
+type readyResponse struct {
+	Ready      bool              `json:"ready"`
+	Configured int               `json:"configured"`
+	Connected  int               `json:"connected"`
+	Checks     map[string]string `json:"checks"`
+}
+
+// Serve GET /healthz, which succeeds whenever the Server is handling
+// requests, and GET /readyz, which succeeds when all checks pass. Device
+// connections are reported but don't affect readiness, since an unplugged
+// device shouldn't take down the API for the rest.
+func (s *Server) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, errorf(http.StatusMethodNotAllowed, "method not allowed"))
+		return
+	}
+	if r.URL.Path == "/healthz" {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"status": "ok",
+			"uptime": time.Since(s.created).Round(time.Second).String(),
+		})
+		return
+	}
+
+	s.mu.Lock()
+	checks := append([]check(nil), s.checks...)
+	s.mu.Unlock()
+	res := readyResponse{Ready: true, Checks: make(map[string]string)}
+	for _, c := range checks {
+		if err := c.fn(); err != nil {
+			res.Ready = false
+			res.Checks[c.name] = err.Error()
+		} else {
+			res.Checks[c.name] = "ok"
+		}
+	}
+	devices := s.Devices()
+	res.Configured = len(devices)
+	for _, dev := range devices {
+		if s.Fleet.Connected(dev.ID) {
+			res.Connected++
+		}
+	}
+	code := http.StatusOK
+	if !res.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, res)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lann/tuya/history"
+	"github.com/lann/tuya/net"
+)
+
+// Default range of GET /devices/{id}/history.
+const defaultHistory = 24 * time.Hour
+
+// Record an event's dps to the History, if any.
+func (s *Server) recordHistory(e Event) {
+	if s.History == nil {
+		return
+	}
+	records := make([]history.Record, 0, len(e.DPs))
+	for dp, v := range e.DPs {
+		records = append(records, history.Record{Time: e.Time, ID: e.ID, DP: dp, Value: v})
+	}
+	if err := s.History.Append(records...); err != nil && s.Logger != nil {
+		s.Logger.Logf(net.LevelError, "server: history: %v", err)
+	}
+}
+
+// The body of GET /devices/{id}/history.
+type historyResponse struct {
+	ID      string                     `json:"id"`
+	From    time.Time                  `json:"from"`
+	To      time.Time                  `json:"to"`
+	Records []history.Record           `json:"records"`
+	Summary map[uint32]history.Summary `json:"summary"`
+}
+
+// Parse a history query's parameters: dp, and from and to as RFC 3339
+// times or since as a duration before now.
+func historyQuery(dev *Device, r *http.Request) (history.Query, error) {
+	params := r.URL.Query()
+	q := history.Query{ID: dev.ID, To: time.Now()}
+	if v := params.Get("dp"); v != "" {
+		dp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return q, errorf(http.StatusBadRequest, "bad dp %q", v)
+		}
+		q.DP = uint32(dp)
+	}
+	if v := params.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, errorf(http.StatusBadRequest, "bad to: %v", err)
+		}
+		q.To = t
+	}
+	q.From = q.To.Add(-defaultHistory)
+	if v := params.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, errorf(http.StatusBadRequest, "bad from: %v", err)
+		}
+		q.From = t
+	} else if v := params.Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return q, errorf(http.StatusBadRequest, "bad since %q", v)
+		}
+		q.From = q.To.Add(-d)
+	}
+	return q, nil
+}
+
+// Recorded history in an export format, written by ServeHTTP instead of JSON.
+type exportResponse struct {
+	format  string
+	records []history.Record
+}
+
+// GET /devices/{id}/history, with format=csv or jsonl to export the records
+// alone.
+func (s *Server) getHistory(dev *Device, r *http.Request, acl *ACL) (interface{}, error) {
+	if s.History == nil {
+		return nil, errorf(http.StatusNotFound, "history isn't enabled")
+	}
+	q, err := historyQuery(dev, r)
+	if err != nil {
+		return nil, err
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json", history.FormatCSV, history.FormatJSONLines:
+	default:
+		return nil, errorf(http.StatusBadRequest, "bad format %q", format)
+	}
+	records, err := s.History.Query(q)
+	if err != nil {
+		return nil, errorf(http.StatusInternalServerError, "query history: %v", err)
+	}
+	if acl != nil {
+		allowed := records[:0]
+		for _, rec := range records {
+			if acl.CanRead(*dev, rec.DP) {
+				allowed = append(allowed, rec)
+			}
+		}
+		records = allowed
+	}
+	if format == history.FormatCSV || format == history.FormatJSONLines {
+		return exportResponse{format, records}, nil
+	}
+	if records == nil {
+		records = []history.Record{}
+	}
+	return historyResponse{
+		ID:      dev.ID,
+		From:    q.From,
+		To:      q.To,
+		Records: records,
+		Summary: history.Summarize(records, q.To),
+	}, nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/history"
+	"github.com/lann/tuya/net"
+)
+
+func TestServerHistory(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.AddDevice(Device{ID: testID, Name: "plug"}, net.ClientConfig{Addr: "127.0.0.1:1", Key: testKey})
+
+	if code, _ := request(t, s, "GET", "/devices/plug/history", ""); code != http.StatusNotFound {
+		t.Errorf("without History: %d", code)
+	}
+
+	s.History = &history.MemoryStore{}
+	now := time.Now().Truncate(time.Second)
+	s.recordHistory(Event{Time: now.Add(-2 * time.Hour), ID: testID, DPs: map[uint32]interface{}{19: 10.0, 1: true}})
+	s.recordHistory(Event{Time: now.Add(-time.Hour), ID: testID, DPs: map[uint32]interface{}{19: 20.0}})
+	s.recordHistory(Event{Time: now.Add(-time.Hour), ID: "other", DPs: map[uint32]interface{}{19: 30.0}})
+
+	code, v := request(t, s, "GET", "/devices/plug/history?dp=19", "")
+	if code != http.StatusOK {
+		t.Fatalf("GET history: %d %v", code, v)
+	}
+	if records := v["records"].([]interface{}); len(records) != 2 {
+		t.Errorf("records: %v", records)
+	}
+	if summary := v["summary"].(map[string]interface{})["19"].(map[string]interface{}); summary["max"] != 20.0 {
+		t.Errorf("summary: %v", summary)
+	}
+
+	code, v = request(t, s, "GET", "/devices/plug/history?since=90m", "")
+	if records := v["records"].([]interface{}); code != http.StatusOK || len(records) != 1 {
+		t.Errorf("since: %d %v", code, v)
+	}
+	to := url.QueryEscape(now.Add(-90 * time.Minute).Format(time.RFC3339))
+	code, v = request(t, s, "GET", "/devices/plug/history?to="+to, "")
+	if records := v["records"].([]interface{}); code != http.StatusOK || len(records) != 2 {
+		t.Errorf("to: %d %v", code, v)
+	}
+	r := httptest.NewRequest("GET", "/devices/plug/history?dp=19&format=csv", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if lines := strings.Count(w.Body.String(), "\n"); w.Code != http.StatusOK || lines != 3 ||
+		w.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("csv export: %d %q", w.Code, w.Body.String())
+	}
+
+	for _, query := range []string{"dp=x", "since=-1h", "from=yesterday", "format=xml"} {
+		if code, _ := request(t, s, "GET", "/devices/plug/history?"+query, ""); code != http.StatusBadRequest {
+			t.Errorf("%s: %d", query, code)
+		}
+	}
+}
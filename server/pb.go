@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/lann/tuya/device"
+)
+
+// Encoding of the messages in tuya.proto. The messages are few and simple
+// enough that the protobuf wire format is written by hand rather than pulling
+// in a protobuf runtime.
+
+// Protocol buffer wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errBadProto = errors.New("malformed protobuf message")
+
+// A pbBuffer accumulates an encoded message.
+type pbBuffer []byte
+
+func (b *pbBuffer) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	*b = append(*b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func (b *pbBuffer) tag(field, wire int) {
+	b.uvarint(uint64(field)<<3 | uint64(wire))
+}
+
+func (b *pbBuffer) varint(field int, v uint64) {
+	b.tag(field, wireVarint)
+	b.uvarint(v)
+}
+
+func (b *pbBuffer) bytes(field int, data []byte) {
+	b.tag(field, wireBytes)
+	b.uvarint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *pbBuffer) string(field int, s string) {
+	if s != "" {
+		b.bytes(field, []byte(s))
+	}
+}
+
+func (b *pbBuffer) double(field int, f float64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+	b.tag(field, wireFixed64)
+	*b = append(*b, buf[:]...)
+}
+
+// Call fn for each field of an encoded message. For wireBytes fields data is
+// the field's contents; otherwise v is its value.
+func pbFields(msg []byte, fn func(field, wire int, v uint64, data []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errBadProto
+		}
+		msg = msg[n:]
+		field, wire := int(key>>3), int(key&7)
+		var v uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			if v, n = binary.Uvarint(msg); n <= 0 {
+				return errBadProto
+			}
+			msg = msg[n:]
+		case wireFixed64:
+			if len(msg) < 8 {
+				return errBadProto
+			}
+			v, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case wireFixed32:
+			if len(msg) < 4 {
+				return errBadProto
+			}
+			v, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return errBadProto
+			}
+			data, msg = msg[n:n+int(size)], msg[n+int(size):]
+		default:
+			return errBadProto
+		}
+		if err := fn(field, wire, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode a tuya.Device.
+func encodeDevice(dev deviceResponse) []byte {
+	var b pbBuffer
+	b.string(1, dev.ID)
+	b.string(2, dev.Name)
+	if dev.Connected {
+		b.varint(3, 1)
+	}
+	b.string(4, dev.IP)
+	b.string(5, dev.Version)
+	return b
+}
+
+// Encode a tuya.ListDevicesResponse.
+func encodeDeviceList(devices []deviceResponse) []byte {
+	var b pbBuffer
+	for _, dev := range devices {
+		b.bytes(1, encodeDevice(dev))
+	}
+	return b
+}
+
+// Encode a tuya.Value. Values other than bools, numbers, and strings are
+// sent as JSON.
+func encodeValue(v interface{}) []byte {
+	var b pbBuffer
+	switch v := v.(type) {
+	case bool:
+		var n uint64
+		if v {
+			n = 1
+		}
+		b.varint(1, n)
+	case float64:
+		b.double(2, v)
+	case string:
+		b.bytes(3, []byte(v))
+	default:
+		data, _ := json.Marshal(v)
+		b.bytes(4, data)
+	}
+	return b
+}
+
+func decodeValue(msg []byte) (interface{}, error) {
+	var value interface{}
+	err := pbFields(msg, func(field, wire int, v uint64, data []byte) error {
+		switch {
+		case field == 1 && wire == wireVarint:
+			value = v != 0
+		case field == 2 && wire == wireFixed64:
+			value = math.Float64frombits(v)
+		case field == 3 && wire == wireBytes:
+			value = string(data)
+		case field == 4 && wire == wireBytes:
+			value = nil
+			if err := json.Unmarshal(data, &value); err != nil {
+				return fmt.Errorf("bad json_value: %v", err)
+			}
+		}
+		return nil
+	})
+	return value, err
+}
+
+// Encode a tuya.State. A zero time is omitted.
+func encodeState(id string, state device.State, unixMillis int64) []byte {
+	dps := make([]uint32, 0, len(state))
+	for dp := range state {
+		dps = append(dps, dp)
+	}
+	sort.Slice(dps, func(i, j int) bool { return dps[i] < dps[j] })
+
+	var b pbBuffer
+	b.string(1, id)
+	for _, dp := range dps {
+		var entry pbBuffer
+		entry.varint(1, uint64(dp))
+		entry.bytes(2, encodeValue(state[dp]))
+		b.bytes(2, entry)
+	}
+	if unixMillis != 0 {
+		b.varint(3, uint64(unixMillis))
+	}
+	return b
+}
+
+func decodeState(msg []byte) (id string, state device.State, err error) {
+	state = device.State{}
+	err = pbFields(msg, func(field, wire int, v uint64, data []byte) error {
+		switch {
+		case field == 1 && wire == wireBytes:
+			id = string(data)
+		case field == 2 && wire == wireBytes:
+			var dp uint32
+			var value interface{}
+			err := pbFields(data, func(field, wire int, v uint64, data []byte) (err error) {
+				switch {
+				case field == 1 && wire == wireVarint:
+					dp = uint32(v)
+				case field == 2 && wire == wireBytes:
+					value, err = decodeValue(data)
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			state[dp] = value
+		}
+		return nil
+	})
+	return id, state, err
+}
+
+// Decode the string field 1 of a message, as in tuya.GetStateRequest.
+func decodeID(msg []byte) (id string, err error) {
+	err = pbFields(msg, func(field, wire int, v uint64, data []byte) error {
+		if field == 1 && wire == wireBytes {
+			id = string(data)
+		}
+		return nil
+	})
+	return id, err
+}
+
+// Decode the repeated string field 1 of a message, as in tuya.WatchRequest.
+func decodeIDs(msg []byte) (ids []string, err error) {
+	err = pbFields(msg, func(field, wire int, v uint64, data []byte) error {
+		if field == 1 && wire == wireBytes {
+			ids = append(ids, string(data))
+		}
+		return nil
+	})
+	return ids, err
+}
@@ -0,0 +1,531 @@
+// Package server exposes a device.Fleet over HTTP.
+//
+// Devices are addressed by ID or name:
+//
+//	GET    /                       web dashboard; also /ui
+//	GET    /devices                list devices
+//	POST   /devices                add or replace a device from a JSON
+//	                               DeviceConfig
+//	DELETE /devices/{id}           remove a device
+//	PUT    /devices/{id}/key       replace a device's key, e.g. {"key": "..."}
+//	GET    /devices/{id}/state     query a device's dps
+//	PUT    /devices/{id}/state     set dps from a JSON object, e.g. {"1": true}
+//	POST   /devices/{id}/dps/{dp}  set one dp from a JSON value, e.g. true
+//	GET    /devices/{id}/history   recorded dp changes and a summary of each
+//	                               dp; see below
+//	GET    /discovered             devices seen broadcasting, and whether
+//	                               they're configured; needs a Registry
+//	GET    /ws[?id={id}...]        WebSocket stream of events, see below
+//	GET    /metrics                Prometheus metrics; see package metrics
+//	GET    /healthz                liveness: always succeeds
+//	GET    /readyz                 readiness: fails while any check fails;
+//	                               also counts connected devices
+//
+// If the Server has a History, dp changes pushed by devices are recorded in
+// it. The history endpoint takes optional dp, from, and to parameters, with
+// times in RFC 3339 format, or since, a duration like 1h before now; the
+// default is the last 24 hours. With format=csv or format=jsonl, the records
+// are returned alone in that format; see history.Export.
+//
+// Devices added, removed, or rekeyed through the API are saved to the Store,
+// if any. Keys are never included in responses.
+//
+// Responses are JSON; errors are objects with an "error" field. Request
+// bodies must be sent as Content-Type application/json, and /ws and requests
+// that change anything are refused with 403 if their Origin header names
+// another site, so a web page can't control devices through a browser. If the
+// Server has Tokens, requests other than health checks and the dashboard page
+// must carry one in an "Authorization: Bearer" header, or for /ws a token
+// query parameter. The dashboard asks for a token and keeps it in the
+// browser's local storage. Tokens with an ACL only see and control the
+// devices and dps it allows, over every API: device lists, states, history,
+// events, and commands are filtered, and other requests fail with 403.
+//
+// A WebSocket client receives each Event as a text message like
+// {"type": "event", "id": ..., "dps": {"1": true}, ...}, for the devices
+// given by id parameters or all devices. It may send commands like
+// {"type": "set", "id": "lamp", "dps": {"1": true}, "ref": 1}, or "get"
+// without dps; each is answered by a message with type "result", the same
+// ref, the device's id and dps, and an "error" if it failed.
+//
+// The same handler serves the gRPC service defined in tuya.proto to requests
+// with a gRPC content type, which requires HTTP/2 and so a TLS listener.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/history"
+	"github.com/lann/tuya/metrics"
+	"github.com/lann/tuya/net"
+)
+
+// Maximum request body size.
+const maxBodySize = 1 << 16
+
+// A Device describes a device served by a Server.
+type Device struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// A device's details, as listed by GET /devices. IP and Version are from the
+// device's most recent broadcast, if any.
+type deviceResponse struct {
+	Device
+	Connected bool   `json:"connected"`
+	IP        string `json:"ip,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+// A Server serves the devices of a Fleet over HTTP.
+type Server struct {
+	Fleet *device.Fleet
+
+	// Metrics collects the Fleet's request metrics and device states.
+	Metrics *metrics.Metrics
+
+	// Timeout bounds each device request.
+	Timeout time.Duration
+
+	// Tokens, if not empty, are the API tokens accepted by ServeHTTP. Set
+	// them before serving.
+	Tokens []string
+
+	// ACLs are further API tokens, each limited to the devices and dps its
+	// ACL allows. Restricted tokens can't add, remove, or rekey devices, or
+	// read /discovered or /metrics. Set them before serving.
+	ACLs map[string]ACL
+
+	// Store, if not nil, saves changes made to devices through the API.
+	Store Store
+
+	// History, if not nil, records dp changes once the Server is started.
+	History history.Store
+
+	// Logger, if not nil, receives errors recording History.
+	Logger net.Logger
+
+	created time.Time
+
+	mu          sync.Mutex
+	devices     map[string]*Device // by ID
+	names       map[string]string  // name to ID
+	configs     map[string]DeviceConfig
+	started     bool
+	closed      bool
+	watchStops  map[string]chan struct{}
+	subscribers map[chan Event]func() // to overflow callbacks
+	checks      []check
+}
+
+// New creates a Server with an empty Fleet.
+func New() *Server {
+	s := &Server{
+		Fleet:       device.NewFleet(),
+		Metrics:     metrics.New(),
+		Timeout:     10 * time.Second,
+		created:     time.Now(),
+		devices:     make(map[string]*Device),
+		names:       make(map[string]string),
+		configs:     make(map[string]DeviceConfig),
+		watchStops:  make(map[string]chan struct{}),
+		subscribers: make(map[chan Event]func()),
+	}
+	s.Fleet.Hooks.Request = s.Metrics.ObserveRequest
+	return s
+}
+
+// AddDevice adds or updates a device. The name is optional.
+func (s *Server) AddDevice(dev Device, config net.ClientConfig) {
+	s.mu.Lock()
+	if old, ok := s.devices[dev.ID]; ok && s.names[old.Name] == dev.ID {
+		delete(s.names, old.Name)
+	}
+	s.devices[dev.ID] = &dev
+	if dev.Name != "" {
+		s.names[dev.Name] = dev.ID
+	}
+	s.mu.Unlock()
+	if config.Metrics == nil {
+		config.Metrics = s.Metrics.Client(dev.ID)
+	}
+	s.Fleet.Add(dev.ID, config)
+	s.Metrics.SetName(dev.ID, dev.Name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started && !s.closed {
+		s.startWatch(dev.ID)
+	}
+}
+
+// RemoveDevice removes a device, closing its connection.
+func (s *Server) RemoveDevice(id string) {
+	s.mu.Lock()
+	if dev, ok := s.devices[id]; ok {
+		delete(s.devices, id)
+		if s.names[dev.Name] == id {
+			delete(s.names, dev.Name)
+		}
+	}
+	delete(s.configs, id)
+	s.stopWatch(id)
+	s.mu.Unlock()
+	s.Fleet.Remove(id)
+	s.Metrics.Remove(id)
+}
+
+// Devices returns the served devices, sorted by ID.
+func (s *Server) Devices() []Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	devices := make([]Device, 0, len(s.devices))
+	for _, dev := range s.devices {
+		devices = append(devices, *dev)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices
+}
+
+// Look up a device by ID or name.
+func (s *Server) lookup(idOrName string) (*Device, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dev, ok := s.devices[idOrName]; ok {
+		return dev, true
+	}
+	if id, ok := s.names[idOrName]; ok {
+		return s.devices[id], true
+	}
+	return nil, false
+}
+
+// An HTTP error response.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e httpError) Error() string {
+	return e.err.Error()
+}
+
+func errorf(code int, format string, args ...interface{}) error {
+	return httpError{code, fmt.Errorf(format, args...)}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isGRPC(r) {
+		s.serveGRPC(w, r)
+		return
+	}
+	switch r.URL.Path {
+	case "/healthz", "/readyz":
+		// Probes usually can't authenticate.
+		s.serveHealth(w, r)
+		return
+	case "/", "/ui":
+		s.serveDashboard(w, r)
+		return
+	}
+	if (r.URL.Path == "/ws" || !safeMethod(r.Method)) && !sameOrigin(r) {
+		// Without this, any page the user visits could open /ws or send a
+		// form POST to a server that has no tokens.
+		writeError(w, errorf(http.StatusForbidden, "cross-origin request"))
+		return
+	}
+	acl, ok := s.authorize(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, errorf(http.StatusUnauthorized, "missing or invalid API token"))
+		return
+	}
+	switch r.URL.Path {
+	case "/ws":
+		s.serveWebSocket(w, r, acl)
+		return
+	case "/metrics", "/discovered":
+		if err := acl.checkAdmin(); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	switch r.URL.Path {
+	case "/metrics":
+		for _, dev := range s.Devices() {
+			s.Metrics.SetReachable(dev.ID, s.Fleet.Connected(dev.ID))
+		}
+		s.Metrics.ServeHTTP(w, r)
+		return
+	case "/discovered":
+		if r.Method != http.MethodGet {
+			writeError(w, errorf(http.StatusMethodNotAllowed, "method not allowed"))
+			return
+		}
+		writeJSON(w, http.StatusOK, s.listDiscovered())
+		return
+	}
+	v, err := s.route(r, acl)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if e, ok := v.(exportResponse); ok {
+		w.Header().Set("Content-Type", history.ContentType(e.format))
+		history.Export(w, e.records, e.format)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// Route an API request, enforcing acl.
+func (s *Server) route(r *http.Request, acl *ACL) (interface{}, error) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "devices" {
+		return nil, errorf(http.StatusNotFound, "not found")
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			return s.listDevices(acl), nil
+		case http.MethodPost:
+			if err := acl.checkAdmin(); err != nil {
+				return nil, err
+			}
+			var config DeviceConfig
+			if err := readJSON(r, &config); err != nil {
+				return nil, err
+			}
+			return s.putDevice(config)
+		}
+		return nil, errorf(http.StatusMethodNotAllowed, "method not allowed")
+	}
+
+	dev, ok := s.lookup(parts[1])
+	if !ok || !acl.CanRead(*dev, 0) {
+		// Devices a token can't read are hidden from it entirely.
+		return nil, errorf(http.StatusNotFound, "no device %q", parts[1])
+	}
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if err := acl.checkAdmin(); err != nil {
+			return nil, err
+		}
+		return s.deleteDevice(dev)
+	case len(parts) == 3 && parts[2] == "key" && r.Method == http.MethodPut:
+		if err := acl.checkAdmin(); err != nil {
+			return nil, err
+		}
+		var body struct {
+			Key string `json:"key"`
+		}
+		if err := readJSON(r, &body); err != nil {
+			return nil, err
+		}
+		return s.rotateKey(dev, body.Key)
+	case len(parts) == 3 && parts[2] == "history" && r.Method == http.MethodGet:
+		return s.getHistory(dev, r, acl)
+	case len(parts) == 3 && parts[2] == "state" && r.Method == http.MethodGet:
+		return s.getState(dev, acl)
+	case len(parts) == 3 && parts[2] == "state" && r.Method == http.MethodPut:
+		var state device.State
+		if err := readJSON(r, &state); err != nil {
+			return nil, err
+		}
+		return s.setState(dev, state, acl)
+	case len(parts) == 4 && parts[2] == "dps" && r.Method == http.MethodPost:
+		dp, err := strconv.ParseUint(parts[3], 10, 32)
+		if err != nil {
+			return nil, errorf(http.StatusBadRequest, "bad dp %q", parts[3])
+		}
+		var value interface{}
+		if err := readJSON(r, &value); err != nil {
+			return nil, err
+		}
+		return s.setState(dev, device.State{uint32(dp): value}, acl)
+	case len(parts) == 2, len(parts) == 3 && (parts[2] == "state" || parts[2] == "key" || parts[2] == "history"),
+		len(parts) == 4 && parts[2] == "dps":
+		return nil, errorf(http.StatusMethodNotAllowed, "method not allowed")
+	}
+	return nil, errorf(http.StatusNotFound, "not found")
+}
+
+// List the devices acl allows reading.
+func (s *Server) listDevices(acl *ACL) []deviceResponse {
+	list := []deviceResponse{}
+	for _, dev := range s.Devices() {
+		if !acl.CanRead(dev, 0) {
+			continue
+		}
+		res := deviceResponse{Device: dev, Connected: s.Fleet.Connected(dev.ID)}
+		if s.Fleet.Registry != nil {
+			if status, ok := s.Fleet.Registry.Status(dev.ID); ok {
+				res.IP = status.IP
+				res.Version = status.Version
+			}
+		}
+		list = append(list, res)
+	}
+	return list
+}
+
+// A device's dps, as returned by the state endpoints.
+type stateResponse struct {
+	ID  string       `json:"id"`
+	DPs device.State `json:"dps"`
+}
+
+// Query a device's state, returning the dps acl allows reading.
+func (s *Server) getState(dev *Device, acl *ACL) (interface{}, error) {
+	if err := acl.checkRead(dev); err != nil {
+		return nil, err
+	}
+	var state device.State
+	err := s.do(dev.ID, func(ctx context.Context, m *device.Manager) (err error) {
+		state, err = m.GetStateContext(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Metrics.SetDPs(dev.ID, state)
+	return stateResponse{dev.ID, acl.Filter(*dev, state)}, nil
+}
+
+// Set a device's dps, if acl allows setting all of them.
+func (s *Server) setState(dev *Device, state device.State, acl *ACL) (interface{}, error) {
+	if len(state) == 0 {
+		return nil, errorf(http.StatusBadRequest, "no dps given")
+	}
+	if err := acl.checkWrite(dev, state); err != nil {
+		return nil, err
+	}
+	err := s.do(dev.ID, func(ctx context.Context, m *device.Manager) error {
+		return m.SetStateContext(ctx, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stateResponse{dev.ID, state}, nil
+}
+
+// GetState queries the state of a device, given by ID or name.
+func (s *Server) GetState(idOrName string) (device.State, error) {
+	dev, ok := s.lookup(idOrName)
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "no device %q", idOrName)
+	}
+	res, err := s.getState(dev, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.(stateResponse).DPs, nil
+}
+
+// SetState sets dps of a device, given by ID or name.
+func (s *Server) SetState(idOrName string, state device.State) error {
+	return s.SetStateACL(idOrName, state, nil)
+}
+
+// SetStateACL is like SetState, but fails unless acl allows setting every
+// dp, for callers relaying commands from restricted clients.
+func (s *Server) SetStateACL(idOrName string, state device.State, acl *ACL) error {
+	dev, ok := s.lookup(idOrName)
+	if !ok {
+		return errorf(http.StatusNotFound, "no device %q", idOrName)
+	}
+	_, err := s.setState(dev, state, acl)
+	return err
+}
+
+// Run fn with a device's Manager and a context that gives up after the
+// Server's Timeout. Only fn's request is abandoned on timeout; the Manager
+// stays open for other requests.
+func (s *Server) do(id string, fn func(context.Context, *device.Manager) error) error {
+	m, err := s.Fleet.Manager(id)
+	if err != nil {
+		return httpError{http.StatusBadGateway, err}
+	}
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	err = fn(ctx, m)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, device.ErrTimeout) {
+		return httpError{http.StatusGatewayTimeout, device.ErrTimeout}
+	}
+	if err != nil {
+		return httpError{http.StatusBadGateway, err}
+	}
+	return nil
+}
+
+// Report whether a request method can't change anything.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Report whether a request comes from a page served by this server, or from
+// something other than a browser, which doesn't send Origin.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// Decode a request's JSON body. Requiring the content type keeps browsers
+// from sending bodies cross-origin without a preflight.
+func readJSON(r *http.Request, v interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return errorf(http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return errorf(http.StatusBadRequest, "read body: %v", err)
+	}
+	if len(data) > maxBodySize {
+		return errorf(http.StatusRequestEntityTooLarge, "body too large")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errorf(http.StatusBadRequest, "bad JSON: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if he, ok := err.(httpError); ok {
+		code = he.code
+	}
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+)
+
+const (
+	testID  = "0123456789abcdef"
+	testKey = "0123456789abcdef"
+)
+
+// A fake 3.1 device that answers queries and applies control requests.
+type fakeDevice struct {
+	l     stdnet.Listener
+	mu    sync.Mutex
+	state device.State
+	conns int  // connections accepted
+	mute  bool // don't answer queries
+}
+
+func newFakeDevice(t *testing.T) *fakeDevice {
+	l, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDevice{l: l, state: device.State{1: false}}
+	go d.serve()
+	return d
+}
+
+func (d *fakeDevice) serve() {
+	for {
+		conn, err := d.l.Accept()
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.conns++
+		d.mu.Unlock()
+		go d.handle(conn)
+	}
+}
+
+func (d *fakeDevice) handle(conn stdnet.Conn) {
+	defer conn.Close()
+	cipher, _ := net.NewCipher([]byte(testKey))
+	for {
+		f, err := net.DecodeFrame(conn)
+		if err != nil {
+			return
+		}
+		reply := &net.Frame{Seq: f.Seq, Cmd: f.Cmd, Payload: []byte{0, 0, 0, 0}}
+		switch f.Cmd {
+		case 0x0a:
+			d.mu.Lock()
+			if d.mute {
+				d.mu.Unlock()
+				continue
+			}
+			data, _ := json.Marshal(map[string]interface{}{"devId": testID, "dps": d.state})
+			d.mu.Unlock()
+			reply.Payload = append(reply.Payload, data...)
+		case 0x07:
+			plaintext, err := cipher.Decrypt(f.Payload)
+			if err != nil {
+				return
+			}
+			var req struct {
+				DPs device.State `json:"dps"`
+			}
+			json.Unmarshal(plaintext, &req)
+			d.mu.Lock()
+			for dp, v := range req.DPs {
+				d.state[dp] = v
+			}
+			d.mu.Unlock()
+		}
+		if err := reply.Encode(conn); err != nil {
+			return
+		}
+	}
+}
+
+func newTestServer(t *testing.T) (*Server, *fakeDevice) {
+	d := newFakeDevice(t)
+	s := New()
+	s.AddDevice(Device{ID: testID, Name: "lamp"}, net.ClientConfig{
+		Addr: d.l.Addr().String(),
+		Key:  testKey,
+	})
+	return s, d
+}
+
+func request(t *testing.T, s *Server, method, path, body string) (int, map[string]interface{}) {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	var v map[string]interface{}
+	if bytes.HasPrefix(w.Body.Bytes(), []byte("{")) {
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, v
+}
+
+func TestServerState(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Fleet.Close()
+
+	code, v := request(t, s, "GET", "/devices/lamp/state", "")
+	if code != http.StatusOK || v["dps"].(map[string]interface{})["1"] != false {
+		t.Fatalf("GET state: %d %v", code, v)
+	}
+	code, v = request(t, s, "PUT", "/devices/"+testID+"/state", `{"1": true}`)
+	if code != http.StatusOK {
+		t.Fatalf("PUT state: %d %v", code, v)
+	}
+	code, v = request(t, s, "POST", "/devices/lamp/dps/2", `42`)
+	if code != http.StatusOK {
+		t.Fatalf("POST dp: %d %v", code, v)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state[1] != true || d.state[2] != 42.0 {
+		t.Errorf("device state %v", d.state)
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Fleet.Close()
+	s.Timeout = 50 * time.Millisecond
+
+	d.mu.Lock()
+	d.mute = true
+	d.mu.Unlock()
+	if code, v := request(t, s, "GET", "/devices/lamp/state", ""); code != http.StatusGatewayTimeout {
+		t.Fatalf("GET state from unresponsive device: %d %v", code, v)
+	}
+
+	// The timed out request doesn't close the connection for others.
+	d.mu.Lock()
+	d.mute = false
+	d.mu.Unlock()
+	if code, v := request(t, s, "GET", "/devices/lamp/state", ""); code != http.StatusOK {
+		t.Fatalf("GET state after timeout: %d %v", code, v)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conns != 1 {
+		t.Errorf("%d connections; the timeout shouldn't reconnect", d.conns)
+	}
+}
+
+func TestServerWrappedTimeout(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Fleet.Close()
+
+	for _, cause := range []error{context.DeadlineExceeded, device.ErrTimeout} {
+		err := s.do(testID, func(context.Context, *device.Manager) error {
+			return fmt.Errorf("GetState: %w", cause)
+		})
+		if he, ok := err.(httpError); !ok || he.code != http.StatusGatewayTimeout {
+			t.Errorf("%v: got %v, want a %d", cause, err, http.StatusGatewayTimeout)
+		}
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Fleet.Close()
+
+	for _, tc := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"GET", "/nope", "", http.StatusNotFound},
+		{"GET", "/devices/nope/state", "", http.StatusNotFound},
+		{"DELETE", "/devices/lamp/state", "", http.StatusMethodNotAllowed},
+		{"PUT", "/devices/lamp/state", `{"1":`, http.StatusBadRequest},
+		{"PUT", "/devices/lamp/state", `{}`, http.StatusBadRequest},
+		{"POST", "/devices/lamp/dps/x", `true`, http.StatusBadRequest},
+	} {
+		code, v := request(t, s, tc.method, tc.path, tc.body)
+		if code != tc.code || v["error"] == nil {
+			t.Errorf("%s %s: got %d %v, want %d", tc.method, tc.path, code, v, tc.code)
+		}
+	}
+}
+
+func TestServerCrossOrigin(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Fleet.Close()
+
+	for _, tc := range []struct {
+		method, path, origin, contentType string
+		code                              int
+	}{
+		{"POST", "/devices/lamp/dps/1", "", "application/json", http.StatusOK},
+		{"POST", "/devices/lamp/dps/1", "http://example.com", "application/json", http.StatusOK},
+		{"POST", "/devices/lamp/dps/1", "http://evil.test", "application/json", http.StatusForbidden},
+		{"POST", "/devices/lamp/dps/1", "null", "application/json", http.StatusForbidden},
+		{"POST", "/devices/lamp/dps/1", "", "text/plain", http.StatusUnsupportedMediaType},
+		{"POST", "/devices/lamp/dps/1", "", "", http.StatusUnsupportedMediaType},
+		{"GET", "/devices/lamp/state", "http://evil.test", "", http.StatusOK},
+		{"GET", "/ws", "http://evil.test", "", http.StatusForbidden},
+	} {
+		// httptest requests are for Host example.com.
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader("true"))
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if tc.contentType != "" {
+			r.Header.Set("Content-Type", tc.contentType)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s from %q as %q: got %d, want %d: %s",
+				tc.method, tc.path, tc.origin, tc.contentType, w.Code, tc.code, w.Body)
+		}
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Fleet.Close()
+
+	if code, v := request(t, s, "GET", "/devices/lamp/state", ""); code != http.StatusOK {
+		t.Fatalf("GET state: %d %v", code, v)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`tuya_device_info{id="` + testID + `",name="lamp"} 1`,
+		`tuya_device_reachable{id="` + testID + `"} 1`,
+		`tuya_request_duration_seconds_count{id="` + testID + `",cmd="10"} 1`,
+		`tuya_dp_value{id="` + testID + `",dp="1"} 0`,
+	} {
+		if !strings.Contains(w.Body.String(), want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Fleet.Close()
+	s.Tokens = []string{"secret"}
+
+	for _, tc := range []struct {
+		path, auth string
+		code       int
+	}{
+		{"/devices", "", http.StatusUnauthorized},
+		{"/devices", "Bearer wrong", http.StatusUnauthorized},
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/devices?token=secret", "", http.StatusUnauthorized},
+		{"/devices", "Bearer secret", http.StatusOK},
+		{"/ws?token=secret", "", http.StatusBadRequest}, // authorized, but not a handshake
+	} {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		if tc.auth != "" {
+			r.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %q: got %d, want %d", tc.path, tc.auth, w.Code, tc.code)
+		}
+	}
+
+	r := grpcRequest(context.Background(), "ListDevices", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if status := w.Result().Trailer.Get("Grpc-Status"); status != "16" {
+		t.Errorf("gRPC without token: status %s, want 16", status)
+	}
+}
+
+func TestServerHealth(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.Tokens = []string{"secret"}
+	s.AddDevice(Device{ID: testID}, net.ClientConfig{Addr: "127.0.0.1:1", Key: testKey})
+
+	code, v := request(t, s, "GET", "/healthz", "")
+	if code != http.StatusOK || v["status"] != "ok" {
+		t.Errorf("healthz: %d %v", code, v)
+	}
+	code, v = request(t, s, "GET", "/readyz", "")
+	if code != http.StatusOK || v["configured"] != 1.0 || v["connected"] != 0.0 {
+		t.Errorf("readyz: %d %v", code, v)
+	}
+
+	s.AddCheck("listener", func() error { return errors.New("closed") })
+	code, v = request(t, s, "GET", "/readyz", "")
+	if code != http.StatusServiceUnavailable || v["checks"].(map[string]interface{})["listener"] != "closed" {
+		t.Errorf("failing readyz: %d %v", code, v)
+	}
+}
+
+func TestServerDashboard(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.Tokens = []string{"secret"}
+	s.Fleet.Registry = device.NewRegistry()
+	s.Fleet.Registry.Update(&net.Status{GatewayID: testID, IP: "10.0.0.2", Version: "3.3"})
+	s.Fleet.Registry.Update(&net.Status{GatewayID: "other", IP: "10.0.0.3", Version: "3.1"})
+	s.AddDevice(Device{ID: testID}, net.ClientConfig{Addr: "127.0.0.1:1", Key: testKey})
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("GET /: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	r := httptest.NewRequest("GET", "/discovered", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	var found []discoveredResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &found); err != nil {
+		t.Fatal(err)
+	}
+	want := []discoveredResponse{
+		{ID: testID, IP: "10.0.0.2", Version: "3.3", Configured: true},
+		{ID: "other", IP: "10.0.0.3", Version: "3.1"},
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("got %+v, want %+v", found, want)
+	}
+}
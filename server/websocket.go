@@ -0,0 +1,332 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	stdnet "net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/lann/tuya/device"
+)
+
+// GUID appended to the client's key in the WebSocket handshake (RFC 6455).
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var errWSClosed = errors.New("websocket closed")
+
+// An event, as sent to WebSocket clients.
+type wsEvent struct {
+	Type string `json:"type"`
+	Event
+}
+
+// A command from a WebSocket client. Type is "set" (the default) or "get";
+// Ref is echoed in the result so clients can match them up.
+type wsCommand struct {
+	Type string       `json:"type"`
+	Ref  interface{}  `json:"ref,omitempty"`
+	ID   string       `json:"id"`
+	DPs  device.State `json:"dps"`
+}
+
+// The result of a wsCommand.
+type wsResult struct {
+	Type  string       `json:"type"`
+	Ref   interface{}  `json:"ref,omitempty"`
+	ID    string       `json:"id,omitempty"`
+	DPs   device.State `json:"dps,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// Serve GET /ws: stream events for the devices given by id query parameters,
+// or all devices, as JSON text messages, and run commands sent by the client.
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request, acl *ACL) {
+	watched := make(map[string]bool)
+	for _, id := range r.URL.Query()["id"] {
+		dev, ok := s.lookup(id)
+		if !ok || !acl.CanRead(*dev, 0) {
+			writeError(w, errorf(http.StatusNotFound, "no device %q", id))
+			return
+		}
+		watched[dev.ID] = true
+	}
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer ws.close()
+
+	commands := make(chan wsCommand)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(commands)
+		for {
+			msg, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+			var cmd wsCommand
+			if err := json.Unmarshal(msg, &cmd); err != nil {
+				ws.writeJSON(wsResult{Type: "result", Error: "bad JSON: " + err.Error()})
+				continue
+			}
+			select {
+			case commands <- cmd:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if len(watched) > 0 && !watched[e.ID] {
+				continue
+			}
+			if e, ok = acl.filterEvent(e); !ok {
+				continue
+			}
+			if err := ws.writeJSON(wsEvent{"event", e}); err != nil {
+				return
+			}
+		case cmd, ok := <-commands:
+			if !ok {
+				return
+			}
+			// Run commands concurrently so a slow device doesn't hold up
+			// events; results may arrive out of order.
+			go func() { ws.writeJSON(s.wsCommand(cmd, acl)) }()
+		}
+	}
+}
+
+func (s *Server) wsCommand(cmd wsCommand, acl *ACL) wsResult {
+	result := wsResult{Type: "result", Ref: cmd.Ref, ID: cmd.ID}
+	dev, ok := s.lookup(cmd.ID)
+	if !ok || !acl.CanRead(*dev, 0) {
+		result.Error = "no device " + cmd.ID
+		return result
+	}
+	result.ID = dev.ID
+	var res interface{}
+	var err error
+	switch cmd.Type {
+	case "get":
+		res, err = s.getState(dev, acl)
+	case "", "set":
+		res, err = s.setState(dev, cmd.DPs, acl)
+	default:
+		err = errorf(http.StatusBadRequest, "unknown command type %q", cmd.Type)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.DPs = res.(stateResponse).DPs
+	return result
+}
+
+// A wsConn is the server side of a WebSocket connection. Writes may be made
+// concurrently with each other and with reads.
+type wsConn struct {
+	conn stdnet.Conn
+	r    *bufio.Reader
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Complete a WebSocket handshake, taking over the request's connection.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet {
+		return nil, errorf(http.StatusMethodNotAllowed, "method not allowed")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		return nil, errorf(http.StatusBadRequest, "not a WebSocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, errorf(http.StatusUpgradeRequired, "unsupported WebSocket version")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errorf(http.StatusInternalServerError, "connection can't be upgraded")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, errorf(http.StatusInternalServerError, "Hijack: %v", err)
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	_, err = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+base64.StdEncoding.EncodeToString(sum[:])+"\r\n\r\n")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+// Report whether a comma-separated header contains a token.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read the next text or binary message, answering pings along the way.
+func (ws *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case wsPing:
+			if err := ws.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			ws.writeFrame(wsClose, nil)
+			return nil, errWSClosed
+		case wsText, wsBinary, wsContinuation:
+			if (op == wsContinuation) == (msg == nil) {
+				return nil, errors.New("bad websocket fragmentation")
+			}
+		default:
+			return nil, errors.New("bad websocket opcode")
+		}
+		msg = append(msg, payload...)
+		if len(msg) > maxBodySize {
+			return nil, errors.New("websocket message too large")
+		}
+		if fin {
+			return msg, nil
+		}
+		if msg == nil {
+			msg = []byte{}
+		}
+	}
+}
+
+func (ws *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(ws.r, hdr[:]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	if hdr[1]&0x80 == 0 {
+		err = errors.New("unmasked websocket frame")
+		return
+	}
+	size := uint64(hdr[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.r, ext[:]); err != nil {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.r, ext[:]); err != nil {
+			return
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > maxBodySize {
+		err = errors.New("websocket frame too large")
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(ws.r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+func (ws *wsConn) writeFrame(op byte, payload []byte) error {
+	frame := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xffff:
+		frame[1] = 126
+		frame = append(frame, byte(n>>8), byte(n))
+	default:
+		frame[1] = 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(frame, ext[:]...)
+	}
+	frame = append(frame, payload...)
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		return errWSClosed
+	}
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+func (ws *wsConn) writeJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.writeFrame(wsText, data)
+}
+
+func (ws *wsConn) close() error {
+	ws.writeFrame(wsClose, nil)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.closed = true
+	return ws.conn.Close()
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdnet "net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+)
+
+// A minimal WebSocket client.
+type wsClient struct {
+	conn stdnet.Conn
+	r    *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, url string) *wsClient {
+	conn, err := stdnet.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\n"+
+		"Connection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Example from RFC 6455 section 1.3.
+	if res.StatusCode != http.StatusSwitchingProtocols ||
+		res.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: %v %v", res.Status, res.Header)
+	}
+	return &wsClient{conn, r}
+}
+
+func (c *wsClient) send(t *testing.T, msg string) {
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x81, 0x80 | byte(len(msg))}, mask...)
+	for i := range msg {
+		frame = append(frame, msg[i]^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *wsClient) receive(t *testing.T) map[string]interface{} {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	if hdr[0] != 0x81 || hdr[1] >= 126 {
+		t.Fatalf("unexpected frame header %x", hdr)
+	}
+	payload := make([]byte, hdr[1])
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		t.Fatalf("%v: %q", err, payload)
+	}
+	return v
+}
+
+func TestWebSocket(t *testing.T) {
+	s, d := newTestServer(t)
+	defer d.l.Close()
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := dialWebSocket(t, ts.URL)
+	defer c.conn.Close()
+
+	c.send(t, `{"type": "set", "id": "lamp", "dps": {"1": true}, "ref": 7}`)
+	if v := c.receive(t); v["type"] != "result" || v["ref"] != 7.0 || v["error"] != nil {
+		t.Errorf("set result %v", v)
+	}
+	c.send(t, `{"type": "get", "id": "nope"}`)
+	if v := c.receive(t); v["type"] != "result" || v["error"] == nil {
+		t.Errorf("get result %v", v)
+	}
+
+	s.publish(Event{Time: time.Now(), ID: testID, Name: "lamp", DPs: device.State{1: false}})
+	v := c.receive(t)
+	if v["type"] != "event" || v["id"] != testID || v["dps"].(map[string]interface{})["1"] != false {
+		t.Errorf("event %v", v)
+	}
+}
@@ -0,0 +1,146 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	stdnet "net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/server"
+)
+
+// A NATS publishes each event as JSON to a subject made of a prefix and the
+// device's name or ID, e.g. "tuya.desk-lamp". Characters not allowed in
+// subject tokens are replaced with underscores. It connects on first use and
+// reconnects after failures.
+type NATS struct {
+	// Addr is the server's address, e.g. "localhost:4222".
+	Addr string
+
+	// Subject is the subject prefix. Empty means "tuya".
+	Subject string
+
+	// Credentials, if the server requires them.
+	User     string
+	Password string
+	Token    string
+
+	// Logger, if not nil, receives errors the server reports
+	// asynchronously, which Send can't return.
+	Logger net.Logger
+
+	mu   sync.Mutex
+	conn stdnet.Conn
+}
+
+// Send publishes an event.
+func (n *NATS) Send(e server.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	prefix := n.Subject
+	if prefix == "" {
+		prefix = "tuya"
+	}
+	name := e.Name
+	if name == "" {
+		name = e.ID
+	}
+	subject := prefix + "." + subjectEscaper.Replace(name)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		if n.conn, err = n.dial(); err != nil {
+			return fmt.Errorf("nats: %v", err)
+		}
+	}
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\n", subject, len(body), body)
+	n.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := n.conn.Write([]byte(msg)); err != nil {
+		n.conn.Close()
+		n.conn = nil
+		return fmt.Errorf("nats: %v", err)
+	}
+	return nil
+}
+
+var subjectEscaper = strings.NewReplacer(".", "_", " ", "_", "*", "_", ">", "_", "\t", "_")
+
+// Connect to the server. A goroutine answers the server's pings and closes
+// the connection when it fails.
+func (n *NATS) dial() (stdnet.Conn, error) {
+	conn, err := stdnet.DialTimeout("tcp", n.Addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("expected INFO, got %q (%v)", line, err)
+	}
+	options, _ := json.Marshal(map[string]interface{}{
+		"verbose":      false,
+		"pedantic":     false,
+		"name":         "tuya",
+		"lang":         "go",
+		"version":      "0",
+		"user":         n.User,
+		"pass":         n.Password,
+		"auth_token":   n.Token,
+		"tls_required": false,
+	})
+	// PING makes the server report a failed CONNECT before answering PONG.
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", options); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return nil, fmt.Errorf("%s", strings.TrimSpace(line))
+		}
+		if strings.HasPrefix(line, "PONG") {
+			break
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	go func() {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			switch {
+			case strings.HasPrefix(line, "PING"):
+				n.mu.Lock()
+				conn.Write([]byte("PONG\r\n"))
+				n.mu.Unlock()
+			case strings.HasPrefix(line, "-ERR"):
+				if n.Logger != nil {
+					n.Logger.Logf(net.LevelWarn, "nats: %s", strings.TrimSpace(line))
+				}
+			}
+		}
+		conn.Close()
+		n.mu.Lock()
+		if n.conn == conn {
+			n.conn = nil
+		}
+		n.mu.Unlock()
+	}()
+	return conn, nil
+}
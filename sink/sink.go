@@ -0,0 +1,105 @@
+// Package sink sends state change events from a server.Server to external
+// systems, such as webhooks and NATS.
+package sink
+
+import (
+	"sync"
+
+	"github.com/lann/tuya/net"
+	"github.com/lann/tuya/server"
+)
+
+// A Sink receives events. Send may block, for example while retrying; events
+// arriving meanwhile are queued.
+type Sink interface {
+	Send(e server.Event) error
+}
+
+// Number of events queued for each Sink before new events are dropped.
+const queueSize = 256
+
+// A Dispatcher sends a Server's events to Sinks. Each Sink has its own queue,
+// so a slow Sink doesn't delay others. The Server must be started for the
+// Dispatcher to see changes.
+type Dispatcher struct {
+	Server *server.Server
+	Sinks  []Sink
+
+	// Logger, if not nil, receives Send errors and dropped events.
+	Logger net.Logger
+
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// Run sends events until the Dispatcher is closed.
+func (d *Dispatcher) Run() error {
+	d.mu.Lock()
+	if d.stop == nil {
+		d.stop = make(chan struct{})
+	}
+	stop := d.stop
+	d.mu.Unlock()
+
+	events, unsubscribe := d.Server.Subscribe()
+	defer unsubscribe()
+	queues := make([]chan server.Event, len(d.Sinks))
+	var wg sync.WaitGroup
+	for i, sink := range d.Sinks {
+		queues[i] = make(chan server.Event, queueSize)
+		wg.Add(1)
+		go func(sink Sink, queue chan server.Event) {
+			defer wg.Done()
+			for e := range queue {
+				if err := sink.Send(e); err != nil {
+					d.logf(net.LevelWarn, "sink: %v", err)
+				}
+			}
+		}(sink, queues[i])
+	}
+	defer wg.Wait()
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+	}()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			for _, queue := range queues {
+				select {
+				case queue <- e:
+				default:
+					d.logf(net.LevelWarn, "sink: queue full; dropped event from %s", e.ID)
+				}
+			}
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+func (d *Dispatcher) logf(level net.Level, format string, args ...interface{}) {
+	if d.Logger != nil {
+		d.Logger.Logf(level, format, args...)
+	}
+}
+
+// Close stops Run. Queued events are still sent.
+func (d *Dispatcher) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stop == nil {
+		d.stop = make(chan struct{})
+	}
+	select {
+	case <-d.stop:
+	default:
+		close(d.stop)
+	}
+	return nil
+}
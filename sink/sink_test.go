@@ -0,0 +1,108 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/server"
+)
+
+var testEvent = server.Event{
+	Time: time.Unix(1500000000, 0).UTC(),
+	ID:   "abc",
+	Name: "desk lamp",
+	DPs:  device.State{1: true},
+}
+
+func TestWebhook(t *testing.T) {
+	attempts := 0
+	var body []byte
+	var signature string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+			return
+		}
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer ts.Close()
+
+	w := &Webhook{URL: ts.URL, Secret: "secret", Retries: 1}
+	if err := w.Send(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("got %d attempts, want 2", attempts)
+	}
+	if signature != "sha256="+Sign("secret", body) {
+		t.Errorf("bad signature %q", signature)
+	}
+	var e server.Event
+	if err := json.Unmarshal(body, &e); err != nil || e.ID != "abc" || e.DPs[1] != true {
+		t.Errorf("got event %+v (%v)", e, err)
+	}
+
+	w.URL = ts.URL + "/gone"
+	if err := w.Send(testEvent); err == nil {
+		t.Error("expected error for 404")
+	}
+}
+
+func TestNATS(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	published := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "INFO {}\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "PING"):
+				io.WriteString(conn, "PONG\r\n")
+			case strings.HasPrefix(line, "PUB"):
+				payload, _ := r.ReadString('\n')
+				published <- line + payload
+			}
+		}
+	}()
+
+	n := &NATS{Addr: l.Addr().String(), Subject: "home"}
+	if err := n.Send(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-published:
+		if !strings.HasPrefix(msg, "PUB home.desk_lamp ") || !strings.Contains(msg, `"id":"abc"`) {
+			t.Errorf("published %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for publish")
+	}
+}
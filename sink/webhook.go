@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lann/tuya/internal/httpclient"
+	"github.com/lann/tuya/server"
+)
+
+// SignatureHeader carries a Webhook's HMAC-SHA256 signature of the request
+// body, as "sha256=" followed by the hex digest.
+const SignatureHeader = "X-Tuya-Signature"
+
+// A Webhook POSTs each event as a JSON object, like a WebSocket event
+// without the type field.
+type Webhook struct {
+	URL string
+
+	// Secret, if set, is the key used to sign requests; see SignatureHeader.
+	Secret string
+
+	// Retries is how many times to retry a failed request, with exponential
+	// backoff starting at one second. Requests are retried after network
+	// errors and 429 and 5xx responses.
+	Retries int
+
+	// Client is used for requests. Nil means a default client with a timeout.
+	Client *http.Client
+}
+
+// Send posts an event, retrying as configured.
+func (w *Webhook) Send(e server.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.Retries {
+			return fmt.Errorf("webhook %s: %v", w.URL, err)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Post a body, reporting whether a failure is worth retrying.
+func (w *Webhook) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.Secret, body))
+	}
+	res, err := httpclient.Or(w.Client).Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry = res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return retry, fmt.Errorf("%s", res.Status)
+}
+
+// Sign returns the hex HMAC-SHA256 of body with the given secret, for
+// verifying Webhook requests.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,349 @@
+package tuyatest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+)
+
+// Conformance check results.
+const (
+	Pass = "pass"
+	Fail = "fail"
+	Skip = "skip"
+	// Unsupported is an optional feature the device lacks; it isn't a
+	// failure.
+	Unsupported = "unsupported"
+)
+
+// A Result is the outcome of one conformance check.
+type Result struct {
+	Check  string `json:"check"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// A Report is the outcome of a conformance run.
+type Report struct {
+	ID      string       `json:"id"`
+	Addr    string       `json:"addr"`
+	Version string       `json:"version"`
+	DPs     device.State `json:"dps,omitempty"`
+	Results []Result     `json:"results"`
+}
+
+// Failed reports whether any check failed.
+func (r *Report) Failed() bool {
+	for _, res := range r.Results {
+		if res.Result == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// Check fails the test for each failed check.
+func (r *Report) Check(t testing.TB) {
+	t.Helper()
+	for _, res := range r.Results {
+		if res.Result == Fail {
+			t.Errorf("%s: %s", res.Check, res.Detail)
+		}
+	}
+}
+
+// A Conformance run exercises a device, real or emulated, to find out how
+// it follows the protocol: which commands it answers, whether it pushes
+// changes, and how it handles reconnections and other versions.
+type Conformance struct {
+	ID     string
+	Config net.ClientConfig
+
+	// Status is the device's broadcast, if one was seen. Nil skips the
+	// broadcast check.
+	Status *net.Status
+
+	// DP is a boolean dp to toggle, and then restore, for the control and
+	// push checks. Zero skips them.
+	DP uint32
+
+	// Timeout is how long to wait for each reply. Zero means 5s.
+	Timeout time.Duration
+}
+
+// Run runs the checks and returns a Report.
+func (c *Conformance) Run() *Report {
+	r := &Report{ID: c.ID, Addr: c.Config.Addr, Version: c.Config.Version}
+	if r.Version == "" {
+		r.Version = net.Version31
+	}
+	add := func(check, result, format string, args ...interface{}) {
+		r.Results = append(r.Results, Result{check, result, fmt.Sprintf(format, args...)})
+	}
+
+	switch s := c.Status; {
+	case s == nil:
+		add("broadcast", Skip, "no broadcast given")
+	case s.GatewayID != c.ID:
+		add("broadcast", Fail, "gwId %q, want %q", s.GatewayID, c.ID)
+	case s.Version != r.Version:
+		add("broadcast", Fail, "claims version %s, testing %s", s.Version, r.Version)
+	default:
+		add("broadcast", Pass, "version %s, encrypt %v, productKey %q", s.Version, s.Encrypt, s.ProductKey)
+	}
+
+	sess, err := c.dial(c.Config)
+	if err != nil {
+		add("connect", Fail, "%v", err)
+		return r
+	}
+	add("connect", Pass, "")
+
+	if dps, err := sess.query(cmdQuery); err != nil {
+		add("query", Fail, "%v", err)
+	} else {
+		r.DPs = dps
+		add("query", Pass, "dps %s", dpList(dps))
+	}
+	if dps, err := sess.query(cmdQueryNew); err != nil {
+		add("query (0x10)", Unsupported, "%v", err)
+	} else {
+		add("query (0x10)", Pass, "dps %s", dpList(dps))
+	}
+	if _, err := sess.request(cmdHeartbeat, false, map[string]interface{}{}); err != nil {
+		add("heartbeat", Fail, "%v", err)
+	} else {
+		add("heartbeat", Pass, "")
+	}
+
+	c.control(sess, r.DPs, add)
+
+	refresh := []uint32{}
+	if c.DP != 0 {
+		refresh = append(refresh, c.DP)
+	}
+	if _, err := sess.client.Write(cmdRefresh, true, map[string]interface{}{"dpId": refresh}); err != nil {
+		add("refresh", Fail, "%v", err)
+	} else if _, err := sess.waitPush(0, nil); err != nil {
+		add("refresh", Unsupported, "%v", err)
+	} else {
+		add("refresh", Pass, "")
+	}
+
+	// A second connection while the first is open.
+	if second, err := c.dial(c.Config); err != nil {
+		add("second connection", Unsupported, "%v", err)
+	} else {
+		if _, err := second.query(cmdQuery); err != nil {
+			add("second connection", Unsupported, "%v", err)
+		} else {
+			add("second connection", Pass, "")
+		}
+		second.close()
+	}
+
+	sess.close()
+	if sess, err = c.dial(c.Config); err != nil {
+		add("reconnect", Fail, "%v", err)
+	} else {
+		if _, err := sess.query(cmdQuery); err != nil {
+			add("reconnect", Fail, "%v", err)
+		} else {
+			add("reconnect", Pass, "")
+		}
+		sess.close()
+	}
+
+	// Other protocol versions, which some firmware also answers.
+	for _, v := range []string{net.Version31, net.Version33} {
+		if v == r.Version {
+			continue
+		}
+		config := c.Config
+		config.Version = v
+		check := "query as " + v
+		if sess, err := c.dial(config); err != nil {
+			add(check, Unsupported, "%v", err)
+		} else {
+			if _, err := sess.query(cmdQuery); err != nil {
+				add(check, Unsupported, "%v", err)
+			} else {
+				add(check, Pass, "")
+			}
+			sess.close()
+		}
+	}
+	return r
+}
+
+// Toggle the test dp, check the ack and push, and restore it.
+func (c *Conformance) control(sess *session, dps device.State, add func(check, result, format string, args ...interface{})) {
+	if c.DP == 0 {
+		add("control", Skip, "no dp given")
+		add("push", Skip, "no dp given")
+		return
+	}
+	old, ok := dps[c.DP].(bool)
+	if !ok {
+		add("control", Fail, "dp %d is %v, not a bool", c.DP, dps[c.DP])
+		add("push", Skip, "")
+		return
+	}
+	set := func(v bool) error {
+		_, err := sess.request(cmdControl, true, map[string]interface{}{
+			"devId": c.ID,
+			"gwId":  c.ID,
+			"uid":   "",
+			"t":     time.Now().Unix(),
+			"dps":   device.State{c.DP: v},
+		})
+		return err
+	}
+	if err := set(!old); err != nil {
+		add("control", Fail, "%v", err)
+		add("push", Skip, "")
+		return
+	}
+	add("control", Pass, "")
+	if state, err := sess.waitPush(c.DP, !old); err != nil {
+		add("push", Fail, "%v", err)
+	} else {
+		add("push", Pass, "pushed dps %s", dpList(state))
+	}
+	if err := set(old); err != nil {
+		add("restore", Fail, "%v", err)
+	} else {
+		sess.waitPush(c.DP, old)
+	}
+}
+
+// A connection under test, reading replies and pushes in the background.
+type session struct {
+	id      string
+	client  *net.Client
+	timeout time.Duration
+	frames  chan *net.Response
+	err     error // set before frames is closed
+}
+
+func (c *Conformance) dial(config net.ClientConfig) (*session, error) {
+	client, err := config.Dial()
+	if err != nil {
+		return nil, err
+	}
+	s := &session{id: c.ID, client: client, timeout: c.Timeout, frames: make(chan *net.Response, 16)}
+	if s.timeout == 0 {
+		s.timeout = 5 * time.Second
+	}
+	go func() {
+		defer close(s.frames)
+		for {
+			res, err := client.Read()
+			if err != nil {
+				s.err = err
+				return
+			}
+			s.frames <- res
+		}
+	}()
+	return s, nil
+}
+
+func (s *session) close() {
+	s.client.Close()
+	for range s.frames {
+	}
+}
+
+// Send a request and wait for its reply, discarding pushes.
+func (s *session) request(cmd uint32, encrypt bool, payload interface{}) (*net.Response, error) {
+	seq, err := s.client.Write(cmd, encrypt, payload)
+	if err != nil {
+		return nil, err
+	}
+	timeout := time.After(s.timeout)
+	for {
+		select {
+		case res, ok := <-s.frames:
+			if !ok {
+				return nil, fmt.Errorf("connection closed: %v", s.err)
+			}
+			if res.Seq != seq || res.Cmd == cmdStatus {
+				continue
+			}
+			return res, res.Err()
+		case <-timeout:
+			return nil, fmt.Errorf("no reply within %v", s.timeout)
+		}
+	}
+}
+
+// Query the device's dps with a query command.
+func (s *session) query(cmd uint32) (device.State, error) {
+	res, err := s.request(cmd, false, map[string]string{"gwId": s.id, "devId": s.id})
+	if err != nil {
+		return nil, err
+	}
+	var reply struct {
+		DPs device.State `json:"dps"`
+	}
+	if err := res.DecodeJSON(&reply); err != nil {
+		return nil, err
+	}
+	if reply.DPs == nil {
+		return nil, errors.New("reply has no dps")
+	}
+	return reply.DPs, nil
+}
+
+// Wait for a push, of a dp value if dp isn't zero.
+func (s *session) waitPush(dp uint32, want interface{}) (device.State, error) {
+	timeout := time.After(s.timeout)
+	for {
+		select {
+		case res, ok := <-s.frames:
+			if !ok {
+				return nil, fmt.Errorf("connection closed: %v", s.err)
+			}
+			if res.Cmd != cmdStatus {
+				continue
+			}
+			payload := res.Payload
+			if len(payload) >= 4 && payload[0] == 0 {
+				payload = payload[4:]
+			}
+			var push struct {
+				DPs device.State `json:"dps"`
+			}
+			if err := json.Unmarshal(payload, &push); err != nil {
+				return nil, fmt.Errorf("bad push %q: %v", payload, err)
+			}
+			if v, ok := push.DPs[dp]; dp == 0 || ok && v == want {
+				return push.DPs, nil
+			}
+		case <-timeout:
+			return nil, fmt.Errorf("no push within %v", s.timeout)
+		}
+	}
+}
+
+// Format a state's dp numbers like "1,2,3".
+func dpList(state device.State) string {
+	dps := make([]int, 0, len(state))
+	for dp := range state {
+		dps = append(dps, int(dp))
+	}
+	sort.Ints(dps)
+	strs := make([]string, len(dps))
+	for i, dp := range dps {
+		strs[i] = fmt.Sprint(dp)
+	}
+	return strings.Join(strs, ",")
+}
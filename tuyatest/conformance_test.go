@@ -0,0 +1,41 @@
+package tuyatest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+)
+
+func TestConformance(t *testing.T) {
+	for _, version := range []string{net.Version31, net.Version33} {
+		t.Run(version, func(t *testing.T) {
+			d, err := New("dev1", testKey, version, device.State{1: false, 2: 50.0})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			if err := d.Listen("127.0.0.1:0"); err != nil {
+				t.Fatal(err)
+			}
+			status := d.Status()
+			c := &Conformance{ID: d.ID, Config: d.ClientConfig(), Status: &status, DP: 1, Timeout: time.Second}
+			r := c.Run()
+			r.Check(t)
+
+			results := make(map[string]string)
+			for _, res := range r.Results {
+				results[res.Check] = res.Result
+			}
+			for _, check := range []string{"broadcast", "query", "query (0x10)", "control", "push", "refresh", "second connection", "reconnect"} {
+				if results[check] != Pass {
+					t.Errorf("%s: %s", check, results[check])
+				}
+			}
+			if d.State()[1] != false {
+				t.Error("dp 1 not restored")
+			}
+		})
+	}
+}
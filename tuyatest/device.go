@@ -0,0 +1,321 @@
+// Package tuyatest emulates Tuya devices, for testing clients without
+// hardware.
+//
+// A Device accepts client connections like a real device: it answers queries,
+// control requests, heartbeats, and refreshes from its dps, pushes changes to
+// every connection, and can announce itself with Status broadcasts. Protocol
+// versions 3.1 and 3.3 are supported.
+//
+// A Fake instead follows a script of expected requests and canned replies,
+// delays, pushes, and dropped connections, for deterministic unit tests.
+package tuyatest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdnet "net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+)
+
+// Command numbers.
+const (
+	cmdControl   = 0x07
+	cmdStatus    = 0x08
+	cmdHeartbeat = 0x09
+	cmdQuery     = 0x0a
+	cmdQueryNew  = 0x10
+	cmdRefresh   = 0x12
+)
+
+// ErrClosed is returned by Listen after the Device is closed.
+var ErrClosed = errors.New("closed")
+
+// A Device is an emulated Tuya device.
+type Device struct {
+	ID         string
+	ProductKey string
+
+	// Control, if not nil, is called with the dps of each control request
+	// and returns the dps to apply, or an error to reject the request. Nil
+	// applies dps as given.
+	Control func(dps device.State) (device.State, error)
+
+	// Logger, if not nil, receives errors handling requests and pushing
+	// updates.
+	Logger net.Logger
+
+	config net.ServerConfig
+
+	mu     sync.Mutex
+	dps    device.State
+	server *net.Server
+	conns  map[*net.Conn]struct{}
+	closed bool
+}
+
+// New returns a Device with the given ID, local key, protocol version
+// (net.Version31 or net.Version33; empty means 3.1), and initial dps.
+func New(id, key, version string, dps device.State) (*Device, error) {
+	if version == "" {
+		version = net.Version31
+	}
+	if version != net.Version31 && version != net.Version33 {
+		return nil, fmt.Errorf("%w: %q", net.ErrUnsupportedVersion, version)
+	}
+	if _, err := net.NewCipher([]byte(key)); err != nil {
+		return nil, fmt.Errorf("NewCipher: %v", err)
+	}
+	d := &Device{
+		ID:     id,
+		config: net.ServerConfig{Key: key, Version: version},
+		dps:    make(device.State),
+		conns:  make(map[*net.Conn]struct{}),
+	}
+	for dp, v := range dps {
+		d.dps[dp] = v
+	}
+	return d, nil
+}
+
+// Listen starts accepting connections on addr, such as ":6668" or
+// "127.0.0.1:0" for tests.
+func (d *Device) Listen(addr string) error {
+	s, err := d.config.Listen(addr)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		s.Close()
+		return ErrClosed
+	}
+	if d.server != nil {
+		s.Close()
+		return fmt.Errorf("already listening on %s", d.server.Addr())
+	}
+	d.server = s
+	go d.accept(s)
+	return nil
+}
+
+// Serve answers requests on an existing connection, such as one end of a
+// net.Pipe, until it fails.
+func (d *Device) Serve(conn stdnet.Conn) error {
+	c, err := d.config.NewConn(conn)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		c.Close()
+		return ErrClosed
+	}
+	d.conns[c] = struct{}{}
+	d.mu.Unlock()
+	return d.serve(c)
+}
+
+// Addr returns the address the Device is listening on, or "" if it isn't.
+func (d *Device) Addr() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.server == nil {
+		return ""
+	}
+	return d.server.Addr().String()
+}
+
+// ClientConfig returns a ClientConfig for connecting to the Device.
+func (d *Device) ClientConfig() net.ClientConfig {
+	return net.ClientConfig{Addr: d.Addr(), Key: d.config.Key, Version: d.config.Version}
+}
+
+// State returns a copy of the Device's dps.
+func (d *Device) State() device.State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state := make(device.State, len(d.dps))
+	for dp, v := range d.dps {
+		state[dp] = v
+	}
+	return state
+}
+
+// Set changes dps as if by a button press, pushing them to connected
+// clients.
+func (d *Device) Set(dps device.State) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for dp, v := range dps {
+		d.dps[dp] = v
+	}
+	for c := range d.conns {
+		d.push(c, dps)
+	}
+}
+
+// Close stops listening and closes all connections.
+func (d *Device) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	var err error
+	if d.server != nil {
+		err = d.server.Close()
+	}
+	for c := range d.conns {
+		c.Close()
+		delete(d.conns, c)
+	}
+	return err
+}
+
+func (d *Device) accept(s *net.Server) {
+	for {
+		c, err := s.Accept()
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		if d.closed {
+			d.mu.Unlock()
+			c.Close()
+			return
+		}
+		d.conns[c] = struct{}{}
+		d.mu.Unlock()
+		go d.serve(c)
+	}
+}
+
+// Read and answer requests until the connection fails.
+func (d *Device) serve(c *net.Conn) error {
+	defer func() {
+		d.mu.Lock()
+		delete(d.conns, c)
+		d.mu.Unlock()
+		c.Close()
+	}()
+	for {
+		f, err := c.Read()
+		if err != nil {
+			return err
+		}
+		if err := d.handle(c, f); err != nil {
+			d.logf(net.LevelWarn, "tuyatest: %s: cmd %#x: %v", d.ID, f.Cmd, err)
+		}
+	}
+}
+
+// Answer a request frame.
+func (d *Device) handle(c *net.Conn, f *net.Frame) error {
+	switch f.Cmd {
+	case cmdHeartbeat:
+		return c.Reply(f, 0, nil)
+
+	case cmdQuery, cmdQueryNew:
+		d.mu.Lock()
+		body, err := json.Marshal(statusMessage{DevID: d.ID, DPs: d.dps})
+		d.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return c.Reply(f, 0, body)
+
+	case cmdControl:
+		var req statusMessage
+		if err := json.Unmarshal(f.Payload, &req); err != nil {
+			c.Reply(f, 1, []byte("bad json"))
+			return fmt.Errorf("Unmarshal: %v", err)
+		}
+		dps := req.DPs
+		if d.Control != nil {
+			var err error
+			if dps, err = d.Control(req.DPs); err != nil {
+				return c.Reply(f, 1, []byte(err.Error()))
+			}
+		}
+		if err := c.Reply(f, 0, nil); err != nil {
+			return err
+		}
+		d.Set(dps)
+		return nil
+
+	case cmdRefresh:
+		var req struct {
+			DPs []uint32 `json:"dpId"`
+		}
+		if err := json.Unmarshal(f.Payload, &req); err != nil {
+			return fmt.Errorf("Unmarshal: %v", err)
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		dps := make(device.State)
+		for _, dp := range req.DPs {
+			if v, ok := d.dps[dp]; ok {
+				dps[dp] = v
+			}
+		}
+		if len(req.DPs) == 0 {
+			dps = d.dps
+		}
+		d.push(c, dps)
+		return nil
+	}
+	return c.Reply(f, 1, []byte("unknown command "+strconv.Itoa(int(f.Cmd))))
+}
+
+// The JSON of queries, query responses, control requests, and pushes.
+type statusMessage struct {
+	DevID string       `json:"devId"`
+	DPs   device.State `json:"dps"`
+}
+
+func (d *Device) logf(level net.Level, format string, args ...interface{}) {
+	if d.Logger != nil {
+		d.Logger.Logf(level, format, args...)
+	}
+}
+
+// Push dps to a connection. Must be called with the lock held.
+func (d *Device) push(c *net.Conn, dps device.State) {
+	// A failed write ends the connection's serve loop.
+	if err := c.Push(statusMessage{DevID: d.ID, DPs: dps}); err != nil {
+		d.logf(net.LevelWarn, "tuyatest: %s: push: %v", d.ID, err)
+	}
+}
+
+// Status returns the Status the Device broadcasts. Its IP is the address
+// the Device listens on, or empty if that's unspecified.
+func (d *Device) Status() net.Status {
+	status := net.Status{
+		GatewayID:  d.ID,
+		Active:     2,
+		Encrypt:    true,
+		ProductKey: d.ProductKey,
+		Version:    d.config.Version,
+	}
+	if host, _, err := stdnet.SplitHostPort(d.Addr()); err == nil {
+		if ip := stdnet.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			status.IP = host
+		}
+	}
+	return status
+}
+
+// Announce broadcasts the Device's Status every interval; see net.Announce.
+func (d *Device) Announce(interval time.Duration) (*net.Announcer, error) {
+	status := d.Status()
+	return net.Announce(&status, interval)
+}
@@ -0,0 +1,107 @@
+package tuyatest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+)
+
+const testKey = "0123456789abcdef"
+
+func startDevice(t *testing.T, version string) (*Device, *device.Manager) {
+	d, err := New("dev1", testKey, version, device.State{1: false, 2: 50.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	client, err := d.ClientConfig().Dial()
+	if err != nil {
+		d.Close()
+		t.Fatal(err)
+	}
+	return d, device.NewManager(d.ID, client)
+}
+
+func TestDevice(t *testing.T) {
+	for _, version := range []string{net.Version31, net.Version33} {
+		t.Run(version, func(t *testing.T) {
+			d, m := startDevice(t, version)
+			defer d.Close()
+			defer m.Close()
+
+			state, err := m.GetState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state[1] != false || state[2] != 50.0 {
+				t.Errorf("GetState = %v", state)
+			}
+
+			watch, stop := m.Watch()
+			defer stop()
+			if err := m.SetState(device.State{1: true}); err != nil {
+				t.Fatal(err)
+			}
+			if got := d.State()[1]; got != true {
+				t.Errorf("device dp 1 = %v after SetState", got)
+			}
+			checkPush(t, watch, 1, true)
+
+			d.Set(device.State{2: 75.0})
+			checkPush(t, watch, 2, 75.0)
+		})
+	}
+}
+
+func checkPush(t *testing.T, watch <-chan device.State, dp uint32, want interface{}) {
+	t.Helper()
+	select {
+	case state := <-watch:
+		if state[dp] != want {
+			t.Errorf("push = %v, want dp %d = %v", state, dp, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no push")
+	}
+}
+
+func TestDeviceControl(t *testing.T) {
+	d, m := startDevice(t, net.Version33)
+	defer d.Close()
+	defer m.Close()
+	d.Control = func(dps device.State) (device.State, error) {
+		if _, ok := dps[2]; ok {
+			return nil, errors.New("dp 2 is read-only")
+		}
+		return dps, nil
+	}
+
+	err := m.SetState(device.State{2: 10.0})
+	if re, ok := err.(net.ResponseError); !ok || re.Message != "dp 2 is read-only" {
+		t.Errorf("SetState err = %v", err)
+	}
+	if got := d.State()[2]; got != 50.0 {
+		t.Errorf("dp 2 = %v after rejected control", got)
+	}
+}
+
+func TestDeviceStatus(t *testing.T) {
+	d, err := New("dev1", testKey, net.Version33, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	d.ProductKey = "pk"
+	if err := d.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	status := d.Status()
+	if status.GatewayID != "dev1" || status.Version != "3.3" || status.IP != "127.0.0.1" || status.ProductKey != "pk" {
+		t.Errorf("status = %+v", status)
+	}
+}
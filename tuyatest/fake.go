@@ -0,0 +1,188 @@
+package tuyatest
+
+import (
+	"fmt"
+	stdnet "net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/net"
+)
+
+// A Step is one action in a Fake's script. A Step with Expect reads a
+// request and, unless NoReply, Drop, or Frame say otherwise, replies to it
+// with Code and Reply. A Step with Push sends a push, and one with only
+// Delay just waits.
+type Step struct {
+	// Expect, if not zero, is the command number of the next request.
+	Expect uint32
+	// Check, if not nil, is called with the decrypted request; an error
+	// fails the test.
+	Check func(req *net.Frame) error
+
+	// Delay is how long to wait before replying, pushing, or dropping.
+	Delay time.Duration
+
+	// Code and Reply are the return code and payload of the reply: nil, a
+	// []byte, or a JSON-serializable object.
+	Code  uint32
+	Reply interface{}
+	// NoReply leaves the request unanswered.
+	NoReply bool
+	// Frame, if not nil, is written as is instead of a reply. A zero Seq is
+	// replaced with the request's.
+	Frame *net.Frame
+
+	// Push, if not nil, is pushed as a status message.
+	Push interface{}
+
+	// Drop closes the connection.
+	Drop bool
+}
+
+// A Fake is a scripted device connected to a Client over net.Pipe, for
+// testing code that uses Clients without a real or emulated device. Steps run
+// in order in the background; mismatched requests fail the test.
+type Fake struct {
+	// Client is connected to the Fake.
+	Client *net.Client
+
+	t    testing.TB
+	conn *net.Conn
+
+	steps   chan Step
+	pending sync.WaitGroup
+	done    chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewFake returns a Fake using key and version, as in New. It fails the test
+// if they're invalid.
+func NewFake(t testing.TB, key, version string) *Fake {
+	t.Helper()
+	clientConn, deviceConn := stdnet.Pipe()
+	client, err := net.ClientConfig{Key: key, Version: version}.NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ServerConfig{Key: key, Version: version}.NewConn(deviceConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Fake{
+		Client: client,
+		t:      t,
+		conn:   conn,
+		steps:  make(chan Step, 64),
+		done:   make(chan struct{}),
+	}
+	go f.run()
+	return f
+}
+
+// Script adds steps to run after those already added.
+func (f *Fake) Script(steps ...Step) {
+	f.pending.Add(len(steps))
+	for _, s := range steps {
+		f.steps <- s
+	}
+}
+
+// Wait waits for all steps to run, failing the test if they take longer
+// than timeout.
+func (f *Fake) Wait(timeout time.Duration) {
+	f.t.Helper()
+	finished := make(chan struct{})
+	go func() {
+		f.pending.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+		f.t.Errorf("tuyatest: script not finished after %v", timeout)
+	}
+}
+
+// Close closes both ends of the connection and stops the script. Steps not
+// yet run are skipped.
+func (f *Fake) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	f.mu.Unlock()
+	f.Client.Close()
+	err := f.conn.Close()
+	close(f.steps)
+	<-f.done
+	return err
+}
+
+func (f *Fake) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+func (f *Fake) run() {
+	defer close(f.done)
+	failed := false
+	for s := range f.steps {
+		if !failed {
+			if err := f.step(s); err != nil {
+				failed = true
+				if !f.isClosed() {
+					f.t.Errorf("tuyatest: %v", err)
+				}
+			}
+		}
+		f.pending.Done()
+	}
+}
+
+func (f *Fake) step(s Step) error {
+	var req *net.Frame
+	if s.Expect != 0 {
+		var err error
+		if req, err = f.conn.Read(); err != nil {
+			return fmt.Errorf("expecting cmd %#x: %v", s.Expect, err)
+		}
+		if req.Cmd != s.Expect {
+			return fmt.Errorf("got cmd %#x, expected %#x: %q", req.Cmd, s.Expect, req.Payload)
+		}
+		if s.Check != nil {
+			if err := s.Check(req); err != nil {
+				return fmt.Errorf("cmd %#x: %v", req.Cmd, err)
+			}
+		}
+	}
+	time.Sleep(s.Delay)
+
+	switch {
+	case s.Drop:
+		f.conn.Close()
+		return nil
+	case s.Frame != nil:
+		frame := *s.Frame
+		if frame.Seq == 0 && req != nil {
+			frame.Seq = req.Seq
+		}
+		if err := f.conn.WriteFrame(&frame); err != nil {
+			return err
+		}
+	case req != nil && !s.NoReply:
+		if err := f.conn.Reply(req, s.Code, s.Reply); err != nil {
+			return err
+		}
+	}
+	if s.Push != nil {
+		return f.conn.Push(s.Push)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package tuyatest
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lann/tuya/device"
+	"github.com/lann/tuya/net"
+)
+
+func TestFake(t *testing.T) {
+	for _, version := range []string{net.Version31, net.Version33} {
+		t.Run(version, func(t *testing.T) {
+			f := NewFake(t, testKey, version)
+			defer f.Close()
+			m := device.ManagerConfig{Now: func() time.Time { return time.Unix(1529442366, 0) }}.NewManager("dev1", f.Client)
+			watch, stop := m.Watch()
+			defer stop()
+
+			f.Script(
+				Step{Expect: 0x0a, Reply: map[string]interface{}{"dps": map[string]bool{"1": true}}},
+				Step{Expect: 0x07, Check: func(req *net.Frame) error {
+					if !bytes.Contains(req.Payload, []byte(`"dps":{"1":false}`)) || !bytes.Contains(req.Payload, []byte(`"t":1529442366`)) {
+						return fmt.Errorf("bad control %q", req.Payload)
+					}
+					return nil
+				}, Push: map[string]interface{}{"dps": map[string]bool{"1": false}}},
+				Step{Expect: 0x07, Code: 1, Reply: []byte("nope"), Delay: 10 * time.Millisecond},
+			)
+
+			state, err := m.GetState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state[1] != true {
+				t.Errorf("GetState = %v", state)
+			}
+			if err := m.SetState(device.State{1: false}); err != nil {
+				t.Fatal(err)
+			}
+			checkPush(t, watch, 1, false)
+			if err := m.SetState(device.State{1: true}); err == nil {
+				t.Error("expected error reply")
+			}
+			f.Wait(time.Second)
+		})
+	}
+}
+
+func TestFakeDrop(t *testing.T) {
+	f := NewFake(t, testKey, net.Version33)
+	defer f.Close()
+	m := device.NewManager("dev1", f.Client)
+	f.Script(Step{Expect: 0x0a, Drop: true})
+	if _, err := m.GetState(); err == nil {
+		t.Error("expected error after drop")
+	}
+	f.Wait(time.Second)
+}